@@ -0,0 +1,227 @@
+package main
+
+import (
+	"NetScan/internal/agent/config"
+	"NetScan/internal/agent/domain"
+	handler "NetScan/internal/agent/handlers"
+	"NetScan/pkg/uuidutil"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes of the check command
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+// optionFlags collects repeated -opt key=value flags
+type optionFlags map[string]interface{}
+
+func (o optionFlags) String() string {
+	return ""
+}
+
+// Set parses values as JSON so numbers, booleans and lists keep their type,
+// anything else is taken as a plain string
+func (o optionFlags) Set(raw string) error {
+	key, value, ok := strings.Cut(raw, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("option %q must be key=value", raw)
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		parsed = value
+	}
+	o[key] = parsed
+	return nil
+}
+
+// runCheck executes a single task with the runners of this binary and prints
+// the result, the backend is not contacted
+func runCheck(args []string) int {
+	options := optionFlags{}
+
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	checkType := flags.String("type", "", "check type: http, https, ping, dns, tcp, mtr")
+	target := flags.String("target", "", "host, address or URL to check")
+	output := flags.String("output", "table", "output format: table or json")
+	timeout := flags.Duration("timeout", 2*time.Minute, "upper bound for the whole check")
+	verbose := flags.Bool("v", false, "log debug messages to stderr")
+	flags.Var(options, "opt", "runner option as key=value, may be repeated")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: agent check -type <type> -target <target> [-opt key=value ...] [-output table|json]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Exit status is 0 when the check succeeds, 1 when it fails and 2 on usage errors.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	// The target may also be given as the only positional argument
+	if *target == "" && flags.NArg() == 1 {
+		*target = flags.Arg(0)
+	} else if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		return exitUsage
+	}
+
+	if *checkType == "" || *target == "" {
+		flags.Usage()
+		return exitUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *output)
+		return exitUsage
+	}
+
+	// stdout is reserved for the result
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	checkLogger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	taskHandler, err := newCheckHandler(checkLogger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	task := &domain.Task{
+		ID:        uuidutil.New(),
+		Type:      domain.CheckType(*checkType),
+		Target:    *target,
+		Options:   options,
+		CreatedAt: time.Now(),
+		AgentID:   "local",
+		Timeout:   *timeout,
+	}
+
+	if !taskHandler.CanExecute(task.Type) {
+		fmt.Fprintf(os.Stderr, "unsupported check type %q\n", *checkType)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	result := taskHandler.ExecuteTask(ctx, task)
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+	} else {
+		printResultTable(os.Stdout, task, result)
+	}
+
+	if !result.Success {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// newCheckHandler builds the runners the same way the daemon does, so proxy
+// settings and runner defaults from the config apply. Every supported runner
+// is available regardless of runners.enabled.
+func newCheckHandler(logger *slog.Logger) (*handler.TaskHandler, error) {
+	configManager, err := config.Load(logger)
+	if err != nil {
+		return nil, err
+	}
+	cfg := configManager.Current()
+
+	container := &Container{Logger: logger}
+	if err := container.initTaskRunners(cfg); err != nil {
+		return nil, err
+	}
+	container.TaskRunner.SetEnabled(container.TaskRunner.Supported())
+
+	taskHandler := handler.NewTaskHandler(container.TaskRunner, nil, logger)
+	taskHandler.SetDefaults(runnerDefaults(cfg))
+	return taskHandler, nil
+}
+
+func printResultTable(w io.Writer, task *domain.Task, result *domain.Result) {
+	status := "ok"
+	switch {
+	case result.Outcome == domain.OutcomeTimeout:
+		status = "timeout"
+	case !result.Success:
+		status = "failed"
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Type\t%s\n", task.Type)
+	fmt.Fprintf(table, "Target\t%s\n", task.Target)
+	fmt.Fprintf(table, "Status\t%s\n", status)
+	fmt.Fprintf(table, "Duration\t%s\n", time.Duration(result.ResponseTime)*time.Microsecond)
+	if result.Error != "" {
+		fmt.Fprintf(table, "Error\t%s\n", result.Error)
+	}
+
+	if len(result.Data) > 0 {
+		fields := make(map[string]string)
+		flattenData("", result.Data, fields)
+
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintln(table, "\t")
+		for _, key := range keys {
+			fmt.Fprintf(table, "%s\t%s\n", key, fields[key])
+		}
+	}
+
+	table.Flush()
+}
+
+// flattenData turns nested maps into dotted keys, lists are printed as JSON
+func flattenData(prefix string, data map[string]interface{}, fields map[string]string) {
+	for key, value := range data {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			flattenData(key, typed, fields)
+		case string:
+			fields[key] = typed
+		case nil:
+			fields[key] = "-"
+		default:
+			encoded, err := json.Marshal(typed)
+			if err != nil {
+				fields[key] = fmt.Sprint(typed)
+				continue
+			}
+			fields[key] = string(encoded)
+		}
+	}
+}
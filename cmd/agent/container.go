@@ -2,81 +2,411 @@ package main
 
 import (
 	client "NetScan/internal/agent/clients"
+	"NetScan/internal/agent/config"
 	"NetScan/internal/agent/domain"
 	handler "NetScan/internal/agent/handlers"
+	"NetScan/internal/agent/metrics"
+	"NetScan/internal/agent/probe"
+	"NetScan/internal/agent/resilience"
 	runner "NetScan/internal/agent/runners"
+	"NetScan/internal/agent/scheduler"
+	"NetScan/internal/agent/spool"
+	"NetScan/internal/agent/sysinfo"
+	"NetScan/pkg/tlsutil"
+	"context"
+	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
+	"slices"
+	"time"
 )
 
 type Container struct {
 	Logger       *slog.Logger
+	LogLevel     *slog.LevelVar
+	Config       *config.Manager
 	AgentHandler *handler.AgentHandler
 	APIClient    *client.APIClient
+	Channel      *client.Channel
 	TaskRunner   *runner.Factory
 	TaskHandler  *handler.TaskHandler
+	SysInfo      *sysinfo.Collector
+	ResultSpool  *spool.Spool
+	Metrics      *metrics.Registry
+	Prober       *probe.Prober
+	Scheduler    *scheduler.Scheduler
+	httpRunner   *runner.HTTPRunner
+	startedAt    time.Time
 }
 
-func GetContainer() *Container {
-	container := &Container{}
+func GetContainer() (*Container, error) {
+	container := &Container{
+		LogLevel:  new(slog.LevelVar),
+		startedAt: time.Now(),
+	}
+
+	// The config is loaded with a bootstrap logger, the format of the final
+	// one depends on the config itself
+	configManager, err := config.Load(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	if err != nil {
+		return nil, err
+	}
+	container.Config = configManager
 
-	container.initLogger()
-	container.initAPIClient()
-	container.initTaskRunners()
-	container.initHandlers()
+	cfg := configManager.Current()
 
-	return container
+	container.initLogger(cfg)
+	container.SysInfo = sysinfo.NewCollector(getEnv("DISK_PATH", "/"))
+	// created early, the API client reports retries and breaker changes to it
+	container.Metrics = metrics.New()
+	if err := container.initAPIClient(cfg); err != nil {
+		return nil, err
+	}
+	container.Prober = probe.NewProber(probe.Config{
+		DNSServer: cfg.Probes.DNSServer,
+		TCPTarget: cfg.Probes.TCPTarget,
+		Timeout:   cfg.Probes.Timeout,
+	}, container.Logger)
+	if err := container.initTaskRunners(cfg); err != nil {
+		return nil, err
+	}
+	if err := container.initSpool(cfg); err != nil {
+		return nil, err
+	}
+	container.initHandlers(cfg)
+	container.initScheduler(cfg)
+	container.initMetrics()
+	container.watchConfig()
+
+	return container, nil
 }
 
 func (c *Container) GetEnv(key, defaultValue string) string {
 	return getEnv(key, defaultValue)
 }
 
-func (c *Container) initLogger() {
-	logLevel := slog.LevelInfo
+func (c *Container) initLogger(cfg *config.Config) {
+	c.LogLevel.Set(cfg.Logging.SlogLevel())
 	if os.Getenv("DEBUG") == "true" {
-		logLevel = slog.LevelDebug
+		c.LogLevel.Set(slog.LevelDebug)
 	}
 
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
-	})
+	options := &slog.HandlerOptions{
+		Level: c.LogLevel,
+	}
+
+	var handler slog.Handler
+	if cfg.Logging.Format == "text" {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	}
 
 	c.Logger = slog.New(handler)
 }
 
-func (c *Container) initAPIClient() {
-	baseURL := getEnv("BACKEND_URL", "http://localhost:8080")
-	token := getEnv("AGENT_TOKEN", "")
-	agentID := getEnv("AGENT_ID", "")
+func (c *Container) initAPIClient(cfg *config.Config) error {
+	c.APIClient = client.NewAPIClient(cfg.Backend.URL, cfg.Backend.Token, cfg.Backend.AgentID)
+	c.APIClient.SetLogger(c.Logger)
+	c.APIClient.SetResilience(resilienceSettings(cfg, c.Metrics, c.Logger))
 
-	c.APIClient = client.NewAPIClient(baseURL, token, agentID)
+	if cfg.Proxy.URL != "" {
+		proxyURL, err := url.Parse(cfg.Proxy.URL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url: %w", err)
+		}
+		c.APIClient.SetProxy(proxyURL)
+	}
+
+	if cfg.Backend.TLS != (config.TLSConfig{}) {
+		source, err := tlsutil.NewSource(tlsutil.Files{
+			CertFile: cfg.Backend.TLS.CertFile,
+			KeyFile:  cfg.Backend.TLS.KeyFile,
+			CAFile:   cfg.Backend.TLS.CAFile,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid backend tls settings: %w", err)
+		}
+		c.APIClient.SetTLS(source.ClientConfig(cfg.Backend.TLS.ServerName))
+	}
+
+	return nil
 }
 
-func (c *Container) initTaskRunners() {
-	httpRunner := runner.NewHTTPRunner()
+func resilienceSettings(cfg *config.Config, observer resilience.Observer, logger *slog.Logger) client.Resilience {
+	policies := make(map[string]resilience.Policy, len(client.Endpoints))
+	for _, endpoint := range client.Endpoints {
+		policy := cfg.Resilience.Policy(endpoint)
+		policies[endpoint] = resilience.Policy{
+			MaxAttempts:      policy.MaxAttempts,
+			InitialBackoff:   policy.InitialBackoff,
+			MaxBackoff:       policy.MaxBackoff,
+			FailureThreshold: policy.FailureThreshold,
+			OpenTimeout:      policy.OpenTimeout,
+			HalfOpenRequests: policy.HalfOpenRequests,
+		}
+	}
+
+	return client.Resilience{
+		Policies:    policies,
+		RetryBudget: cfg.Resilience.RetryBudget,
+		Observer:    observer,
+		Logger:      logger,
+	}
+}
+
+func (c *Container) initTaskRunners(cfg *config.Config) error {
+	c.httpRunner = runner.NewHTTPRunner()
 	pingRunner := runner.NewPingRunner()
 	dnsRunner := runner.NewDNSRunner()
 	tcpRunner := runner.NewTCPRunner()
+	mtrRunner := runner.NewMTRRunner()
+
+	if cfg.Proxy.URL != "" && cfg.Proxy.HTTPChecks {
+		proxyURL, err := url.Parse(cfg.Proxy.URL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url: %w", err)
+		}
+		c.httpRunner.SetProxy(proxyURL)
+	}
+
+	c.TaskRunner = runner.NewFactory(c.httpRunner, pingRunner, dnsRunner, tcpRunner, mtrRunner)
+	c.TaskRunner.SetEnabled(c.activeCheckTypes(cfg))
+
+	return nil
+}
+
+func (c *Container) initSpool(cfg *config.Config) error {
+	resultSpool, err := spool.Open(spool.Config{
+		Dir:      cfg.Spool.Dir,
+		MaxBytes: cfg.Spool.MaxSizeMB << 20,
+		MaxAge:   cfg.Spool.MaxAge,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open result spool: %w", err)
+	}
+
+	if depth := resultSpool.Depth(); depth > 0 {
+		c.Logger.Info("Result spool has pending results", "depth", depth, "dir", cfg.Spool.Dir)
+	}
+
+	c.ResultSpool = resultSpool
+	return nil
+}
+
+func (c *Container) initHandlers(cfg *config.Config) {
+	c.TaskHandler = handler.NewTaskHandler(c.TaskRunner, c.APIClient, c.Logger)
+	c.TaskHandler.SetDefaults(runnerDefaults(cfg))
+
+	c.AgentHandler = handler.NewAgentHandler(c.Logger, c.APIClient, c.TaskHandler, c.ResultSpool, handler.PoolConfig{
+		Workers:        cfg.Concurrency.Workers,
+		PrefetchBuffer: cfg.Concurrency.Prefetch,
+		TypeLimits:     typeLimits(cfg),
+		DrainTimeout:   cfg.Shutdown.GracePeriod,
+	})
+
+	if cfg.Backend.Channel {
+		c.Channel = client.NewChannel(c.APIClient, c.Logger)
+		c.Channel.OnCancel(c.AgentHandler.CancelTask)
+		c.Channel.OnConfig(func(settings domain.RemoteConfig) {
+			if err := c.Config.ApplyRemote(settings); err != nil {
+				c.Logger.Warn("Config pushed by backend rejected", "error", err)
+			}
+		})
+		c.AgentHandler.SetChannel(c.Channel)
+	}
+}
+
+// initScheduler prepares the local runs of recurring monitors, the cached
+// manifest lets them run even when the agent starts without a backend
+func (c *Container) initScheduler(cfg *config.Config) {
+	if !cfg.Scheduler.Enabled {
+		return
+	}
+
+	offlineAfter := cfg.Scheduler.OfflineAfter
+	c.Scheduler = scheduler.New(c.TaskHandler, c.ResultSpool, scheduler.Config{
+		CacheFile:     cfg.Scheduler.CacheFile,
+		MaxConcurrent: cfg.Scheduler.MaxConcurrent,
+		Online: func() bool {
+			return c.backendOnline(offlineAfter)
+		},
+		AgentID: c.APIClient.GetAgentID,
+	}, c.Logger)
+
+	if err := c.Scheduler.Load(); err != nil {
+		c.Logger.Warn("Failed to load cached monitor manifest", "error", err, "path", cfg.Scheduler.CacheFile)
+	}
+
+	if c.Channel != nil {
+		c.Channel.OnManifest(c.Scheduler.Update)
+	}
+}
+
+// backendOnline reports whether a heartbeat was accepted recently, before
+// the first heartbeat the start of the agent counts instead
+func (c *Container) backendOnline(offlineAfter time.Duration) bool {
+	last, err := c.APIClient.LastHeartbeat()
+	if err == nil {
+		return true
+	}
+	if last.IsZero() {
+		last = c.startedAt
+	}
+	return time.Since(last) < offlineAfter
+}
+
+func (c *Container) initMetrics() {
+	c.APIClient.SetMetrics(c.Metrics)
+	c.TaskHandler.SetMetrics(c.Metrics)
+
+	c.Metrics.RegisterGauge("active_jobs", "Tasks being executed.", func() float64 {
+		return float64(c.AgentHandler.ActiveJobs())
+	})
+	c.Metrics.RegisterGauge("max_jobs", "Size of the worker pool.", func() float64 {
+		return float64(c.AgentHandler.SystemLoad().MaxJobs)
+	})
+	c.Metrics.RegisterGauge("spool_depth", "Results waiting in the local spool.", func() float64 {
+		return float64(c.AgentHandler.SpoolDepth())
+	})
+	c.Metrics.RegisterGauge("circuit_breaker_open", "1 while the result submission circuit breaker is open.", func() float64 {
+		if c.APIClient.CircuitState() == "open" {
+			return 1
+		}
+		return 0
+	})
+	c.Metrics.RegisterGauge("channel_connected", "1 while the push channel to the backend is open.", func() float64 {
+		if c.Channel != nil && c.Channel.Connected() {
+			return 1
+		}
+		return 0
+	})
+	c.Metrics.RegisterGauge("last_heartbeat_timestamp_seconds", "Time of the last accepted heartbeat.", func() float64 {
+		last, _ := c.APIClient.LastHeartbeat()
+		if last.IsZero() {
+			return 0
+		}
+		return float64(last.Unix())
+	})
+}
+
+// watchConfig applies live reloads of the config file to the components
+func (c *Container) watchConfig() {
+	c.Config.OnChange(func(cfg *config.Config) {
+		if os.Getenv("DEBUG") != "true" {
+			c.LogLevel.Set(cfg.Logging.SlogLevel())
+		}
+		before := c.TaskRunner.Enabled()
+		c.TaskRunner.SetEnabled(c.activeCheckTypes(cfg))
+		// Otherwise the backend keeps routing the disabled types to the agent
+		if !slices.Equal(before, c.TaskRunner.Enabled()) {
+			go c.reportChangedCapabilities()
+		}
+		c.TaskHandler.SetDefaults(runnerDefaults(cfg))
+		c.AgentHandler.SetTypeLimits(typeLimits(cfg))
+		c.AgentHandler.SetDrainTimeout(cfg.Shutdown.GracePeriod)
+	})
+	c.Config.Watch()
+}
+
+func enabledCheckTypes(cfg *config.Config) []domain.CheckType {
+	checkTypes := make([]domain.CheckType, 0, len(cfg.Runners.Enabled))
+	for _, name := range cfg.Runners.Enabled {
+		checkTypes = append(checkTypes, domain.CheckType(name))
+	}
+	return checkTypes
+}
+
+// activeCheckTypes keeps the enabled runners that passed the self-probes
+func (c *Container) activeCheckTypes(cfg *config.Config) []domain.CheckType {
+	enabled := enabledCheckTypes(cfg)
+	if c.Prober == nil {
+		return enabled
+	}
+
+	active := make([]domain.CheckType, 0, len(enabled))
+	for _, checkType := range enabled {
+		if c.Prober.Supports(checkType) {
+			active = append(active, checkType)
+		}
+	}
+	return active
+}
+
+// probeCapabilities runs the self-probes and limits the runners to the
+// check types that work in this environment
+func (c *Container) probeCapabilities(ctx context.Context) {
+	c.Prober.Run(ctx)
+
+	enabled := enabledCheckTypes(c.Config.Current())
+	active := c.activeCheckTypes(c.Config.Current())
+	c.TaskRunner.SetEnabled(active)
+
+	if len(active) < len(enabled) {
+		c.Logger.Warn("Some enabled runners failed the capability probes and are not advertised",
+			"enabled", enabled,
+			"active", active,
+		)
+	}
+}
+
+// reportCapabilities sends the active runners and the probed environment
+// to the backend
+func (c *Container) reportCapabilities(ctx context.Context) error {
+	environment, ok := c.Prober.Last()
+	if !ok {
+		return nil
+	}
+	return c.APIClient.UpdateCapabilities(ctx, c.TaskRunner.Enabled(), environment)
+}
+
+// reportChangedCapabilities reports the runners enabled by a config change,
+// listeners must not block on the backend
+func (c *Container) reportChangedCapabilities() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	c.TaskRunner = runner.NewFactory(httpRunner, pingRunner, dnsRunner, tcpRunner)
+	if err := c.reportCapabilities(ctx); err != nil {
+		c.Logger.Warn("Failed to report capabilities", "error", err)
+		return
+	}
+	c.Logger.Info("Capabilities reported after config change", "enabled", c.TaskRunner.Enabled())
 }
 
-func (c *Container) initHandlers() {
-	c.TaskHandler = handler.NewTaskHandler(c.TaskRunner, c.Logger)
-	c.AgentHandler = handler.NewAgentHandler(c.Logger, c.APIClient, c.TaskHandler)
+func runnerDefaults(cfg *config.Config) map[domain.CheckType]map[string]interface{} {
+	defaults := make(map[domain.CheckType]map[string]interface{}, len(cfg.Runners.Defaults))
+	for name, options := range cfg.Runners.Defaults {
+		defaults[domain.CheckType(name)] = options
+	}
+	return defaults
 }
 
-func initAgentMetadata() (domain.AgentMetadata, error) {
-	hostname, _ := os.Hostname()
+func typeLimits(cfg *config.Config) map[domain.CheckType]int {
+	limits := make(map[domain.CheckType]int, len(cfg.Concurrency.TypeLimits))
+	for name, limit := range cfg.Concurrency.TypeLimits {
+		limits[domain.CheckType(name)] = limit
+	}
+	return limits
+}
+
+func (c *Container) initAgentMetadata() domain.AgentMetadata {
+	metadata := c.SysInfo.Metadata()
+
+	// Behind NAT the detected address is not the one the backend sees
+	if ip := getEnv("AGENT_IP", ""); ip != "" {
+		metadata.IPAddress = ip
+	}
+
+	return metadata
+}
 
-	return domain.AgentMetadata{
-		IPAddress: getEnv("AGENT_IP", "127.0.0.1"),
-		Hostname:  hostname,
-		OS:        getEnv("OS", "unknown"),
-		Arch:      getEnv("ARCH", "unknown"),
-		CPUCount:  getCPUCount(),
-		MemoryMB:  getTotalMemory(),
-		GoVersion: getGoVersion(),
-	}, nil
+// currentLoad combines host utilisation with the worker pool counters
+func (c *Container) currentLoad() domain.SystemLoad {
+	load := c.SysInfo.Load()
+	jobs := c.AgentHandler.SystemLoad()
+	load.ActiveJobs = jobs.ActiveJobs
+	load.MaxJobs = jobs.MaxJobs
+	load.SpoolDepth = jobs.SpoolDepth
+	return load
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	client "NetScan/internal/agent/clients"
+	"NetScan/internal/agent/domain"
+	"NetScan/internal/agent/state"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ensureIdentity reuses the credentials saved by a previous run and registers
+// the agent only when the backend rejects them
+func ensureIdentity(ctx context.Context, container *Container, agent *domain.Agent) error {
+	cfg := container.Config.Current()
+	apiClient := container.APIClient
+	store := state.NewStore(cfg.Agent.StateFile)
+
+	saved, err := store.Load()
+	if err != nil {
+		logger.Warn("Failed to load agent state, registering again", "error", err, "path", store.Path())
+	}
+
+	switch {
+	case saved != nil && saved.BackendURL == cfg.Backend.URL:
+		apiClient.SetCredentials(saved.AgentID, saved.Token)
+	case saved != nil:
+		logger.Info("Saved agent state belongs to another backend, ignoring it",
+			"saved_backend", saved.BackendURL,
+			"backend", cfg.Backend.URL,
+		)
+	}
+
+	if apiClient.GetToken() != "" {
+		err := apiClient.Authenticate(ctx)
+		if err == nil {
+			logger.Info("Agent authenticated with saved credentials", "agent_id", apiClient.GetAgentID())
+			return nil
+		}
+		if !errors.Is(err, client.ErrNotRegistered) {
+			// cached monitors keep running until the backend is reachable again
+			if container.Scheduler != nil && len(container.Scheduler.Manifest().Monitors) > 0 {
+				logger.Warn("Backend unreachable, starting with saved credentials to run cached monitors",
+					"error", err,
+					"agent_id", apiClient.GetAgentID(),
+				)
+				return nil
+			}
+			return fmt.Errorf("agent authentication failed: %w", err)
+		}
+		logger.Warn("Saved agent credentials were rejected, registering again", "agent_id", apiClient.GetAgentID())
+	}
+
+	logger.Info("Registering agent", "name", agent.Name, "location", agent.Location)
+
+	if err := apiClient.RegisterAgent(ctx, agent); err != nil {
+		return fmt.Errorf("agent registration failed: %w", err)
+	}
+
+	err = store.Save(&state.State{
+		AgentID:      apiClient.GetAgentID(),
+		Token:        apiClient.GetToken(),
+		BackendURL:   cfg.Backend.URL,
+		RegisteredAt: time.Now(),
+	})
+	if err != nil {
+		// The agent keeps working, the next start registers it again
+		logger.Error("Failed to save agent state", "error", err, "path", store.Path())
+	}
+
+	logger.Info("Agent registered successfully", "agent_id", apiClient.GetAgentID())
+	return nil
+}
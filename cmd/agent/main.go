@@ -11,7 +11,10 @@ import (
 	"time"
 
 	client "NetScan/internal/agent/clients"
+	"NetScan/internal/agent/config"
 	"NetScan/internal/agent/domain"
+	"NetScan/internal/agent/status"
+	"NetScan/internal/agent/sysinfo"
 )
 
 var (
@@ -19,17 +22,56 @@ var (
 	logger      *slog.Logger
 	shutdownCtx context.Context
 	cancelFunc  context.CancelFunc
+
+	// serviceCtx keeps the channel, heartbeats and the spool running while
+	// in-flight tasks are drained after shutdownCtx is cancelled
+	serviceCtx    context.Context
+	cancelService context.CancelFunc
+	drained       = make(chan struct{})
 )
 
+// shutdownTimeout bounds the wait for goroutines on top of the grace period
+const shutdownTimeout = 30 * time.Second
+
+const usage = `Usage:
+  agent [run]            start the agent daemon
+  agent check [flags]    execute a single check locally and print the result
+  agent help             show this help
+
+Run "agent check -h" for the check flags.
+`
+
 func main() {
+	command := "run"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "run":
+		runDaemon()
+	case "check":
+		os.Exit(runCheck(os.Args[2:]))
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(exitUsage)
+	}
+}
+
+func runDaemon() {
 	shutdownCtx, cancelFunc = context.WithCancel(context.Background())
+	serviceCtx, cancelService = context.WithCancel(context.Background())
 	// УБРАНО: defer cancelFunc() - это вызывало immediate shutdown
 
 	setupSignalHandling()
 
-	if err := run(); err != nil {
+	container, err := run()
+	if err != nil {
 		logger.Error("Failed to start agent", "error", err)
 		cancelFunc()
+		cancelService()
 		os.Exit(1)
 	}
 
@@ -38,45 +80,66 @@ func main() {
 	<-shutdownCtx.Done()
 
 	// Теперь останавливаем
-	stop()
+	stop(container)
 	cancelFunc()
 }
 
-func run() error {
-	container := GetContainer()
-	logger = container.Logger
-
-	agentMetadata, err := initAgentMetadata()
+func run() (*Container, error) {
+	container, err := GetContainer()
 	if err != nil {
-		return fmt.Errorf("failed to init agent metadata: %w", err)
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		return nil, fmt.Errorf("failed to init agent: %w", err)
 	}
+	logger = container.Logger
+	cfg := container.Config.Current()
+
+	agentMetadata := container.initAgentMetadata()
+
+	// Only check types that work in this environment are advertised
+	container.probeCapabilities(shutdownCtx)
 
 	// Creation of the test agent
 	agent := domain.NewAgent(
-		getEnv("AGENT_NAME", "net-scan-agent"),
-		getEnv("AGENT_LOCATION", "unknown"),
-		getEnv("REGISTRATION_TOKEN", ""),
+		cfg.Agent.Name,
+		cfg.Agent.Location,
+		cfg.Backend.RegistrationToken,
 	)
 	agent.UpdateMetadata(agentMetadata)
+	agent.Capabilities = container.TaskRunner.Enabled()
+	agent.Fingerprint = sysinfo.Fingerprint()
+	agent.Labels = cfg.Agent.Labels
 
-	// Registration of the agent
-	baseURL := getEnv("BACKEND_URL", "http://localhost:8080")
+	// Registration of the agent, skipped when saved credentials are still valid
 	apiClient := container.APIClient
 
-	logger.Info("Registering agent", "name", agent.Name, "location", agent.Location)
-
-	if err := apiClient.RegisterAgent(shutdownCtx, agent); err != nil {
-		return fmt.Errorf("agent registration failed: %w", err)
+	if err := ensureIdentity(shutdownCtx, container, agent); err != nil {
+		return nil, err
 	}
 
-	logger.Info("Agent registered successfully", "agent_id", apiClient.GetAgentID())
+	// A reused host record keeps the capabilities of the previous run
+	if err := container.reportCapabilities(shutdownCtx); err != nil {
+		logger.Warn("Failed to report capabilities", "error", err)
+	}
 
 	// ИСПРАВЛЕНИЕ: используем handler'ы из контейнера
-	wg.Add(3)
+	wg.Add(5)
+
+	// Push channel for tasks, polling is used while it is down
+	if container.Channel != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info("Starting agent channel")
+			container.Channel.Run(serviceCtx)
+			logger.Info("Agent channel stopped")
+		}()
+	}
 
-	// Main loop of tasks processing
+	// Main loop of tasks processing, it stops fetching on shutdown and
+	// drains the running tasks
 	go func() {
 		defer wg.Done()
+		defer close(drained)
 		logger.Info("Starting task processing loop")
 		container.AgentHandler.Run(shutdownCtx) // <- используем из контейнера
 		logger.Info("Task processing loop stopped")
@@ -86,33 +149,80 @@ func run() error {
 	go func() {
 		defer wg.Done()
 		logger.Info("Starting heartbeat loop")
-		runHeartbeatLoop(shutdownCtx, apiClient)
+		runHeartbeatLoop(serviceCtx, apiClient, container)
 		logger.Info("Heartbeat loop stopped")
 	}()
 
-	// Health check loop (for agent monitoring)
+	// Replay of results that could not be submitted
 	go func() {
 		defer wg.Done()
-		logger.Info("Starting health check loop")
-		runHealthCheckLoop(shutdownCtx)
-		logger.Info("Health check loop stopped")
+		logger.Info("Starting result spool replay loop")
+		container.AgentHandler.ReplaySpool(serviceCtx, cfg.Spool.ReplayInterval)
+		logger.Info("Result spool replay loop stopped")
+	}()
+
+	// Periodic capability probes
+	go func() {
+		defer wg.Done()
+		runProbeLoop(shutdownCtx, container)
+	}()
+
+	// Recurring monitors run locally while the backend is unreachable, the
+	// results go to the spool and are replayed once it returns
+	if container.Scheduler != nil {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			logger.Info("Starting monitor scheduler")
+			container.Scheduler.Run(shutdownCtx)
+			logger.Info("Monitor scheduler stopped")
+		}()
+		go func() {
+			defer wg.Done()
+			runManifestLoop(shutdownCtx, container)
+		}()
+	}
+
+	// Local health, status and metrics endpoints
+	go func() {
+		defer wg.Done()
+		if cfg.Status.Listen == "" {
+			return
+		}
+		statusServer := status.NewServer(cfg.Status.Listen, apiClient, container.Channel,
+			container.AgentHandler, container.Metrics, logger)
+		if err := statusServer.Run(serviceCtx); err != nil {
+			logger.Error("Status server failed", "error", err)
+		}
 	}()
 
 	logger.Info("Agent service fully initialized and running",
 		"agent_id", apiClient.GetAgentID(),
-		"backend", baseURL,
+		"backend", cfg.Backend.URL,
 	)
 
 	<-shutdownCtx.Done()
 	logger.Info("Run: Shutdown signal received")
 
-	return nil
+	return container, nil
 }
 
-func stop() {
+// stop waits for the task loop to drain, then stops the remaining loops and
+// tells the backend that the agent is offline
+func stop(container *Container) {
 	logger.Info("Shutting down agent service...")
 
-	// Уже отменяем в main, поэтому здесь только ждем завершения горутин
+	// The task loop enforces the grace period itself, the timeout here only
+	// guards against a hung runner
+	gracePeriod := container.Config.Current().Shutdown.GracePeriod
+	select {
+	case <-drained:
+	case <-time.After(gracePeriod + shutdownTimeout):
+		logger.Warn("Task drain timed out")
+	}
+
+	cancelService()
+
 	done := make(chan struct{})
 	go func() {
 		wg.Wait()
@@ -122,9 +232,25 @@ func stop() {
 	select {
 	case <-done:
 		logger.Info("Agent service stopped gracefully")
-	case <-time.After(30 * time.Second):
+	case <-time.After(shutdownTimeout):
 		logger.Warn("Agent service shutdown timed out - forcing exit")
 	}
+
+	// Sent last so that no heartbeat marks the agent online again
+	notifyOffline(container.APIClient)
+}
+
+// notifyOffline lets the backend requeue tasks still assigned to the agent
+// instead of waiting for their leases to expire
+func notifyOffline(apiClient *client.APIClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := apiClient.GoOffline(ctx, domain.NackShuttingDown); err != nil {
+		logger.Warn("Failed to notify backend about shutdown", "error", err)
+		return
+	}
+	logger.Info("Backend notified about shutdown")
 }
 
 func setupSignalHandling() {
@@ -133,31 +259,67 @@ func setupSignalHandling() {
 
 	go func() {
 		sig := <-sigChan
-		logger.Info("Received signal, initiating shutdown", "signal", sig)
+		logger.Info("Received signal, draining tasks before shutdown", "signal", sig)
 		cancelFunc()
+
+		// A second signal skips the drain
+		sig = <-sigChan
+		logger.Warn("Received second signal, exiting immediately", "signal", sig)
+		os.Exit(1)
 	}()
 }
 
-func runHeartbeatLoop(ctx context.Context, apiClient *client.APIClient) {
-	ticker := time.NewTicker(30 * time.Second)
+func runHeartbeatLoop(ctx context.Context, apiClient *client.APIClient, container *Container) {
+	configManager := container.Config
+	interval := configManager.Current().Heartbeat.Interval
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	intervalChanged := make(chan time.Duration, 1)
+	configManager.OnChange(func(cfg *config.Config) {
+		// Keep only the latest interval if the loop has not picked up the previous one
+		select {
+		case <-intervalChanged:
+		default:
+		}
+		intervalChanged <- cfg.Heartbeat.Interval
+	})
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case next := <-intervalChanged:
+			if next != interval {
+				interval = next
+				ticker.Reset(interval)
+				logger.Info("Heartbeat interval changed", "interval", interval)
+			}
 		case <-ticker.C:
-			if err := apiClient.SendHeartbeat(ctx); err != nil {
+			cancelled, err := apiClient.SendHeartbeat(ctx, container.currentLoad())
+			if err != nil {
 				logger.Warn("Heartbeat failed", "error", err)
-			} else {
-				logger.Debug("Heartbeat sent successfully")
+				continue
+			}
+			logger.Debug("Heartbeat sent successfully")
+
+			// Polling agents learn about cancellations only from the heartbeat
+			for _, taskID := range cancelled {
+				container.AgentHandler.CancelTask(taskID)
 			}
 		}
 	}
 }
 
-func runHealthCheckLoop(ctx context.Context) {
-	ticker := time.NewTicker(60 * time.Second)
+// runProbeLoop repeats the capability probes so that a lost route or a
+// changed firewall is reflected in the advertised capabilities
+func runProbeLoop(ctx context.Context, container *Container) {
+	interval := container.Config.Current().Probes.Interval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -165,13 +327,37 @@ func runHealthCheckLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			monitorAgentHealth()
+			container.probeCapabilities(ctx)
+			if err := container.reportCapabilities(ctx); err != nil {
+				logger.Warn("Failed to report capabilities", "error", err)
+			}
 		}
 	}
 }
 
-func monitorAgentHealth() {
-	logger.Debug("Agent health check completed")
+// runManifestLoop keeps the cached monitor manifest in sync with the
+// backend, pushes over the channel arrive in between
+func runManifestLoop(ctx context.Context, container *Container) {
+	cfg := container.Config.Current().Scheduler
+	ticker := time.NewTicker(cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if container.backendOnline(cfg.OfflineAfter) {
+			manifest, err := container.APIClient.FetchManifest(ctx)
+			if err != nil {
+				logger.Warn("Failed to fetch monitor manifest", "error", err)
+			} else {
+				container.Scheduler.Update(manifest)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func getEnv(key, defaultValue string) string {
@@ -180,15 +366,3 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
-
-func getCPUCount() int {
-	return 1
-}
-
-func getTotalMemory() int64 {
-	return 1024
-}
-
-func getGoVersion() string {
-	return "1.21"
-}
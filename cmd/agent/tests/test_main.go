@@ -135,10 +135,11 @@ func TestAgentStandalone() {
 	pingRunner := runner.NewPingRunner()
 	dnsRunner := runner.NewDNSRunner()
 	tcpRunner := runner.NewTCPRunner()
+	mtrRunner := runner.NewMTRRunner()
 
-	factory := runner.NewFactory(httpRunner, pingRunner, dnsRunner, tcpRunner)
-	taskHandler := handler.NewTaskHandler(factory, logger)
-	agentHandler := handler.NewAgentHandler(logger, apiClient, taskHandler)
+	factory := runner.NewFactory(httpRunner, pingRunner, dnsRunner, tcpRunner, mtrRunner)
+	taskHandler := handler.NewTaskHandler(factory, apiClient, logger)
+	agentHandler := handler.NewAgentHandler(logger, apiClient, taskHandler, nil, handler.PoolConfig{Workers: 2})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -303,6 +304,7 @@ func createRunnerFactory() *runner.Factory {
 		runner.NewPingRunner(),
 		runner.NewDNSRunner(),
 		runner.NewTCPRunner(),
+		runner.NewMTRRunner(),
 	)
 }
 
@@ -326,6 +328,9 @@ func printKeyMetrics(checkType domain.CheckType, result map[string]interface{})
 	case domain.TCPCheck:
 		fmt.Printf("   Port Open: %v, Connect Time: %vms\n",
 			result["port_open"], result["connect_time"])
+	case domain.MTRCheck:
+		fmt.Printf("   Hops: %v, Destination Reached: %v\n",
+			result["hop_count"], result["destination_reached"])
 	}
 }
 
@@ -39,7 +39,7 @@ func main() {
 	// Создаем контейнер зависимостей
 	container, err := dependencies.NewContainer(ctx, cfg, log)
 	if err != nil {
-		log.Error("Failed to create dependency container", err)
+		log.Error("Failed to create dependency container", "error", err)
 		os.Exit(1)
 	}
 	defer container.Close()
@@ -48,12 +48,25 @@ func main() {
 	srv := server.New(&server.Config{
 		Port: cfg.Server.Port,
 		Mode: cfg.Server.Mode,
+		TLS:  cfg.Server.TLS,
 	}, container)
 
+	// Задачи общей очереди прежних версий переносим в очереди их типов
+	if _, err := container.QueueService.MigrateLegacyQueue(ctx); err != nil {
+		log.Error("Failed to migrate legacy task queue", "error", err)
+	}
+
+	// Фоновые задачи: возврат в очередь задач с истекшей арендой
+	// и запуск регулярных проверок по расписанию
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go container.QueueService.RunLeaseReaper(backgroundCtx, 15*time.Second)
+	go container.MonitorService.RunScheduler(backgroundCtx, time.Second)
+
 	// Запускаем сервер в горутине
 	go func() {
 		if err := srv.Start(); err != nil {
-			log.Error("Server failed to start", err)
+			log.Error("Server failed to start", "error", err)
 			os.Exit(1)
 		}
 	}()
@@ -64,11 +77,13 @@ func main() {
 	<-quit
 
 	// Graceful shutdown
+	stopBackground()
+
 	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Error("Server shutdown failed: %s", err)
+		log.Error("Server shutdown failed", "error", err)
 		os.Exit(1)
 	}
 
@@ -7,6 +7,16 @@ CREATE TABLE IF NOT EXISTS agents (
     status VARCHAR(20) DEFAULT 'offline',
     capabilities TEXT[],
     last_heartbeat TIMESTAMP,
+    load INTEGER NOT NULL DEFAULT 0,
+    active_jobs INTEGER NOT NULL DEFAULT 0,
+    max_jobs INTEGER NOT NULL DEFAULT 0,
+    spool_depth INTEGER NOT NULL DEFAULT 0,
+    metadata JSONB,
+    system_load JSONB,
+    environment JSONB,
+    fingerprint VARCHAR(128) UNIQUE,
+    geo JSONB,
+    labels JSONB,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
@@ -17,6 +27,26 @@ CREATE TABLE IF NOT EXISTS checks (
     type VARCHAR(20) NOT NULL,
     target VARCHAR(500) NOT NULL,
     status VARCHAR(20) DEFAULT 'pending',
+    monitor_id UUID,
+    options JSONB,
+    selector JSONB,
+    target_agents TEXT[],
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+-- Создание таблицы регулярных проверок
+CREATE TABLE IF NOT EXISTS monitors (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    type VARCHAR(20) NOT NULL,
+    target VARCHAR(500) NOT NULL,
+    options JSONB,
+    interval_seconds INTEGER NOT NULL,
+    selector JSONB,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
@@ -30,14 +60,22 @@ CREATE TABLE IF NOT EXISTS check_results (
     data JSONB,
     error TEXT,
     duration FLOAT,
+    result_id UUID UNIQUE,
+    outcome VARCHAR(20) NOT NULL DEFAULT 'success',
+    error_code VARCHAR(64),
+    error_phase VARCHAR(32),
+    geo JSONB,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
 
 -- Индексы для оптимизации
 CREATE INDEX IF NOT EXISTS idx_checks_status ON checks(status);
 CREATE INDEX IF NOT EXISTS idx_checks_created_at ON checks(created_at);
+CREATE INDEX IF NOT EXISTS idx_checks_monitor_id ON checks(monitor_id) WHERE monitor_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_monitors_next_run_at ON monitors(next_run_at) WHERE enabled;
 CREATE INDEX IF NOT EXISTS idx_check_results_check_id ON check_results(check_id);
 CREATE INDEX IF NOT EXISTS idx_check_results_agent_id ON check_results(agent_id);
+CREATE INDEX IF NOT EXISTS idx_check_results_error_code ON check_results(error_code) WHERE error_code IS NOT NULL;
 CREATE INDEX IF NOT EXISTS idx_agents_status ON agents(status);
 CREATE INDEX IF NOT EXISTS idx_agents_location ON agents(location);
 
@@ -64,7 +102,10 @@ CREATE TABLE IF NOT EXISTS agent_tasks (
     check_id UUID NOT NULL REFERENCES checks(id) ON DELETE CASCADE,
     task_data JSONB NOT NULL,
     taken_at TIMESTAMP NOT NULL,
-    status VARCHAR(20) DEFAULT 'processing',
+    status VARCHAR(20) DEFAULT 'assigned',
+    attempt INTEGER NOT NULL DEFAULT 0,
+    reason TEXT,
+    acked_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 
     UNIQUE(agent_id, check_id)
@@ -0,0 +1,99 @@
+-- Миграции для уже развернутых баз (init.sql применяется только к пустой базе)
+
+-- Нагрузка пула воркеров агента из heartbeat
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS load INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS active_jobs INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS max_jobs INTEGER NOT NULL DEFAULT 0;
+
+-- Сведения о хосте агента: metadata при регистрации, system_load из heartbeat
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS metadata JSONB;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS system_load JSONB;
+
+-- Отпечаток хоста для повторной регистрации агента без создания новой записи
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(128);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agents_fingerprint ON agents(fingerprint);
+
+-- Спул результатов агента: ключ идемпотентности и глубина очереди в heartbeat
+ALTER TABLE check_results ADD COLUMN IF NOT EXISTS result_id UUID;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_check_results_result_id ON check_results(result_id);
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS spool_depth INTEGER NOT NULL DEFAULT 0;
+
+-- Протокол аренды задач: состояние выдачи, номер попытки и причина nack
+ALTER TABLE agent_tasks ALTER COLUMN status SET DEFAULT 'assigned';
+ALTER TABLE agent_tasks ADD COLUMN IF NOT EXISTS attempt INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE agent_tasks ADD COLUMN IF NOT EXISTS reason TEXT;
+ALTER TABLE agent_tasks ADD COLUMN IF NOT EXISTS acked_at TIMESTAMP;
+UPDATE agent_tasks SET status = 'assigned' WHERE status = 'processing';
+
+-- Результаты самопроверки агента: ICMP, IPv6, исходящие DNS и TCP
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS environment JSONB;
+
+-- Исход проверки: таймаут отделен от обычной ошибки
+ALTER TABLE check_results ADD COLUMN IF NOT EXISTS outcome VARCHAR(20) NOT NULL DEFAULT 'success';
+UPDATE check_results SET outcome = 'failure' WHERE NOT success AND outcome = 'success';
+
+-- Классификация ошибок: стабильный код и фаза для группировки по агентам
+ALTER TABLE check_results ADD COLUMN IF NOT EXISTS error_code VARCHAR(64);
+ALTER TABLE check_results ADD COLUMN IF NOT EXISTS error_phase VARCHAR(32);
+CREATE INDEX IF NOT EXISTS idx_check_results_error_code ON check_results(error_code) WHERE error_code IS NOT NULL;
+
+-- Регулярные проверки агента: манифест для работы без бэкенда и связь запусков с проверкой
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS monitors JSONB;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS monitors_version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS monitors_updated_at TIMESTAMP;
+ALTER TABLE checks ADD COLUMN IF NOT EXISTS monitor_id UUID;
+CREATE INDEX IF NOT EXISTS idx_checks_monitor_id ON checks(monitor_id) WHERE monitor_id IS NOT NULL;
+
+-- GeoIP: страна, город и AS агента по адресу регистрации и цели по адресу из результата
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS geo JSONB;
+ALTER TABLE check_results ADD COLUMN IF NOT EXISTS geo JSONB;
+
+-- Опции проверки из API, передаются агенту в задаче
+ALTER TABLE checks ADD COLUMN IF NOT EXISTS options JSONB;
+
+-- Регулярные проверки: отдельная сущность с планировщиком на бэкенде,
+-- манифест агента собирается из них вместо колонок агента
+CREATE TABLE IF NOT EXISTS monitors (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    type VARCHAR(20) NOT NULL,
+    target VARCHAR(500) NOT NULL,
+    options JSONB,
+    interval_seconds INTEGER NOT NULL,
+    agent_ids TEXT[] NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS idx_monitors_next_run_at ON monitors(next_run_at) WHERE enabled;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'agents' AND column_name = 'monitors') THEN
+        INSERT INTO monitors (id, type, target, options, interval_seconds, agent_ids, next_run_at)
+        SELECT (m->>'id')::uuid, m->>'type', m->>'target', m->'options', (m->>'interval_seconds')::int,
+               ARRAY[a.id::text], CURRENT_TIMESTAMP
+        FROM agents a, jsonb_array_elements(a.monitors) m
+        WHERE a.monitors IS NOT NULL
+        ON CONFLICT (id) DO NOTHING;
+    END IF;
+END $$;
+ALTER TABLE agents DROP COLUMN IF EXISTS monitors;
+ALTER TABLE agents DROP COLUMN IF EXISTS monitors_version;
+ALTER TABLE agents DROP COLUMN IF EXISTS monitors_updated_at;
+
+-- Выбор агентов: метки агента, селектор и фактические исполнители проверки,
+-- список агентов регулярной проверки становится селектором
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS labels JSONB;
+ALTER TABLE checks ADD COLUMN IF NOT EXISTS selector JSONB;
+ALTER TABLE checks ADD COLUMN IF NOT EXISTS target_agents TEXT[];
+ALTER TABLE monitors ADD COLUMN IF NOT EXISTS selector JSONB;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'monitors' AND column_name = 'agent_ids') THEN
+        UPDATE monitors SET selector = jsonb_build_object('ids', to_jsonb(agent_ids))
+        WHERE cardinality(agent_ids) > 0 AND selector IS NULL;
+    END IF;
+END $$;
+ALTER TABLE monitors DROP COLUMN IF EXISTS agent_ids;
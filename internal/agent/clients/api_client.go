@@ -2,15 +2,17 @@ package client
 
 import (
 	domain "NetScan/internal/agent/domain"
+	"NetScan/internal/agent/resilience"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
-	"strings"
+	"net/url"
 	"sync"
 	"time"
 )
@@ -20,15 +22,13 @@ type APIClient struct {
 	token      string
 	agentID    string
 	httpClient *http.Client
-	cbState    circuitBreakerState
+	executors  map[string]*resilience.Executor
 	metrics    APIClientMetrics
-}
+	logger     *slog.Logger
 
-type circuitBreakerState struct {
-	failures    int
-	lastFailure time.Time
-	state       string // "closed", "open", "half-open"
-	mutex       sync.RWMutex
+	heartbeatMutex sync.RWMutex
+	lastHeartbeat  time.Time
+	heartbeatError error
 }
 
 type APIClientMetrics interface {
@@ -37,11 +37,35 @@ type APIClientMetrics interface {
 	IncErrorCounter(method string, errorType string)
 }
 
+// SetProxy routes backend requests through the given proxy, nil disables it
+func (c *APIClient) SetProxy(proxyURL *url.URL) {
+	transport := c.httpClient.Transport.(*http.Transport)
+	if proxyURL == nil {
+		transport.Proxy = nil
+		return
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+}
+
+// SetLogger replaces the logger of the client, slog.Default() is used until
+// it is set
+func (c *APIClient) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetTLS replaces the TLS settings of the backend transport, the channel
+// uses the same settings
+func (c *APIClient) SetTLS(config *tls.Config) {
+	transport := c.httpClient.Transport.(*http.Transport)
+	transport.TLSClientConfig = config
+}
+
 func NewAPIClient(baseURL, token, agentID string) *APIClient {
-	return &APIClient{
+	a := &APIClient{
 		baseURL: baseURL,
 		token:   token,
 		agentID: agentID,
+		logger:  slog.Default(),
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 			Transport: &http.Transport{
@@ -57,41 +81,77 @@ func NewAPIClient(baseURL, token, agentID string) *APIClient {
 			},
 		},
 	}
+	a.SetResilience(Resilience{Default: resilience.DefaultPolicy, Policies: DefaultPolicies()})
+	return a
 }
 
 func (a *APIClient) GetAgentID() string {
 	return a.agentID
 }
 
-// FetchTask - From Backend to Agent
-func (a *APIClient) FetchTask(ctx context.Context) (*domain.Task, error) {
-	const maxRetries = 3
-	var lastErr error
+func (a *APIClient) GetToken() string {
+	return a.token
+}
 
-	for i := 0; i < maxRetries; i++ {
-		task, err := a.fetchTaskSingle(ctx)
-		if err == nil {
-			return task, nil
-		}
+// SetCredentials restores the identity saved by a previous run
+func (a *APIClient) SetCredentials(agentID, token string) {
+	a.agentID = agentID
+	a.token = token
+}
 
-		if errors.Is(err, ErrNoTasks) || errors.Is(err, ErrNotRegistered) {
-			return nil, err
-		}
+// Authenticate checks the current token against the backend. ErrNotRegistered
+// means the credentials were rejected and the agent has to register again.
+func (a *APIClient) Authenticate(ctx context.Context) error {
+	if a.token == "" {
+		return ErrNotRegistered
+	}
 
-		lastErr = err
+	body, err := json.Marshal(map[string]string{"token": a.token})
+	if err != nil {
+		return fmt.Errorf("failed to marshal auth request: %w", err)
+	}
 
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-time.After(time.Second * time.Duration(i*i)):
-			continue
-		}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/api/v1/agents/auth", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	return nil, fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.send(ctx, EndpointAuth, req, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusNotFound:
+		return ErrNotRegistered
+	default:
+		return newStatusError("authentication failed", resp)
+	}
+
+	var response struct {
+		Data struct {
+			AgentID string `json:"agent_id"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode auth response: %w", err)
+	}
+
+	if response.Data.AgentID == "" {
+		return fmt.Errorf("server returned empty agent_id")
+	}
+
+	a.agentID = response.Data.AgentID
+	return nil
 }
 
-func (a *APIClient) fetchTaskSingle(ctx context.Context) (*domain.Task, error) {
+// FetchTask - From Backend to Agent
+func (a *APIClient) FetchTask(ctx context.Context) (*domain.Task, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+"/api/v1/tasks/next", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -100,33 +160,28 @@ func (a *APIClient) fetchTaskSingle(ctx context.Context) (*domain.Task, error) {
 	req.Header.Set("Authorization", "Bearer "+a.token)
 	req.Header.Set("X-Agent-ID", a.agentID)
 
-	fmt.Printf("🔐 DEBUG: Auth - Token present: %t, AgentID: %s\n", a.token != "", a.agentID)
-
-	fmt.Printf("🔍 DEBUG: Fetching task from: %s\n", a.baseURL+"/api/v1/tasks/next")
-
-	resp, err := a.httpClient.Do(req)
+	resp, err := a.send(ctx, EndpointFetch, req, nil)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrBackendDown, err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	fmt.Printf("🔍 DEBUG: Task response status: %d\n", resp.StatusCode)
-
 	switch resp.StatusCode {
 	case http.StatusNoContent:
-		fmt.Printf("🔍 DEBUG: No tasks available\n")
 		return nil, ErrNoTasks
 	case http.StatusOK:
 		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("🔍 DEBUG: Raw task response: %s\n", string(body))
 
 		var response struct {
 			Data struct {
 				Task struct {
-					CheckID   string    `json:"check_id"`   // это будет ID задачи
-					Type      string    `json:"type"`       // это соответствует Type
-					Target    string    `json:"target"`     // это соответствует Target
-					CreatedAt time.Time `json:"created_at"` // это соответствует CreatedAt
+					CheckID   string                 `json:"check_id"` // это будет ID задачи
+					Type      string                 `json:"type"`     // это соответствует Type
+					Target    string                 `json:"target"`   // это соответствует Target
+					Options   map[string]interface{} `json:"options"`
+					CreatedAt time.Time              `json:"created_at"` // это соответствует CreatedAt
+					Attempt   int                    `json:"attempt"`
+					TimeoutMs int64                  `json:"timeout_ms"`
 				} `json:"task"`
 			} `json:"data"`
 		}
@@ -135,128 +190,197 @@ func (a *APIClient) fetchTaskSingle(ctx context.Context) (*domain.Task, error) {
 			return nil, fmt.Errorf("failed to decode task response: %w", err)
 		}
 
+		options := response.Data.Task.Options
+		if options == nil {
+			options = make(map[string]interface{})
+		}
+
 		// ПРАВИЛЬНОЕ СОЗДАНИЕ TASK С СООТВЕТСТВИЕМ ПОЛЕЙ
 		task := &domain.Task{
 			ID:        response.Data.Task.CheckID,                // check_id -> ID
 			Type:      domain.CheckType(response.Data.Task.Type), // string -> CheckType
 			Target:    response.Data.Task.Target,                 // target -> Target
-			Options:   make(map[string]interface{}),              // пустые опции по умолчанию
-			CreatedAt: response.Data.Task.CreatedAt,              // created_at -> CreatedAt
-			AgentID:   a.agentID,                                 // добавляем agent_id
+			Options:   options,
+			CreatedAt: response.Data.Task.CreatedAt, // created_at -> CreatedAt
+			AgentID:   a.agentID,                    // добавляем agent_id
+			Attempt:   response.Data.Task.Attempt,
+			Timeout:   time.Duration(response.Data.Task.TimeoutMs) * time.Millisecond,
 		}
 
-		fmt.Printf("🔍 DEBUG: Parsed task: ID=%s, Type=%s, Target=%s, AgentID=%s\n",
-			task.ID, task.Type, task.Target, task.AgentID)
+		a.logger.Debug("Task fetched",
+			"task_id", task.ID,
+			"type", task.Type,
+			"target", task.Target,
+		)
 		return task, nil
 	case http.StatusUnauthorized:
 		return nil, ErrNotRegistered
 	default:
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+		return nil, newStatusError("fetch task", resp)
 	}
 }
 
-// SubmitResult - From Agent to Backend
-func (a *APIClient) SubmitResult(ctx context.Context, result *domain.Result) error {
-	return a.withCircuitBreaker(ctx, func() error {
-		data := result.Data
-		if data == nil {
-			data = make(map[string]interface{})
-		}
+// AckTask confirms that the agent has taken the task. ErrLeaseLost means the
+// backend has already given the task to someone else and it must not run.
+func (a *APIClient) AckTask(ctx context.Context, taskID string) error {
+	return a.postTaskLease(ctx, taskID, "ack", nil)
+}
 
-		backendResult := map[string]interface{}{
-			"check_id":   result.TaskID, // task_id -> check_id
-			"agent_id":   result.AgentID,
-			"success":    result.Success,
-			"data":       data,
-			"error":      result.Error,
-			"duration":   result.ResponseTime, // response_time -> duration
-			"created_at": result.Timestamp,    // timestamp -> created_at
-		}
+// NackTask gives the task back to the backend, with retry the backend
+// requeues it for another attempt
+func (a *APIClient) NackTask(ctx context.Context, taskID, reason string, retry bool) error {
+	return a.postTaskLease(ctx, taskID, "nack", map[string]interface{}{
+		"reason": reason,
+		"retry":  retry,
+	})
+}
 
-		body, err := json.Marshal(backendResult)
+func (a *APIClient) postTaskLease(ctx context.Context, taskID, action string, payload map[string]interface{}) error {
+	var data []byte
+	if payload != nil {
+		var err error
+		data, err = json.Marshal(payload)
 		if err != nil {
-			return fmt.Errorf("failed to marshal result: %w", err)
+			return fmt.Errorf("failed to marshal %s request: %w", action, err)
 		}
+	}
 
-		fmt.Printf("🔍 DEBUG: Submitting result body: %s\n", string(body))
-		fmt.Printf("🔍 DEBUG: Submitting result to: %s\n", a.baseURL+"/api/v1/results/"+result.TaskID)
-
-		req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/api/v1/results/"+result.TaskID, bytes.NewReader(body))
-		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
-		}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/api/v1/tasks/"+taskID+"/"+action, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
 
-		req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.agentID)
+	if payload != nil {
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-Agent-ID", a.agentID)
+	}
 
-		resp, err := a.httpClient.Do(req)
-		if err != nil {
-			return fmt.Errorf("%w: %v", ErrBackendDown, err)
-		}
-		defer resp.Body.Close()
+	resp, err := a.send(ctx, EndpointLease, req, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-		if resp.StatusCode >= 400 {
-			errorBody, _ := io.ReadAll(resp.Body)
-			fmt.Printf("🔍 DEBUG: Result submission error response: %s\n", string(errorBody))
-		} else {
-			fmt.Printf("✅ DEBUG: Result submitted successfully!\n")
-		}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrLeaseLost
+	case http.StatusUnauthorized:
+		return ErrNotRegistered
+	default:
+		return newStatusError(action+" failed", resp)
+	}
+}
 
-		switch resp.StatusCode {
-		case http.StatusOK, http.StatusCreated:
-			return nil
-		case http.StatusUnauthorized:
-			return fmt.Errorf("%w: invalid token", ErrNotRegistered)
-		case http.StatusTooManyRequests:
-			return fmt.Errorf("rate limit exceeded")
-		case http.StatusRequestTimeout:
-			return fmt.Errorf("request timeout")
-		case http.StatusServiceUnavailable:
-			return fmt.Errorf("service temporarily unavailable")
-		default:
-			var errorResp struct {
-				Error string `json:"error"`
-			}
-			if err := json.NewDecoder(resp.Body).Decode(&errorResp); err == nil && errorResp.Error != "" {
-				return fmt.Errorf("submit failed %d: %s", resp.StatusCode, errorResp.Error)
-			}
-			return fmt.Errorf("submit failed with status %d", resp.StatusCode)
+// SubmitResult - From Agent to Backend
+func (a *APIClient) SubmitResult(ctx context.Context, result *domain.Result) error {
+	data := result.Data
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+
+	backendResult := map[string]interface{}{
+		"result_id":  result.ResultID,
+		"check_id":   result.TaskID, // task_id -> check_id
+		"agent_id":   result.AgentID,
+		"success":    result.Success,
+		"data":       data,
+		"error":      result.Error,
+		"duration":   result.DurationSeconds(), // response_time -> duration
+		"created_at": result.Timestamp,         // timestamp -> created_at
+		"outcome":    result.Outcome,
+		"failure":    result.Failure,
+	}
+
+	body, err := json.Marshal(backendResult)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	// results of local runs have no check on the backend yet
+	resultURL := a.baseURL + "/api/v1/results/" + result.TaskID
+	if result.MonitorID != "" {
+		resultURL = a.baseURL + "/api/v1/agents/monitors/" + result.MonitorID + "/results"
+	}
+
+	a.logger.Debug("Submitting result",
+		"task_id", result.TaskID,
+		"url", resultURL,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", resultURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent-ID", a.agentID)
+
+	resp, err := a.send(ctx, EndpointSubmit, req, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusUnauthorized:
+		return fmt.Errorf("%w: invalid token", ErrNotRegistered)
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity:
+		// Retrying will not help, the result must not be spooled
+		return fmt.Errorf("%w: status %d", ErrResultRejected, resp.StatusCode)
+	default:
+		var errorResp struct {
+			Error string `json:"error"`
 		}
-	})
+		if err := json.NewDecoder(resp.Body).Decode(&errorResp); err == nil && errorResp.Error != "" {
+			return fmt.Errorf("submit failed %d: %s", resp.StatusCode, errorResp.Error)
+		}
+		return fmt.Errorf("submit failed with status %d", resp.StatusCode)
+	}
 }
 
-func (a *APIClient) RegisterAgent(ctx context.Context, agent *domain.Agent) error {
-	const maxRetries = 3
+// SubmitProgress - streaming intermediate results of a running task
+func (a *APIClient) SubmitProgress(ctx context.Context, taskID, stage string, progress float64, data map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	for i := 0; i < maxRetries; i++ {
-		err := a.registerAgentSingle(ctx, agent)
-		if err == nil {
-			return nil
-		}
+	body, err := json.Marshal(map[string]interface{}{
+		"stage":    stage,
+		"progress": progress,
+		"data":     data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal progress: %w", err)
+	}
 
-		if errors.Is(err, context.Canceled) ||
-			strings.Contains(err.Error(), "validation") {
-			return err
-		}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/api/v1/results/"+taskID+"/progress", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create progress request: %w", err)
+	}
 
-		if i < maxRetries-1 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(time.Duration(i+1) * time.Second):
-				continue
-			}
-		}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.agentID)
+	req.Header.Set("Content-Type", "application/json")
 
-		return fmt.Errorf("registration failed after %d attempts: %w", maxRetries, err)
+	resp, err := a.send(ctx, EndpointProgress, req, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("progress", resp)
 	}
 
 	return nil
 }
 
-func (a *APIClient) registerAgentSingle(ctx context.Context, agent *domain.Agent) error {
+func (a *APIClient) RegisterAgent(ctx context.Context, agent *domain.Agent) error {
 	body, err := json.Marshal(agent)
 	if err != nil {
 		return fmt.Errorf("failed to marshal agent: %w", err)
@@ -269,15 +393,14 @@ func (a *APIClient) registerAgentSingle(ctx context.Context, agent *domain.Agent
 
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := a.httpClient.Do(req)
+	resp, err := a.send(ctx, EndpointRegister, req, body)
 	if err != nil {
-		return fmt.Errorf("backend unavailable: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("registration failed: status %d, body: %s", resp.StatusCode, string(body))
+		return newStatusError("registration failed", resp)
 	}
 
 	// ИСПРАВЛЕННАЯ СТРУКТУРА - с data оберткой
@@ -303,79 +426,197 @@ func (a *APIClient) registerAgentSingle(ctx context.Context, agent *domain.Agent
 	a.token = response.Data.Token
 	a.agentID = response.Data.AgentID
 
-	fmt.Printf("✅ DEBUG: Registration successful - AgentID: %s\n", a.agentID)
+	a.logger.Debug("Registration successful", "agent_id", a.agentID)
 
 	return nil
 }
 
-// SendHeartbeat - sending heartbeat for monitoring of the agent activity
-func (a *APIClient) SendHeartbeat(ctx context.Context) error {
+// SendHeartbeat - sending heartbeat for monitoring of the agent activity,
+// returns the IDs of tasks the backend cancelled while they were running
+func (a *APIClient) SendHeartbeat(ctx context.Context, load domain.SystemLoad) ([]string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// ПРАВИЛЬНАЯ структура для бэкенда
 	heartbeatData := map[string]interface{}{
-		"load": 0, // ← обязательное поле типа int от 0 до 100
+		"load":        load.Percent(), // ← обязательное поле типа int от 0 до 100
+		"active_jobs": load.ActiveJobs,
+		"max_jobs":    load.MaxJobs,
+		"spool_depth": load.SpoolDepth,
+		"system":      load,
 	}
 
 	body, err := json.Marshal(heartbeatData)
 	if err != nil {
-		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+		return nil, fmt.Errorf("failed to marshal heartbeat: %w", err)
 	}
 
-	fmt.Printf("🚀 DEBUG: Sending heartbeat with body: %s\n", string(body))
-
 	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/api/v1/agents/heartbeat", bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create heartbeat request: %w", err)
+		return nil, fmt.Errorf("failed to create heartbeat request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+a.token)
 	req.Header.Set("X-Agent-ID", a.agentID)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := a.httpClient.Do(req)
+	resp, err := a.send(ctx, EndpointHeartbeat, req, body)
 	if err != nil {
-		return fmt.Errorf("heartbeat failed: %w", err)
+		err = fmt.Errorf("heartbeat failed: %w", err)
+		a.recordHeartbeat(err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("heartbeat received status %d: %s", resp.StatusCode, string(body))
+		err := newStatusError("heartbeat", resp)
+		a.recordHeartbeat(err)
+		return nil, err
+	}
+
+	a.recordHeartbeat(nil)
+
+	var response struct {
+		Data struct {
+			CancelledTasks []string `json:"cancelled_tasks"`
+		} `json:"data"`
+	}
+
+	// older backends reply without data, there is nothing to cancel then
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil
+	}
+
+	return response.Data.CancelledTasks, nil
+}
+
+// GoOffline tells the backend that the agent is shutting down, tasks still
+// assigned to it are requeued without waiting for their leases to expire
+func (a *APIClient) GoOffline(ctx context.Context, reason string) error {
+	body, err := json.Marshal(map[string]string{"reason": reason})
+	if err != nil {
+		return fmt.Errorf("failed to marshal offline request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/api/v1/agents/offline", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create offline request: %w", err)
 	}
 
-	fmt.Printf("✅ DEBUG: Heartbeat successful\n")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.agentID)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.send(ctx, EndpointOffline, req, body)
+	if err != nil {
+		return fmt.Errorf("offline notification failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("offline", resp)
+	}
 	return nil
 }
 
-func (a *APIClient) withCircuitBreaker(ctx context.Context, operation func() error) error {
-	a.cbState.mutex.RLock()
+// UpdateCapabilities reports the check types that passed the self-probes
+// together with the probed environment
+func (a *APIClient) UpdateCapabilities(ctx context.Context, capabilities []domain.CheckType, environment domain.Environment) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if capabilities == nil {
+		capabilities = []domain.CheckType{}
+	}
 
-	if a.cbState.state == "open" && time.Since(a.cbState.lastFailure) < 30*time.Second {
-		a.cbState.mutex.RUnlock()
-		return fmt.Errorf("circuit breaker open")
+	body, err := json.Marshal(map[string]interface{}{
+		"capabilities": capabilities,
+		"environment":  environment,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal capabilities: %w", err)
 	}
-	a.cbState.mutex.RUnlock()
 
-	err := operation()
+	req, err := http.NewRequestWithContext(ctx, "PUT", a.baseURL+"/api/v1/agents/capabilities", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create capabilities request: %w", err)
+	}
 
-	a.cbState.mutex.Lock()
-	defer a.cbState.mutex.Unlock()
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.agentID)
+	req.Header.Set("Content-Type", "application/json")
 
+	resp, err := a.send(ctx, EndpointCapabilities, req, body)
 	if err != nil {
-		a.cbState.failures++
-		a.cbState.lastFailure = time.Now()
+		return fmt.Errorf("capabilities update failed: %w", err)
+	}
+	defer resp.Body.Close()
 
-		if a.cbState.failures >= 5 {
-			a.cbState.state = "open"
-		}
-	} else {
-		a.cbState.failures = 0
-		a.cbState.state = "closed"
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrNotRegistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("capabilities update", resp)
+	}
+
+	return nil
+}
+
+func (a *APIClient) recordHeartbeat(err error) {
+	a.heartbeatMutex.Lock()
+	defer a.heartbeatMutex.Unlock()
+
+	a.heartbeatError = err
+	if err == nil {
+		a.lastHeartbeat = time.Now()
+	}
+}
+
+// LastHeartbeat returns the time of the last accepted heartbeat and the
+// error of the latest attempt, if it failed
+func (a *APIClient) LastHeartbeat() (time.Time, error) {
+	a.heartbeatMutex.RLock()
+	defer a.heartbeatMutex.RUnlock()
+	return a.lastHeartbeat, a.heartbeatError
+}
+
+// Ping checks that the backend answers its health endpoint
+func (a *APIClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := a.send(ctx, EndpointPing, req, nil)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	return err
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: health status %d", ErrBackendDown, resp.StatusCode)
+	}
+	return nil
+}
+
+// SetMetrics enables collection of request metrics
+func (a *APIClient) SetMetrics(metrics APIClientMetrics) {
+	a.metrics = metrics
+}
+
+// CircuitState returns the state of the circuit breaker around result
+// submission: closed, open or half-open
+func (a *APIClient) CircuitState() string {
+	return string(a.executor(EndpointSubmit).State())
+}
+
+// CircuitStates returns the breaker state of every endpoint
+func (a *APIClient) CircuitStates() map[string]string {
+	states := make(map[string]string, len(a.executors))
+	for name, executor := range a.executors {
+		states[name] = string(executor.State())
+	}
+	return states
 }
 
 func (a *APIClient) doRequestWithMetrics(ctx context.Context, req *http.Request) (*http.Response, error) {
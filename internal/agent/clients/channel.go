@@ -0,0 +1,471 @@
+package client
+
+import (
+	domain "NetScan/internal/agent/domain"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrChannelClosed = errors.New("agent channel is not connected")
+
+const (
+	channelWriteWait    = 10 * time.Second
+	channelPongWait     = 60 * time.Second
+	channelReplyTimeout = 15 * time.Second
+	channelMinBackoff   = time.Second
+	channelMaxBackoff   = 30 * time.Second
+)
+
+type channelMessage struct {
+	Type    string               `json:"type"`
+	ID      string               `json:"id,omitempty"`
+	Task    *channelTask         `json:"task,omitempty"`
+	CheckID string               `json:"check_id,omitempty"`
+	Slots   int                  `json:"slots,omitempty"`
+	Reason  string               `json:"reason,omitempty"`
+	Retry   bool                 `json:"retry,omitempty"`
+	Result  *channelResult       `json:"result,omitempty"`
+	Config  *domain.RemoteConfig `json:"config,omitempty"`
+	Status  string               `json:"status,omitempty"`
+	Error   string               `json:"error,omitempty"`
+
+	Manifest *manifestPayload `json:"manifest,omitempty"`
+}
+
+type channelTask struct {
+	CheckID   string                 `json:"check_id"`
+	Type      string                 `json:"type"`
+	Target    string                 `json:"target"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	Attempt   int                    `json:"attempt,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	TimeoutMs int64                  `json:"timeout_ms,omitempty"`
+}
+
+type channelResult struct {
+	ResultID  string                 `json:"result_id"`
+	Success   bool                   `json:"success"`
+	Data      map[string]interface{} `json:"data"`
+	Error     string                 `json:"error,omitempty"`
+	Duration  float64                `json:"duration"`
+	CreatedAt time.Time              `json:"created_at"`
+	Outcome   string                 `json:"outcome,omitempty"`
+	Failure   *domain.Failure        `json:"failure,omitempty"`
+}
+
+// Channel is a persistent WebSocket connection to the backend. Tasks are
+// pushed over it as soon as they are queued, acks, nacks and results are
+// sent back over the same connection. Callers fall back to the HTTP API
+// whenever a method returns ErrChannelClosed.
+type Channel struct {
+	api    *APIClient
+	logger *slog.Logger
+
+	mutex     sync.Mutex
+	conn      *websocket.Conn
+	down      chan struct{}
+	requested int
+	pending   map[string]chan *channelMessage
+
+	writeMutex sync.Mutex
+	nextID     atomic.Uint64
+	tasks      chan *domain.Task
+
+	onCancel   func(taskID string)
+	onConfig   func(domain.RemoteConfig)
+	onManifest func(*domain.Manifest)
+}
+
+func NewChannel(api *APIClient, logger *slog.Logger) *Channel {
+	down := make(chan struct{})
+	close(down)
+
+	return &Channel{
+		api:     api,
+		logger:  logger,
+		down:    down,
+		pending: make(map[string]chan *channelMessage),
+		tasks:   make(chan *domain.Task, 16),
+	}
+}
+
+// OnCancel registers the handler for task cancellations sent by the backend
+func (c *Channel) OnCancel(handler func(taskID string)) {
+	c.onCancel = handler
+}
+
+// OnConfig registers the handler for settings pushed by the backend
+func (c *Channel) OnConfig(handler func(domain.RemoteConfig)) {
+	c.onConfig = handler
+}
+
+// OnManifest registers the handler for monitor manifests pushed by the backend
+func (c *Channel) OnManifest(handler func(*domain.Manifest)) {
+	c.onManifest = handler
+}
+
+// Connected reports whether the channel is currently open
+func (c *Channel) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn != nil
+}
+
+// Run keeps the channel connected until ctx is cancelled, reconnecting with
+// a growing delay after failures
+func (c *Channel) Run(ctx context.Context) {
+	backoff := channelMinBackoff
+
+	for {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Warn("Agent channel connection failed, using polling",
+				"error", err,
+				"retry_in", backoff,
+			)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > channelMaxBackoff {
+				backoff = channelMaxBackoff
+			}
+			continue
+		}
+
+		backoff = channelMinBackoff
+		c.logger.Info("Agent channel connected")
+
+		c.serve(ctx, conn)
+
+		if ctx.Err() != nil {
+			return
+		}
+		c.logger.Warn("Agent channel disconnected, using polling until it reconnects")
+	}
+}
+
+func (c *Channel) dial(ctx context.Context) (*websocket.Conn, error) {
+	channelURL := c.api.baseURL + "/api/v1/agents/channel"
+	switch {
+	case strings.HasPrefix(channelURL, "https://"):
+		channelURL = "wss://" + strings.TrimPrefix(channelURL, "https://")
+	case strings.HasPrefix(channelURL, "http://"):
+		channelURL = "ws://" + strings.TrimPrefix(channelURL, "http://")
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+	}
+	if transport, ok := c.api.httpClient.Transport.(*http.Transport); ok {
+		dialer.Proxy = transport.Proxy
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+c.api.token)
+	header.Set("X-Agent-ID", c.api.agentID)
+
+	conn, resp, err := dialer.DialContext(ctx, channelURL, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, ErrNotRegistered
+		}
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// serve reads messages until the connection fails or ctx is cancelled
+func (c *Channel) serve(ctx context.Context, conn *websocket.Conn) {
+	c.mutex.Lock()
+	c.conn = conn
+	c.down = make(chan struct{})
+	c.requested = 0
+	c.mutex.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+		conn.Close()
+	})
+	defer stop()
+
+	conn.SetReadDeadline(time.Now().Add(channelPongWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(channelPongWait))
+		c.writeMutex.Lock()
+		defer c.writeMutex.Unlock()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(channelWriteWait))
+	})
+
+	for {
+		var message channelMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			break
+		}
+		c.handle(&message)
+	}
+
+	c.mutex.Lock()
+	c.conn = nil
+	close(c.down)
+	pending := c.pending
+	c.pending = make(map[string]chan *channelMessage)
+	c.mutex.Unlock()
+
+	for _, reply := range pending {
+		close(reply)
+	}
+	conn.Close()
+}
+
+func (c *Channel) handle(message *channelMessage) {
+	switch message.Type {
+	case "task":
+		if message.Task == nil {
+			return
+		}
+
+		c.mutex.Lock()
+		if c.requested > 0 {
+			c.requested--
+		}
+		c.mutex.Unlock()
+
+		options := message.Task.Options
+		if options == nil {
+			options = make(map[string]interface{})
+		}
+
+		c.tasks <- &domain.Task{
+			ID:        message.Task.CheckID,
+			Type:      domain.CheckType(message.Task.Type),
+			Target:    message.Task.Target,
+			Options:   options,
+			CreatedAt: message.Task.CreatedAt,
+			AgentID:   c.api.GetAgentID(),
+			Attempt:   message.Task.Attempt,
+			Timeout:   time.Duration(message.Task.TimeoutMs) * time.Millisecond,
+		}
+
+	case "cancel":
+		if c.onCancel != nil && message.CheckID != "" {
+			c.onCancel(message.CheckID)
+		}
+
+	case "config":
+		if c.onConfig != nil && message.Config != nil {
+			c.onConfig(*message.Config)
+		}
+
+	case "monitors":
+		if c.onManifest != nil && message.Manifest != nil {
+			c.onManifest(message.Manifest.manifest())
+		}
+
+	case "reply":
+		c.mutex.Lock()
+		reply, ok := c.pending[message.ID]
+		delete(c.pending, message.ID)
+		c.mutex.Unlock()
+
+		if ok {
+			reply <- message
+		}
+
+	default:
+		c.logger.Debug("Unknown agent channel message", "type", message.Type)
+	}
+}
+
+// NextTask asks the backend for one task and waits until it is pushed
+func (c *Channel) NextTask(ctx context.Context) (*domain.Task, error) {
+	select {
+	case task := <-c.tasks:
+		return task, nil
+	default:
+	}
+
+	c.mutex.Lock()
+	down := c.down
+	needReady := c.conn != nil && c.requested == 0
+	if needReady {
+		c.requested++
+	}
+	c.mutex.Unlock()
+
+	if needReady {
+		if err := c.write(&channelMessage{Type: "ready", Slots: 1}); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case task := <-c.tasks:
+		return task, nil
+	case <-down:
+		return nil, ErrChannelClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TakePending returns the tasks that were pushed but not picked up by
+// NextTask yet
+func (c *Channel) TakePending() []*domain.Task {
+	var tasks []*domain.Task
+	for {
+		select {
+		case task := <-c.tasks:
+			tasks = append(tasks, task)
+		default:
+			return tasks
+		}
+	}
+}
+
+// AckTask confirms that the task has been taken
+func (c *Channel) AckTask(ctx context.Context, taskID string) error {
+	reply, err := c.request(ctx, &channelMessage{Type: "ack", CheckID: taskID})
+	if err != nil {
+		return err
+	}
+	return replyError(reply, "ack")
+}
+
+// NackTask gives the task back to the backend
+func (c *Channel) NackTask(ctx context.Context, taskID, reason string, retry bool) error {
+	reply, err := c.request(ctx, &channelMessage{Type: "nack", CheckID: taskID, Reason: reason, Retry: retry})
+	if err != nil {
+		return err
+	}
+	return replyError(reply, "nack")
+}
+
+// SubmitResult sends a task result, a duplicate of an already stored result
+// counts as success
+func (c *Channel) SubmitResult(ctx context.Context, result *domain.Result) error {
+	data := result.Data
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+
+	reply, err := c.request(ctx, &channelMessage{
+		Type:    "result",
+		CheckID: result.TaskID,
+		Result: &channelResult{
+			ResultID:  result.ResultID,
+			Success:   result.Success,
+			Data:      data,
+			Error:     result.Error,
+			Duration:  result.DurationSeconds(),
+			CreatedAt: result.Timestamp,
+			Outcome:   result.Outcome,
+			Failure:   result.Failure,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if reply.Status == "duplicate" {
+		return nil
+	}
+	if reply.Status == "rejected" || reply.Status == "not_assigned" {
+		return fmt.Errorf("%w: %s", ErrResultRejected, reply.Error)
+	}
+	return replyError(reply, "submit")
+}
+
+func replyError(reply *channelMessage, action string) error {
+	switch reply.Status {
+	case "ok":
+		return nil
+	case "not_assigned":
+		return ErrLeaseLost
+	default:
+		return fmt.Errorf("%s failed: %s %s", action, reply.Status, reply.Error)
+	}
+}
+
+// request sends a message and waits for the matching reply
+func (c *Channel) request(ctx context.Context, message *channelMessage) (*channelMessage, error) {
+	message.ID = strconv.FormatUint(c.nextID.Add(1), 10)
+	reply := make(chan *channelMessage, 1)
+
+	c.mutex.Lock()
+	if c.conn == nil {
+		c.mutex.Unlock()
+		return nil, ErrChannelClosed
+	}
+	c.pending[message.ID] = reply
+	c.mutex.Unlock()
+
+	if err := c.write(message); err != nil {
+		c.forget(message.ID)
+		return nil, err
+	}
+
+	timer := time.NewTimer(channelReplyTimeout)
+	defer timer.Stop()
+
+	select {
+	case response, ok := <-reply:
+		if !ok {
+			return nil, ErrChannelClosed
+		}
+		return response, nil
+	case <-timer.C:
+		c.forget(message.ID)
+		return nil, fmt.Errorf("%w: no reply to %s", ErrBackendDown, message.Type)
+	case <-ctx.Done():
+		c.forget(message.ID)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Channel) forget(id string) {
+	c.mutex.Lock()
+	delete(c.pending, id)
+	c.mutex.Unlock()
+}
+
+func (c *Channel) write(message *channelMessage) error {
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+
+	if conn == nil {
+		return ErrChannelClosed
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(channelWriteWait))
+	if err := conn.WriteJSON(message); err != nil {
+		conn.Close()
+		return ErrChannelClosed
+	}
+	return nil
+}
@@ -2,8 +2,59 @@ package client
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 var ErrNoTasks = errors.New("no tasks available")
 var ErrNotRegistered = errors.New("agent not registered")
 var ErrBackendDown = errors.New("backend unavailable")
+var ErrResultRejected = errors.New("result rejected by backend")
+var ErrLeaseLost = errors.New("task is no longer assigned to this agent")
+
+// StatusError is a response with an unexpected status
+type StatusError struct {
+	Action     string
+	StatusCode int
+	Body       string
+	// Delay asked for by the Retry-After header, zero when absent
+	Delay time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: status %d, body: %s", e.Action, e.StatusCode, e.Body)
+}
+
+// RetryAfter makes the executors wait as long as the backend asked
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.Delay
+}
+
+// newStatusError reads the start of the body, the caller still closes it
+func newStatusError(action string, resp *http.Response) *StatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return &StatusError{
+		Action:     action,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		Delay:      parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter accepts both forms of the header: seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
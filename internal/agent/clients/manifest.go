@@ -0,0 +1,79 @@
+package client
+
+import (
+	"NetScan/internal/agent/domain"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// manifestPayload is the wire format of the monitor manifest, used by the
+// HTTP API and the channel
+type manifestPayload struct {
+	Version  int64 `json:"version"`
+	Monitors []struct {
+		ID              string                 `json:"id"`
+		Type            string                 `json:"type"`
+		Target          string                 `json:"target"`
+		Options         map[string]interface{} `json:"options"`
+		IntervalSeconds int                    `json:"interval_seconds"`
+	} `json:"monitors"`
+}
+
+func (p *manifestPayload) manifest() *domain.Manifest {
+	manifest := &domain.Manifest{
+		Version:  p.Version,
+		Monitors: make([]domain.Monitor, 0, len(p.Monitors)),
+	}
+
+	for _, monitor := range p.Monitors {
+		manifest.Monitors = append(manifest.Monitors, domain.Monitor{
+			ID:       monitor.ID,
+			Type:     domain.CheckType(monitor.Type),
+			Target:   monitor.Target,
+			Options:  monitor.Options,
+			Interval: time.Duration(monitor.IntervalSeconds) * time.Second,
+		})
+	}
+	return manifest
+}
+
+// FetchManifest returns the recurring monitors assigned to the agent
+func (a *APIClient) FetchManifest(ctx context.Context) (*domain.Manifest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+"/api/v1/agents/monitors", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.agentID)
+
+	resp, err := a.send(ctx, EndpointManifest, req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("manifest fetch failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrNotRegistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError("manifest fetch", resp)
+	}
+
+	var response struct {
+		Data struct {
+			Manifest manifestPayload `json:"manifest"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	return response.Data.Manifest.manifest(), nil
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"NetScan/internal/agent/resilience"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// Endpoints of the backend API, each has its own retry policy and breaker
+const (
+	EndpointAuth         = "auth"
+	EndpointRegister     = "register"
+	EndpointFetch        = "fetch"
+	EndpointLease        = "lease"
+	EndpointSubmit       = "submit"
+	EndpointProgress     = "progress"
+	EndpointHeartbeat    = "heartbeat"
+	EndpointCapabilities = "capabilities"
+	EndpointPing         = "ping"
+	EndpointOffline      = "offline"
+	EndpointManifest     = "manifest"
+)
+
+var Endpoints = []string{
+	EndpointAuth,
+	EndpointRegister,
+	EndpointFetch,
+	EndpointLease,
+	EndpointSubmit,
+	EndpointProgress,
+	EndpointHeartbeat,
+	EndpointCapabilities,
+	EndpointPing,
+	EndpointOffline,
+	EndpointManifest,
+}
+
+// Resilience configures retries and circuit breakers of backend calls
+type Resilience struct {
+	Default resilience.Policy
+	// Policies override Default for single endpoints
+	Policies map[string]resilience.Policy
+	// RetryBudget is the share of calls that may be retried, zero disables the limit
+	RetryBudget float64
+	Observer    resilience.Observer
+	Logger      *slog.Logger
+}
+
+// DefaultPolicies keeps progress and heartbeats to a single attempt, the
+// next update or tick is as good as a retry. The offline notification is sent
+// once as well, shutdown must not wait for backoffs.
+func DefaultPolicies() map[string]resilience.Policy {
+	single := resilience.DefaultPolicy
+	single.MaxAttempts = 1
+
+	return map[string]resilience.Policy{
+		EndpointProgress:  single,
+		EndpointHeartbeat: single,
+		EndpointOffline:   single,
+	}
+}
+
+// SetResilience replaces the executors of all endpoints, breaker state is
+// reset. It must be called before the client is used.
+func (a *APIClient) SetResilience(settings Resilience) {
+	logger := settings.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	budget := resilience.NewBudget(settings.RetryBudget)
+
+	a.executors = make(map[string]*resilience.Executor, len(Endpoints))
+	for _, endpoint := range Endpoints {
+		policy, ok := settings.Policies[endpoint]
+		if !ok {
+			policy = settings.Default
+		}
+		a.executors[endpoint] = resilience.NewExecutor(endpoint, policy, budget, transient, settings.Observer, logger)
+	}
+}
+
+func (a *APIClient) executor(endpoint string) *resilience.Executor {
+	return a.executors[endpoint]
+}
+
+// send performs req with the policy of the endpoint. Network errors and
+// transient statuses are retried and returned as errors, any other response
+// is left to the caller. body is replayed on every attempt.
+func (a *APIClient) send(ctx context.Context, endpoint string, req *http.Request, body []byte) (*http.Response, error) {
+	var resp *http.Response
+
+	err := a.executor(endpoint).Do(ctx, func(ctx context.Context) error {
+		attempt := req.Clone(ctx)
+		if body != nil {
+			attempt.Body = io.NopCloser(bytes.NewReader(body))
+			attempt.ContentLength = int64(len(body))
+		}
+
+		r, err := a.doRequestWithMetrics(ctx, attempt)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBackendDown, err)
+		}
+		if transientStatus(r.StatusCode) {
+			defer r.Body.Close()
+			return newStatusError(endpoint, r)
+		}
+
+		resp = r
+		return nil
+	})
+
+	if errors.Is(err, resilience.ErrCircuitOpen) {
+		return nil, fmt.Errorf("%w: %w", ErrBackendDown, err)
+	}
+	return resp, err
+}
+
+// transient tells the executors which errors mean the backend is struggling
+func transient(err error) bool {
+	if errors.Is(err, ErrBackendDown) {
+		return true
+	}
+
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && transientStatus(statusErr.StatusCode)
+}
+
+func transientStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
@@ -0,0 +1,750 @@
+package config
+
+import (
+	"NetScan/internal/agent/domain"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+const (
+	ConfigEnvVar = "AGENT_CONFIG"
+	envPrefix    = "NETSCAN_AGENT"
+)
+
+type Config struct {
+	Backend     BackendConfig     `mapstructure:"backend"`
+	Agent       AgentConfig       `mapstructure:"agent"`
+	Concurrency ConcurrencyConfig `mapstructure:"concurrency"`
+	Runners     RunnersConfig     `mapstructure:"runners"`
+	Proxy       ProxyConfig       `mapstructure:"proxy"`
+	Logging     LoggingConfig     `mapstructure:"logging"`
+	Heartbeat   HeartbeatConfig   `mapstructure:"heartbeat"`
+	Spool       SpoolConfig       `mapstructure:"spool"`
+	Status      StatusConfig      `mapstructure:"status"`
+	Probes      ProbesConfig      `mapstructure:"probes"`
+	Resilience  ResilienceConfig  `mapstructure:"resilience"`
+	Shutdown    ShutdownConfig    `mapstructure:"shutdown"`
+	Scheduler   SchedulerConfig   `mapstructure:"scheduler"`
+}
+
+type BackendConfig struct {
+	URL               string `mapstructure:"url"`
+	Token             string `mapstructure:"token"`
+	AgentID           string `mapstructure:"agent_id"`
+	RegistrationToken string `mapstructure:"registration_token"`
+	// Channel keeps a WebSocket open to receive tasks as soon as they are
+	// queued, polling is used while it is down
+	Channel bool      `mapstructure:"channel"`
+	TLS     TLSConfig `mapstructure:"tls"`
+}
+
+// TLSConfig sets the trust and client certificate used towards the backend.
+// The files are re-read when they change, rotation needs no restart.
+type TLSConfig struct {
+	// CAFile replaces the system roots for verifying the backend
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are presented when the backend requires mTLS,
+	// the certificate CN must be the agent ID
+	CertFile   string `mapstructure:"cert_file"`
+	KeyFile    string `mapstructure:"key_file"`
+	ServerName string `mapstructure:"server_name"`
+}
+
+type AgentConfig struct {
+	Name     string `mapstructure:"name"`
+	Location string `mapstructure:"location"`
+	// Labels are reported at registration, checks select agents by them.
+	// The "region" label overrides location as the agent region.
+	Labels map[string]string `mapstructure:"labels"`
+	// StateFile keeps the agent ID and token assigned at registration
+	StateFile string `mapstructure:"state_file"`
+}
+
+type ConcurrencyConfig struct {
+	Workers    int            `mapstructure:"workers"`
+	Prefetch   int            `mapstructure:"prefetch"`
+	TypeLimits map[string]int `mapstructure:"type_limits"`
+}
+
+type RunnersConfig struct {
+	Enabled []string `mapstructure:"enabled"`
+	// Defaults are merged into task options of the matching check type,
+	// options sent by the backend take precedence
+	Defaults map[string]map[string]interface{} `mapstructure:"defaults"`
+}
+
+type ProxyConfig struct {
+	URL        string `mapstructure:"url"`
+	HTTPChecks bool   `mapstructure:"http_checks"`
+}
+
+type LoggingConfig struct {
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"`
+}
+
+type HeartbeatConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// SpoolConfig controls the local store of results that could not be submitted
+type SpoolConfig struct {
+	Dir            string        `mapstructure:"dir"`
+	MaxSizeMB      int64         `mapstructure:"max_size_mb"`
+	MaxAge         time.Duration `mapstructure:"max_age"`
+	ReplayInterval time.Duration `mapstructure:"replay_interval"`
+}
+
+// StatusConfig controls the local health, status and metrics listener
+type StatusConfig struct {
+	// Listen is the address of the listener, empty disables it
+	Listen string `mapstructure:"listen"`
+}
+
+// ProbesConfig controls the self-tests that decide which capabilities the
+// agent advertises
+type ProbesConfig struct {
+	// Interval between repeated probes, zero probes only at startup
+	Interval  time.Duration `mapstructure:"interval"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+	DNSServer string        `mapstructure:"dns_server"`
+	TCPTarget string        `mapstructure:"tcp_target"`
+}
+
+// ShutdownConfig controls the drain phase after a termination signal
+type ShutdownConfig struct {
+	// GracePeriod is how long running tasks may finish after fetching stops,
+	// tasks still running then are cancelled and given back to the backend
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+}
+
+// SchedulerConfig controls the local runs of recurring monitors while the
+// backend is unreachable
+type SchedulerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// CacheFile keeps the last manifest so monitors run after a restart
+	// without the backend
+	CacheFile string `mapstructure:"cache_file"`
+	// RefreshInterval between manifest fetches, pushes over the channel
+	// arrive in between
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// OfflineAfter is how long the backend must be unreachable before
+	// monitors are run locally
+	OfflineAfter  time.Duration `mapstructure:"offline_after"`
+	MaxConcurrent int           `mapstructure:"max_concurrent"`
+}
+
+// ResilienceConfig controls retries and circuit breakers of backend calls.
+// Endpoint sections override the default policy, fields left at zero are
+// taken from the default.
+type ResilienceConfig struct {
+	// RetryBudget is the share of calls that may be retried, zero disables the limit
+	RetryBudget float64                 `mapstructure:"retry_budget"`
+	Default     PolicyConfig            `mapstructure:"default"`
+	Endpoints   map[string]PolicyConfig `mapstructure:"endpoints"`
+}
+
+type PolicyConfig struct {
+	// MaxAttempts includes the first call
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	// FailureThreshold consecutive failures open the breaker for OpenTimeout,
+	// then HalfOpenRequests trial calls decide whether it closes again
+	FailureThreshold int           `mapstructure:"failure_threshold"`
+	OpenTimeout      time.Duration `mapstructure:"open_timeout"`
+	HalfOpenRequests int           `mapstructure:"half_open_requests"`
+}
+
+// Policy returns the effective policy of an endpoint
+func (r ResilienceConfig) Policy(endpoint string) PolicyConfig {
+	policy := r.Default
+	override, ok := r.Endpoints[endpoint]
+	if !ok {
+		return policy
+	}
+
+	if override.MaxAttempts != 0 {
+		policy.MaxAttempts = override.MaxAttempts
+	}
+	if override.InitialBackoff != 0 {
+		policy.InitialBackoff = override.InitialBackoff
+	}
+	if override.MaxBackoff != 0 {
+		policy.MaxBackoff = override.MaxBackoff
+	}
+	if override.FailureThreshold != 0 {
+		policy.FailureThreshold = override.FailureThreshold
+	}
+	if override.OpenTimeout != 0 {
+		policy.OpenTimeout = override.OpenTimeout
+	}
+	if override.HalfOpenRequests != 0 {
+		policy.HalfOpenRequests = override.HalfOpenRequests
+	}
+	return policy
+}
+
+var knownEndpoints = map[string]bool{
+	"auth":         true,
+	"register":     true,
+	"fetch":        true,
+	"lease":        true,
+	"submit":       true,
+	"progress":     true,
+	"heartbeat":    true,
+	"capabilities": true,
+	"ping":         true,
+	"offline":      true,
+	"manifest":     true,
+}
+
+var knownCheckTypes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"ping":  true,
+	"dns":   true,
+	"tcp":   true,
+	"mtr":   true,
+}
+
+// Manager holds the current configuration and applies live reloads of the
+// fields that are safe to change without a restart
+type Manager struct {
+	v         *viper.Viper
+	logger    *slog.Logger
+	mutex     sync.RWMutex
+	current   *Config
+	listeners []func(*Config)
+}
+
+// Load reads the configuration from the file named by AGENT_CONFIG or from
+// the default locations, applies environment overrides and validates it
+func Load(logger *slog.Logger) (*Manager, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+
+	if path := os.Getenv(ConfigEnvVar); path != "" {
+		v.SetConfigFile(path)
+	} else {
+		v.SetConfigName("agent-config")
+		v.AddConfigPath(".")
+		v.AddConfigPath("configs")
+		v.AddConfigPath("deployments/agent")
+		v.AddConfigPath("/etc/netscan")
+	}
+
+	setDefaults(v)
+	if err := bindEnv(v); err != nil {
+		return nil, err
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if errors.As(err, &notFound) {
+			logger.Warn("Agent config file not found, using defaults and environment")
+		} else {
+			return nil, fmt.Errorf("error reading agent config file: %w", err)
+		}
+	}
+
+	cfg, err := decode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Validate(cfg); err != nil {
+		return nil, fmt.Errorf("agent config validation failed: %w", err)
+	}
+
+	logger.Info("Agent configuration loaded", "file", v.ConfigFileUsed())
+
+	return &Manager{
+		v:       v,
+		logger:  logger,
+		current: cfg,
+	}, nil
+}
+
+// Current returns the active configuration, it must not be modified
+func (m *Manager) Current() *Config {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.current
+}
+
+// OnChange registers a callback invoked after a successful live reload
+func (m *Manager) OnChange(listener func(*Config)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Watch starts watching the config file for changes
+func (m *Manager) Watch() {
+	if m.v.ConfigFileUsed() == "" {
+		return
+	}
+
+	m.v.OnConfigChange(func(event fsnotify.Event) {
+		m.reload()
+	})
+	m.v.WatchConfig()
+}
+
+func (m *Manager) reload() {
+	next, err := decode(m.v)
+	if err != nil {
+		m.logger.Error("Failed to reload agent config", "error", err)
+		return
+	}
+
+	if err := Validate(next); err != nil {
+		m.logger.Error("Reloaded agent config is invalid, keeping previous", "error", err)
+		return
+	}
+
+	m.mutex.Lock()
+	previous := m.current
+	applied := applySafeFields(previous, next)
+	m.mutex.Unlock()
+
+	for _, field := range restartRequiredChanges(previous, next) {
+		m.logger.Warn("Agent config change requires restart", "field", field)
+	}
+
+	m.apply(applied, "file")
+}
+
+// ApplyRemote applies settings pushed by the backend. They stay in effect
+// until the next change of the config file or a restart.
+func (m *Manager) ApplyRemote(settings domain.RemoteConfig) error {
+	next := *m.Current()
+
+	if settings.LogLevel != "" {
+		next.Logging.Level = settings.LogLevel
+	}
+	if settings.HeartbeatInterval != "" {
+		interval, err := time.ParseDuration(settings.HeartbeatInterval)
+		if err != nil {
+			return fmt.Errorf("invalid heartbeat interval: %w", err)
+		}
+		next.Heartbeat.Interval = interval
+	}
+	if len(settings.EnabledRunners) > 0 {
+		next.Runners.Enabled = settings.EnabledRunners
+	}
+	if settings.TypeLimits != nil {
+		next.Concurrency.TypeLimits = settings.TypeLimits
+	}
+
+	if err := Validate(&next); err != nil {
+		return fmt.Errorf("remote config rejected: %w", err)
+	}
+
+	m.apply(&next, "backend")
+	return nil
+}
+
+// apply makes cfg current and notifies the listeners
+func (m *Manager) apply(cfg *Config, source string) {
+	m.mutex.Lock()
+	m.current = cfg
+	listeners := append([]func(*Config){}, m.listeners...)
+	m.mutex.Unlock()
+
+	m.logger.Info("Agent configuration reloaded",
+		"source", source,
+		"log_level", cfg.Logging.Level,
+		"heartbeat_interval", cfg.Heartbeat.Interval,
+		"enabled_runners", cfg.Runners.Enabled,
+	)
+
+	for _, listener := range listeners {
+		listener(cfg)
+	}
+}
+
+// applySafeFields takes the live-reloadable fields from next and keeps
+// everything else from previous
+func applySafeFields(previous, next *Config) *Config {
+	applied := *previous
+	applied.Logging.Level = next.Logging.Level
+	applied.Heartbeat = next.Heartbeat
+	applied.Runners = next.Runners
+	applied.Concurrency.TypeLimits = next.Concurrency.TypeLimits
+	return &applied
+}
+
+func restartRequiredChanges(previous, next *Config) []string {
+	var changed []string
+
+	if previous.Backend != next.Backend {
+		changed = append(changed, "backend")
+	}
+	if !reflect.DeepEqual(previous.Agent, next.Agent) {
+		changed = append(changed, "agent")
+	}
+	if previous.Concurrency.Workers != next.Concurrency.Workers ||
+		previous.Concurrency.Prefetch != next.Concurrency.Prefetch {
+		changed = append(changed, "concurrency")
+	}
+	if previous.Proxy != next.Proxy {
+		changed = append(changed, "proxy")
+	}
+	if previous.Logging.Format != next.Logging.Format {
+		changed = append(changed, "logging.format")
+	}
+	if previous.Spool != next.Spool {
+		changed = append(changed, "spool")
+	}
+	if previous.Status != next.Status {
+		changed = append(changed, "status")
+	}
+	if previous.Probes != next.Probes {
+		changed = append(changed, "probes")
+	}
+	if !reflect.DeepEqual(previous.Resilience, next.Resilience) {
+		changed = append(changed, "resilience")
+	}
+	if previous.Scheduler != next.Scheduler {
+		changed = append(changed, "scheduler")
+	}
+
+	return changed
+}
+
+func decode(v *viper.Viper) (*Config, error) {
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal agent config: %w", err)
+	}
+
+	// Environment overrides arrive as a single comma separated string
+	if len(cfg.Runners.Enabled) == 1 && strings.Contains(cfg.Runners.Enabled[0], ",") {
+		cfg.Runners.Enabled = strings.Split(cfg.Runners.Enabled[0], ",")
+	}
+	for i, runner := range cfg.Runners.Enabled {
+		cfg.Runners.Enabled[i] = strings.TrimSpace(runner)
+	}
+
+	return &cfg, nil
+}
+
+func setDefaults(v *viper.Viper) {
+	// backend defaults
+	v.SetDefault("backend.url", "http://localhost:8080")
+	v.SetDefault("backend.token", "")
+	v.SetDefault("backend.agent_id", "")
+	v.SetDefault("backend.registration_token", "")
+	v.SetDefault("backend.channel", true)
+	v.SetDefault("backend.tls.ca_file", "")
+	v.SetDefault("backend.tls.cert_file", "")
+	v.SetDefault("backend.tls.key_file", "")
+	v.SetDefault("backend.tls.server_name", "")
+
+	// agent defaults
+	v.SetDefault("agent.name", "net-scan-agent")
+	v.SetDefault("agent.location", "unknown")
+	v.SetDefault("agent.state_file", "agent-state.json")
+
+	// concurrency defaults
+	v.SetDefault("concurrency.workers", 5)
+	v.SetDefault("concurrency.prefetch", 2)
+	v.SetDefault("concurrency.type_limits", map[string]int{"mtr": 2})
+
+	// runners defaults
+	v.SetDefault("runners.enabled", []string{"http", "https", "ping", "dns", "tcp", "mtr"})
+	v.SetDefault("runners.defaults", map[string]map[string]interface{}{})
+
+	// proxy defaults
+	v.SetDefault("proxy.url", "")
+	v.SetDefault("proxy.http_checks", false)
+
+	// logging defaults
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.format", "json")
+
+	// heartbeat defaults
+	v.SetDefault("heartbeat.interval", "30s")
+
+	// spool defaults
+	v.SetDefault("spool.dir", "spool")
+	v.SetDefault("spool.max_size_mb", 64)
+	v.SetDefault("spool.max_age", "24h")
+	v.SetDefault("spool.replay_interval", "10s")
+
+	// status defaults
+	v.SetDefault("status.listen", "")
+
+	// probes defaults
+	v.SetDefault("probes.interval", "10m")
+	v.SetDefault("probes.timeout", "3s")
+	v.SetDefault("probes.dns_server", "8.8.8.8:53")
+	v.SetDefault("probes.tcp_target", "1.1.1.1:443")
+
+	// shutdown defaults
+	v.SetDefault("shutdown.grace_period", "30s")
+
+	// scheduler defaults
+	v.SetDefault("scheduler.enabled", true)
+	v.SetDefault("scheduler.cache_file", "monitors.json")
+	v.SetDefault("scheduler.refresh_interval", "5m")
+	v.SetDefault("scheduler.offline_after", "1m")
+	v.SetDefault("scheduler.max_concurrent", 2)
+
+	// resilience defaults
+	v.SetDefault("resilience.retry_budget", 0.2)
+	v.SetDefault("resilience.default.max_attempts", 3)
+	v.SetDefault("resilience.default.initial_backoff", "500ms")
+	v.SetDefault("resilience.default.max_backoff", "30s")
+	v.SetDefault("resilience.default.failure_threshold", 5)
+	v.SetDefault("resilience.default.open_timeout", "30s")
+	v.SetDefault("resilience.default.half_open_requests", 1)
+	// progress and heartbeats are not retried, the next update is as good,
+	// the offline notification must not delay shutdown
+	v.SetDefault("resilience.endpoints", map[string]interface{}{
+		"progress":  map[string]interface{}{"max_attempts": 1},
+		"heartbeat": map[string]interface{}{"max_attempts": 1},
+		"offline":   map[string]interface{}{"max_attempts": 1},
+	})
+}
+
+// bindEnv maps NETSCAN_AGENT_* variables and the legacy variable names
+// used by earlier deployments onto config keys
+func bindEnv(v *viper.Viper) error {
+	bindings := map[string][]string{
+		"backend.url":                {"BACKEND_URL"},
+		"backend.token":              {"AGENT_TOKEN"},
+		"backend.agent_id":           {"AGENT_ID"},
+		"backend.registration_token": {"REGISTRATION_TOKEN"},
+		"backend.channel":            {},
+		"backend.tls.ca_file":        {},
+		"backend.tls.cert_file":      {},
+		"backend.tls.key_file":       {},
+		"backend.tls.server_name":    {},
+		"agent.name":                 {"AGENT_NAME"},
+		"agent.location":             {"AGENT_LOCATION"},
+		"agent.state_file":           {"AGENT_STATE_FILE"},
+		"concurrency.workers":        {"MAX_CONCURRENT_CHECKS"},
+		"concurrency.prefetch":       {"PREFETCH_BUFFER"},
+		"runners.enabled":            {"CAPABILITIES"},
+		"proxy.url":                  {"HTTPS_PROXY"},
+		"proxy.http_checks":          {},
+		"logging.level":              {"LOG_LEVEL"},
+		"logging.format":             {},
+		"heartbeat.interval":         {"HEARTBEAT_INTERVAL"},
+		"spool.dir":                  {"SPOOL_DIR"},
+		"spool.max_size_mb":          {},
+		"spool.max_age":              {},
+		"spool.replay_interval":      {},
+		"status.listen":              {"STATUS_LISTEN"},
+		"probes.interval":            {},
+		"probes.timeout":             {},
+		"probes.dns_server":          {},
+		"probes.tcp_target":          {},
+		"shutdown.grace_period":      {"SHUTDOWN_GRACE_PERIOD"},
+		"scheduler.enabled":          {},
+		"scheduler.cache_file":       {"MONITORS_CACHE_FILE"},
+		"scheduler.refresh_interval": {},
+		"scheduler.offline_after":    {},
+		"scheduler.max_concurrent":   {},
+
+		// retry policy of all endpoints, endpoint overrides are file only
+		"resilience.retry_budget":               {},
+		"resilience.default.max_attempts":       {},
+		"resilience.default.initial_backoff":    {},
+		"resilience.default.max_backoff":        {},
+		"resilience.default.failure_threshold":  {},
+		"resilience.default.open_timeout":       {},
+		"resilience.default.half_open_requests": {},
+	}
+
+	for key, legacy := range bindings {
+		name := envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if err := v.BindEnv(append([]string{key, name}, legacy...)...); err != nil {
+			return fmt.Errorf("failed to bind env for %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func Validate(cfg *Config) error {
+	backendURL, err := url.Parse(cfg.Backend.URL)
+	if err != nil || (backendURL.Scheme != "http" && backendURL.Scheme != "https") || backendURL.Host == "" {
+		return fmt.Errorf("invalid backend url %q", cfg.Backend.URL)
+	}
+
+	if (cfg.Backend.TLS.CertFile == "") != (cfg.Backend.TLS.KeyFile == "") {
+		return errors.New("backend.tls.cert_file and backend.tls.key_file must be set together")
+	}
+	if cfg.Backend.TLS != (TLSConfig{}) && backendURL.Scheme != "https" {
+		return errors.New("backend.tls requires an https backend url")
+	}
+
+	if cfg.Agent.Name == "" {
+		return errors.New("agent name is required")
+	}
+
+	if cfg.Agent.StateFile == "" {
+		return errors.New("agent state file is required")
+	}
+
+	for name := range cfg.Agent.Labels {
+		if name == "" {
+			return errors.New("agent.labels must not contain empty names")
+		}
+	}
+
+	if cfg.Concurrency.Workers < 1 || cfg.Concurrency.Workers > 256 {
+		return fmt.Errorf("concurrency.workers must be between 1 and 256, got %d", cfg.Concurrency.Workers)
+	}
+
+	if cfg.Concurrency.Prefetch < 0 {
+		return fmt.Errorf("concurrency.prefetch must not be negative, got %d", cfg.Concurrency.Prefetch)
+	}
+
+	for checkType, limit := range cfg.Concurrency.TypeLimits {
+		if !knownCheckTypes[checkType] {
+			return fmt.Errorf("unknown check type in concurrency.type_limits: %s", checkType)
+		}
+		if limit < 0 {
+			return fmt.Errorf("concurrency.type_limits.%s must not be negative", checkType)
+		}
+	}
+
+	if len(cfg.Runners.Enabled) == 0 {
+		return errors.New("at least one runner must be enabled")
+	}
+	for _, runner := range cfg.Runners.Enabled {
+		if !knownCheckTypes[runner] {
+			return fmt.Errorf("unknown runner in runners.enabled: %s", runner)
+		}
+	}
+	for checkType := range cfg.Runners.Defaults {
+		if !knownCheckTypes[checkType] {
+			return fmt.Errorf("unknown check type in runners.defaults: %s", checkType)
+		}
+	}
+
+	if cfg.Proxy.URL != "" {
+		if _, err := url.Parse(cfg.Proxy.URL); err != nil {
+			return fmt.Errorf("invalid proxy url: %w", err)
+		}
+	}
+
+	switch cfg.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid logging level %q", cfg.Logging.Level)
+	}
+
+	if cfg.Heartbeat.Interval < time.Second {
+		return fmt.Errorf("heartbeat.interval must be at least 1s, got %s", cfg.Heartbeat.Interval)
+	}
+
+	if cfg.Spool.Dir == "" {
+		return errors.New("spool.dir is required")
+	}
+	if cfg.Spool.MaxSizeMB < 1 {
+		return fmt.Errorf("spool.max_size_mb must be positive, got %d", cfg.Spool.MaxSizeMB)
+	}
+	if cfg.Spool.ReplayInterval < time.Second {
+		return fmt.Errorf("spool.replay_interval must be at least 1s, got %s", cfg.Spool.ReplayInterval)
+	}
+
+	if cfg.Probes.Interval != 0 && cfg.Probes.Interval < time.Minute {
+		return fmt.Errorf("probes.interval must be zero or at least 1m, got %s", cfg.Probes.Interval)
+	}
+	if cfg.Probes.Timeout <= 0 {
+		return fmt.Errorf("probes.timeout must be positive, got %s", cfg.Probes.Timeout)
+	}
+	for key, address := range map[string]string{"probes.dns_server": cfg.Probes.DNSServer, "probes.tcp_target": cfg.Probes.TCPTarget} {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("%s must be host:port, got %q", key, address)
+		}
+	}
+
+	if cfg.Shutdown.GracePeriod < 0 {
+		return fmt.Errorf("shutdown.grace_period must not be negative, got %s", cfg.Shutdown.GracePeriod)
+	}
+
+	if cfg.Scheduler.Enabled {
+		if cfg.Scheduler.CacheFile == "" {
+			return errors.New("scheduler.cache_file is required")
+		}
+		if cfg.Scheduler.RefreshInterval < time.Minute {
+			return fmt.Errorf("scheduler.refresh_interval must be at least 1m, got %s", cfg.Scheduler.RefreshInterval)
+		}
+		if cfg.Scheduler.OfflineAfter < cfg.Heartbeat.Interval {
+			return fmt.Errorf("scheduler.offline_after must be at least heartbeat.interval, got %s", cfg.Scheduler.OfflineAfter)
+		}
+		if cfg.Scheduler.MaxConcurrent < 1 {
+			return fmt.Errorf("scheduler.max_concurrent must be positive, got %d", cfg.Scheduler.MaxConcurrent)
+		}
+	}
+
+	if err := validateResilience(cfg.Resilience); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func validateResilience(cfg ResilienceConfig) error {
+	if cfg.RetryBudget < 0 || cfg.RetryBudget > 1 {
+		return fmt.Errorf("resilience.retry_budget must be between 0 and 1, got %g", cfg.RetryBudget)
+	}
+
+	for endpoint := range cfg.Endpoints {
+		if !knownEndpoints[endpoint] {
+			return fmt.Errorf("unknown endpoint in resilience.endpoints: %s", endpoint)
+		}
+	}
+
+	for endpoint := range knownEndpoints {
+		policy := cfg.Policy(endpoint)
+		key := "resilience.endpoints." + endpoint
+
+		if policy.MaxAttempts < 1 {
+			return fmt.Errorf("%s.max_attempts must be positive, got %d", key, policy.MaxAttempts)
+		}
+		if policy.InitialBackoff < 0 || policy.MaxBackoff < policy.InitialBackoff {
+			return fmt.Errorf("%s: backoff must satisfy 0 <= initial_backoff <= max_backoff", key)
+		}
+		if policy.FailureThreshold < 0 {
+			return fmt.Errorf("%s.failure_threshold must not be negative, got %d", key, policy.FailureThreshold)
+		}
+		if policy.FailureThreshold > 0 && policy.OpenTimeout <= 0 {
+			return fmt.Errorf("%s.open_timeout must be positive when the breaker is enabled", key)
+		}
+	}
+
+	return nil
+}
+
+// SlogLevel converts the configured level name
+func (l LoggingConfig) SlogLevel() slog.Level {
+	switch l.Level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
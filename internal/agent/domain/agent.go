@@ -12,26 +12,36 @@ const (
 )
 
 type Agent struct {
-	ID           string        `json:"id"`
-	Name         string        `json:"name"`
-	Location     string        `json:"location"`
-	Status       AgentStatus   `json:"status"`
-	Token        string        `json:"-"`
-	Version      string        `json:"version"`
-	Metadata     AgentMetadata `json:"metadata"`
-	Capabilities []CheckType   `json:"capabilities"`
-	LastSeen     time.Time     `json:"last_seen"`
-	CreatedAt    time.Time     `json:"created_at"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Location     string            `json:"location"`
+	Status       AgentStatus       `json:"status"`
+	Token        string            `json:"-"`
+	Version      string            `json:"version"`
+	Metadata     AgentMetadata     `json:"metadata"`
+	Capabilities []CheckType       `json:"capabilities"`
+	Fingerprint  string            `json:"fingerprint"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	LastSeen     time.Time         `json:"last_seen"`
+	CreatedAt    time.Time         `json:"created_at"`
 }
 
 type AgentMetadata struct {
-	IPAddress string `json:"ip_address"`
-	Hostname  string `json:"hostname"`
-	OS        string `json:"os"`
-	Arch      string `json:"arch"`
-	CPUCount  int    `json:"cpu_count"`
-	MemoryMB  int64  `json:"memory_mb"`
-	GoVersion string `json:"go_version"`
+	IPAddress  string             `json:"ip_address"`
+	Hostname   string             `json:"hostname"`
+	OS         string             `json:"os"`
+	Arch       string             `json:"arch"`
+	CPUCount   int                `json:"cpu_count"`
+	MemoryMB   int64              `json:"memory_mb"`
+	DiskMB     int64              `json:"disk_mb"`
+	GoVersion  string             `json:"go_version"`
+	Interfaces []NetworkInterface `json:"interfaces"`
+}
+
+type NetworkInterface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac,omitempty"`
+	Addresses []string `json:"addresses"`
 }
 
 type Heartbeat struct {
@@ -42,10 +52,32 @@ type Heartbeat struct {
 }
 
 type SystemLoad struct {
-	CPUUsage    float64 `json:"cpu_usage"`    // 0.0 - 1.0
-	MemoryUsage float64 `json:"memory_usage"` // 0.0 - 1.0
-	DiskUsage   float64 `json:"disk_usage"`   // 0.0 - 1.0
-	ActiveJobs  int     `json:"active_jobs"`
+	CPUUsage     float64 `json:"cpu_usage"`    // 0.0 - 1.0
+	MemoryUsage  float64 `json:"memory_usage"` // 0.0 - 1.0
+	DiskUsage    float64 `json:"disk_usage"`   // 0.0 - 1.0
+	LoadAvg1     float64 `json:"load_avg_1"`
+	LoadAvg5     float64 `json:"load_avg_5"`
+	LoadAvg15    float64 `json:"load_avg_15"`
+	MemoryUsedMB int64   `json:"memory_used_mb"`
+	OpenFDs      int     `json:"open_fds"`
+	MaxFDs       int     `json:"max_fds"`
+	Goroutines   int     `json:"goroutines"`
+	ActiveJobs   int     `json:"active_jobs"`
+	MaxJobs      int     `json:"max_jobs"`
+	SpoolDepth   int     `json:"spool_depth"`
+}
+
+// Percent returns the share of busy workers in range 0-100
+func (l SystemLoad) Percent() int {
+	if l.MaxJobs <= 0 {
+		return 0
+	}
+
+	percent := l.ActiveJobs * 100 / l.MaxJobs
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
 }
 
 func NewAgent(name, location, token string) *Agent {
@@ -61,6 +93,7 @@ func NewAgent(name, location, token string) *Agent {
 			PingCheck,
 			DNSCheck,
 			TCPCheck,
+			MTRCheck,
 		},
 		Metadata:  AgentMetadata{},
 		CreatedAt: time.Now(),
@@ -91,3 +124,40 @@ func (a *Agent) AddCapability(checkType CheckType) {
 		a.Capabilities = append(a.Capabilities, checkType)
 	}
 }
+
+// Environment is the result of the agent self-probes
+type Environment struct {
+	ICMP     bool              `json:"icmp"`     // an ICMP socket can be opened
+	ICMPRaw  bool              `json:"icmp_raw"` // a raw socket, required by mtr
+	IPv4     bool              `json:"ipv4"`
+	IPv6     bool              `json:"ipv6"`
+	DNS      bool              `json:"dns"` // outbound DNS over UDP 53
+	TCP      bool              `json:"tcp"` // outbound TCP connections
+	Errors   map[string]string `json:"errors,omitempty"`
+	ProbedAt time.Time         `json:"probed_at"`
+}
+
+// Supports reports whether the environment has what the check type needs
+func (e Environment) Supports(checkType CheckType) bool {
+	switch checkType {
+	case PingCheck:
+		return e.ICMP
+	case MTRCheck, TracerouteCheck:
+		return e.ICMPRaw
+	case DNSCheck:
+		return e.DNS
+	case HTTPCheck, HTTPSCheck, TCPCheck:
+		return e.TCP
+	default:
+		return false
+	}
+}
+
+// RemoteConfig holds settings pushed by the backend over the agent channel,
+// empty fields are left unchanged
+type RemoteConfig struct {
+	LogLevel          string         `json:"log_level,omitempty"`
+	HeartbeatInterval string         `json:"heartbeat_interval,omitempty"`
+	EnabledRunners    []string       `json:"enabled_runners,omitempty"`
+	TypeLimits        map[string]int `json:"type_limits,omitempty"`
+}
@@ -0,0 +1,77 @@
+package domain
+
+import "errors"
+
+// Failure tells why a check failed. Code and phase are stable values the
+// backend can group by, the message is for humans.
+type Failure struct {
+	Code    string `json:"code"`
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message"`
+}
+
+// Failure codes
+const (
+	FailureDNSNXDomain          = "dns_nxdomain"
+	FailureDNSServFail          = "dns_servfail"
+	FailureDNSRefused           = "dns_refused"
+	FailureDNSError             = "dns_error"
+	FailureConnectionRefused    = "connection_refused"
+	FailureConnectionReset      = "connection_reset"
+	FailureHostUnreachable      = "host_unreachable"
+	FailureNetworkUnreachable   = "network_unreachable"
+	FailureNoReply              = "no_reply"
+	FailureTimeout              = "timeout"
+	FailureTLSHandshake         = "tls_handshake"
+	FailureCertExpired          = "cert_expired"
+	FailureCertHostnameMismatch = "cert_hostname_mismatch"
+	FailureCertUntrusted        = "cert_untrusted"
+	FailureHTTPStatus           = "http_status"
+	FailureTooManyRedirects     = "too_many_redirects"
+	FailurePermissionDenied     = "permission_denied"
+	FailureInvalidTask          = "invalid_task"
+	FailureInvalidOptions       = "invalid_options"
+	FailureUnsupportedCheck     = "unsupported_check"
+	FailureCancelled            = "cancelled"
+	FailureUnknown              = "unknown"
+)
+
+// Phases of a check, in the order they happen
+const (
+	PhaseSetup    = "setup"
+	PhaseResolve  = "resolve"
+	PhaseConnect  = "connect"
+	PhaseTLS      = "tls"
+	PhaseRequest  = "request"
+	PhaseResponse = "response"
+)
+
+// CheckError is returned by runners to attach a failure code and phase to
+// the underlying error
+type CheckError struct {
+	Code  string
+	Phase string
+	Err   error
+}
+
+func (e *CheckError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
+// FailureOf describes err, errors that were not classified by a runner
+// get FailureUnknown
+func FailureOf(err error) *Failure {
+	if err == nil {
+		return nil
+	}
+
+	var checkErr *CheckError
+	if errors.As(err, &checkErr) {
+		return &Failure{Code: checkErr.Code, Phase: checkErr.Phase, Message: err.Error()}
+	}
+	return &Failure{Code: FailureUnknown, Message: err.Error()}
+}
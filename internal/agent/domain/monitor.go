@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// Monitor is a recurring check assigned to the agent. The agent runs it on
+// its own schedule while the backend is unreachable and submits the results
+// once the backend is back.
+type Monitor struct {
+	ID       string                 `json:"id"`
+	Type     CheckType              `json:"type"`
+	Target   string                 `json:"target"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Interval time.Duration          `json:"interval"`
+}
+
+// Manifest is the set of monitors of the agent, Version grows with every
+// change on the backend
+type Manifest struct {
+	Version  int64     `json:"version"`
+	Monitors []Monitor `json:"monitors"`
+}
+
+// Task builds the task of one local run of the monitor
+func (m Monitor) Task(agentID string) *Task {
+	return &Task{
+		ID:        m.ID,
+		Type:      m.Type,
+		Target:    m.Target,
+		Options:   m.Options,
+		CreatedAt: time.Now(),
+		AgentID:   agentID,
+		// a run must not overlap the next one
+		Timeout:   m.Interval,
+		MonitorID: m.ID,
+	}
+}
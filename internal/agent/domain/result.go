@@ -1,18 +1,42 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 type Result struct {
+	// ResultID makes resubmission idempotent, the backend ignores duplicates
+	ResultID     string                 `json:"result_id"`
 	TaskID       string                 `json:"task_id"`
 	AgentID      string                 `json:"agent_id"`
 	Success      bool                   `json:"success"`
-	ResponseTime int                    `json:"response_time"`
+	ResponseTime int                    `json:"response_time"` // microseconds
 	Error        string                 `json:"error,omitempty"`
 	Data         map[string]interface{} `json:"data"`
 	Timestamp    time.Time              `json:"timestamp"`
 	Metadata     map[string]interface{} `json:"metadata"`
+	Outcome      string                 `json:"outcome,omitempty"`
+	Failure      *Failure               `json:"failure,omitempty"`
+	// MonitorID marks results of local runs, they are submitted per monitor
+	MonitorID string `json:"monitor_id,omitempty"`
 }
 
+// DurationSeconds converts ResponseTime to seconds, the unit the backend stores
+func (r *Result) DurationSeconds() float64 {
+	return (time.Duration(r.ResponseTime) * time.Microsecond).Seconds()
+}
+
+// ErrTaskTimeout is reported when a check does not finish before its deadline
+var ErrTaskTimeout = errors.New("task deadline exceeded")
+
+// Outcomes of an executed check, a timeout is not reported as a plain failure
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeTimeout = "timeout"
+)
+
 type HTTPResult struct {
 	StatusCode    int               `json:"status_code"`
 	Headers       map[string]string `json:"headers"`
@@ -28,6 +52,12 @@ type PingResult struct {
 	MinRTT          float64 `json:"min_rtt"`
 	MaxRTT          float64 `json:"max_rtt"`
 	AvgRTT          float64 `json:"avg_rtt"`
+	Jitter          float64 `json:"jitter"`
+	StdDevRTT       float64 `json:"stddev_rtt"`
+	P50RTT          float64 `json:"p50_rtt"`
+	P90RTT          float64 `json:"p90_rtt"`
+	P99RTT          float64 `json:"p99_rtt"`
+	Mode            string  `json:"mode"`
 }
 
 type DNSResult struct {
@@ -49,6 +79,7 @@ type SSLInfo struct {
 
 func NewSuccessResult(taskID, agentID string, responseTime int, data map[string]interface{}) *Result {
 	return &Result{
+		ResultID:     generateUUID(),
 		TaskID:       taskID,
 		AgentID:      agentID,
 		Success:      true,
@@ -56,16 +87,29 @@ func NewSuccessResult(taskID, agentID string, responseTime int, data map[string]
 		Data:         data,
 		Timestamp:    time.Now(),
 		Metadata:     map[string]interface{}{},
+		Outcome:      OutcomeSuccess,
 	}
 }
 
 func NewErrorResult(taskID, agentID string, error error) *Result {
 	return &Result{
+		ResultID:  generateUUID(),
 		TaskID:    taskID,
 		AgentID:   agentID,
 		Success:   false,
 		Error:     error.Error(),
 		Timestamp: time.Now(),
 		Metadata:  map[string]interface{}{},
+		Outcome:   OutcomeFailure,
+		Failure:   FailureOf(error),
 	}
 }
+
+func NewTimeoutResult(taskID, agentID string, responseTime int, data map[string]interface{}) *Result {
+	result := NewErrorResult(taskID, agentID, ErrTaskTimeout)
+	result.ResponseTime = responseTime
+	result.Data = data
+	result.Outcome = OutcomeTimeout
+	result.Failure.Code = FailureTimeout
+	return result
+}
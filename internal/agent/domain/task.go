@@ -14,6 +14,7 @@ const (
 	DNSCheck        CheckType = "dns"
 	TCPCheck        CheckType = "tcp"
 	TracerouteCheck CheckType = "traceroute"
+	MTRCheck        CheckType = "mtr"
 )
 
 type DNSType string
@@ -32,10 +33,29 @@ type Task struct {
 	Type      CheckType              `json:"type"`
 	Target    string                 `json:"target"`
 	Options   map[string]interface{} `json:"options"`
+	Attempt   int                    `json:"attempt,omitempty"`
 	CreatedAt time.Time              `json:"created_at"`
 	AgentID   string                 `json:"agent_id"`
+	// Timeout is the execution budget counted from the start of execution,
+	// empty means the agent default. The backend sends no absolute deadline
+	// on purpose: a clock skewed against the backend's, or time spent in the
+	// prefetch buffer, would cut the check short or cancel it before it
+	// starts. The deadline is derived on the agent, see taskDeadline.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// MonitorID is set for runs scheduled by the agent itself
+	MonitorID string `json:"monitor_id,omitempty"`
 }
 
+// Reasons reported to the backend when the agent gives a task back
+const (
+	NackCapabilityMissing = "capability_missing"
+	NackShuttingDown      = "shutting_down"
+	NackRunnerPanic       = "runner_panic"
+	// the per type concurrency limit is reached, the backend requeues the
+	// task without counting an attempt
+	NackTypeBusy = "type_busy"
+)
+
 func NewHTTPTask(target string, timeout int) *Task {
 	return &Task{
 		ID:     generateUUID(),
@@ -85,6 +105,18 @@ func NewTCPCheck(target string, port int) *Task {
 	}
 }
 
+func NewMTRTask(target string, duration time.Duration) *Task {
+	return &Task{
+		ID:     generateUUID(),
+		Type:   MTRCheck,
+		Target: target,
+		Options: map[string]interface{}{
+			"duration": duration.String(),
+		},
+		CreatedAt: time.Now(),
+	}
+}
+
 func generateUUID() string {
 	return uuidutil.New()
 }
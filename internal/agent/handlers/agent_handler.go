@@ -4,32 +4,242 @@ import (
 	client "NetScan/internal/agent/clients"
 	clients "NetScan/internal/agent/clients"
 	"NetScan/internal/agent/domain"
+	"NetScan/internal/agent/resilience"
+	"NetScan/internal/agent/spool"
 	"context"
 	"errors"
 	"log/slog"
-	"strings"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type AgentHandler struct {
-	api    *clients.APIClient
-	runner *TaskHandler
-	logger *slog.Logger
+	api     *clients.APIClient
+	channel *clients.Channel
+	runner  *TaskHandler
+	spool   *spool.Spool
+	logger  *slog.Logger
+	config  PoolConfig
+
+	activeJobs atomic.Int32
+	slotsMutex sync.RWMutex
+	typeSlots  map[domain.CheckType]chan struct{}
+	// signalled whenever a type slot is freed, wakes up a busy fetcher
+	typeSlotFreed chan struct{}
+
+	drainTimeout atomic.Int64
+
+	runningMutex sync.Mutex
+	running      map[string]*runningTask
+}
+
+// acceptedTask is a fetched task together with the slot of its check type it
+// holds until it is finished, typeSlots is nil for types without a limit
+type acceptedTask struct {
+	task      *domain.Task
+	typeSlots chan struct{}
+}
+
+type runningTask struct {
+	task      *domain.Task
+	startedAt time.Time
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// TaskStatus describes a task that is being executed
+type TaskStatus struct {
+	TaskID    string           `json:"task_id"`
+	Type      domain.CheckType `json:"type"`
+	Target    string           `json:"target"`
+	Attempt   int              `json:"attempt"`
+	StartedAt time.Time        `json:"started_at"`
+}
+
+// PoolConfig controls how many tasks the agent runs at the same time
+type PoolConfig struct {
+	// Workers is the number of tasks executed concurrently
+	Workers int
+	// PrefetchBuffer is the number of tasks fetched ahead of free workers
+	PrefetchBuffer int
+	// TypeLimits caps concurrent tasks per check type, zero means no limit.
+	// Tasks over the limit are given back instead of waiting for a worker.
+	TypeLimits map[domain.CheckType]int
+	// DrainTimeout is how long running tasks may finish once fetching stops
+	DrainTimeout time.Duration
 }
 
 const (
 	INITIAL_DELAY     = 5 * time.Second
 	MAX_INITIAL_DELAY = 30 * time.Second
 	IDLE_DELAY        = time.Second
+	MAX_BUSY_DELAY    = 30 * time.Second
 	ERROR_DELAY       = time.Second * 5
+	MAX_ERROR_DELAY   = 2 * time.Minute
 	HEALTH_CHECK_URL  = "/health"
 )
 
-func NewAgentHandler(logger *slog.Logger, clients *clients.APIClient, runner *TaskHandler) *AgentHandler {
-	return &AgentHandler{
-		api:    clients,
-		runner: runner,
-		logger: logger,
+func NewAgentHandler(logger *slog.Logger, clients *clients.APIClient, runner *TaskHandler, resultSpool *spool.Spool, config PoolConfig) *AgentHandler {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.PrefetchBuffer < 0 {
+		config.PrefetchBuffer = 0
+	}
+
+	handler := &AgentHandler{
+		api:     clients,
+		runner:  runner,
+		spool:   resultSpool,
+		logger:  logger,
+		config:  config,
+		running: make(map[string]*runningTask),
+
+		typeSlotFreed: make(chan struct{}, 1),
+	}
+	handler.SetTypeLimits(config.TypeLimits)
+	handler.SetDrainTimeout(config.DrainTimeout)
+
+	return handler
+}
+
+// SetChannel makes the handler receive tasks over the push channel while it
+// is connected, polling is used otherwise
+func (s *AgentHandler) SetChannel(channel *clients.Channel) {
+	s.channel = channel
+}
+
+// CancelTask stops a running task at the backend's request, its result is
+// not submitted
+func (s *AgentHandler) CancelTask(taskID string) {
+	s.runningMutex.Lock()
+	task, ok := s.running[taskID]
+	if ok {
+		task.cancelled = true
+		task.cancel()
+	}
+	s.runningMutex.Unlock()
+
+	if ok {
+		s.logger.Info("Task cancelled by backend", "task_id", taskID)
+	}
+}
+
+// RunningTasks returns the tasks currently being executed, oldest first
+func (s *AgentHandler) RunningTasks() []TaskStatus {
+	s.runningMutex.Lock()
+	tasks := make([]TaskStatus, 0, len(s.running))
+	for _, running := range s.running {
+		tasks = append(tasks, TaskStatus{
+			TaskID:    running.task.ID,
+			Type:      running.task.Type,
+			Target:    running.task.Target,
+			Attempt:   running.task.Attempt,
+			StartedAt: running.startedAt,
+		})
+	}
+	s.runningMutex.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].StartedAt.Before(tasks[j].StartedAt)
+	})
+	return tasks
+}
+
+// SetTypeLimits replaces the per check type concurrency limits. Tasks that
+// already hold a slot release it to the limiter they acquired it from.
+func (s *AgentHandler) SetTypeLimits(limits map[domain.CheckType]int) {
+	typeSlots := make(map[domain.CheckType]chan struct{})
+	for checkType, limit := range limits {
+		if limit > 0 {
+			typeSlots[checkType] = make(chan struct{}, limit)
+		}
+	}
+
+	s.slotsMutex.Lock()
+	s.typeSlots = typeSlots
+	s.slotsMutex.Unlock()
+}
+
+// SetDrainTimeout changes the grace period of running tasks at shutdown
+func (s *AgentHandler) SetDrainTimeout(timeout time.Duration) {
+	s.drainTimeout.Store(int64(timeout))
+}
+
+// ActiveJobs returns the number of tasks currently being executed
+func (s *AgentHandler) ActiveJobs() int {
+	return int(s.activeJobs.Load())
+}
+
+// SpoolDepth returns the number of results waiting in the local spool
+func (s *AgentHandler) SpoolDepth() int {
+	if s.spool == nil {
+		return 0
+	}
+	return s.spool.Depth()
+}
+
+// SystemLoad reports how busy the worker pool is
+func (s *AgentHandler) SystemLoad() domain.SystemLoad {
+	return domain.SystemLoad{
+		ActiveJobs: s.ActiveJobs(),
+		MaxJobs:    s.config.Workers,
+		SpoolDepth: s.SpoolDepth(),
+	}
+}
+
+// ReplaySpool periodically resubmits spooled results in the order they were
+// stored until ctx is cancelled
+func (s *AgentHandler) ReplaySpool(ctx context.Context, interval time.Duration) {
+	if s.spool == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.spool.Depth() > 0 {
+				s.replaySpoolOnce(ctx)
+			}
+		}
+	}
+}
+
+func (s *AgentHandler) replaySpoolOnce(ctx context.Context) {
+	sent, expired, err := s.spool.Replay(func(result *domain.Result) error {
+		err := s.submitResult(ctx, result)
+		if errors.Is(err, clients.ErrResultRejected) {
+			s.logger.Warn("Spooled result rejected by backend, dropping it",
+				"error", err,
+				"task_id", result.TaskID,
+				"result_id", result.ResultID,
+			)
+			return nil
+		}
+		return err
+	})
+
+	if sent > 0 || expired > 0 {
+		s.logger.Info("Spooled results replayed",
+			"sent", sent,
+			"expired", expired,
+			"remaining", s.spool.Depth(),
+		)
+	}
+
+	if err != nil && ctx.Err() == nil {
+		s.logger.Warn("Spool replay interrupted, will retry",
+			"error", err,
+			"remaining", s.spool.Depth(),
+		)
 	}
 }
 
@@ -65,7 +275,9 @@ func (s *AgentHandler) waitForBackendStability(ctx context.Context) error {
 				"next_attempt", backoff,
 			)
 
-			time.Sleep(backoff)
+			if !sleepContext(ctx, backoff) {
+				return ctx.Err()
+			}
 			backoff = time.Duration(float64(backoff) * 1.5)
 			if backoff > MAX_INITIAL_DELAY {
 				backoff = MAX_INITIAL_DELAY
@@ -74,92 +286,362 @@ func (s *AgentHandler) waitForBackendStability(ctx context.Context) error {
 	}
 }
 
+// isBackendHealthy uses the health endpoint, fetching a task here would
+// leave it assigned to the agent without ever running it
 func (s *AgentHandler) isBackendHealthy(ctx context.Context) bool {
-	_, err := s.api.FetchTask(ctx)
-	if err == nil {
-		return true
-	}
+	return s.api.Ping(ctx) == nil
+}
 
-	if errors.Is(err, client.ErrNoTasks) {
-		return true
+// processTasks fetches tasks until ctx is done and then drains the pool:
+// running tasks get the drain timeout to finish and submit their results,
+// fetched tasks that have not started yet are given back
+func (s *AgentHandler) processTasks(ctx context.Context) {
+	// Every fetched task holds a slot until it is finished, so at most
+	// Workers+PrefetchBuffer tasks are taken from the backend at once
+	slots := make(chan struct{}, s.config.Workers+s.config.PrefetchBuffer)
+	tasks := make(chan *acceptedTask, s.config.PrefetchBuffer)
+
+	// Running tasks outlive ctx, workCtx is cancelled when the drain times out
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	var workers sync.WaitGroup
+	for i := 0; i < s.config.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for accepted := range tasks {
+				s.runTask(ctx, workCtx, accepted)
+				<-slots
+			}
+		}()
 	}
 
-	// Добавить проверку на 401
-	if strings.Contains(err.Error(), "status 401") {
-		return false
-	}
+	s.logger.Info("Worker pool started",
+		"workers", s.config.Workers,
+		"prefetch", s.config.PrefetchBuffer,
+	)
 
-	if s.isServerError(err) {
-		return false
-	}
+	s.fetchTasks(ctx, slots, tasks)
+	close(tasks)
 
-	if errors.Is(err, client.ErrNoTasks) {
-		return true
-	}
+	s.drain(&workers, tasks, slots, cancelWork)
+	s.logger.Info("Stopping agent handler due to context cancellation")
+}
 
-	if s.isServerError(err) {
-		return false
+// drain waits for the workers, tasks still running after the drain timeout
+// are cancelled and nacked
+func (s *AgentHandler) drain(workers *sync.WaitGroup, tasks <-chan *acceptedTask, slots chan struct{}, cancelWork context.CancelFunc) {
+	timeout := time.Duration(s.drainTimeout.Load())
+	s.logger.Info("Draining tasks",
+		"active_jobs", s.ActiveJobs(),
+		"timeout", timeout,
+	)
+
+	// Prefetched tasks would otherwise wait for a free worker, tasks pushed
+	// over the channel but not fetched for the lease reaper
+	for accepted := range tasks {
+		s.nackTask(accepted.task, domain.NackShuttingDown, true)
+		s.releaseTypeSlot(accepted)
+		<-slots
+	}
+	if s.channel != nil {
+		for _, task := range s.channel.TakePending() {
+			s.nackTask(task, domain.NackShuttingDown, true)
+		}
 	}
 
-	return false
-}
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		s.logger.Info("All tasks drained")
+		return
+	case <-timer.C:
+	}
 
-func (s *AgentHandler) isServerError(err error) bool {
-	errorStr := err.Error()
-	return contains(errorStr, "status 500") ||
-		contains(errorStr, "internal server error") ||
-		contains(errorStr, "service unavailable")
+	s.logger.Warn("Drain timeout reached, cancelling running tasks",
+		"active_jobs", s.ActiveJobs(),
+	)
+	cancelWork()
+	<-done
 }
 
-func (s *AgentHandler) processTasks(ctx context.Context) {
+func (s *AgentHandler) fetchTasks(ctx context.Context, slots chan struct{}, tasks chan<- *acceptedTask) {
 	consecutiveErrors := 0
 	maxConsecutiveErrors := 5
+	consecutiveBusy := 0
 
 	for {
+		// Every task fetched now would be given back
+		for s.allTypesBusy() {
+			if !s.waitTypeSlot(ctx, MAX_BUSY_DELAY) {
+				return
+			}
+		}
+
 		select {
 		case <-ctx.Done():
-			s.logger.Info("Stopping agent handler due to context cancellation")
 			return
-		default:
-			task, err := s.api.FetchTask(ctx)
-			if err != nil {
-				s.handleFetchError(err, &consecutiveErrors, maxConsecutiveErrors)
-				continue
+		case slots <- struct{}{}:
+		}
+
+		task, err := s.fetchTask(ctx)
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				return
 			}
+			s.handleFetchError(ctx, err, &consecutiveErrors, maxConsecutiveErrors)
+			continue
+		}
 
-			consecutiveErrors = 0
+		consecutiveErrors = 0
+
+		accepted, busy := s.acceptTask(ctx, task)
+		if accepted == nil {
+			<-slots
+			// The task comes back from the backend right away, back off
+			// until a slot is freed or the delay grows to MAX_BUSY_DELAY
+			if busy {
+				consecutiveBusy++
+				if !s.waitTypeSlot(ctx, busyDelay(consecutiveBusy)) {
+					return
+				}
+			}
+			continue
+		}
+
+		consecutiveBusy = 0
+		tasks <- accepted
+	}
+}
 
-			s.processSingleTask(ctx, task)
+// acceptTask takes a slot of the task's check type and acks the task. Tasks
+// the agent cannot run are given back, busy reports that the type limit is
+// reached.
+func (s *AgentHandler) acceptTask(ctx context.Context, task *domain.Task) (accepted *acceptedTask, busy bool) {
+	if !s.runner.CanExecute(task.Type) {
+		s.logger.Warn("Task type is not enabled on this agent, giving it back",
+			"task_id", task.ID,
+			"type", task.Type,
+		)
+		s.nackTask(task, domain.NackCapabilityMissing, true)
+		return nil, false
+	}
+
+	// Waiting for the slot would hold a worker while tasks of other types
+	// are queued behind this one
+	typeSlots, ok := s.takeTypeSlot(task.Type)
+	if !ok {
+		s.logger.Debug("Task type limit reached, giving the task back",
+			"task_id", task.ID,
+			"type", task.Type,
+		)
+		s.nackTask(task, domain.NackTypeBusy, true)
+		return nil, true
+	}
+	accepted = &acceptedTask{task: task, typeSlots: typeSlots}
+
+	err := s.ackTask(ctx, task.ID)
+	if errors.Is(err, clients.ErrLeaseLost) {
+		s.logger.Warn("Task lease lost before ack, skipping it", "task_id", task.ID)
+		s.releaseTypeSlot(accepted)
+		return nil, false
+	}
+	if err != nil {
+		// The backend requeues unacked tasks after a timeout, running the
+		// task anyway at worst produces a duplicate result
+		s.logger.Warn("Failed to ack task, running it anyway",
+			"error", err,
+			"task_id", task.ID,
+		)
+	}
+
+	return accepted, false
+}
+
+// releaseTypeSlot frees the slot of an accepted task and wakes up the fetcher
+func (s *AgentHandler) releaseTypeSlot(accepted *acceptedTask) {
+	if accepted.typeSlots == nil {
+		return
+	}
+	<-accepted.typeSlots
+
+	select {
+	case s.typeSlotFreed <- struct{}{}:
+	default:
+	}
+}
+
+// allTypesBusy reports whether every enabled check type has reached its
+// limit, fetching then only produces tasks that are given back
+func (s *AgentHandler) allTypesBusy() bool {
+	enabled := s.runner.EnabledTypes()
+	if len(enabled) == 0 {
+		return false
+	}
+
+	s.slotsMutex.RLock()
+	defer s.slotsMutex.RUnlock()
+
+	for _, checkType := range enabled {
+		typeSlots, ok := s.typeSlots[checkType]
+		if !ok || len(typeSlots) < cap(typeSlots) {
+			return false
 		}
 	}
+	return true
 }
 
-func (s *AgentHandler) handleFetchError(err error, consecutiveErrors *int, max int) {
-	*consecutiveErrors++
+// waitTypeSlot waits until a type slot is freed or the delay passes, false
+// means ctx is done
+func (s *AgentHandler) waitTypeSlot(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-s.typeSlotFreed:
+		return true
+	case <-timer.C:
+		return true
+	}
+}
+
+// busyDelay doubles IDLE_DELAY for every task given back in a row
+func busyDelay(consecutiveBusy int) time.Duration {
+	delay := IDLE_DELAY
+	for i := 1; i < consecutiveBusy && delay < MAX_BUSY_DELAY; i++ {
+		delay *= 2
+	}
+	return min(delay, MAX_BUSY_DELAY)
+}
+
+// takeTypeSlot takes a free slot of the check type without waiting, types
+// without a limit need no slot
+func (s *AgentHandler) takeTypeSlot(checkType domain.CheckType) (chan struct{}, bool) {
+	s.slotsMutex.RLock()
+	typeSlots, ok := s.typeSlots[checkType]
+	s.slotsMutex.RUnlock()
+
+	if !ok {
+		return nil, true
+	}
+
+	select {
+	case typeSlots <- struct{}{}:
+		return typeSlots, true
+	default:
+		return nil, false
+	}
+}
+
+// nackTask reports a task the agent is not going to finish. It does not use
+// the worker context, which is usually already cancelled at this point.
+func (s *AgentHandler) nackTask(task *domain.Task, reason string, retry bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.sendNack(ctx, task.ID, reason, retry); err != nil {
+		s.logger.Warn("Failed to nack task",
+			"error", err,
+			"task_id", task.ID,
+			"reason", reason,
+		)
+		return
+	}
+
+	s.logger.Info("Task given back to backend",
+		"task_id", task.ID,
+		"reason", reason,
+		"retry", retry,
+	)
+}
+
+// runTask executes an accepted task and frees the slot of its check type.
+// Tasks that have not started when ctx is done are nacked, started tasks
+// run with workCtx.
+func (s *AgentHandler) runTask(ctx, workCtx context.Context, accepted *acceptedTask) {
+	defer s.releaseTypeSlot(accepted)
+	task := accepted.task
+
+	if ctx.Err() != nil {
+		s.nackTask(task, domain.NackShuttingDown, true)
+		return
+	}
+
+	s.activeJobs.Add(1)
+	defer s.activeJobs.Add(-1)
+
+	taskCtx, cancel := context.WithCancel(workCtx)
+	defer cancel()
+
+	s.runningMutex.Lock()
+	s.running[task.ID] = &runningTask{task: task, startedAt: time.Now(), cancel: cancel}
+	s.runningMutex.Unlock()
 
+	defer func() {
+		s.runningMutex.Lock()
+		delete(s.running, task.ID)
+		s.runningMutex.Unlock()
+	}()
+
+	s.processSingleTask(taskCtx, task)
+}
+
+func (s *AgentHandler) cancelledByBackend(taskID string) bool {
+	s.runningMutex.Lock()
+	defer s.runningMutex.Unlock()
+
+	task, ok := s.running[taskID]
+	return ok && task.cancelled
+}
+
+// handleFetchError waits before the next fetch. An empty queue means the
+// backend is fine, errors back off exponentially with jitter.
+func (s *AgentHandler) handleFetchError(ctx context.Context, err error, consecutiveErrors *int, max int) {
 	if errors.Is(err, client.ErrNoTasks) {
+		*consecutiveErrors = 0
 		s.logger.Debug("No tasks available", "delay", IDLE_DELAY)
-		time.Sleep(IDLE_DELAY)
+		sleepContext(ctx, IDLE_DELAY)
 		return
 	}
 
+	*consecutiveErrors++
+	delay := resilience.Backoff(*consecutiveErrors, ERROR_DELAY, MAX_ERROR_DELAY)
+
 	s.logger.Error("Failed to fetch task",
 		"error", err,
 		"consecutive_errors", *consecutiveErrors,
+		"delay", delay,
 	)
 
-	delay := ERROR_DELAY
-	if *consecutiveErrors > 3 {
-		delay = time.Duration(*consecutiveErrors) * ERROR_DELAY
-		s.logger.Warn("Multiple consecutive errors, increasing delay",
-			"delay", delay,
-		)
+	if *consecutiveErrors >= max {
+		s.logger.Error("Too many consecutive errors, agent might be unstable")
 	}
 
-	time.Sleep(delay)
+	sleepContext(ctx, delay)
+}
 
-	if *consecutiveErrors >= max {
-		s.logger.Error("Too many consecutive errors, agent might be unstable")
+// sleepContext waits for d and returns false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
@@ -168,27 +650,117 @@ func (s *AgentHandler) processSingleTask(ctx context.Context, task *domain.Task)
 		"task_id", task.ID,
 		"type", task.Type,
 		"target", task.Target,
+		"active_jobs", s.ActiveJobs(),
 	)
 
-	result := s.runner.ExecuteTask(ctx, task)
+	result, ok := s.executeTask(ctx, task)
+	if !ok {
+		return
+	}
 
-	if err := s.api.SubmitResult(ctx, result); err != nil {
+	if ctx.Err() != nil {
+		if s.cancelledByBackend(task.ID) {
+			return
+		}
+		// The run was cut short by shutdown, its result is not meaningful
+		s.nackTask(task, domain.NackShuttingDown, true)
+		return
+	}
+
+	if err := s.submitResult(ctx, result); err != nil {
 		s.logger.Error("Failed to submit result",
 			"error", err,
 			"task_id", task.ID,
 		)
+		s.spoolResult(result, err)
 	} else {
 		s.logger.Info("Result submitted successfully",
 			"task_id", task.ID,
 			"success", result.Success,
-			"duration_ms", result.ResponseTime,
+			"duration", time.Duration(result.ResponseTime)*time.Microsecond,
 		)
 	}
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) &&
-		(s == substr || len(s) > 0 &&
-			(s[0:len(substr)] == substr ||
-				contains(s[1:], substr)))
+// executeTask runs the task and turns a runner panic into a nack
+func (s *AgentHandler) executeTask(ctx context.Context, task *domain.Task) (result *domain.Result, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("Runner panicked",
+				"panic", r,
+				"task_id", task.ID,
+				"type", task.Type,
+				"stack", string(debug.Stack()),
+			)
+			s.nackTask(task, domain.NackRunnerPanic, false)
+			result, ok = nil, false
+		}
+	}()
+
+	return s.runner.ExecuteTask(ctx, task), true
+}
+
+// fetchTask waits for a task pushed over the channel and polls the backend
+// while the channel is down
+func (s *AgentHandler) fetchTask(ctx context.Context) (*domain.Task, error) {
+	if s.channel != nil && s.channel.Connected() {
+		task, err := s.channel.NextTask(ctx)
+		if !errors.Is(err, clients.ErrChannelClosed) {
+			return task, err
+		}
+	}
+	return s.api.FetchTask(ctx)
+}
+
+func (s *AgentHandler) ackTask(ctx context.Context, taskID string) error {
+	if s.channel != nil {
+		err := s.channel.AckTask(ctx, taskID)
+		if !errors.Is(err, clients.ErrChannelClosed) {
+			return err
+		}
+	}
+	return s.api.AckTask(ctx, taskID)
+}
+
+func (s *AgentHandler) sendNack(ctx context.Context, taskID, reason string, retry bool) error {
+	if s.channel != nil {
+		err := s.channel.NackTask(ctx, taskID, reason, retry)
+		if !errors.Is(err, clients.ErrChannelClosed) {
+			return err
+		}
+	}
+	return s.api.NackTask(ctx, taskID, reason, retry)
+}
+
+func (s *AgentHandler) submitResult(ctx context.Context, result *domain.Result) error {
+	// results of local monitor runs have no task on the backend side
+	if s.channel != nil && result.MonitorID == "" {
+		err := s.channel.SubmitResult(ctx, result)
+		if !errors.Is(err, clients.ErrChannelClosed) {
+			return err
+		}
+	}
+	return s.api.SubmitResult(ctx, result)
+}
+
+// spoolResult keeps a result that could not be submitted for a later replay
+func (s *AgentHandler) spoolResult(result *domain.Result, submitErr error) {
+	if s.spool == nil || errors.Is(submitErr, clients.ErrResultRejected) {
+		return
+	}
+
+	if err := s.spool.Append(result); err != nil {
+		s.logger.Error("Failed to spool result, it is lost",
+			"error", err,
+			"task_id", result.TaskID,
+			"result_id", result.ResultID,
+		)
+		return
+	}
+
+	s.logger.Info("Result spooled for later submission",
+		"task_id", result.TaskID,
+		"result_id", result.ResultID,
+		"spool_depth", s.spool.Depth(),
+	)
 }
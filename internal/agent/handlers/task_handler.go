@@ -4,36 +4,108 @@ import (
 	"NetScan/internal/agent/domain"
 	runner "NetScan/internal/agent/runners"
 	"context"
-	"fmt"
+	"errors"
 	"log/slog"
+	"sync"
 	"time"
 )
 
+const (
+	progressBufferSize = 16
+	// budget for tasks that arrive without a timeout
+	defaultTaskTimeout = 5 * time.Minute
+)
+
 type TaskHandler struct {
 	runnerFactory *runner.Factory
+	progress      ProgressReporter
+	metrics       RunnerMetrics
 	logger        *slog.Logger
+
+	mutex    sync.RWMutex
+	defaults map[domain.CheckType]map[string]interface{}
+}
+
+// ProgressReporter delivers intermediate results of a task to the backend
+type ProgressReporter interface {
+	SubmitProgress(ctx context.Context, taskID, stage string, progress float64, data map[string]interface{}) error
+}
+
+// RunnerMetrics records the outcome and duration of executed checks
+type RunnerMetrics interface {
+	ObserveCheck(checkType domain.CheckType, outcome string, duration time.Duration)
 }
 
-func NewTaskHandler(runnerFactory *runner.Factory, logger *slog.Logger) *TaskHandler {
+type progressUpdate struct {
+	stage    string
+	progress float64
+	data     map[string]interface{}
+}
+
+func NewTaskHandler(runnerFactory *runner.Factory, progress ProgressReporter, logger *slog.Logger) *TaskHandler {
 	return &TaskHandler{
 		runnerFactory: runnerFactory,
+		progress:      progress,
 		logger:        logger,
 	}
 }
 
+// SetMetrics enables collection of per runner metrics
+func (t *TaskHandler) SetMetrics(metrics RunnerMetrics) {
+	t.metrics = metrics
+}
+
+// SetDefaults replaces the per check type default options
+func (t *TaskHandler) SetDefaults(defaults map[domain.CheckType]map[string]interface{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.defaults = defaults
+}
+
+// withDefaults merges configured defaults with the task options,
+// task options take precedence
+func (t *TaskHandler) withDefaults(task *domain.Task) map[string]interface{} {
+	t.mutex.RLock()
+	defaults := t.defaults[task.Type]
+	t.mutex.RUnlock()
+
+	if len(defaults) == 0 {
+		return task.Options
+	}
+
+	options := make(map[string]interface{}, len(defaults)+len(task.Options))
+	for key, value := range defaults {
+		options[key] = value
+	}
+	for key, value := range task.Options {
+		options[key] = value
+	}
+	return options
+}
+
+// CanExecute reports whether the agent has an enabled runner for the check type
+func (t *TaskHandler) CanExecute(checkType domain.CheckType) bool {
+	return t.runnerFactory.IsEnabled(checkType)
+}
+
+// EnabledTypes returns the check types the agent currently executes
+func (t *TaskHandler) EnabledTypes() []domain.CheckType {
+	return t.runnerFactory.Enabled()
+}
+
 func (t *TaskHandler) ExecuteTask(ctx context.Context, task *domain.Task) *domain.Result {
 	if task == nil {
-		return domain.NewErrorResult("", "", fmt.Errorf("task is nil"))
+		return domain.NewErrorResult("", "", invalidTask("task is nil"))
 	}
 
 	if task.ID == "" {
-		return domain.NewErrorResult("", task.AgentID, fmt.Errorf("task ID is empty"))
+		return domain.NewErrorResult("", task.AgentID, invalidTask("task ID is empty"))
 	}
 	if task.Type == "" {
-		return domain.NewErrorResult(task.ID, task.AgentID, fmt.Errorf("task type is empty"))
+		return domain.NewErrorResult(task.ID, task.AgentID, invalidTask("task type is empty"))
 	}
 	if task.Target == "" {
-		return domain.NewErrorResult(task.ID, task.AgentID, fmt.Errorf("task target is empty"))
+		return domain.NewErrorResult(task.ID, task.AgentID, invalidTask("task target is empty"))
 	}
 
 	t.logger.Debug("Getting runner for task",
@@ -43,13 +115,108 @@ func (t *TaskHandler) ExecuteTask(ctx context.Context, task *domain.Task) *domai
 
 	runner, err := t.runnerFactory.GetRunner(task.Type)
 	if err != nil {
-		return domain.NewErrorResult(task.ID, task.AgentID, err)
+		return domain.NewErrorResult(task.ID, task.AgentID, &domain.CheckError{
+			Code:  domain.FailureUnsupportedCheck,
+			Phase: domain.PhaseSetup,
+			Err:   err,
+		})
 	}
 
 	start := time.Now()
 
-	data, err := runner.Execute(ctx, task.Target, task.Options)
+	runCtx, cancel := context.WithDeadline(ctx, taskDeadline(task, start))
+	defer cancel()
+
+	data, err := t.execute(runCtx, runner, task)
+	responseTime := int(time.Since(start).Microseconds())
+
+	var result *domain.Result
+	switch {
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		result = domain.NewTimeoutResult(task.ID, task.AgentID, responseTime, data)
+		// keep the step the runner was in when the deadline hit
+		if failure := domain.FailureOf(err); failure != nil {
+			result.Failure.Phase = failure.Phase
+		}
+	case err != nil:
+		result = domain.NewErrorResult(task.ID, task.AgentID, err)
+		result.ResponseTime = responseTime
+		result.Data = data
+	default:
+		result = domain.NewSuccessResult(task.ID, task.AgentID, responseTime, data)
+	}
+
+	result.MonitorID = task.MonitorID
+
+	if t.metrics != nil {
+		t.metrics.ObserveCheck(task.Type, result.Outcome, time.Since(start))
+	}
+
+	return result
+}
+
+func invalidTask(message string) error {
+	return &domain.CheckError{Code: domain.FailureInvalidTask, Phase: domain.PhaseSetup, Err: errors.New(message)}
+}
+
+// taskDeadline is the deadline of the task: its budget counted from the
+// start of execution on the agent's own clock, so neither clock skew nor
+// time in the prefetch buffer shortens it. Tasks without a budget get
+// defaultTaskTimeout.
+func taskDeadline(task *domain.Task, start time.Time) time.Time {
+	timeout := task.Timeout
+	if timeout <= 0 {
+		timeout = defaultTaskTimeout
+	}
+	return start.Add(timeout)
+}
+
+func (t *TaskHandler) execute(ctx context.Context, r runner.Runner, task *domain.Task) (map[string]interface{}, error) {
+	options := t.withDefaults(task)
+
+	// local runs have no check on the backend to report progress to
+	progressRunner, ok := r.(runner.ProgressRunner)
+	if !ok || t.progress == nil || task.MonitorID != "" {
+		return r.Execute(ctx, task.Target, options)
+	}
+
+	report, stop := t.streamProgress(ctx, task)
+	defer stop()
+
+	return progressRunner.ExecuteWithProgress(ctx, task.Target, options, report)
+}
+
+// streamProgress sends progress updates in order without blocking the runner.
+// Updates are dropped when the backend cannot keep up.
+func (t *TaskHandler) streamProgress(ctx context.Context, task *domain.Task) (runner.ProgressFunc, func()) {
+	updates := make(chan progressUpdate, progressBufferSize)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for update := range updates {
+			if err := t.progress.SubmitProgress(ctx, task.ID, update.stage, update.progress, update.data); err != nil {
+				t.logger.Debug("Failed to submit progress",
+					"task_id", task.ID,
+					"stage", update.stage,
+					"error", err,
+				)
+			}
+		}
+	}()
+
+	report := func(stage string, progress float64, data map[string]interface{}) {
+		select {
+		case updates <- progressUpdate{stage: stage, progress: progress, data: data}:
+		default:
+			t.logger.Debug("Dropping progress update", "task_id", task.ID, "stage", stage)
+		}
+	}
+
+	stop := func() {
+		close(updates)
+		<-done
+	}
 
-	responseTime := time.Since(start).Microseconds()
-	return domain.NewSuccessResult(task.ID, task.AgentID, int(responseTime), data)
+	return report, stop
 }
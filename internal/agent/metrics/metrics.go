@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"NetScan/internal/agent/domain"
+	"NetScan/internal/agent/resilience"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const namespace = "netscan_agent"
+
+// Request latency buckets in seconds
+var requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Check latency buckets in seconds, MTR and traceroute take tens of seconds
+var checkBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+type gauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// Registry collects agent metrics and renders them in the Prometheus text
+// exposition format. It implements client.APIClientMetrics and
+// resilience.Observer.
+type Registry struct {
+	mutex sync.Mutex
+
+	requests      map[string]uint64 // method, status
+	requestTime   map[string]*histogram
+	requestErrors map[string]uint64 // method, type
+	checks        map[string]uint64 // type, outcome
+	checkTime     map[string]*histogram
+	retries       map[string]uint64 // endpoint
+	budgetDenied  map[string]uint64 // endpoint
+	transitions   map[string]uint64 // endpoint, state
+	breakers      map[string]resilience.State
+	gauges        []gauge
+}
+
+func New() *Registry {
+	return &Registry{
+		requests:      make(map[string]uint64),
+		requestTime:   make(map[string]*histogram),
+		requestErrors: make(map[string]uint64),
+		checks:        make(map[string]uint64),
+		checkTime:     make(map[string]*histogram),
+		retries:       make(map[string]uint64),
+		budgetDenied:  make(map[string]uint64),
+		transitions:   make(map[string]uint64),
+		breakers:      make(map[string]resilience.State),
+	}
+}
+
+func (r *Registry) ObserveRequestDuration(method string, statusCode int, duration time.Duration) {
+	key := labels("method", method, "status", strconv.Itoa(statusCode))
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	h, ok := r.requestTime[key]
+	if !ok {
+		h = newHistogram(requestBuckets)
+		r.requestTime[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (r *Registry) IncRequestCounter(method string, statusCode int) {
+	key := labels("method", method, "status", strconv.Itoa(statusCode))
+
+	r.mutex.Lock()
+	r.requests[key]++
+	r.mutex.Unlock()
+}
+
+func (r *Registry) IncErrorCounter(method string, errorType string) {
+	key := labels("method", method, "type", errorType)
+
+	r.mutex.Lock()
+	r.requestErrors[key]++
+	r.mutex.Unlock()
+}
+
+// ObserveCheck records a finished check of the given type
+func (r *Registry) ObserveCheck(checkType domain.CheckType, outcome string, duration time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.checks[labels("type", string(checkType), "outcome", outcome)]++
+
+	key := labels("type", string(checkType))
+	h, ok := r.checkTime[key]
+	if !ok {
+		h = newHistogram(checkBuckets)
+		r.checkTime[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (r *Registry) Retried(endpoint string) {
+	r.mutex.Lock()
+	r.retries[labels("endpoint", endpoint)]++
+	r.mutex.Unlock()
+}
+
+func (r *Registry) RetryBudgetExhausted(endpoint string) {
+	r.mutex.Lock()
+	r.budgetDenied[labels("endpoint", endpoint)]++
+	r.mutex.Unlock()
+}
+
+func (r *Registry) BreakerStateChanged(endpoint string, from, to resilience.State) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.transitions[labels("endpoint", endpoint, "state", string(to))]++
+	r.breakers[endpoint] = to
+}
+
+// RegisterGauge adds a value read at scrape time, name is prefixed with
+// the agent namespace
+func (r *Registry) RegisterGauge(name, help string, value func() float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gauges = append(r.gauges, gauge{
+		name:  namespace + "_" + name,
+		help:  help,
+		value: value,
+	})
+}
+
+// WriteTo renders all metrics
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	r.mutex.Lock()
+	writeCounters(&b, namespace+"_api_requests_total", "Requests sent to the backend.", r.requests)
+	writeHistograms(&b, namespace+"_api_request_duration_seconds", "Latency of requests sent to the backend.", r.requestTime)
+	writeCounters(&b, namespace+"_api_errors_total", "Failed requests to the backend by error type.", r.requestErrors)
+	writeCounters(&b, namespace+"_checks_total", "Executed checks by type and outcome.", r.checks)
+	writeHistograms(&b, namespace+"_check_duration_seconds", "Duration of executed checks.", r.checkTime)
+	writeCounters(&b, namespace+"_api_retries_total", "Retried requests to the backend by endpoint.", r.retries)
+	writeCounters(&b, namespace+"_api_retry_budget_exhausted_total", "Retries skipped because the retry budget was used up.", r.budgetDenied)
+	writeCounters(&b, namespace+"_circuit_breaker_transitions_total", "Circuit breaker state changes by endpoint and new state.", r.transitions)
+	writeBreakerStates(&b, namespace+"_circuit_breaker_state", r.breakers)
+	gauges := append([]gauge(nil), r.gauges...)
+	r.mutex.Unlock()
+
+	// Gauges may take their own locks, they are read without holding ours
+	for _, g := range gauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.value()))
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeCounters(b *strings.Builder, name, help string, values map[string]uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s{%s} %d\n", name, key, values[key])
+	}
+}
+
+// writeBreakerStates renders one series per endpoint and state, 1 for the
+// current state. Endpoints appear after their first state change.
+func writeBreakerStates(b *strings.Builder, name string, states map[string]resilience.State) {
+	fmt.Fprintf(b, "# HELP %s Circuit breaker state by endpoint.\n# TYPE %s gauge\n", name, name)
+	for _, endpoint := range sortedKeys(states) {
+		for _, state := range []resilience.State{resilience.StateClosed, resilience.StateOpen, resilience.StateHalfOpen} {
+			value := 0
+			if states[endpoint] == state {
+				value = 1
+			}
+			fmt.Fprintf(b, "%s{%s} %d\n", name, labels("endpoint", endpoint, "state", string(state)), value)
+		}
+	}
+}
+
+func writeHistograms(b *strings.Builder, name, help string, values map[string]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, key := range sortedKeys(values) {
+		h := values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, key, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, key, h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, key, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, key, h.count)
+	}
+}
+
+// labels renders label pairs, the result is used both as map key and output
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+"="+strconv.Quote(pairs[i+1]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
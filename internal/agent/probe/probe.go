@@ -0,0 +1,174 @@
+package probe
+
+import (
+	"NetScan/internal/agent/domain"
+	runner "NetScan/internal/agent/runners"
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Connecting a UDP socket sends nothing, it only needs a route
+const (
+	ipv4RouteTarget = "8.8.8.8:53"
+	ipv6RouteTarget = "[2001:4860:4860::8888]:53"
+)
+
+type Config struct {
+	// DNSServer is queried to check outbound DNS over UDP
+	DNSServer string
+	// TCPTarget is dialed to check outbound TCP
+	TCPTarget string
+	Timeout   time.Duration
+}
+
+// Prober runs the self-tests that decide which checks the agent can
+// actually perform in its network
+type Prober struct {
+	config Config
+	logger *slog.Logger
+
+	mutex sync.RWMutex
+	last  *domain.Environment
+}
+
+func NewProber(config Config, logger *slog.Logger) *Prober {
+	if config.Timeout <= 0 {
+		config.Timeout = 3 * time.Second
+	}
+
+	return &Prober{
+		config: config,
+		logger: logger,
+	}
+}
+
+// Last returns the result of the latest run, false before the first one
+func (p *Prober) Last() (domain.Environment, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.last == nil {
+		return domain.Environment{}, false
+	}
+	return *p.last, true
+}
+
+// Supports reports whether the check type passed the latest run, everything
+// is allowed before the first one
+func (p *Prober) Supports(checkType domain.CheckType) bool {
+	environment, ok := p.Last()
+	return !ok || environment.Supports(checkType)
+}
+
+// Run executes all probes in parallel and stores the result
+func (p *Prober) Run(ctx context.Context) domain.Environment {
+	probes := map[string]func(context.Context) error{
+		"icmp":     func(context.Context) error { return runner.ProbeICMP(false) },
+		"icmp_raw": func(context.Context) error { return runner.ProbeICMP(true) },
+		"ipv4":     func(ctx context.Context) error { return probeRoute(ctx, "udp4", ipv4RouteTarget) },
+		"ipv6":     func(ctx context.Context) error { return probeRoute(ctx, "udp6", ipv6RouteTarget) },
+		"dns":      p.probeDNS,
+		"tcp":      p.probeTCP,
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	failures := make(map[string]string)
+
+	for name, probe := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+			defer cancel()
+
+			if err := probe(probeCtx); err != nil {
+				mutex.Lock()
+				failures[name] = err.Error()
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	passed := func(name string) bool {
+		_, failed := failures[name]
+		return !failed
+	}
+
+	environment := domain.Environment{
+		ICMP:     passed("icmp"),
+		ICMPRaw:  passed("icmp_raw"),
+		IPv4:     passed("ipv4"),
+		IPv6:     passed("ipv6"),
+		DNS:      passed("dns"),
+		TCP:      passed("tcp"),
+		ProbedAt: time.Now(),
+	}
+	if len(failures) > 0 {
+		environment.Errors = failures
+	}
+
+	p.mutex.Lock()
+	p.last = &environment
+	p.mutex.Unlock()
+
+	p.logger.Info("Capability probes finished",
+		"icmp", environment.ICMP,
+		"icmp_raw", environment.ICMPRaw,
+		"ipv4", environment.IPv4,
+		"ipv6", environment.IPv6,
+		"dns", environment.DNS,
+		"tcp", environment.TCP,
+	)
+	for name, failure := range failures {
+		p.logger.Debug("Capability probe failed", "probe", name, "error", failure)
+	}
+
+	return environment
+}
+
+// Capabilities keeps the enabled check types the environment supports
+func Capabilities(environment domain.Environment, enabled []domain.CheckType) []domain.CheckType {
+	capabilities := make([]domain.CheckType, 0, len(enabled))
+	for _, checkType := range enabled {
+		if environment.Supports(checkType) {
+			capabilities = append(capabilities, checkType)
+		}
+	}
+	return capabilities
+}
+
+func probeRoute(ctx context.Context, network, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *Prober) probeDNS(ctx context.Context) error {
+	var msg dns.Msg
+	msg.SetQuestion(".", dns.TypeNS)
+
+	// Any answer, even an error code, proves that UDP 53 is open
+	client := dns.Client{Net: "udp", Timeout: p.config.Timeout}
+	_, _, err := client.ExchangeContext(ctx, &msg, p.config.DNSServer)
+	return err
+}
+
+func (p *Prober) probeTCP(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.config.TCPTarget)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,135 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half-open"
+)
+
+// ErrCircuitOpen is returned without calling the backend while the breaker
+// of the endpoint is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Breaker stops calls to an endpoint after consecutive failures. Once the
+// open timeout passes a limited number of trial calls go through, the first
+// result among them closes or reopens the breaker.
+type Breaker struct {
+	threshold   int
+	openTimeout time.Duration
+	maxTrials   int
+	onChange    func(from, to State)
+
+	mutex    sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+func NewBreaker(threshold int, openTimeout time.Duration, maxTrials int, onChange func(from, to State)) *Breaker {
+	if maxTrials < 1 {
+		maxTrials = 1
+	}
+
+	return &Breaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		maxTrials:   maxTrials,
+		onChange:    onChange,
+		state:       StateClosed,
+	}
+}
+
+// State returns the current state, an open breaker whose timeout has passed
+// is reported as half-open
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Allow reserves a call, every allowed call must be followed by Record
+func (b *Breaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return ErrCircuitOpen
+		}
+		b.transition(StateHalfOpen)
+		b.trials = 1
+		return nil
+	case StateHalfOpen:
+		if b.trials >= b.maxTrials {
+			return ErrCircuitOpen
+		}
+		b.trials++
+		return nil
+	}
+
+	return nil
+}
+
+// Record reports the outcome of an allowed call
+func (b *Breaker) Record(success bool) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == StateHalfOpen {
+		b.trials--
+	}
+
+	if success {
+		b.failures = 0
+		if b.state != StateClosed {
+			b.transition(StateClosed)
+		}
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == StateHalfOpen:
+		b.open()
+	case b.state == StateClosed && b.failures >= b.threshold:
+		b.open()
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = time.Now()
+	b.trials = 0
+	b.transition(StateOpen)
+}
+
+// transition is called with the mutex held, the callback must not call
+// back into the breaker
+func (b *Breaker) transition(to State) {
+	from := b.state
+	b.state = to
+	if b.onChange != nil && from != to {
+		b.onChange(from, to)
+	}
+}
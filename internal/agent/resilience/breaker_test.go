@@ -0,0 +1,137 @@
+package resilience
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type transitions []string
+
+func (t *transitions) record(from, to State) {
+	*t = append(*t, string(from)+">"+string(to))
+}
+
+func TestBreaker(t *testing.T) {
+	// steps: "ok" и "fail" - исход разрешенного вызова, "deny" - вызов
+	// должен быть отклонен, "allow" - разрешен без записи исхода
+	tests := []struct {
+		name        string
+		threshold   int
+		openTimeout time.Duration
+		maxTrials   int
+		steps       []string
+		state       State
+		transitions transitions
+	}{
+		{
+			name:      "stays closed below threshold",
+			threshold: 3, openTimeout: time.Hour, maxTrials: 1,
+			steps: []string{"fail", "fail"},
+			state: StateClosed,
+		},
+		{
+			name:      "success resets consecutive failures",
+			threshold: 3, openTimeout: time.Hour, maxTrials: 1,
+			steps: []string{"fail", "fail", "ok", "fail", "fail"},
+			state: StateClosed,
+		},
+		{
+			name:      "opens at threshold and rejects calls",
+			threshold: 3, openTimeout: time.Hour, maxTrials: 1,
+			steps:       []string{"fail", "fail", "fail", "deny", "deny"},
+			state:       StateOpen,
+			transitions: transitions{"closed>open"},
+		},
+		{
+			name:      "half-open limits concurrent trials",
+			threshold: 1, openTimeout: 0, maxTrials: 2,
+			steps:       []string{"fail", "allow", "allow", "deny"},
+			state:       StateHalfOpen,
+			transitions: transitions{"closed>open", "open>half-open"},
+		},
+		{
+			name:      "successful trial closes",
+			threshold: 1, openTimeout: 0, maxTrials: 1,
+			steps:       []string{"fail", "ok", "ok"},
+			state:       StateClosed,
+			transitions: transitions{"closed>open", "open>half-open", "half-open>closed"},
+		},
+		{
+			name:      "failed trial reopens",
+			threshold: 2, openTimeout: 0, maxTrials: 1,
+			steps:       []string{"fail", "fail", "fail"},
+			state:       StateHalfOpen,
+			transitions: transitions{"closed>open", "open>half-open", "half-open>open"},
+		},
+		{
+			name:      "reopened breaker allows new trials",
+			threshold: 1, openTimeout: 0, maxTrials: 1,
+			steps: []string{"fail", "fail", "ok"},
+			state: StateClosed,
+			transitions: transitions{
+				"closed>open", "open>half-open", "half-open>open",
+				"open>half-open", "half-open>closed",
+			},
+		},
+		{
+			name:      "zero threshold disables the breaker",
+			threshold: 0, openTimeout: time.Hour, maxTrials: 1,
+			steps: []string{"fail", "fail", "fail", "fail", "ok"},
+			state: StateClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got transitions
+			breaker := NewBreaker(tt.threshold, tt.openTimeout, tt.maxTrials, got.record)
+
+			for i, step := range tt.steps {
+				err := breaker.Allow()
+				if step == "deny" {
+					if !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: Allow() = %v, want ErrCircuitOpen", i, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("step %d (%s): Allow() = %v", i, step, err)
+				}
+				if step != "allow" {
+					breaker.Record(step == "ok")
+				}
+			}
+
+			if state := breaker.State(); state != tt.state {
+				t.Errorf("state = %s, want %s", state, tt.state)
+			}
+			if !reflect.DeepEqual(got, tt.transitions) {
+				t.Errorf("transitions = %v, want %v", got, tt.transitions)
+			}
+		})
+	}
+}
+
+func TestBreakerOpenTimeout(t *testing.T) {
+	breaker := NewBreaker(1, 20*time.Millisecond, 1, nil)
+
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("Allow() = %v", err)
+	}
+	breaker.Record(false)
+
+	if err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() right after opening = %v, want ErrCircuitOpen", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if state := breaker.State(); state != StateHalfOpen {
+		t.Errorf("state after timeout = %s, want %s", state, StateHalfOpen)
+	}
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("Allow() after timeout = %v, want a trial call", err)
+	}
+}
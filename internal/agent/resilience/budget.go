@@ -0,0 +1,46 @@
+package resilience
+
+import "sync"
+
+// budgetBurst is the number of retries available after a quiet period
+const budgetBurst = 10
+
+// Budget limits retries to a fraction of calls across all endpoints. Every
+// call earns ratio tokens, every retry spends one, so a failing backend sees
+// at most ratio extra load once the burst is used up.
+type Budget struct {
+	ratio float64
+
+	mutex  sync.Mutex
+	tokens float64
+}
+
+// NewBudget creates a budget, a ratio of zero or less disables the limit
+func NewBudget(ratio float64) *Budget {
+	return &Budget{ratio: ratio, tokens: budgetBurst}
+}
+
+func (b *Budget) deposit() {
+	if b == nil || b.ratio <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	b.tokens = min(b.tokens+b.ratio, budgetBurst)
+	b.mutex.Unlock()
+}
+
+func (b *Budget) withdraw() bool {
+	if b == nil || b.ratio <= 0 {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
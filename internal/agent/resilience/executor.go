@@ -0,0 +1,175 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy is the retry and breaker setup of one endpoint
+type Policy struct {
+	// MaxAttempts includes the first call, one disables retries
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// FailureThreshold consecutive failures open the breaker, zero disables it
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	HalfOpenRequests int
+}
+
+// DefaultPolicy is used for endpoints without their own policy
+var DefaultPolicy = Policy{
+	MaxAttempts:      3,
+	InitialBackoff:   500 * time.Millisecond,
+	MaxBackoff:       30 * time.Second,
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+// RetryAfter is implemented by errors that carry a delay requested by the
+// server, such as the Retry-After header of a 429 or 503 response
+type RetryAfter interface {
+	RetryAfter() time.Duration
+}
+
+// Observer receives the events of all executors, it is used for metrics
+type Observer interface {
+	BreakerStateChanged(endpoint string, from, to State)
+	Retried(endpoint string)
+	RetryBudgetExhausted(endpoint string)
+}
+
+// Executor runs calls to one endpoint with its policy. Only errors accepted
+// by transient are retried and count as breaker failures, everything else
+// is an answer from a healthy backend.
+type Executor struct {
+	name      string
+	policy    Policy
+	breaker   *Breaker
+	budget    *Budget
+	transient func(error) bool
+	observer  Observer
+	logger    *slog.Logger
+}
+
+func NewExecutor(name string, policy Policy, budget *Budget, transient func(error) bool, observer Observer, logger *slog.Logger) *Executor {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	e := &Executor{
+		name:      name,
+		policy:    policy,
+		budget:    budget,
+		transient: transient,
+		observer:  observer,
+		logger:    logger,
+	}
+	e.breaker = NewBreaker(policy.FailureThreshold, policy.OpenTimeout, policy.HalfOpenRequests, e.stateChanged)
+	return e
+}
+
+func (e *Executor) Name() string {
+	return e.name
+}
+
+func (e *Executor) State() State {
+	return e.breaker.State()
+}
+
+// Do calls op until it succeeds, fails with a permanent error, the attempts
+// or the retry budget run out, or ctx is done
+func (e *Executor) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	e.budget.deposit()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if allowErr := e.breaker.Allow(); allowErr != nil {
+			if err != nil {
+				return err
+			}
+			return allowErr
+		}
+
+		err = op(ctx)
+		failed := err != nil && e.transient(err) && ctx.Err() == nil
+		e.breaker.Record(!failed)
+
+		// a retry is pointless once this failure has opened the breaker
+		if !failed || attempt >= e.policy.MaxAttempts || e.breaker.State() == StateOpen {
+			return err
+		}
+
+		if !e.budget.withdraw() {
+			if e.observer != nil {
+				e.observer.RetryBudgetExhausted(e.name)
+			}
+			e.logger.Debug("Retry budget exhausted", "endpoint", e.name, "error", err)
+			return err
+		}
+
+		delay := Backoff(attempt, e.policy.InitialBackoff, e.policy.MaxBackoff)
+		var retryAfter RetryAfter
+		if errors.As(err, &retryAfter) && retryAfter.RetryAfter() > delay {
+			delay = min(retryAfter.RetryAfter(), e.policy.MaxBackoff)
+		}
+
+		e.logger.Debug("Retrying backend call",
+			"endpoint", e.name,
+			"attempt", attempt,
+			"delay", delay,
+			"error", err,
+		)
+		if e.observer != nil {
+			e.observer.Retried(e.name)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (e *Executor) stateChanged(from, to State) {
+	if to == StateOpen {
+		e.logger.Warn("Circuit breaker opened",
+			"endpoint", e.name,
+			"from", from,
+			"open_timeout", e.policy.OpenTimeout,
+		)
+	} else {
+		e.logger.Info("Circuit breaker state changed", "endpoint", e.name, "from", from, "to", to)
+	}
+
+	if e.observer != nil {
+		e.observer.BreakerStateChanged(e.name, from, to)
+	}
+}
+
+// Backoff returns the delay before the given retry: exponential growth from
+// initial, capped at max, with the upper half randomized so that agents do
+// not retry in lockstep
+func Backoff(attempt int, initial, max time.Duration) time.Duration {
+	if initial <= 0 {
+		return 0
+	}
+
+	delay := initial
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
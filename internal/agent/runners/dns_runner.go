@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"NetScan/internal/agent/domain"
 	"context"
 	"fmt"
 	"time"
@@ -13,7 +14,6 @@ type DNSRunner struct {
 }
 
 func NewDNSRunner() *DNSRunner {
-	fmt.Printf("🔧 DEBUG: Creating DNSRunner")
 	return &DNSRunner{
 		timeout: time.Second * 10,
 	}
@@ -33,11 +33,11 @@ func (r *DNSRunner) Execute(ctx context.Context, target string, options map[stri
 
 	response, rtt, err := client.ExchangeContext(ctx, &msg, server)
 	if err != nil {
-		return nil, fmt.Errorf("DNS query failed: %w", err)
+		return nil, fail(domain.PhaseRequest, fmt.Errorf("DNS query failed: %w", err))
 	}
 
 	if response.Rcode != dns.RcodeSuccess {
-		return nil, fmt.Errorf("DNS error: %s", dns.RcodeToString[response.Rcode])
+		return nil, failWith(rcodeFailure(response.Rcode), domain.PhaseResponse, "DNS error: %s", dns.RcodeToString[response.Rcode])
 	}
 
 	records := make([]string, 0)
@@ -64,6 +64,19 @@ func (r *DNSRunner) Execute(ctx context.Context, target string, options map[stri
 	return result, nil
 }
 
+func rcodeFailure(rcode int) string {
+	switch rcode {
+	case dns.RcodeNameError:
+		return domain.FailureDNSNXDomain
+	case dns.RcodeServerFailure:
+		return domain.FailureDNSServFail
+	case dns.RcodeRefused:
+		return domain.FailureDNSRefused
+	default:
+		return domain.FailureDNSError
+	}
+}
+
 func recordTypeToDNSType(recordType string) uint16 {
 	switch recordType {
 	case "A":
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"NetScan/internal/agent/domain"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+var (
+	errTooManyRedirects       = errors.New("too many redirects")
+	errRequestTimedOut        = errors.New("request timed out")
+	errDestinationUnreachable = errors.New("destination unreachable")
+)
+
+// fail attaches a failure code derived from err. Phase is the step of the
+// check that failed, resolver errors always count as the resolve phase.
+// Errors that are already classified are returned unchanged.
+func fail(phase string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var checkErr *domain.CheckError
+	if errors.As(err, &checkErr) {
+		return err
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		phase = domain.PhaseResolve
+	}
+
+	code := classify(err)
+	if code == domain.FailureUnknown && phase == domain.PhaseTLS {
+		code = domain.FailureTLSHandshake
+	}
+
+	return &domain.CheckError{Code: code, Phase: phase, Err: err}
+}
+
+// failWith attaches an explicit failure code, for failures that are decided
+// by the runner rather than by the network
+func failWith(code, phase string, format string, args ...interface{}) error {
+	return &domain.CheckError{Code: code, Phase: phase, Err: fmt.Errorf(format, args...)}
+}
+
+func classify(err error) string {
+	var dnsErr *net.DNSError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return domain.FailureCancelled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return domain.FailureTimeout
+	case errors.Is(err, errTooManyRedirects):
+		return domain.FailureTooManyRedirects
+	case errors.Is(err, errRequestTimedOut):
+		return domain.FailureNoReply
+	case errors.Is(err, errDestinationUnreachable):
+		return domain.FailureHostUnreachable
+	case errors.As(err, &dnsErr):
+		switch {
+		case dnsErr.IsNotFound:
+			return domain.FailureDNSNXDomain
+		case dnsErr.IsTimeout:
+			return domain.FailureTimeout
+		case dnsErr.Err == "server misbehaving":
+			// the resolver reports SERVFAIL this way
+			return domain.FailureDNSServFail
+		}
+		return domain.FailureDNSError
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return domain.FailureCertExpired
+	case errors.As(err, &hostnameErr):
+		return domain.FailureCertHostnameMismatch
+	case errors.As(err, &authorityErr), errors.As(err, &invalidErr):
+		return domain.FailureCertUntrusted
+	case errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return domain.FailureTLSHandshake
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return domain.FailureConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return domain.FailureConnectionReset
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return domain.FailureHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return domain.FailureNetworkUnreachable
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES):
+		return domain.FailurePermissionDenied
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return domain.FailureTimeout
+	}
+
+	return domain.FailureUnknown
+}
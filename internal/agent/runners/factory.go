@@ -2,59 +2,133 @@ package runner
 
 import (
 	"NetScan/internal/agent/domain"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
 )
 
+var ErrRunnerDisabled = errors.New("runner disabled")
+
 type Factory struct {
 	httpRunner *HTTPRunner
 	pingRunner *PingRunner
 	dnsRunner  *DNSRunner
 	tcpRunner  *TCPRunner
-}
+	mtrRunner  *MTRRunner
 
-func NewFactory(http *HTTPRunner, ping *PingRunner, dns *DNSRunner, tcp *TCPRunner) *Factory {
-	log.Printf("🔧 DEBUG: Creating Factory - http: %p, ping: %p, dns: %p, tcp: %p",
-		http, ping, dns, tcp)
+	mutex    sync.RWMutex
+	disabled map[domain.CheckType]bool
+}
 
+func NewFactory(http *HTTPRunner, ping *PingRunner, dns *DNSRunner, tcp *TCPRunner, mtr *MTRRunner) *Factory {
 	return &Factory{
 		httpRunner: http,
 		pingRunner: ping,
 		dnsRunner:  dns,
 		tcpRunner:  tcp,
+		mtrRunner:  mtr,
+		disabled:   make(map[domain.CheckType]bool),
+	}
+}
+
+// SetEnabled restricts the factory to the given check types
+func (f *Factory) SetEnabled(checkTypes []domain.CheckType) {
+	enabled := make(map[domain.CheckType]bool, len(checkTypes))
+	for _, checkType := range checkTypes {
+		enabled[checkType] = true
+	}
+
+	disabled := make(map[domain.CheckType]bool)
+	for _, checkType := range f.Supported() {
+		if !enabled[checkType] {
+			disabled[checkType] = true
+		}
+	}
+
+	f.mutex.Lock()
+	f.disabled = disabled
+	f.mutex.Unlock()
+}
+
+// Supported returns every check type the factory has a runner for
+func (f *Factory) Supported() []domain.CheckType {
+	var supported []domain.CheckType
+	if f.httpRunner != nil {
+		supported = append(supported, domain.HTTPCheck, domain.HTTPSCheck)
+	}
+	if f.pingRunner != nil {
+		supported = append(supported, domain.PingCheck)
 	}
+	if f.dnsRunner != nil {
+		supported = append(supported, domain.DNSCheck)
+	}
+	if f.tcpRunner != nil {
+		supported = append(supported, domain.TCPCheck)
+	}
+	if f.mtrRunner != nil {
+		supported = append(supported, domain.MTRCheck)
+	}
+	return supported
+}
+
+// Enabled returns the check types that can currently be executed
+func (f *Factory) Enabled() []domain.CheckType {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	var enabled []domain.CheckType
+	for _, checkType := range f.Supported() {
+		if !f.disabled[checkType] {
+			enabled = append(enabled, checkType)
+		}
+	}
+	return enabled
+}
+
+// IsEnabled reports whether tasks of the given check type can be executed
+func (f *Factory) IsEnabled(checkType domain.CheckType) bool {
+	for _, enabled := range f.Enabled() {
+		if enabled == checkType {
+			return true
+		}
+	}
+	return false
 }
 
 func (f *Factory) GetRunner(checkType domain.CheckType) (Runner, error) {
-	log.Printf("🔧 DEBUG: GetRunner called with type: %s", checkType)
-	log.Printf("🔧 DEBUG: Factory state - http: %p, ping: %p, dns: %p, tcp: %p",
-		f.httpRunner, f.pingRunner, f.dnsRunner, f.tcpRunner)
+	f.mutex.RLock()
+	disabled := f.disabled[checkType]
+	f.mutex.RUnlock()
+	if disabled {
+		return nil, fmt.Errorf("%w: %s", ErrRunnerDisabled, checkType)
+	}
 
 	switch checkType {
 	case domain.HTTPCheck, domain.HTTPSCheck:
-		log.Printf("🔧 DEBUG: Returning HTTP runner: %p", f.httpRunner)
 		if f.httpRunner == nil {
 			return nil, fmt.Errorf("HTTP runner not initialized")
 		}
 		return f.httpRunner, nil
 	case domain.PingCheck:
-		log.Printf("🔧 DEBUG: Returning Ping runner: %p", f.pingRunner)
 		if f.pingRunner == nil {
 			return nil, fmt.Errorf("Ping runner not initialized")
 		}
 		return f.pingRunner, nil
 	case domain.DNSCheck:
-		log.Printf("🔧 DEBUG: Returning DNS runner: %p", f.dnsRunner)
 		if f.dnsRunner == nil {
 			return nil, fmt.Errorf("DNS runner not initialized")
 		}
 		return f.dnsRunner, nil
 	case domain.TCPCheck:
-		log.Printf("🔧 DEBUG: Returning TCP runner: %p", f.tcpRunner)
 		if f.tcpRunner == nil {
 			return nil, fmt.Errorf("TCP runner not initialized")
 		}
 		return f.tcpRunner, nil
+	case domain.MTRCheck:
+		if f.mtrRunner == nil {
+			return nil, fmt.Errorf("MTR runner not initialized")
+		}
+		return f.mtrRunner, nil
 	default:
 		return nil, fmt.Errorf("unknown check type: %s", checkType)
 	}
@@ -1,12 +1,16 @@
 package runner
 
 import (
+	"NetScan/internal/agent/domain"
 	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,7 +19,6 @@ type HTTPRunner struct {
 }
 
 func NewHTTPRunner() *HTTPRunner {
-	fmt.Printf("🔧 DEBUG: Creating HTTPRunner")
 	return &HTTPRunner{
 		client: &http.Client{
 			Timeout: 30 * time.Second,
@@ -30,7 +33,7 @@ func NewHTTPRunner() *HTTPRunner {
 			},
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				if len(via) >= 10 {
-					return fmt.Errorf("too many redirects")
+					return errTooManyRedirects
 				}
 				return nil
 			},
@@ -38,22 +41,36 @@ func NewHTTPRunner() *HTTPRunner {
 	}
 }
 
+// SetProxy sends HTTP checks through the given proxy, nil disables it
+func (r *HTTPRunner) SetProxy(proxyURL *url.URL) {
+	transport := r.client.Transport.(*http.Transport)
+	if proxyURL == nil {
+		transport.Proxy = nil
+		return
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+}
+
 func (r *HTTPRunner) Execute(ctx context.Context, target string, options map[string]interface{}) (map[string]interface{}, error) {
 	fullURL, err := r.normalizeURL(target)
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+		return nil, failWith(domain.FailureInvalidTask, domain.PhaseSetup, "invalid URL: %v", err)
 	}
 
 	method := getStringOption(options, "method", "GET")
 	headers := getHeadersOption(options)
 	followRedirects := getBoolOption(options, "follow_redirects", true)
 	verifySSL := getBoolOption(options, "verify_ssl", true)
+	timeout := getDurationOption(options, "timeout", r.client.Timeout)
+
+	client := r.configureClient(followRedirects, verifySSL, timeout)
 
-	client := r.configureClient(followRedirects, verifySSL)
+	phase := newPhaseTracker()
+	ctx = httptrace.WithClientTrace(ctx, phase.trace())
 
 	req, err := http.NewRequestWithContext(ctx, method, fullURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, failWith(domain.FailureInvalidOptions, domain.PhaseSetup, "failed to create request: %v", err)
 	}
 
 	for key, value := range headers {
@@ -69,12 +86,17 @@ func (r *HTTPRunner) Execute(ctx context.Context, target string, options map[str
 	responseTime := time.Since(start)
 
 	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %w", err)
+		return nil, fail(phase.current(), fmt.Errorf("HTTP request failed: %w", err))
 	}
 	defer resp.Body.Close()
 
 	result := r.collectBasicInfo(resp, responseTime, fullURL)
 
+	// through a proxy the connection goes to the proxy, not the target
+	if ip := phase.remoteIP(); ip != "" && client.Transport.(*http.Transport).Proxy == nil {
+		result["resolved_ip"] = ip
+	}
+
 	if resp.TLS != nil {
 		result["ssl"] = r.collectSSLInfo(resp.TLS)
 		result["protocol"] = resp.TLS.NegotiatedProtocol
@@ -95,9 +117,70 @@ func (r *HTTPRunner) Execute(ctx context.Context, target string, options map[str
 		result["content_type"] = resp.Header.Get("Content-Type")
 	}
 
+	if !statusExpected(resp.StatusCode, getIntSliceOption(options, "expected_status")) {
+		return result, failWith(domain.FailureHTTPStatus, domain.PhaseResponse, "unexpected status %s", resp.Status)
+	}
+
 	return result, nil
 }
 
+// statusExpected accepts any status below 400 unless expected lists the
+// accepted codes explicitly
+func statusExpected(status int, expected []int) bool {
+	if len(expected) == 0 {
+		return status < http.StatusBadRequest
+	}
+	for _, code := range expected {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// phaseTracker remembers how far a request got, so a transport error can be
+// attributed to resolving, connecting, the TLS handshake or the exchange
+type phaseTracker struct {
+	phase atomic.Value
+	// address of the first connection, redirects may connect elsewhere
+	remote atomic.Value
+}
+
+func newPhaseTracker() *phaseTracker {
+	tracker := &phaseTracker{}
+	tracker.phase.Store(domain.PhaseConnect)
+	return tracker
+}
+
+func (p *phaseTracker) current() string {
+	return p.phase.Load().(string)
+}
+
+// remoteIP returns the address the request was sent to, empty before a
+// connection was made
+func (p *phaseTracker) remoteIP() string {
+	ip, _ := p.remote.Load().(string)
+	return ip
+}
+
+func (p *phaseTracker) trace() *httptrace.ClientTrace {
+	set := func(phase string) { p.phase.Store(phase) }
+	gotConn := func(info httptrace.GotConnInfo) {
+		set(domain.PhaseRequest)
+		if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+			p.remote.CompareAndSwap(nil, host)
+		}
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { set(domain.PhaseResolve) },
+		ConnectStart:      func(string, string) { set(domain.PhaseConnect) },
+		TLSHandshakeStart: func() { set(domain.PhaseTLS) },
+		GotConn:           gotConn,
+		WroteRequest:      func(httptrace.WroteRequestInfo) { set(domain.PhaseResponse) },
+	}
+}
+
 func (r *HTTPRunner) normalizeURL(target string) (string, error) {
 	if _, err := url.ParseRequestURI(target); err != nil {
 		if httpURL, err := url.Parse("http://" + target); err == nil {
@@ -108,13 +191,16 @@ func (r *HTTPRunner) normalizeURL(target string) (string, error) {
 	return target, nil
 }
 
-func (r *HTTPRunner) configureClient(followRedirects, verifySSL bool) *http.Client {
+// configureClient copies the shared client with the options of one check,
+// timeout covers the whole exchange including redirects and the body
+func (r *HTTPRunner) configureClient(followRedirects, verifySSL bool, timeout time.Duration) *http.Client {
 	transport := r.client.Transport.(*http.Transport).Clone()
 
 	transport.TLSClientConfig.InsecureSkipVerify = !verifySSL
 
 	client := *r.client
 	client.Transport = transport
+	client.Timeout = timeout
 
 	if !followRedirects {
 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
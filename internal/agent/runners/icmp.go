@@ -0,0 +1,246 @@
+package runner
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	protocolICMP = 1
+	icmpReadSize = 1500
+)
+
+var icmpIDCounter uint32
+
+// icmpSocket is an IPv4 ICMP endpoint used by the ping and mtr runners.
+// A raw socket is preferred because only it receives Time Exceeded messages;
+// the unprivileged datagram socket is used as a fallback for plain echo.
+type icmpSocket struct {
+	conn       *icmp.PacketConn
+	privileged bool
+	id         int
+}
+
+type icmpReply struct {
+	seq      int
+	from     net.IP
+	received time.Time
+	// reached is true for an echo reply from the destination itself
+	reached bool
+}
+
+func listenICMP(requirePrivileged bool) (*icmpSocket, error) {
+	id := int((uint32(os.Getpid()) + atomic.AddUint32(&icmpIDCounter, 1)) & 0xffff)
+
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err == nil {
+		return &icmpSocket{conn: conn, privileged: true, id: id}, nil
+	}
+
+	if requirePrivileged {
+		return nil, fmt.Errorf("raw ICMP socket unavailable (CAP_NET_RAW required): %w", err)
+	}
+
+	conn, udpErr := icmp.ListenPacket("udp4", "0.0.0.0")
+	if udpErr != nil {
+		return nil, fmt.Errorf("ICMP socket unavailable: %w", udpErr)
+	}
+
+	return &icmpSocket{conn: conn, privileged: false, id: id}, nil
+}
+
+// ProbeICMP checks that an ICMP socket can be opened, requirePrivileged
+// asks for the raw socket used by mtr
+func ProbeICMP(requirePrivileged bool) error {
+	socket, err := listenICMP(requirePrivileged)
+	if err != nil {
+		return err
+	}
+	return socket.Close()
+}
+
+func (s *icmpSocket) Close() error {
+	return s.conn.Close()
+}
+
+func (s *icmpSocket) mode() string {
+	if s.privileged {
+		return "raw"
+	}
+	return "datagram"
+}
+
+func (s *icmpSocket) destination(ip net.IP) net.Addr {
+	if s.privileged {
+		return &net.IPAddr{IP: ip}
+	}
+	return &net.UDPAddr{IP: ip}
+}
+
+// sendEcho sends an echo request with the given sequence number. A ttl of zero
+// keeps the system default.
+func (s *icmpSocket) sendEcho(dst net.IP, seq, ttl, payloadSize int) (time.Time, error) {
+	if ttl > 0 {
+		if err := s.conn.IPv4PacketConn().SetTTL(ttl); err != nil {
+			return time.Time{}, fmt.Errorf("failed to set TTL: %w", err)
+		}
+	}
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   s.id,
+			Seq:  seq & 0xffff,
+			Data: make([]byte, payloadSize),
+		},
+	}
+
+	packet, err := msg.Marshal(nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to marshal ICMP echo: %w", err)
+	}
+
+	sentAt := time.Now()
+	if _, err := s.conn.WriteTo(packet, s.destination(dst)); err != nil {
+		return time.Time{}, fmt.Errorf("failed to send ICMP echo: %w", err)
+	}
+
+	return sentAt, nil
+}
+
+// readReply waits until the deadline for a reply that belongs to this socket.
+// It returns nil without an error when the deadline passes.
+func (s *icmpSocket) readReply(deadline time.Time) (*icmpReply, error) {
+	buffer := make([]byte, icmpReadSize)
+
+	for {
+		if err := s.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		n, peer, err := s.conn.ReadFrom(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		received := time.Now()
+
+		reply := s.parseReply(buffer[:n], peer)
+		if reply == nil {
+			continue
+		}
+		reply.received = received
+		return reply, nil
+	}
+}
+
+func (s *icmpSocket) parseReply(packet []byte, peer net.Addr) *icmpReply {
+	msg, err := icmp.ParseMessage(protocolICMP, packet)
+	if err != nil {
+		return nil
+	}
+
+	from := addrIP(peer)
+
+	switch msg.Type {
+	case ipv4.ICMPTypeEchoReply:
+		echo, ok := msg.Body.(*icmp.Echo)
+		// The kernel rewrites the identifier of datagram sockets and already
+		// filters replies for us, so the ID is only meaningful on raw sockets.
+		if !ok || (s.privileged && echo.ID != s.id) {
+			return nil
+		}
+		return &icmpReply{seq: echo.Seq, from: from, reached: true}
+	case ipv4.ICMPTypeTimeExceeded:
+		body, ok := msg.Body.(*icmp.TimeExceeded)
+		if !ok {
+			return nil
+		}
+		id, seq, ok := quotedEcho(body.Data)
+		if !ok || id != s.id {
+			return nil
+		}
+		return &icmpReply{seq: seq, from: from}
+	case ipv4.ICMPTypeDestinationUnreachable:
+		body, ok := msg.Body.(*icmp.DstUnreach)
+		if !ok {
+			return nil
+		}
+		id, seq, ok := quotedEcho(body.Data)
+		if !ok || id != s.id {
+			return nil
+		}
+		return &icmpReply{seq: seq, from: from}
+	}
+
+	return nil
+}
+
+// quotedEcho extracts the identifier and sequence of the original echo request
+// quoted inside an ICMP error message (IPv4 header + first 8 bytes).
+func quotedEcho(data []byte) (id, seq int, ok bool) {
+	if len(data) < ipv4.HeaderLen {
+		return 0, 0, false
+	}
+
+	headerLen := int(data[0]&0x0f) * 4
+	if len(data) < headerLen+8 {
+		return 0, 0, false
+	}
+
+	echo := data[headerLen:]
+	if echo[0] != byte(ipv4.ICMPTypeEcho) {
+		return 0, 0, false
+	}
+
+	return int(binary.BigEndian.Uint16(echo[4:6])), int(binary.BigEndian.Uint16(echo[6:8])), true
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func resolveIPv4(ctx context.Context, target string) (net.IP, error) {
+	host := extractHost(target)
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		return nil, fmt.Errorf("only IPv4 targets are supported: %s", host)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	for _, addr := range addrs {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no IPv4 address found for %s", host)
+}
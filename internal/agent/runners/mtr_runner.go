@@ -0,0 +1,294 @@
+package runner
+
+import (
+	"NetScan/internal/agent/domain"
+	"context"
+	"net"
+	"time"
+)
+
+type MTRRunner struct {
+	timeout time.Duration
+}
+
+type mtrProbe struct {
+	hop    int
+	sentAt time.Time
+}
+
+type mtrHop struct {
+	number    int
+	addresses []string
+	sent      int
+	received  int
+	rtts      []time.Duration
+}
+
+func NewMTRRunner() *MTRRunner {
+	return &MTRRunner{
+		timeout: 2 * time.Second,
+	}
+}
+
+func (r *MTRRunner) Execute(ctx context.Context, target string, options map[string]interface{}) (map[string]interface{}, error) {
+	return r.ExecuteWithProgress(ctx, target, options, nil)
+}
+
+// ExecuteWithProgress probes every hop towards the target once per interval
+// for the configured duration and reports per-hop statistics after each cycle.
+func (r *MTRRunner) ExecuteWithProgress(ctx context.Context, target string, options map[string]interface{}, report ProgressFunc) (map[string]interface{}, error) {
+	maxHops := getIntOption(options, "max_hops", 30)
+	interval := getDurationOption(options, "interval", time.Second)
+	duration := getDurationOption(options, "duration", 10*time.Second)
+	probeTimeout := getDurationOption(options, "timeout", r.timeout)
+	packetSize := getIntOption(options, "packet_size", 56)
+
+	if maxHops < 1 || maxHops > 64 {
+		return nil, failWith(domain.FailureInvalidOptions, domain.PhaseSetup, "max_hops must be between 1 and 64, got %d", maxHops)
+	}
+	if interval <= 0 {
+		return nil, failWith(domain.FailureInvalidOptions, domain.PhaseSetup, "interval must be positive")
+	}
+
+	cycles := getIntOption(options, "cycles", int(duration/interval))
+	if cycles < 1 {
+		cycles = 1
+	}
+
+	dst, err := resolveIPv4(ctx, target)
+	if err != nil {
+		return nil, fail(domain.PhaseResolve, err)
+	}
+
+	socket, err := listenICMP(true)
+	if err != nil {
+		return nil, fail(domain.PhaseSetup, err)
+	}
+	defer socket.Close()
+
+	replies := make(chan *icmpReply, maxHops*2)
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	go r.readReplies(readCtx, socket, replies)
+
+	hops := make([]*mtrHop, maxHops)
+	for i := range hops {
+		hops[i] = &mtrHop{number: i + 1}
+	}
+
+	pending := make(map[int]mtrProbe)
+	destinationHop := 0
+	seq := 0
+
+	handleReply := func(reply *icmpReply) {
+		probe, ok := pending[reply.seq]
+		if !ok {
+			return
+		}
+		delete(pending, reply.seq)
+
+		rtt := reply.received.Sub(probe.sentAt)
+		if rtt > probeTimeout {
+			return
+		}
+
+		hop := hops[probe.hop-1]
+		hop.received++
+		hop.rtts = append(hop.rtts, rtt)
+		hop.addAddress(reply.from)
+
+		if reply.reached && (destinationHop == 0 || probe.hop < destinationHop) {
+			destinationHop = probe.hop
+		}
+	}
+
+	for cycle := 0; cycle < cycles; cycle++ {
+		lastHop := maxHops
+		if destinationHop > 0 {
+			lastHop = destinationHop
+		}
+
+		for ttl := 1; ttl <= lastHop; ttl++ {
+			seq++
+			sentAt, err := socket.sendEcho(dst, seq, ttl, packetSize)
+			if err != nil {
+				return nil, fail(domain.PhaseRequest, err)
+			}
+			hops[ttl-1].sent++
+			pending[seq&0xffff] = mtrProbe{hop: ttl, sentAt: sentAt}
+		}
+
+		wait := time.NewTimer(interval)
+	collect:
+		for {
+			select {
+			case <-ctx.Done():
+				wait.Stop()
+				return nil, fail(domain.PhaseResponse, ctx.Err())
+			case reply := <-replies:
+				handleReply(reply)
+			case <-wait.C:
+				break collect
+			}
+		}
+
+		r.expireProbes(pending, probeTimeout)
+
+		if report != nil {
+			report("mtr_cycle", float64(cycle+1)/float64(cycles), map[string]interface{}{
+				"cycle":  cycle + 1,
+				"cycles": cycles,
+				"hops":   summarizeHops(hops, destinationHop),
+			})
+		}
+	}
+
+	// Give the probes of the last cycle a chance to come back
+	drain := time.NewTimer(probeTimeout)
+	defer drain.Stop()
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, fail(domain.PhaseResponse, ctx.Err())
+		case reply := <-replies:
+			handleReply(reply)
+		case <-drain.C:
+			pending = nil
+		}
+	}
+
+	summary := summarizeHops(hops, destinationHop)
+
+	result := map[string]interface{}{
+		"target":              target,
+		"resolved_ip":         dst.String(),
+		"cycles":              cycles,
+		"interval":            interval.Milliseconds(),
+		"max_hops":            maxHops,
+		"socket":              socket.mode(),
+		"destination_reached": destinationHop > 0,
+		"hop_count":           len(summary),
+		"hops":                summary,
+	}
+
+	if lossHop := findLossHop(hops, destinationHop); lossHop > 0 {
+		result["loss_hop"] = lossHop
+	}
+
+	return result, nil
+}
+
+func (r *MTRRunner) readReplies(ctx context.Context, socket *icmpSocket, replies chan<- *icmpReply) {
+	for ctx.Err() == nil {
+		reply, err := socket.readReply(time.Now().Add(200 * time.Millisecond))
+		if err != nil {
+			return
+		}
+		if reply == nil {
+			continue
+		}
+
+		select {
+		case replies <- reply:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *MTRRunner) expireProbes(pending map[int]mtrProbe, timeout time.Duration) {
+	now := time.Now()
+	for seq, probe := range pending {
+		if now.Sub(probe.sentAt) > timeout {
+			delete(pending, seq)
+		}
+	}
+}
+
+func (h *mtrHop) addAddress(ip net.IP) {
+	if ip == nil {
+		return
+	}
+
+	address := ip.String()
+	for _, existing := range h.addresses {
+		if existing == address {
+			return
+		}
+	}
+	h.addresses = append(h.addresses, address)
+}
+
+func (h *mtrHop) loss() float64 {
+	if h.sent == 0 {
+		return 0
+	}
+	return float64(h.sent-h.received) / float64(h.sent) * 100
+}
+
+func (h *mtrHop) summary() map[string]interface{} {
+	hop := map[string]interface{}{
+		"hop":       h.number,
+		"addresses": h.addresses,
+		"sent":      h.sent,
+		"received":  h.received,
+		"loss":      h.loss(),
+	}
+
+	if len(h.addresses) > 0 {
+		hop["address"] = h.addresses[0]
+	}
+
+	if len(h.rtts) > 0 {
+		best, worst, avg := calculateRTTStats(h.rtts)
+		hop["best_rtt"] = durationMs(best)
+		hop["avg_rtt"] = durationMs(avg)
+		hop["worst_rtt"] = durationMs(worst)
+		hop["last_rtt"] = durationMs(h.rtts[len(h.rtts)-1])
+		hop["jitter"] = durationMs(calculateJitter(h.rtts))
+	}
+
+	return hop
+}
+
+// summarizeHops trims hops beyond the destination and trailing silent hops
+func summarizeHops(hops []*mtrHop, destinationHop int) []map[string]interface{} {
+	last := len(hops)
+	if destinationHop > 0 {
+		last = destinationHop
+	} else {
+		for last > 0 && hops[last-1].received == 0 {
+			last--
+		}
+	}
+
+	summary := make([]map[string]interface{}, 0, last)
+	for _, hop := range hops[:last] {
+		summary = append(summary, hop.summary())
+	}
+	return summary
+}
+
+// findLossHop returns the first hop from which loss persists up to the
+// destination. Loss on a single intermediate hop that does not carry over to
+// later hops is usually ICMP rate limiting and is ignored.
+func findLossHop(hops []*mtrHop, destinationHop int) int {
+	if destinationHop == 0 {
+		return 0
+	}
+
+	final := hops[destinationHop-1].loss()
+	if final == 0 {
+		return 0
+	}
+
+	lossHop := destinationHop
+	for i := destinationHop - 2; i >= 0; i-- {
+		if hops[i].loss() == 0 {
+			break
+		}
+		lossHop = i + 1
+	}
+
+	return lossHop
+}
@@ -30,8 +30,9 @@ func getBoolOption(options map[string]interface{}, key string, defaultValue bool
 }
 
 func getDurationOption(options map[string]interface{}, key string, defaultValue time.Duration) time.Duration {
+	// fractional seconds like 0.5 must not be truncated to zero
 	if value, ok := options[key].(float64); ok {
-		return time.Duration(value) * time.Second
+		return time.Duration(value * float64(time.Second))
 	}
 	if value, ok := options[key].(string); ok {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -79,6 +80,28 @@ func getStringSliceOption(options map[string]interface{}, key string) []string {
 	return result
 }
 
+// getIntSliceOption accepts a single number or a list of numbers
+func getIntSliceOption(options map[string]interface{}, key string) []int {
+	switch value := options[key].(type) {
+	case float64:
+		return []int{int(value)}
+	case int:
+		return []int{value}
+	case []interface{}:
+		var result []int
+		for _, item := range value {
+			switch number := item.(type) {
+			case float64:
+				result = append(result, int(number))
+			case int:
+				result = append(result, number)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
 func parsePort(port interface{}) (int, bool) {
 	switch v := port.(type) {
 	case float64:
@@ -1,95 +1,303 @@
 package runner
 
 import (
+	"NetScan/internal/agent/domain"
 	"context"
 	"fmt"
 	"net"
 	"time"
 )
 
+const (
+	pingModeAuto = "auto"
+	pingModeICMP = "icmp"
+	pingModeTCP  = "tcp"
+
+	maxPingPacketSize = 65500
+)
+
 type PingRunner struct {
 	timeout time.Duration
 }
 
+// pingProber sends a single probe and returns its round-trip time
+type pingProber interface {
+	probe(ctx context.Context, seq int) (time.Duration, error)
+	mode() string
+	Close() error
+}
+
 func NewPingRunner() *PingRunner {
-	fmt.Printf("🔧 DEBUG: Creating PingRunner")
 	return &PingRunner{
 		timeout: 10 * time.Second,
 	}
 }
 
 func (r *PingRunner) Execute(ctx context.Context, target string, options map[string]interface{}) (map[string]interface{}, error) {
+	return r.ExecuteWithProgress(ctx, target, options, nil)
+}
+
+// ExecuteWithProgress sends count probes paced by interval and reports every
+// probe as it completes. The run stops as soon as ctx is cancelled or the
+// overall deadline passes.
+func (r *PingRunner) ExecuteWithProgress(ctx context.Context, target string, options map[string]interface{}, report ProgressFunc) (map[string]interface{}, error) {
 	count := getIntOption(options, "count", 4)
 	timeout := getDurationOption(options, "timeout", r.timeout)
+	interval := getDurationOption(options, "interval", time.Second)
+	packetSize := getIntOption(options, "packet_size", 56)
+	deadline := getDurationOption(options, "deadline", 0)
+	mode := getStringOption(options, "mode", pingModeAuto)
 
-	host, port, err := net.SplitHostPort(target)
+	if count < 1 {
+		return nil, failWith(domain.FailureInvalidOptions, domain.PhaseSetup, "count must be positive, got %d", count)
+	}
+	if packetSize < 0 || packetSize > maxPingPacketSize {
+		return nil, failWith(domain.FailureInvalidOptions, domain.PhaseSetup, "packet_size must be between 0 and %d, got %d", maxPingPacketSize, packetSize)
+	}
+	if interval < 0 {
+		return nil, failWith(domain.FailureInvalidOptions, domain.PhaseSetup, "interval must not be negative")
+	}
+
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	prober, err := r.newProber(ctx, target, mode, timeout, packetSize)
 	if err != nil {
-		host = target
-		port = "80"
+		return nil, fail(domain.PhaseSetup, err)
 	}
+	defer prober.Close()
 
 	var rtts []time.Duration
 	packetsSent := 0
 	packetsReceived := 0
+	deadlineReached := false
+	var lastErr error
 
 	for i := 0; i < count; i++ {
 		start := time.Now()
 
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
-		if err != nil {
-			packetsSent++
-			continue
+		rtt, err := prober.probe(ctx, i+1)
+		packetsSent++
+
+		if ctx.Err() != nil {
+			// An expired deadline ends the run early, cancellation aborts it
+			if deadline > 0 && ctx.Err() == context.DeadlineExceeded {
+				packetsSent--
+				deadlineReached = true
+				break
+			}
+			return nil, fail(domain.PhaseResponse, ctx.Err())
 		}
-		conn.Close()
 
-		rtt := time.Since(start)
-		rtts = append(rtts, rtt)
-		packetsSent++
-		packetsReceived++
+		packet := map[string]interface{}{
+			"seq":      i + 1,
+			"received": err == nil,
+		}
+		if err == nil {
+			rtts = append(rtts, rtt)
+			packetsReceived++
+			packet["rtt"] = durationMs(rtt)
+		} else {
+			lastErr = err
+			packet["error"] = err.Error()
+		}
+
+		if report != nil {
+			report("ping_packet", float64(i+1)/float64(count), packet)
+		}
 
 		if i < count-1 {
-			time.Sleep(1 * time.Second)
+			if !sleepContext(ctx, interval-time.Since(start)) {
+				if deadline > 0 && ctx.Err() == context.DeadlineExceeded {
+					deadlineReached = true
+					break
+				}
+				return nil, fail(domain.PhaseResponse, ctx.Err())
+			}
 		}
 	}
 
 	if len(rtts) == 0 {
-		return nil, fmt.Errorf("all ping attempts failed")
+		if lastErr == nil {
+			return nil, failWith(domain.FailureNoReply, domain.PhaseResponse, "all ping attempts failed")
+		}
+		return nil, fail(domain.PhaseResponse, fmt.Errorf("all ping attempts failed: %w", lastErr))
 	}
 
 	minRTT, maxRTT, avgRTT := calculateRTTStats(rtts)
 	packetLoss := float64(packetsSent-packetsReceived) / float64(packetsSent) * 100
 
-	return map[string]interface{}{
+	rttsMs := make([]float64, len(rtts))
+	for i, rtt := range rtts {
+		rttsMs[i] = durationMs(rtt)
+	}
+
+	result := map[string]interface{}{
 		"packets_sent":     packetsSent,
 		"packets_received": packetsReceived,
 		"packet_loss":      packetLoss,
-		"min_rtt":          minRTT.Seconds() * 1000,
-		"max_rtt":          maxRTT.Seconds() * 1000,
-		"avg_rtt":          avgRTT.Seconds() * 1000,
+		"min_rtt":          durationMs(minRTT),
+		"max_rtt":          durationMs(maxRTT),
+		"avg_rtt":          durationMs(avgRTT),
+		"jitter":           durationMs(calculateJitter(rtts)),
+		"stddev_rtt":       durationMs(calculateStdDev(rtts)),
+		"p50_rtt":          durationMs(calculatePercentile(rtts, 50)),
+		"p90_rtt":          durationMs(calculatePercentile(rtts, 90)),
+		"p99_rtt":          durationMs(calculatePercentile(rtts, 99)),
+		"packet_size":      packetSize,
+		"interval":         interval.Milliseconds(),
+		"mode":             prober.mode(),
+		"deadline_reached": deadlineReached,
 		"target":           target,
-		"rtts":             rtts,
-	}, nil
+		"rtts":             rttsMs,
+	}
+	if icmp, ok := prober.(*icmpProber); ok {
+		result["resolved_ip"] = icmp.dst.String()
+	}
+
+	return result, nil
 }
 
-func calculateRTTStats(rtts []time.Duration) (min, max, avg time.Duration) {
-	if len(rtts) == 0 {
-		return 0, 0, 0
+func (r *PingRunner) newProber(ctx context.Context, target, mode string, timeout time.Duration, packetSize int) (pingProber, error) {
+	switch mode {
+	case pingModeTCP:
+		return newTCPProber(target, timeout), nil
+	case pingModeICMP, pingModeAuto:
+		prober, err := newICMPProber(ctx, target, timeout, packetSize)
+		if err == nil {
+			return prober, nil
+		}
+		if mode == pingModeICMP {
+			return nil, err
+		}
+		return newTCPProber(target, timeout), nil
+	default:
+		return nil, failWith(domain.FailureInvalidOptions, domain.PhaseSetup, "unknown ping mode: %s", mode)
+	}
+}
+
+type icmpProber struct {
+	socket     *icmpSocket
+	dst        net.IP
+	timeout    time.Duration
+	packetSize int
+}
+
+func newICMPProber(ctx context.Context, target string, timeout time.Duration, packetSize int) (*icmpProber, error) {
+	dst, err := resolveIPv4(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := listenICMP(false)
+	if err != nil {
+		return nil, err
 	}
 
-	min = rtts[0]
-	max = rtts[0]
-	total := time.Duration(0)
+	return &icmpProber{
+		socket:     socket,
+		dst:        dst,
+		timeout:    timeout,
+		packetSize: packetSize,
+	}, nil
+}
+
+func (p *icmpProber) probe(ctx context.Context, seq int) (time.Duration, error) {
+	// Closing the socket is the only way to interrupt a blocked read
+	stop := context.AfterFunc(ctx, func() { p.socket.Close() })
+	defer stop()
 
-	for _, rtt := range rtts {
-		if rtt < min {
-			min = rtt
+	sentAt, err := p.socket.sendEcho(p.dst, seq, 0, p.packetSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deadline := sentAt.Add(p.timeout)
+	for {
+		reply, err := p.socket.readReply(deadline)
+		if err != nil {
+			return 0, err
+		}
+		if reply == nil {
+			return 0, errRequestTimedOut
+		}
+		if reply.seq != seq&0xffff {
+			continue
 		}
-		if rtt > max {
-			max = rtt
+		if !reply.reached {
+			return 0, fmt.Errorf("%w, reported by %s", errDestinationUnreachable, reply.from)
 		}
-		total += rtt
+		return reply.received.Sub(sentAt), nil
 	}
+}
+
+func (p *icmpProber) mode() string {
+	return pingModeICMP
+}
 
-	avg = total / time.Duration(len(rtts))
-	return min, max, avg
+func (p *icmpProber) Close() error {
+	return p.socket.Close()
+}
+
+type tcpProber struct {
+	address string
+	timeout time.Duration
+}
+
+func newTCPProber(target string, timeout time.Duration) *tcpProber {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+		port = "80"
+	}
+
+	return &tcpProber{
+		address: net.JoinHostPort(host, port),
+		timeout: timeout,
+	}
+}
+
+func (p *tcpProber) probe(ctx context.Context, seq int) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.Close()
+
+	return rtt, nil
+}
+
+func (p *tcpProber) mode() string {
+	return pingModeTCP
+}
+
+func (p *tcpProber) Close() error {
+	return nil
+}
+
+// sleepContext waits for d and returns false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
@@ -5,3 +5,12 @@ import "context"
 type Runner interface {
 	Execute(ctx context.Context, target string, options map[string]interface{}) (map[string]interface{}, error)
 }
+
+// ProgressFunc receives intermediate results of long-running checks
+type ProgressFunc func(stage string, progress float64, data map[string]interface{})
+
+// ProgressRunner is implemented by runners that can stream intermediate results
+type ProgressRunner interface {
+	Runner
+	ExecuteWithProgress(ctx context.Context, target string, options map[string]interface{}, report ProgressFunc) (map[string]interface{}, error)
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+func calculateRTTStats(rtts []time.Duration) (min, max, avg time.Duration) {
+	if len(rtts) == 0 {
+		return 0, 0, 0
+	}
+
+	min = rtts[0]
+	max = rtts[0]
+	total := time.Duration(0)
+
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		total += rtt
+	}
+
+	avg = total / time.Duration(len(rtts))
+	return min, max, avg
+}
+
+// calculateJitter returns the mean absolute difference between consecutive RTTs
+func calculateJitter(rtts []time.Duration) time.Duration {
+	if len(rtts) < 2 {
+		return 0
+	}
+
+	total := time.Duration(0)
+	for i := 1; i < len(rtts); i++ {
+		diff := rtts[i] - rtts[i-1]
+		if diff < 0 {
+			diff = -diff
+		}
+		total += diff
+	}
+
+	return total / time.Duration(len(rtts)-1)
+}
+
+// calculateStdDev returns the population standard deviation of the RTTs
+func calculateStdDev(rtts []time.Duration) time.Duration {
+	if len(rtts) < 2 {
+		return 0
+	}
+
+	_, _, avg := calculateRTTStats(rtts)
+
+	var sum float64
+	for _, rtt := range rtts {
+		diff := float64(rtt - avg)
+		sum += diff * diff
+	}
+
+	return time.Duration(math.Sqrt(sum / float64(len(rtts))))
+}
+
+// calculatePercentile uses the nearest-rank method, p is in range (0, 100]
+func calculatePercentile(rtts []time.Duration, p float64) time.Duration {
+	if len(rtts) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(rtts))
+	copy(sorted, rtts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank-1]
+}
+
+func durationMs(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func ms(values ...int) []time.Duration {
+	durations := make([]time.Duration, len(values))
+	for i, value := range values {
+		durations[i] = time.Duration(value) * time.Millisecond
+	}
+	return durations
+}
+
+func TestCalculatePercentile(t *testing.T) {
+	tests := []struct {
+		name string
+		rtts []time.Duration
+		p    float64
+		want time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single", ms(7), 99, 7 * time.Millisecond},
+		{"median of odd count", ms(30, 10, 20), 50, 20 * time.Millisecond},
+		// nearest rank: ceil(0.5 * 4) = 2, no interpolation
+		{"median of even count", ms(40, 10, 30, 20), 50, 20 * time.Millisecond},
+		{"p90 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 90, 9 * time.Millisecond},
+		{"p95 of ten rounds up", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 95, 10 * time.Millisecond},
+		{"p100 is the maximum", ms(5, 1, 9), 100, 9 * time.Millisecond},
+		{"tiny p is the minimum", ms(5, 1, 9), 0.1, 1 * time.Millisecond},
+		{"p above 100 is clamped", ms(5, 1, 9), 150, 9 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculatePercentile(tt.rtts, tt.p); got != tt.want {
+				t.Errorf("calculatePercentile(%v, %v) = %v, want %v", tt.rtts, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculatePercentileKeepsInput(t *testing.T) {
+	rtts := ms(3, 1, 2)
+	calculatePercentile(rtts, 50)
+
+	if rtts[0] != 3*time.Millisecond || rtts[1] != time.Millisecond || rtts[2] != 2*time.Millisecond {
+		t.Errorf("input was reordered: %v", rtts)
+	}
+}
+
+func TestRTTStats(t *testing.T) {
+	tests := []struct {
+		name           string
+		rtts           []time.Duration
+		min, max, avg  time.Duration
+		jitter, stdDev time.Duration
+	}{
+		{"empty", nil, 0, 0, 0, 0, 0},
+		{"single", ms(10), 10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond, 0, 0},
+		{
+			"steady",
+			ms(10, 10, 10),
+			10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond,
+			0, 0,
+		},
+		{
+			"varying",
+			ms(10, 30, 20, 40),
+			10 * time.Millisecond, 40 * time.Millisecond, 25 * time.Millisecond,
+			// |30-10| + |20-30| + |40-20| = 50 over 3 differences
+			50 * time.Millisecond / 3,
+			// sqrt((225 + 25 + 25 + 225) / 4) ms
+			11180339,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min, max, avg := calculateRTTStats(tt.rtts)
+			if min != tt.min || max != tt.max || avg != tt.avg {
+				t.Errorf("stats = %v/%v/%v, want %v/%v/%v", min, max, avg, tt.min, tt.max, tt.avg)
+			}
+			if jitter := calculateJitter(tt.rtts); jitter != tt.jitter {
+				t.Errorf("jitter = %v, want %v", jitter, tt.jitter)
+			}
+			if stdDev := calculateStdDev(tt.rtts); stdDev != tt.stdDev {
+				t.Errorf("std dev = %v, want %v", stdDev, tt.stdDev)
+			}
+		})
+	}
+}
+
+func TestGetDurationOption(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  time.Duration
+	}{
+		{"whole seconds", float64(2), 2 * time.Second},
+		{"fractional seconds", 0.5, 500 * time.Millisecond},
+		{"duration string", "250ms", 250 * time.Millisecond},
+		{"invalid string", "soon", time.Minute},
+		{"missing", nil, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]interface{}{}
+			if tt.value != nil {
+				options["timeout"] = tt.value
+			}
+			if got := getDurationOption(options, "timeout", time.Minute); got != tt.want {
+				t.Errorf("getDurationOption(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
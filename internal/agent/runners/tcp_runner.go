@@ -1,19 +1,21 @@
 package runner
 
 import (
+	"NetScan/internal/agent/domain"
 	"context"
-	"fmt"
 	"net"
 	"strconv"
 	"time"
 )
 
+// how long to wait for a server that speaks first
+const bannerReadTimeout = 2 * time.Second
+
 type TCPRunner struct {
 	timeout time.Duration
 }
 
 func NewTCPRunner() *TCPRunner {
-	fmt.Printf("🔧 DEBUG: Creating TCPRunner")
 	return &TCPRunner{
 		timeout: 10 * time.Second,
 	}
@@ -57,16 +59,19 @@ func (r *TCPRunner) Execute(ctx context.Context, target string, options map[stri
 			result["temporary"] = netErr.Temporary()
 		}
 
-		return result, nil
+		return result, fail(domain.PhaseConnect, err)
 	}
 	defer conn.Close()
 
 	result["port_open"] = true
 	result["local_address"] = conn.LocalAddr().String()
 	result["remote_address"] = conn.RemoteAddr().String()
+	if ip, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+		result["resolved_ip"] = ip
+	}
 
 	if getBoolOption(options, "banner_grab", false) {
-		banner, bannerErr := r.grabBanner(ctx, conn, timeout)
+		banner, bannerErr := r.grabBanner(ctx, conn)
 		if bannerErr == nil && banner != "" {
 			result["banner"] = banner
 			result["banner_grabbed"] = true
@@ -81,9 +86,18 @@ func (r *TCPRunner) Execute(ctx context.Context, target string, options map[stri
 	return result, nil
 }
 
-func (r *TCPRunner) grabBanner(ctx context.Context, conn net.Conn, timeout time.Duration) (string, error) {
-	readTimeout := getDurationOption(nil, "", 2*time.Second)
-	conn.SetReadDeadline(time.Now().Add(readTimeout))
+// grabBanner reads what the server sends first, waiting at most
+// bannerReadTimeout and never past the deadline of ctx
+func (r *TCPRunner) grabBanner(ctx context.Context, conn net.Conn) (string, error) {
+	readDeadline := time.Now().Add(bannerReadTimeout)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(readDeadline) {
+		readDeadline = deadline
+	}
+	conn.SetReadDeadline(readDeadline)
+
+	// Cancellation has no deadline, closing the connection unblocks the read
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
 	buffer := make([]byte, 1024)
 	n, err := conn.Read(buffer)
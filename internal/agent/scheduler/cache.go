@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"NetScan/internal/agent/domain"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// loadManifest returns nil without an error when nothing is cached yet
+func loadManifest(path string) (*domain.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read manifest cache: %w", err)
+	}
+
+	var manifest domain.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest cache: %w", err)
+	}
+	return &manifest, nil
+}
+
+// saveManifest replaces the cache atomically, a crash while writing keeps
+// the previous manifest
+func saveManifest(path string, manifest *domain.Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create manifest cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".monitors-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary manifest cache: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest cache: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync manifest cache: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close manifest cache: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace manifest cache: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package scheduler
+
+import (
+	"NetScan/internal/agent/domain"
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// MinInterval is the shortest interval a monitor may run at
+const MinInterval = 10 * time.Second
+
+// Executor runs a single check, the task handler implements it
+type Executor interface {
+	ExecuteTask(ctx context.Context, task *domain.Task) *domain.Result
+	CanExecute(checkType domain.CheckType) bool
+}
+
+// Spool keeps results until the backend accepts them
+type Spool interface {
+	Append(result *domain.Result) error
+}
+
+type Config struct {
+	// CacheFile keeps the last manifest for starts without a backend
+	CacheFile string
+	// MaxConcurrent limits local runs executing at the same time
+	MaxConcurrent int
+	// Online reports whether the backend is reachable, monitors are only
+	// run locally while it is not
+	Online func() bool
+	// AgentID returns the ID results are reported under
+	AgentID func() string
+}
+
+// Scheduler runs the monitors of the cached manifest while the backend is
+// unreachable. Runs are aligned to the interval with a fixed offset per
+// monitor, so they neither drift nor fire all at once after a restart.
+type Scheduler struct {
+	executor Executor
+	spool    Spool
+	config   Config
+	logger   *slog.Logger
+	slots    chan struct{}
+	changed  chan struct{}
+
+	mutex    sync.Mutex
+	manifest *domain.Manifest
+	running  map[string]bool
+}
+
+func New(executor Executor, spool Spool, config Config, logger *slog.Logger) *Scheduler {
+	if config.MaxConcurrent < 1 {
+		config.MaxConcurrent = 1
+	}
+
+	return &Scheduler{
+		executor: executor,
+		spool:    spool,
+		config:   config,
+		logger:   logger,
+		slots:    make(chan struct{}, config.MaxConcurrent),
+		changed:  make(chan struct{}, 1),
+		manifest: &domain.Manifest{},
+		running:  make(map[string]bool),
+	}
+}
+
+// Load restores the manifest cached by a previous run
+func (s *Scheduler) Load() error {
+	manifest, err := loadManifest(s.config.CacheFile)
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	s.setManifest(manifest)
+	s.logger.Info("Monitor manifest loaded from cache",
+		"version", manifest.Version,
+		"monitors", len(manifest.Monitors),
+	)
+	return nil
+}
+
+// Manifest returns the current manifest
+func (s *Scheduler) Manifest() *domain.Manifest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.manifest
+}
+
+// Update replaces the manifest and caches it, manifests of the same version
+// are ignored
+func (s *Scheduler) Update(manifest *domain.Manifest) {
+	if manifest == nil || manifest.Version == s.Manifest().Version {
+		return
+	}
+
+	s.setManifest(manifest)
+	s.logger.Info("Monitor manifest updated",
+		"version", manifest.Version,
+		"monitors", len(manifest.Monitors),
+	)
+
+	if err := saveManifest(s.config.CacheFile, manifest); err != nil {
+		s.logger.Warn("Failed to cache monitor manifest", "error", err)
+	}
+}
+
+func (s *Scheduler) setManifest(manifest *domain.Manifest) {
+	s.mutex.Lock()
+	s.manifest = manifest
+	s.mutex.Unlock()
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run fires due monitors until ctx is cancelled and waits for the runs in
+// progress
+func (s *Scheduler) Run(ctx context.Context) {
+	var runs sync.WaitGroup
+	defer runs.Wait()
+
+	next := make(map[string]time.Time)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		monitors := s.monitors()
+		now := time.Now()
+
+		// monitors that were added or changed interval get a new slot
+		planned := make(map[string]time.Time, len(monitors))
+		var earliest time.Time
+		for id, monitor := range monitors {
+			at, ok := next[id]
+			if !ok || at.Sub(now) > monitor.Interval {
+				at = nextRun(monitor, now)
+			}
+			planned[id] = at
+			if earliest.IsZero() || at.Before(earliest) {
+				earliest = at
+			}
+		}
+		next = planned
+
+		var wake <-chan time.Time
+		if !earliest.IsZero() {
+			timer.Reset(time.Until(earliest))
+			wake = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.changed:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			continue
+		case <-wake:
+		}
+
+		now = time.Now()
+		for id, at := range next {
+			if at.After(now) {
+				continue
+			}
+			monitor := monitors[id]
+			next[id] = nextRun(monitor, now)
+			s.fire(ctx, &runs, monitor)
+		}
+	}
+}
+
+// monitors returns the runnable monitors of the manifest by ID
+func (s *Scheduler) monitors() map[string]domain.Monitor {
+	manifest := s.Manifest()
+
+	monitors := make(map[string]domain.Monitor, len(manifest.Monitors))
+	for _, monitor := range manifest.Monitors {
+		if monitor.ID == "" || monitor.Interval < MinInterval {
+			s.logger.Debug("Skipping invalid monitor", "monitor_id", monitor.ID, "interval", monitor.Interval)
+			continue
+		}
+		monitors[monitor.ID] = monitor
+	}
+	return monitors
+}
+
+// fire starts a local run unless the backend is reachable, the previous run
+// of the monitor is still going or all run slots are taken
+func (s *Scheduler) fire(ctx context.Context, runs *sync.WaitGroup, monitor domain.Monitor) {
+	if s.config.Online != nil && s.config.Online() {
+		return
+	}
+
+	if !s.executor.CanExecute(monitor.Type) {
+		s.logger.Debug("Monitor check type is not enabled", "monitor_id", monitor.ID, "type", monitor.Type)
+		return
+	}
+
+	s.mutex.Lock()
+	if s.running[monitor.ID] {
+		s.mutex.Unlock()
+		s.logger.Warn("Previous run of monitor still in progress, skipping", "monitor_id", monitor.ID)
+		return
+	}
+	s.running[monitor.ID] = true
+	s.mutex.Unlock()
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		s.finished(monitor.ID)
+		s.logger.Warn("All local run slots busy, skipping monitor run", "monitor_id", monitor.ID)
+		return
+	}
+
+	runs.Add(1)
+	go func() {
+		defer runs.Done()
+		defer func() { <-s.slots }()
+		defer s.finished(monitor.ID)
+
+		s.run(ctx, monitor)
+	}()
+}
+
+func (s *Scheduler) run(ctx context.Context, monitor domain.Monitor) {
+	agentID := ""
+	if s.config.AgentID != nil {
+		agentID = s.config.AgentID()
+	}
+
+	result := s.executor.ExecuteTask(ctx, monitor.Task(agentID))
+	if ctx.Err() != nil {
+		// cut short by shutdown, the result says nothing about the target
+		return
+	}
+
+	if err := s.spool.Append(result); err != nil {
+		s.logger.Error("Failed to spool monitor result, it is lost",
+			"error", err,
+			"monitor_id", monitor.ID,
+		)
+		return
+	}
+
+	s.logger.Info("Monitor run locally",
+		"monitor_id", monitor.ID,
+		"type", monitor.Type,
+		"target", monitor.Target,
+		"success", result.Success,
+	)
+}
+
+func (s *Scheduler) finished(monitorID string) {
+	s.mutex.Lock()
+	delete(s.running, monitorID)
+	s.mutex.Unlock()
+}
+
+// nextRun returns the first slot after now. Slots are multiples of the
+// interval shifted by an offset derived from the monitor ID.
+func nextRun(monitor domain.Monitor, now time.Time) time.Time {
+	hash := fnv.New64a()
+	hash.Write([]byte(monitor.ID))
+	offset := time.Duration(hash.Sum64() % uint64(monitor.Interval))
+
+	at := now.Truncate(monitor.Interval).Add(offset)
+	for !at.After(now) {
+		at = at.Add(monitor.Interval)
+	}
+	return at
+}
@@ -0,0 +1,294 @@
+package spool
+
+import (
+	"NetScan/internal/agent/domain"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	dataFile   = "results.jsonl"
+	offsetFile = "results.offset"
+
+	// delivered records are cut from the file once they take this much space
+	compactThreshold = 4 << 20
+)
+
+var ErrFull = errors.New("spool is full")
+
+// Config limits the size of the spool and how long results are kept
+type Config struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+type record struct {
+	SpooledAt time.Time      `json:"spooled_at"`
+	Result    *domain.Result `json:"result"`
+}
+
+// Spool is an append-only file of results that could not be submitted.
+// Records are replayed in the order they were written, the position of the
+// first unsent record is kept in a separate offset file so a restart does not
+// submit them twice.
+type Spool struct {
+	config Config
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	offset int64
+	depth  int
+}
+
+func Open(config Config) (*Spool, error) {
+	if err := os.MkdirAll(config.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(config.Dir, dataFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool file: %w", err)
+	}
+
+	s := &Spool{
+		config: config,
+		file:   file,
+	}
+
+	if err := s.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// recover drops a partially written last record and counts pending records
+func (s *Spool) recover() error {
+	data, err := io.ReadAll(io.NewSectionReader(s.file, 0, 1<<62))
+	if err != nil {
+		return fmt.Errorf("failed to read spool file: %w", err)
+	}
+
+	size := int64(len(data))
+	if size > 0 && data[size-1] != '\n' {
+		size = int64(bytes.LastIndexByte(data, '\n') + 1)
+		if err := s.file.Truncate(size); err != nil {
+			return fmt.Errorf("failed to truncate spool file: %w", err)
+		}
+		data = data[:size]
+	}
+	s.size = size
+
+	s.offset = s.readOffset()
+	if s.offset > size {
+		s.offset = 0
+	}
+
+	s.depth = bytes.Count(data[s.offset:], []byte{'\n'})
+	return nil
+}
+
+func (s *Spool) readOffset() int64 {
+	data, err := os.ReadFile(filepath.Join(s.config.Dir, offsetFile))
+	if err != nil {
+		return 0
+	}
+
+	offset, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// writeOffset replaces the offset file atomically
+func (s *Spool) writeOffset(offset int64) error {
+	path := filepath.Join(s.config.Dir, offsetFile)
+	tmpPath := path + ".tmp"
+
+	if err := os.WriteFile(tmpPath, []byte(strconv.FormatInt(offset, 10)), 0600); err != nil {
+		return fmt.Errorf("failed to write spool offset: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace spool offset: %w", err)
+	}
+
+	return nil
+}
+
+// Append stores a result for later delivery
+func (s *Spool) Append(result *domain.Result) error {
+	line, err := json.Marshal(record{SpooledAt: time.Now(), Result: result})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spool record: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.config.MaxBytes > 0 && s.size-s.offset+int64(len(line)) > s.config.MaxBytes {
+		return ErrFull
+	}
+
+	if _, err := s.file.WriteAt(line, s.size); err != nil {
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool file: %w", err)
+	}
+
+	s.size += int64(len(line))
+	s.depth++
+	return nil
+}
+
+// Depth returns the number of results waiting to be submitted
+func (s *Spool) Depth() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.depth
+}
+
+// Replay submits spooled results in order until the spool is empty or submit
+// fails. Records older than MaxAge are dropped. The number of submitted and
+// expired records is returned together with the submit error.
+func (s *Spool) Replay(submit func(*domain.Result) error) (sent, expired int, err error) {
+	for {
+		rec, next, ok, err := s.next()
+		if err != nil || !ok {
+			return sent, expired, err
+		}
+
+		switch {
+		case rec == nil:
+			// unreadable record, skipped
+		case s.config.MaxAge > 0 && time.Since(rec.SpooledAt) > s.config.MaxAge:
+			expired++
+		default:
+			if err := submit(rec.Result); err != nil {
+				return sent, expired, err
+			}
+			sent++
+		}
+
+		if err := s.advance(next); err != nil {
+			return sent, expired, err
+		}
+	}
+}
+
+// next reads the record at the current offset and returns the offset after it
+func (s *Spool) next() (*record, int64, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.offset >= s.size {
+		return nil, 0, false, nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to read spool record: %w", err)
+	}
+
+	next := s.offset + int64(len(line))
+
+	var rec record
+	if err := json.Unmarshal(line, &rec); err != nil || rec.Result == nil {
+		return nil, next, true, nil
+	}
+
+	return &rec, next, true, nil
+}
+
+// advance moves the offset past a delivered record and compacts the file
+// once everything has been delivered
+func (s *Spool) advance(next int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.offset = next
+	if s.depth > 0 {
+		s.depth--
+	}
+
+	if s.offset >= s.size {
+		if err := s.file.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate spool file: %w", err)
+		}
+		s.size = 0
+		s.offset = 0
+		s.depth = 0
+	} else if s.offset > compactThreshold && s.offset > s.size/2 {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+
+	return s.writeOffset(s.offset)
+}
+
+// compact rewrites the file without the delivered records
+func (s *Spool) compact() error {
+	path := filepath.Join(s.config.Dir, dataFile)
+	tmpPath := path + ".tmp"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted spool file: %w", err)
+	}
+
+	if _, err := io.Copy(tmp, io.NewSectionReader(s.file, s.offset, s.size-s.offset)); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact spool file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync compacted spool file: %w", err)
+	}
+
+	// The offset is reset before the new file becomes visible: a crash in
+	// between replays delivered records, which the backend deduplicates,
+	// instead of skipping undelivered ones
+	if err := s.writeOffset(0); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to replace spool file: %w", err)
+	}
+
+	s.file.Close()
+	s.file = tmp
+	s.size -= s.offset
+	s.offset = 0
+	return nil
+}
+
+func (s *Spool) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	fileMode = 0600
+	dirMode  = 0700
+)
+
+// State is the identity assigned to the agent by the backend
+type State struct {
+	AgentID      string    `json:"agent_id"`
+	Token        string    `json:"token"`
+	BackendURL   string    `json:"backend_url"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// Store keeps the agent state in a file readable only by the agent user
+type Store struct {
+	path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load returns nil without an error when no state has been saved yet
+func (s *Store) Load() (*State, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat state file: %w", err)
+	}
+
+	// The file holds a credential, tighten permissions left by an older version or by hand
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(s.path, fileMode); err != nil {
+			return nil, fmt.Errorf("state file %s is accessible by other users: %w", s.path, err)
+		}
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+
+	if state.AgentID == "" || state.Token == "" {
+		return nil, nil
+	}
+
+	return &state, nil
+}
+
+// Save replaces the state file atomically so a crash never leaves a
+// truncated file behind
+func (s *Store) Save(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".agent-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(fileMode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set state file permissions: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package status
+
+import (
+	client "NetScan/internal/agent/clients"
+	handler "NetScan/internal/agent/handlers"
+	"NetScan/internal/agent/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	probeTimeout    = 2 * time.Second
+	shutdownTimeout = 5 * time.Second
+)
+
+// Server is the local HTTP listener used by orchestrators and Prometheus to
+// probe the agent
+type Server struct {
+	addr      string
+	api       *client.APIClient
+	channel   *client.Channel
+	agent     *handler.AgentHandler
+	metrics   *metrics.Registry
+	logger    *slog.Logger
+	startedAt time.Time
+}
+
+type statusResponse struct {
+	AgentID          string               `json:"agent_id"`
+	Uptime           string               `json:"uptime"`
+	Registered       bool                 `json:"registered"`
+	ChannelConnected bool                 `json:"channel_connected"`
+	CircuitBreaker   string               `json:"circuit_breaker"`
+	CircuitBreakers  map[string]string    `json:"circuit_breakers"`
+	LastHeartbeat    *time.Time           `json:"last_heartbeat,omitempty"`
+	HeartbeatError   string               `json:"heartbeat_error,omitempty"`
+	ActiveJobs       int                  `json:"active_jobs"`
+	MaxJobs          int                  `json:"max_jobs"`
+	SpoolDepth       int                  `json:"spool_depth"`
+	InFlight         []handler.TaskStatus `json:"in_flight"`
+}
+
+func NewServer(addr string, api *client.APIClient, channel *client.Channel, agent *handler.AgentHandler, registry *metrics.Registry, logger *slog.Logger) *Server {
+	return &Server{
+		addr:      addr,
+		api:       api,
+		channel:   channel,
+		agent:     agent,
+		metrics:   registry,
+		logger:    logger,
+		startedAt: time.Now(),
+	}
+}
+
+// Run serves until ctx is cancelled
+func (s *Server) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("GET /metrics", s.serveMetrics)
+
+	server := &http.Server{
+		Addr:              s.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	s.logger.Info("Status server listening", "addr", s.addr)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz reports ready once the agent is registered and can reach the backend
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.api.GetAgentID() == "" || s.api.GetToken() == "" {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "not_ready",
+			"reason": "agent is not registered",
+		})
+		return
+	}
+
+	if s.channel == nil || !s.channel.Connected() {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		if err := s.api.Ping(ctx); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+				"status": "not_ready",
+				"reason": "backend unreachable: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	load := s.agent.SystemLoad()
+
+	response := statusResponse{
+		AgentID:          s.api.GetAgentID(),
+		Uptime:           time.Since(s.startedAt).Round(time.Second).String(),
+		Registered:       s.api.GetAgentID() != "" && s.api.GetToken() != "",
+		ChannelConnected: s.channel != nil && s.channel.Connected(),
+		CircuitBreaker:   s.api.CircuitState(),
+		CircuitBreakers:  s.api.CircuitStates(),
+		ActiveJobs:       load.ActiveJobs,
+		MaxJobs:          load.MaxJobs,
+		SpoolDepth:       load.SpoolDepth,
+		InFlight:         s.agent.RunningTasks(),
+	}
+
+	lastHeartbeat, heartbeatErr := s.api.LastHeartbeat()
+	if !lastHeartbeat.IsZero() {
+		response.LastHeartbeat = &lastHeartbeat
+	}
+	if heartbeatErr != nil {
+		response.HeartbeatError = heartbeatErr.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := s.metrics.WriteTo(w); err != nil {
+		s.logger.Debug("Failed to write metrics", "error", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,163 @@
+package sysinfo
+
+import (
+	"NetScan/internal/agent/domain"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Collector reads host metrics from /proc and the Go runtime. CPU usage is
+// computed from the difference between two consecutive samples, the first one
+// is taken when the collector is created.
+type Collector struct {
+	diskPath string
+
+	mutex   sync.Mutex
+	lastCPU cpuTimes
+}
+
+func NewCollector(diskPath string) *Collector {
+	if diskPath == "" {
+		diskPath = "/"
+	}
+
+	lastCPU, _ := readCPUTimes()
+
+	return &Collector{
+		diskPath: diskPath,
+		lastCPU:  lastCPU,
+	}
+}
+
+// Metadata returns static information about the host
+func (c *Collector) Metadata() domain.AgentMetadata {
+	hostname, _ := os.Hostname()
+
+	metadata := domain.AgentMetadata{
+		Hostname:   hostname,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUCount:   runtime.NumCPU(),
+		GoVersion:  runtime.Version(),
+		Interfaces: interfaces(),
+	}
+
+	if memory, err := readMemInfo(); err == nil {
+		metadata.MemoryMB = memory.totalKB / 1024
+	}
+
+	if disk, err := diskUsage(c.diskPath); err == nil {
+		metadata.DiskMB = int64(disk.total / 1024 / 1024)
+	}
+
+	metadata.IPAddress = primaryIPv4(metadata.Interfaces)
+
+	return metadata
+}
+
+// Load returns the current utilisation of the host, job counters are left
+// for the caller to fill in
+func (c *Collector) Load() domain.SystemLoad {
+	load := domain.SystemLoad{
+		Goroutines: runtime.NumGoroutine(),
+	}
+
+	if avg, err := readLoadAvg(); err == nil {
+		load.LoadAvg1, load.LoadAvg5, load.LoadAvg15 = avg[0], avg[1], avg[2]
+	}
+
+	if memory, err := readMemInfo(); err == nil && memory.totalKB > 0 {
+		used := memory.totalKB - memory.availableKB
+		load.MemoryUsedMB = used / 1024
+		load.MemoryUsage = float64(used) / float64(memory.totalKB)
+	}
+
+	if disk, err := diskUsage(c.diskPath); err == nil && disk.total > 0 {
+		load.DiskUsage = float64(disk.total-disk.free) / float64(disk.total)
+	}
+
+	if current, err := readCPUTimes(); err == nil {
+		c.mutex.Lock()
+		load.CPUUsage = current.usageSince(c.lastCPU)
+		c.lastCPU = current
+		c.mutex.Unlock()
+	}
+
+	load.OpenFDs = countOpenFDs()
+	load.MaxFDs = readMaxFDs()
+
+	return load
+}
+
+func interfaces() []domain.NetworkInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var result []domain.NetworkInterface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+
+		networkInterface := domain.NetworkInterface{
+			Name: iface.Name,
+			MAC:  iface.HardwareAddr.String(),
+		}
+		for _, addr := range addrs {
+			networkInterface.Addresses = append(networkInterface.Addresses, addr.String())
+		}
+
+		result = append(result, networkInterface)
+	}
+
+	return result
+}
+
+// primaryIPv4 returns the first global IPv4 address of the host
+func primaryIPv4(ifaces []domain.NetworkInterface) string {
+	for _, iface := range ifaces {
+		for _, address := range iface.Addresses {
+			ip, _, err := net.ParseCIDR(address)
+			if err != nil || ip.To4() == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// Fingerprint identifies the host across agent restarts. It is derived from
+// the machine id when available and falls back to the hostname.
+func Fingerprint() string {
+	hostname, _ := os.Hostname()
+
+	var machineID string
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if data, err := os.ReadFile(path); err == nil {
+			machineID = strings.TrimSpace(string(data))
+			if machineID != "" {
+				break
+			}
+		}
+	}
+
+	if machineID == "" && hostname == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(machineID + "|" + hostname))
+	return hex.EncodeToString(sum[:])
+}
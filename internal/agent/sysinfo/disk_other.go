@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package sysinfo
+
+import "errors"
+
+type diskStats struct {
+	total uint64
+	free  uint64
+}
+
+func diskUsage(path string) (diskStats, error) {
+	return diskStats{}, errors.New("disk usage is not supported on this platform")
+}
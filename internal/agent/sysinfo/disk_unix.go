@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package sysinfo
+
+import "syscall"
+
+type diskStats struct {
+	total uint64
+	free  uint64
+}
+
+func diskUsage(path string) (diskStats, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return diskStats{}, err
+	}
+
+	return diskStats{
+		total: stat.Blocks * uint64(stat.Bsize),
+		free:  stat.Bavail * uint64(stat.Bsize),
+	}, nil
+}
@@ -0,0 +1,180 @@
+package sysinfo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const procPath = "/proc"
+
+type memInfo struct {
+	totalKB     int64
+	availableKB int64
+}
+
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+func readMemInfo() (memInfo, error) {
+	data, err := os.ReadFile(procPath + "/meminfo")
+	if err != nil {
+		return memInfo{}, err
+	}
+
+	var info memInfo
+	var free, buffers, cached int64
+	hasAvailable := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			info.totalKB = value
+		case "MemAvailable:":
+			info.availableKB = value
+			hasAvailable = true
+		case "MemFree:":
+			free = value
+		case "Buffers:":
+			buffers = value
+		case "Cached:":
+			cached = value
+		}
+	}
+
+	// Kernels older than 3.14 do not report MemAvailable
+	if !hasAvailable {
+		info.availableKB = free + buffers + cached
+	}
+
+	if info.totalKB == 0 {
+		return memInfo{}, fmt.Errorf("MemTotal not found in meminfo")
+	}
+
+	return info, nil
+}
+
+func readLoadAvg() ([3]float64, error) {
+	var avg [3]float64
+
+	data, err := os.ReadFile(procPath + "/loadavg")
+	if err != nil {
+		return avg, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return avg, fmt.Errorf("unexpected loadavg format")
+	}
+
+	for i := 0; i < 3; i++ {
+		avg[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return avg, fmt.Errorf("failed to parse loadavg: %w", err)
+		}
+	}
+
+	return avg, nil
+}
+
+// readCPUTimes reads the aggregated "cpu" line of /proc/stat
+func readCPUTimes() (cpuTimes, error) {
+	data, err := os.ReadFile(procPath + "/stat")
+	if err != nil {
+		return cpuTimes{}, err
+	}
+
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("unexpected stat format")
+	}
+
+	var times cpuTimes
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return cpuTimes{}, fmt.Errorf("failed to parse cpu times: %w", err)
+		}
+
+		// guest time is already included in user and nice
+		if i >= 8 {
+			break
+		}
+
+		times.total += value
+		// idle and iowait
+		if i == 3 || i == 4 {
+			times.idle += value
+		}
+	}
+
+	return times, nil
+}
+
+// usageSince returns the busy share of CPU time between two samples in range 0-1
+func (t cpuTimes) usageSince(previous cpuTimes) float64 {
+	total := t.total - previous.total
+	if t.total <= previous.total || total == 0 {
+		return 0
+	}
+
+	idle := t.idle - previous.idle
+	if idle > total {
+		return 0
+	}
+
+	return float64(total-idle) / float64(total)
+}
+
+func countOpenFDs() int {
+	entries, err := os.ReadDir(procPath + "/self/fd")
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+func readMaxFDs() int {
+	data, err := os.ReadFile(procPath + "/self/limits")
+	if err != nil {
+		return 0
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			return 0
+		}
+
+		limit, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return 0
+		}
+		return limit
+	}
+
+	return 0
+}
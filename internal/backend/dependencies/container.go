@@ -9,6 +9,7 @@ import (
 	"NetScan/internal/backend/services"
 	"NetScan/internal/backend/storage"
 	"NetScan/internal/config"
+	"NetScan/pkg/geoip"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
@@ -27,12 +28,16 @@ type Container struct {
 	AgentStore      storage.AgentStore
 	ResultStore     storage.ResultStore
 	AgentTasksStore storage.AgentTasksStore
+	MonitorStore    storage.MonitorStore
 	Queue           storage.Queue
 
 	// Services
-	CheckService *services.CheckService
-	AgentService *services.AgentService
-	QueueService *services.QueueService
+	CheckService   *services.CheckService
+	AgentService   *services.AgentService
+	QueueService   *services.QueueService
+	MonitorService *services.MonitorService
+	AgentHub       *services.AgentHub
+	Geo            *services.GeoLocator
 
 	// Database connections
 	DB    *pgxpool.Pool
@@ -59,6 +64,10 @@ func NewContainer(ctx context.Context, cfg *config.Config, log *slog.Logger) (*C
 		return nil, err
 	}
 
+	if err := container.initGeoIP(); err != nil {
+		return nil, err
+	}
+
 	if err := container.initServices(); err != nil {
 		return nil, err
 	}
@@ -92,12 +101,43 @@ func (c *Container) initStorage() error {
 	c.AgentStore = storage.NewAgentStore(c.DB)
 	c.ResultStore = storage.NewResultStore(c.DB)
 	c.AgentTasksStore = storage.NewAgentTasksStore(c.DB)
+	c.MonitorStore = storage.NewMonitorStore(c.DB)
+	return nil
+}
+
+// initGeoIP открывает базы GeoIP, без настроенных файлов обогащение отключено
+func (c *Container) initGeoIP() error {
+	files := geoip.Files{
+		CityFile: c.Config.GeoIP.CityDB,
+		ASNFile:  c.Config.GeoIP.ASNDB,
+	}
+	if files.CityFile == "" && files.ASNFile == "" {
+		return nil
+	}
+
+	db, err := geoip.Open(files)
+	if err != nil {
+		return fmt.Errorf("failed to open geoip databases: %w", err)
+	}
+
+	c.Geo = services.NewGeoLocator(db, slog.Default().With("service", "geoip"))
+	slog.Info("GeoIP enrichment enabled", "city_db", files.CityFile, "asn_db", files.ASNFile)
 	return nil
 }
 
 func (c *Container) initServices() error {
 	logger := slog.Default()
 
+	consensus := services.ConsensusRules{
+		Quorum:        c.Config.Consensus.Quorum,
+		DownRatio:     c.Config.Consensus.DownRatio,
+		DegradedRatio: c.Config.Consensus.DegradedRatio,
+		SlowThreshold: c.Config.Consensus.SlowThreshold,
+	}
+	if err := consensus.Validate(); err != nil {
+		return err
+	}
+
 	c.CheckService = services.NewCheckService(
 		c.CheckStore,
 		c.AgentStore,
@@ -105,6 +145,7 @@ func (c *Container) initServices() error {
 		c.Queue,
 		services.CheckServiceConfig{
 			TaskTimeout: 30 * time.Second,
+			Consensus:   &consensus,
 		},
 		logger.With("service", "check"),
 	)
@@ -113,8 +154,10 @@ func (c *Container) initServices() error {
 		c.AgentStore,
 		c.CheckStore,
 		c.ResultStore,
+		c.MonitorStore,
 		services.AgentServiceConfig{
 			HeartbeatTimeout: 2 * time.Minute,
+			Geo:              c.Geo,
 		},
 		logger.With("service", "agent"),
 	)
@@ -127,14 +170,25 @@ func (c *Container) initServices() error {
 		c.ResultStore,
 		services.QueueServiceConfig{
 			TaskTimeout:      30 * time.Second,
+			AckTimeout:       30 * time.Second,
 			PollInterval:     5 * time.Second,
 			MaxRetries:       3,
 			RetryDelay:       1 * time.Second,
 			StuckTaskTimeout: 10 * time.Minute,
+			Geo:              c.Geo,
 		},
 		logger.With("service", "queue"),
 	)
 
+	c.MonitorService = services.NewMonitorService(
+		c.MonitorStore,
+		c.CheckStore,
+		c.CheckService,
+		logger.With("service", "monitor"),
+	)
+
+	c.AgentHub = services.NewAgentHub(logger.With("service", "agent_hub"))
+
 	return nil
 }
 
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"NetScan/internal/backend/models"
+	"NetScan/internal/backend/services"
+	"NetScan/internal/backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	channelWriteWait  = 10 * time.Second
+	channelPongWait   = 60 * time.Second
+	channelPingPeriod = 25 * time.Second
+	// сколько ждем задачу в Redis за один запрос, ограничивает задержку
+	// реакции на закрытие соединения
+	channelTaskWait = 5 * time.Second
+	channelMaxSize  = 1 << 20
+)
+
+// agentSession обслуживает постоянное соединение одного агента: выдает задачи,
+// когда у агента есть свободные слоты, и принимает ack, nack и результаты
+type agentSession struct {
+	conn         *websocket.Conn
+	agent        *models.Agent
+	queueService *services.QueueService
+	logger       *slog.Logger
+
+	writeMutex sync.Mutex
+	credits    chan int
+}
+
+// AgentChannel открывает постоянный канал агента вместо опроса /tasks/next
+func (h *Handlers) AgentChannel(c *gin.Context) {
+	agent := h.getAgentFromContext(c)
+	if agent == nil {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// upgrader уже ответил клиенту
+		h.logger.Warn("failed to upgrade agent channel", "error", err, "agent_id", agent.ID)
+		return
+	}
+	defer conn.Close()
+
+	session := &agentSession{
+		conn:         conn,
+		agent:        agent,
+		queueService: h.queueService,
+		logger:       h.logger.With("agent_id", agent.ID),
+		credits:      make(chan int, 16),
+	}
+
+	outbox, unsubscribe := h.agentHub.Subscribe(agent.ID)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	session.logger.Info("agent channel connected")
+
+	go session.dispatchTasks(ctx)
+	go session.forward(ctx, outbox)
+
+	session.readLoop(ctx)
+	session.logger.Info("agent channel disconnected")
+}
+
+// PushAgentConfig отправляет агенту настройки, применяемые без перезапуска
+func (h *Handlers) PushAgentConfig(c *gin.Context) {
+	agentID := c.Param("id")
+
+	var req models.AgentConfigUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Invalid request body"))
+		return
+	}
+
+	if req.HeartbeatInterval != "" {
+		if interval, err := time.ParseDuration(req.HeartbeatInterval); err != nil || interval < time.Second {
+			c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "heartbeat_interval must be a duration of at least 1s"))
+			return
+		}
+	}
+
+	if !h.agentHub.Send(agentID, &models.ChannelMessage{Type: models.ChannelConfig, Config: &req}) {
+		c.JSON(http.StatusNotFound, ErrorResponse("agent_not_connected", "Agent has no open channel"))
+		return
+	}
+
+	h.logger.Info("agent config pushed", "agent_id", agentID)
+	c.JSON(http.StatusOK, SuccessResponse("config_pushed", gin.H{
+		"agent_id": agentID,
+		"config":   req,
+	}))
+}
+
+func (s *agentSession) readLoop(ctx context.Context) {
+	s.conn.SetReadLimit(channelMaxSize)
+	s.conn.SetReadDeadline(time.Now().Add(channelPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(channelPongWait))
+	})
+
+	for {
+		var message models.ChannelMessage
+		if err := s.conn.ReadJSON(&message); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.logger.Warn("agent channel read failed", "error", err)
+			}
+			return
+		}
+
+		s.handleMessage(ctx, &message)
+	}
+}
+
+func (s *agentSession) handleMessage(ctx context.Context, message *models.ChannelMessage) {
+	switch message.Type {
+	case models.ChannelReady:
+		if message.Slots <= 0 {
+			return
+		}
+		select {
+		case s.credits <- message.Slots:
+		case <-ctx.Done():
+		}
+
+	case models.ChannelAck:
+		err := s.queueService.AckTask(ctx, s.agent.ID, message.CheckID)
+		s.reply(message.ID, err)
+
+	case models.ChannelNack:
+		if message.Reason == "" {
+			s.replyStatus(message.ID, models.ChannelStatusRejected, "reason is required")
+			return
+		}
+		_, err := s.queueService.NackTask(ctx, s.agent.ID, message.CheckID, message.Reason, message.Retry)
+		s.reply(message.ID, err)
+
+	case models.ChannelResult:
+		if message.Result == nil || message.CheckID == "" || message.Result.Duration < 0 {
+			s.replyStatus(message.ID, models.ChannelStatusRejected, "invalid result")
+			return
+		}
+		err := s.queueService.SubmitTaskResult(ctx, newCheckResult(message.CheckID, s.agent.ID, message.Result))
+		s.reply(message.ID, err)
+
+	default:
+		s.replyStatus(message.ID, models.ChannelStatusRejected, "unknown message type")
+	}
+}
+
+// dispatchTasks отправляет агенту задачи, пока у него есть свободные слоты
+func (s *agentSession) dispatchTasks(ctx context.Context) {
+	pending := 0
+
+	for {
+		if pending == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case slots := <-s.credits:
+				pending += slots
+			}
+		}
+
+		task, err := s.queueService.WaitNextTask(ctx, s.agent.ID, channelTaskWait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Warn("failed to get task for agent channel", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		if task != nil {
+			if err := s.send(&models.ChannelMessage{Type: models.ChannelTask, Task: task}); err != nil {
+				s.releaseTask(task)
+				return
+			}
+			pending--
+		}
+
+		// забираем слоты, пришедшие пока ждали очередь
+		for drained := false; !drained; {
+			select {
+			case slots := <-s.credits:
+				pending += slots
+			default:
+				drained = true
+			}
+		}
+	}
+}
+
+// releaseTask возвращает задачу, которую не удалось передать агенту
+func (s *agentSession) releaseTask(task *models.CheckTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.queueService.NackTask(ctx, s.agent.ID, task.CheckID, "channel_closed", true); err != nil {
+		s.logger.Warn("failed to release undelivered task", "error", err, "check_id", task.CheckID)
+	}
+}
+
+// forward пересылает сообщения из AgentHub и поддерживает соединение пингами
+func (s *agentSession) forward(ctx context.Context, outbox <-chan *models.ChannelMessage) {
+	ticker := time.NewTicker(channelPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-outbox:
+			if !ok {
+				// агент подключился заново, это соединение больше не нужно
+				s.conn.Close()
+				return
+			}
+			if err := s.send(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			s.writeMutex.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(channelWriteWait))
+			s.writeMutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *agentSession) send(message *models.ChannelMessage) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(channelWriteWait))
+	if err := s.conn.WriteJSON(message); err != nil {
+		s.logger.Debug("agent channel write failed", "error", err, "type", message.Type)
+		s.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *agentSession) reply(requestID string, err error) {
+	switch {
+	case err == nil:
+		s.replyStatus(requestID, models.ChannelStatusOK, "")
+	case errors.Is(err, storage.ErrDuplicateResult):
+		s.replyStatus(requestID, models.ChannelStatusDuplicate, "")
+	case errors.Is(err, storage.ErrAgentTaskNotFound):
+		s.replyStatus(requestID, models.ChannelStatusNotAssigned, "")
+	case errors.Is(err, services.ErrCheckCancelled):
+		s.replyStatus(requestID, models.ChannelStatusRejected, "check cancelled")
+	default:
+		s.logger.Error("agent channel request failed", "error", err, "request_id", requestID)
+		s.replyStatus(requestID, models.ChannelStatusError, "internal error")
+	}
+}
+
+func (s *agentSession) replyStatus(requestID, status, message string) {
+	if requestID == "" {
+		return
+	}
+
+	s.send(&models.ChannelMessage{
+		Type:   models.ChannelReply,
+		ID:     requestID,
+		Status: status,
+		Error:  message,
+	})
+}
@@ -2,7 +2,12 @@ package handlers
 
 import (
 	"NetScan/internal/backend/models"
+	"NetScan/internal/backend/services"
+	"NetScan/internal/backend/storage"
+	"NetScan/pkg/uuidutil"
+	"errors"
 	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,6 +20,7 @@ func (h *Handlers) RegisterAgent(c *gin.Context) {
 		return
 	}
 
+	req.RemoteIP = c.ClientIP()
 	agent, token, err := h.agentService.RegisterAgent(c.Request.Context(), &req)
 	if err != nil {
 		h.logger.Error("failed to register agent", "error", err, "name", req.Name)
@@ -65,22 +71,163 @@ func (h *Handlers) Heartbeat(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Load int `json:"load" binding:"min=0,max=100"`
-	}
-
+	var req models.HeartbeatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Invalid request body"))
 		return
 	}
 
-	if err := h.agentService.UpdateHeartbeat(c.Request.Context(), agent.ID, req.Load); err != nil {
+	if err := h.agentService.UpdateHeartbeat(c.Request.Context(), agent.ID, &req); err != nil {
 		h.logger.Error("heartbeat update failed", "error", err, "agent_id", agent.ID)
 		c.JSON(http.StatusInternalServerError, ErrorResponse("heartbeat_failed", "Failed to update heartbeat"))
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse("heartbeat_received", nil))
+	// Агент без канала не получит отмену по WebSocket, передаем ее в ответе
+	cancelled, err := h.queueService.CancelledTasks(c.Request.Context(), agent.ID)
+	if err != nil {
+		h.logger.Warn("failed to list cancelled tasks", "error", err, "agent_id", agent.ID)
+	}
+	if cancelled == nil {
+		cancelled = []string{}
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("heartbeat_received", gin.H{
+		"cancelled_tasks": cancelled,
+	}))
+}
+
+// GoOffline обрабатывает штатную остановку агента: агент помечается офлайн,
+// его незавершенные задачи сразу возвращаются в очередь
+func (h *Handlers) GoOffline(c *gin.Context) {
+	agent := h.getAgentFromContext(c)
+	if agent == nil {
+		return
+	}
+
+	var req models.OfflineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Invalid request body"))
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "agent_offline"
+	}
+
+	ctx := c.Request.Context()
+
+	if err := h.agentService.UpdateAgentStatus(ctx, agent.ID, models.AgentStatusOffline); err != nil {
+		h.logger.Error("failed to mark agent offline", "error", err, "agent_id", agent.ID)
+		c.JSON(http.StatusInternalServerError, ErrorResponse("offline_failed", "Failed to mark agent offline"))
+		return
+	}
+
+	requeued, err := h.queueService.ReleaseAgentTasks(ctx, agent.ID, req.Reason)
+	if err != nil {
+		h.logger.Error("failed to release agent tasks", "error", err, "agent_id", agent.ID)
+		c.JSON(http.StatusInternalServerError, ErrorResponse("offline_failed", "Failed to release agent tasks"))
+		return
+	}
+
+	h.logger.Info("agent went offline", "agent_id", agent.ID, "reason", req.Reason, "requeued", requeued)
+	c.JSON(http.StatusOK, SuccessResponse("agent_offline", gin.H{
+		"agent_id": agent.ID,
+		"requeued": requeued,
+	}))
+}
+
+// UpdateCapabilities принимает результаты самопроверки агента, задачи
+// выдаются только по подтвержденным возможностям
+func (h *Handlers) UpdateCapabilities(c *gin.Context) {
+	agent := h.getAgentFromContext(c)
+	if agent == nil {
+		return
+	}
+
+	var req models.UpdateCapabilitiesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Invalid request body"))
+		return
+	}
+
+	if req.Capabilities == nil {
+		req.Capabilities = []string{}
+	}
+
+	if err := h.agentService.UpdateAgentCapabilities(c.Request.Context(), agent.ID, req.Capabilities, req.Environment); err != nil {
+		h.logger.Error("capabilities update failed", "error", err, "agent_id", agent.ID)
+		c.JSON(http.StatusInternalServerError, ErrorResponse("update_failed", "Failed to update capabilities"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("capabilities_updated", gin.H{
+		"capabilities": req.Capabilities,
+	}))
+}
+
+// GetMonitorManifest отдает агенту его регулярные проверки
+func (h *Handlers) GetMonitorManifest(c *gin.Context) {
+	agent := h.getAgentFromContext(c)
+	if agent == nil {
+		return
+	}
+
+	manifest, err := h.agentService.GetMonitorManifest(c.Request.Context(), agent.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("get_failed", "Failed to get monitor manifest"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("monitor_manifest", gin.H{
+		"manifest": manifest,
+	}))
+}
+
+// SubmitMonitorResult принимает результат регулярной проверки, выполненной
+// агентом без бэкенда
+func (h *Handlers) SubmitMonitorResult(c *gin.Context) {
+	monitorID := c.Param("monitor_id")
+	agent := h.getAgentFromContext(c)
+	if agent == nil {
+		return
+	}
+
+	var req models.ResultRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Invalid request body"))
+		return
+	}
+	if !uuidutil.IsValid(req.ResultID) {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "result_id must be a uuid"))
+		return
+	}
+
+	err := h.agentService.RecordMonitorRun(c.Request.Context(), agent.ID, monitorID, newCheckResult(req.ResultID, agent.ID, &req))
+	if errors.Is(err, services.ErrMonitorNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("monitor_not_found", "Monitor is not assigned to the agent"))
+		return
+	}
+	if errors.Is(err, services.ErrMonitorRunConflict) {
+		c.JSON(http.StatusConflict, ErrorResponse("result_conflict", "result_id belongs to another check"))
+		return
+	}
+	if errors.Is(err, storage.ErrDuplicateResult) {
+		c.JSON(http.StatusOK, SuccessResponse("result_duplicate", gin.H{
+			"monitor_id": monitorID,
+			"result_id":  req.ResultID,
+		}))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("submit_failed", "Failed to submit monitor result"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("result_submitted", gin.H{
+		"monitor_id": monitorID,
+		"check_id":   req.ResultID,
+		"agent_id":   agent.ID,
+	}))
 }
 
 // возвращает список агентов
@@ -169,6 +316,44 @@ func (h *Handlers) AgentAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// AgentCertMiddleware проверяет, что клиентский сертификат выдан именно
+// этому агенту: CN или DNS SAN должен совпадать с ID агента.
+// Ставится после AgentAuthMiddleware.
+func (h *Handlers) AgentCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.requireAgentCert {
+			c.Next()
+			return
+		}
+
+		agent := h.getAgentFromContext(c)
+		if agent == nil {
+			c.Abort()
+			return
+		}
+
+		// цепочка уже проверена при TLS рукопожатии
+		if c.Request.TLS == nil || len(c.Request.TLS.PeerCertificates) == 0 {
+			c.JSON(http.StatusUnauthorized, ErrorResponse("client_cert_required", "Client certificate is required"))
+			c.Abort()
+			return
+		}
+
+		cert := c.Request.TLS.PeerCertificates[0]
+		if cert.Subject.CommonName != agent.ID && !slices.Contains(cert.DNSNames, agent.ID) {
+			h.logger.Warn("agent client certificate mismatch",
+				"agent_id", agent.ID,
+				"cert_subject", cert.Subject.CommonName,
+			)
+			c.JSON(http.StatusForbidden, ErrorResponse("client_cert_mismatch", "Client certificate is not issued to this agent"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // возвращает агента из контекста
 func (h *Handlers) getAgentFromContext(c *gin.Context) *models.Agent {
 	agent, exists := c.Get("agent")
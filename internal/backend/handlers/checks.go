@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"NetScan/internal/backend/models"
+	"NetScan/internal/backend/services"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -13,6 +15,10 @@ func (h *Handlers) CreateCheck(c *gin.Context) {
 	var req struct {
 		Type   models.CheckType `json:"type" binding:"required"`
 		Target string           `json:"target" binding:"required"`
+		// опции раннера, допустимый набор зависит от типа проверки
+		Options map[string]interface{} `json:"options"`
+		// какие агенты выполняют проверку, без селектора - все онлайн агенты
+		Agents *models.AgentSelector `json:"agents"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -20,17 +26,30 @@ func (h *Handlers) CreateCheck(c *gin.Context) {
 		return
 	}
 
-	check, err := h.checkService.CreateCheck(c.Request.Context(), req.Type, req.Target)
+	check, err := h.checkService.CreateCheck(c.Request.Context(), req.Type, req.Target, req.Options, req.Agents)
+	if errors.Is(err, services.ErrInvalidCheckOptions) {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_options", err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrInvalidSelector) {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_selector", err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrNoAgentsAvailable) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse("no_agents", err.Error()))
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to create check", "error", err, "type", req.Type, "target", req.Target)
 		c.JSON(http.StatusInternalServerError, ErrorResponse("create_failed", err.Error()))
 		return
 	}
 
-	h.logger.Info("check created", "check_id", check.ID, "type", req.Type, "target", req.Target)
+	h.logger.Info("check created", "check_id", check.ID, "type", req.Type, "target", req.Target, "agents", len(check.TargetAgents))
 	c.JSON(http.StatusCreated, SuccessResponse("check_created", gin.H{
-		"check_id": check.ID,
-		"check":    check,
+		"check_id":      check.ID,
+		"check":         check,
+		"target_agents": check.TargetAgents,
 	}))
 }
 
@@ -55,6 +74,49 @@ func (h *Handlers) GetCheck(c *gin.Context) {
 	}))
 }
 
+// CancelCheck отменяет проверку: задачи удаляются из очередей, агентам с
+// подключенным каналом, выполняющим ее, отправляется отмена. Агенты без
+// канала получают отмененные задачи в ответе на heartbeat.
+func (h *Handlers) CancelCheck(c *gin.Context) {
+	checkID := c.Param("id")
+	ctx := c.Request.Context()
+
+	err := h.checkService.UpdateCheckStatus(ctx, checkID, models.CheckStatusCancelled)
+	if errors.Is(err, services.ErrCheckNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("not_found", "Check not found"))
+		return
+	}
+	if errors.Is(err, services.ErrInvalidStatusTransition) {
+		c.JSON(http.StatusConflict, ErrorResponse("check_finished", "Check is already finished"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to cancel check", "error", err, "check_id", checkID)
+		c.JSON(http.StatusInternalServerError, ErrorResponse("cancel_failed", "Failed to cancel check"))
+		return
+	}
+
+	removed, agents, err := h.queueService.CancelCheckTasks(ctx, checkID)
+	if err != nil {
+		// проверка уже отменена, оставшиеся задачи агенты отбросят при выдаче
+		h.logger.Error("failed to cancel check tasks", "error", err, "check_id", checkID)
+	}
+
+	notified := 0
+	for _, agentID := range agents {
+		if h.agentHub.Send(agentID, &models.ChannelMessage{Type: models.ChannelCancel, CheckID: checkID}) {
+			notified++
+		}
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("check_cancelled", gin.H{
+		"check_id":      checkID,
+		"removed_tasks": removed,
+		"aborted_tasks": len(agents),
+		"notified":      notified,
+	}))
+}
+
 // GetCheckResults возвращает результаты проверки
 func (h *Handlers) GetCheckResults(c *gin.Context) {
 	checkID := c.Param("id")
@@ -8,17 +8,26 @@ import (
 )
 
 type Handlers struct {
-	checkService *services.CheckService
-	agentService *services.AgentService
-	queueService *services.QueueService
-	logger       *slog.Logger
+	checkService   *services.CheckService
+	agentService   *services.AgentService
+	queueService   *services.QueueService
+	monitorService *services.MonitorService
+	agentHub       *services.AgentHub
+	logger         *slog.Logger
+
+	// требовать клиентский сертификат на маршрутах агентов
+	requireAgentCert bool
 }
 
 func NewHandlers(container *dependencies.Container) *Handlers {
 	return &Handlers{
-		checkService: container.CheckService,
-		agentService: container.AgentService,
-		queueService: container.QueueService,
-		logger:       slog.Default(),
+		checkService:   container.CheckService,
+		agentService:   container.AgentService,
+		queueService:   container.QueueService,
+		monitorService: container.MonitorService,
+		agentHub:       container.AgentHub,
+		logger:         slog.Default(),
+
+		requireAgentCert: container.Config != nil && container.Config.Server.TLS.RequireAgentCert,
 	}
 }
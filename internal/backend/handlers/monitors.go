@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"NetScan/internal/backend/models"
+	"NetScan/internal/backend/services"
+	"NetScan/internal/backend/storage"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateMonitor создает регулярную проверку
+func (h *Handlers) CreateMonitor(c *gin.Context) {
+	var req models.MonitorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Type, target and interval_seconds (at least 10) are required"))
+		return
+	}
+
+	monitor, err := h.monitorService.CreateMonitor(c.Request.Context(), &req)
+	if errors.Is(err, services.ErrInvalidMonitor) {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_monitor", err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("create_failed", "Failed to create monitor"))
+		return
+	}
+
+	pushed := h.pushMonitorManifests(c.Request.Context())
+
+	c.JSON(http.StatusCreated, SuccessResponse("monitor_created", gin.H{
+		"monitor": monitor,
+		"pushed":  pushed,
+	}))
+}
+
+// ListMonitors возвращает список регулярных проверок
+func (h *Handlers) ListMonitors(c *gin.Context) {
+	limit, offset := pagination(c)
+
+	monitors, err := h.monitorService.ListMonitors(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("list_failed", "Failed to list monitors"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("monitors_list", gin.H{
+		"monitors": monitors,
+		"count":    len(monitors),
+		"limit":    limit,
+		"offset":   offset,
+	}))
+}
+
+// GetMonitor возвращает регулярную проверку
+func (h *Handlers) GetMonitor(c *gin.Context) {
+	monitor, err := h.monitorService.GetMonitor(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, storage.ErrMonitorNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("not_found", "Monitor not found"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to get monitor", "error", err, "monitor_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, ErrorResponse("get_failed", "Failed to get monitor"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("monitor_found", gin.H{
+		"monitor": monitor,
+	}))
+}
+
+// UpdateMonitor заменяет настройки регулярной проверки
+func (h *Handlers) UpdateMonitor(c *gin.Context) {
+	var req models.MonitorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Type, target and interval_seconds (at least 10) are required"))
+		return
+	}
+
+	monitor, err := h.monitorService.UpdateMonitor(c.Request.Context(), c.Param("id"), &req)
+	if errors.Is(err, services.ErrInvalidMonitor) {
+		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_monitor", err.Error()))
+		return
+	}
+	if errors.Is(err, storage.ErrMonitorNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("not_found", "Monitor not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("update_failed", "Failed to update monitor"))
+		return
+	}
+
+	pushed := h.pushMonitorManifests(c.Request.Context())
+
+	c.JSON(http.StatusOK, SuccessResponse("monitor_updated", gin.H{
+		"monitor": monitor,
+		"pushed":  pushed,
+	}))
+}
+
+// DeleteMonitor удаляет регулярную проверку
+func (h *Handlers) DeleteMonitor(c *gin.Context) {
+	monitorID := c.Param("id")
+
+	err := h.monitorService.DeleteMonitor(c.Request.Context(), monitorID)
+	if errors.Is(err, storage.ErrMonitorNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("not_found", "Monitor not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("delete_failed", "Failed to delete monitor"))
+		return
+	}
+
+	pushed := h.pushMonitorManifests(c.Request.Context())
+
+	c.JSON(http.StatusOK, SuccessResponse("monitor_deleted", gin.H{
+		"monitor_id": monitorID,
+		"pushed":     pushed,
+	}))
+}
+
+// GetMonitorRuns возвращает историю запусков регулярной проверки
+func (h *Handlers) GetMonitorRuns(c *gin.Context) {
+	monitorID := c.Param("id")
+	limit, offset := pagination(c)
+
+	runs, err := h.monitorService.ListRuns(c.Request.Context(), monitorID, limit, offset)
+	if errors.Is(err, storage.ErrMonitorNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("not_found", "Monitor not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("list_failed", "Failed to list monitor runs"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("monitor_runs", gin.H{
+		"monitor_id": monitorID,
+		"runs":       runs,
+		"count":      len(runs),
+		"limit":      limit,
+		"offset":     offset,
+	}))
+}
+
+// pushMonitorManifests отправляет подключенным агентам их манифесты после
+// изменения регулярных проверок. Агент пропускает манифест той же версии,
+// остальные агенты получат его при следующем запросе.
+func (h *Handlers) pushMonitorManifests(ctx context.Context) int {
+	pushed := 0
+	for _, agentID := range h.agentHub.Connected() {
+		manifest, err := h.agentService.GetMonitorManifest(ctx, agentID)
+		if err != nil {
+			continue
+		}
+		if h.agentHub.Send(agentID, &models.ChannelMessage{Type: models.ChannelMonitors, Manifest: manifest}) {
+			pushed++
+		}
+	}
+	return pushed
+}
+
+// pagination разбирает limit и offset запроса, limit не больше 100
+func pagination(c *gin.Context) (int, int) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	if limit > 100 {
+		limit = 100
+	}
+	if limit < 1 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"NetScan/internal/backend/models"
+	"NetScan/internal/backend/services"
+	"NetScan/internal/backend/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,7 +36,7 @@ func (h *Handlers) GetNextTask(c *gin.Context) {
 	}))
 }
 
-// AckTask подтверждает выполнение задачи
+// AckTask подтверждает получение задачи агентом
 func (h *Handlers) AckTask(c *gin.Context) {
 	taskID := c.Param("task_id")
 	agent := h.getAgentFromContext(c)
@@ -41,8 +44,17 @@ func (h *Handlers) AckTask(c *gin.Context) {
 		return
 	}
 
-	// TODO: Реализовать подтверждение задачи в queueService
-	// Пока возвращаем заглушку
+	err := h.queueService.AckTask(c.Request.Context(), agent.ID, taskID)
+	if errors.Is(err, storage.ErrAgentTaskNotFound) {
+		// задача уже возвращена в очередь или выдана другому агенту
+		c.JSON(http.StatusNotFound, ErrorResponse("task_not_assigned", "Task is not assigned to this agent"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to ack task", "error", err, "task_id", taskID, "agent_id", agent.ID)
+		c.JSON(http.StatusInternalServerError, ErrorResponse("ack_failed", "Failed to acknowledge task"))
+		return
+	}
 
 	h.logger.Info("task acknowledged", "task_id", taskID, "agent_id", agent.ID)
 	c.JSON(http.StatusOK, SuccessResponse("task_acknowledged", gin.H{
@@ -60,17 +72,22 @@ func (h *Handlers) NackTask(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Reason string `json:"reason" binding:"required"`
-		Retry  bool   `json:"retry"`
-	}
-
+	var req models.NackRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Invalid request body"))
 		return
 	}
 
-	// TODO: Реализовать отклонение задачи в queueService
+	requeued, err := h.queueService.NackTask(c.Request.Context(), agent.ID, taskID, req.Reason, req.Retry)
+	if errors.Is(err, storage.ErrAgentTaskNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("task_not_assigned", "Task is not assigned to this agent"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to nack task", "error", err, "task_id", taskID, "agent_id", agent.ID)
+		c.JSON(http.StatusInternalServerError, ErrorResponse("nack_failed", "Failed to reject task"))
+		return
+	}
 
 	h.logger.Warn("task rejected", "task_id", taskID, "agent_id", agent.ID, "reason", req.Reason)
 	c.JSON(http.StatusOK, SuccessResponse("task_rejected", gin.H{
@@ -78,6 +95,7 @@ func (h *Handlers) NackTask(c *gin.Context) {
 		"agent_id":  agent.ID,
 		"reason":    req.Reason,
 		"retry":     req.Retry,
+		"requeued":  requeued,
 		"timestamp": time.Now(),
 	}))
 }
@@ -90,28 +108,26 @@ func (h *Handlers) SubmitResult(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Success  bool                   `json:"success" binding:"required"`
-		Data     map[string]interface{} `json:"data" binding:"required"`
-		Error    string                 `json:"error,omitempty"`
-		Duration float64                `json:"duration" binding:"required,min=0"`
-	}
-
+	var req models.ResultRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse("invalid_request", "Invalid request body"))
 		return
 	}
 
-	result := &models.CheckResult{
-		CheckID:  checkID,
-		AgentID:  agent.ID,
-		Success:  req.Success,
-		Data:     req.Data,
-		Error:    req.Error,
-		Duration: req.Duration,
+	err := h.queueService.SubmitTaskResult(c.Request.Context(), newCheckResult(checkID, agent.ID, &req))
+	if errors.Is(err, storage.ErrDuplicateResult) {
+		// повтор из спула агента, результат уже сохранен
+		c.JSON(http.StatusOK, SuccessResponse("result_duplicate", gin.H{
+			"check_id":  checkID,
+			"result_id": req.ResultID,
+		}))
+		return
 	}
-
-	if err := h.queueService.SubmitTaskResult(c.Request.Context(), result); err != nil {
+	if errors.Is(err, services.ErrCheckCancelled) {
+		c.JSON(http.StatusConflict, ErrorResponse("check_cancelled", "Check has been cancelled"))
+		return
+	}
+	if err != nil {
 		h.logger.Error("failed to submit result", "error", err, "check_id", checkID, "agent_id", agent.ID)
 		c.JSON(http.StatusInternalServerError, ErrorResponse("submit_failed", "Failed to submit result"))
 		return
@@ -125,6 +141,30 @@ func (h *Handlers) SubmitResult(c *gin.Context) {
 	}))
 }
 
+func newCheckResult(checkID, agentID string, req *models.ResultRequest) *models.CheckResult {
+	outcome := req.Outcome
+	if outcome == "" {
+		// агенты до появления outcome передают только success
+		outcome = models.ResultOutcomeFailure
+		if req.Success {
+			outcome = models.ResultOutcomeSuccess
+		}
+	}
+
+	return &models.CheckResult{
+		ResultID:  req.ResultID,
+		CheckID:   checkID,
+		AgentID:   agentID,
+		Success:   req.Success && outcome == models.ResultOutcomeSuccess,
+		Data:      req.Data,
+		Error:     req.Error,
+		Duration:  req.Duration,
+		CreatedAt: req.CreatedAt,
+		Outcome:   outcome,
+		Failure:   req.Failure,
+	}
+}
+
 // SubmitProgress отправляет прогресс выполнения
 func (h *Handlers) SubmitProgress(c *gin.Context) {
 	checkID := c.Param("check_id")
@@ -161,3 +201,16 @@ func (h *Handlers) SubmitProgress(c *gin.Context) {
 
 	c.JSON(http.StatusOK, SuccessResponse("progress_submitted", nil))
 }
+
+// GetQueueStats возвращает статистику очередей задач
+func (h *Handlers) GetQueueStats(c *gin.Context) {
+	stats, err := h.queueService.GetQueueStats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("stats_failed", "Failed to get queue stats"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse("queue_stats", gin.H{
+		"stats": stats,
+	}))
+}
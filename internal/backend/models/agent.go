@@ -10,33 +10,135 @@ const (
 )
 
 type Agent struct {
-	ID            string      `json:"id"`
-	Name          string      `json:"name"`
-	Token         string      `json:"-"`
-	Location      string      `json:"location"`
-	Status        AgentStatus `json:"status"`
-	LastHeartbeat time.Time   `json:"last_heartbeat"`
-	CreatedAt     time.Time   `json:"created_at"`
-	Capabilities  []string    `json:"capabilities"`
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Token         string         `json:"-"`
+	Location      string         `json:"location"`
+	Status        AgentStatus    `json:"status"`
+	LastHeartbeat time.Time      `json:"last_heartbeat"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Capabilities  []string       `json:"capabilities"`
+	Load          int            `json:"load"`                  // занятость воркеров 0-100
+	ActiveJobs    int            `json:"active_jobs"`           // задачи в работе
+	MaxJobs       int            `json:"max_jobs"`              // размер пула воркеров
+	SpoolDepth    int            `json:"spool_depth"`           // неотправленные результаты в спуле агента
+	Metadata      *AgentMetadata `json:"metadata,omitempty"`    // сведения о хосте при регистрации
+	SystemLoad    *SystemLoad    `json:"system_load,omitempty"` // метрики хоста из последнего heartbeat
+	Fingerprint   string         `json:"-"`
+	// результаты самопроверки агента, по ним выбираются агенты для задач
+	Environment *AgentEnvironment `json:"environment,omitempty"`
+	// расположение и AS по адресу, с которого агент зарегистрировался
+	Geo *GeoInfo `json:"geo,omitempty"`
+	// произвольные метки из конфигурации агента, по ним выбираются агенты для проверок
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
+// метка, задающая регион агента явно
+const RegionLabel = "region"
+
+// Region возвращает регион агента: метку region, а без нее - location
+func (a *Agent) Region() string {
+	if region := a.Labels[RegionLabel]; region != "" {
+		return region
+	}
+	return a.Location
+}
+
+// AgentEnvironment описывает, что реально доступно агенту в его сети
+type AgentEnvironment struct {
+	ICMP     bool              `json:"icmp"`     // можно открыть ICMP сокет
+	ICMPRaw  bool              `json:"icmp_raw"` // raw сокет, нужен для mtr
+	IPv4     bool              `json:"ipv4"`
+	IPv6     bool              `json:"ipv6"`
+	DNS      bool              `json:"dns"` // исходящий DNS по UDP 53
+	TCP      bool              `json:"tcp"` // исходящие TCP соединения
+	Errors   map[string]string `json:"errors,omitempty"`
+	ProbedAt time.Time         `json:"probed_at"`
+}
+
+type UpdateCapabilitiesRequest struct {
+	Capabilities []string          `json:"capabilities" binding:"dive,oneof=http https ping tcp dns traceroute mtr"`
+	Environment  *AgentEnvironment `json:"environment"`
+}
+
+// описание хоста агента
+type AgentMetadata struct {
+	IPAddress  string             `json:"ip_address"`
+	Hostname   string             `json:"hostname"`
+	OS         string             `json:"os"`
+	Arch       string             `json:"arch"`
+	CPUCount   int                `json:"cpu_count"`
+	MemoryMB   int64              `json:"memory_mb"`
+	DiskMB     int64              `json:"disk_mb"`
+	GoVersion  string             `json:"go_version"`
+	Interfaces []NetworkInterface `json:"interfaces"`
+}
+
+type NetworkInterface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac,omitempty"`
+	Addresses []string `json:"addresses"`
+}
+
+// утилизация хоста агента, доли в диапазоне 0-1
+type SystemLoad struct {
+	CPUUsage     float64 `json:"cpu_usage"`
+	MemoryUsage  float64 `json:"memory_usage"`
+	DiskUsage    float64 `json:"disk_usage"`
+	LoadAvg1     float64 `json:"load_avg_1"`
+	LoadAvg5     float64 `json:"load_avg_5"`
+	LoadAvg15    float64 `json:"load_avg_15"`
+	MemoryUsedMB int64   `json:"memory_used_mb"`
+	OpenFDs      int     `json:"open_fds"`
+	MaxFDs       int     `json:"max_fds"`
+	Goroutines   int     `json:"goroutines"`
+}
+
+// состояния выдачи задачи агенту
+const (
+	AgentTaskAssigned  = "assigned"  // выдана, ждем ack
+	AgentTaskAcked     = "acked"     // агент подтвердил получение
+	AgentTaskCompleted = "completed" // результат получен
+	AgentTaskFailed    = "failed"    // агент отказался без повтора или попытки исчерпаны
+	AgentTaskRequeued  = "requeued"  // задача возвращена в очередь
+	AgentTaskCancelled = "cancelled" // проверка отменена во время выполнения
+)
+
 type AgentTask struct {
 	ID        string                 `json:"id"`
 	AgentID   string                 `json:"agent_id"`
 	CheckID   string                 `json:"check_id"`
 	TaskData  map[string]interface{} `json:"task_data"`
 	TakenAt   time.Time              `json:"taken_at"`
-	Status    string                 `json:"status"` // processing, completed, failed
+	Status    string                 `json:"status"`
+	Attempt   int                    `json:"attempt"`          // номер попытки, начиная с 0
+	Reason    string                 `json:"reason,omitempty"` // причина nack
+	AckedAt   *time.Time             `json:"acked_at,omitempty"`
 	CreatedAt time.Time              `json:"created_at"`
 }
 
 type HeartbeatRequest struct {
-	AgentID string `json:"agent_id"`
-	Load    int    `json:"load"` // текущая нагрузка 0-100
+	AgentID    string      `json:"agent_id"`
+	Load       int         `json:"load" binding:"min=0,max=100"` // текущая нагрузка 0-100
+	ActiveJobs int         `json:"active_jobs" binding:"min=0"`
+	MaxJobs    int         `json:"max_jobs" binding:"min=0"`
+	SpoolDepth int         `json:"spool_depth" binding:"min=0"` // результаты, ожидающие отправки
+	System     *SystemLoad `json:"system"`
+}
+
+// уведомление агента о завершении работы
+type OfflineRequest struct {
+	Reason string `json:"reason" binding:"max=64"`
 }
 
 type RegisterRequest struct {
-	Name         string   `json:"name"`
-	Location     string   `json:"location"`
-	Capabilities []string `json:"capabilities"`
+	Name         string         `json:"name"`
+	Location     string         `json:"location"`
+	Capabilities []string       `json:"capabilities"`
+	Metadata     *AgentMetadata `json:"metadata"`
+	// отпечаток хоста, по нему повторная регистрация переиспользует запись агента
+	Fingerprint string            `json:"fingerprint"`
+	Labels      map[string]string `json:"labels"`
+	// адрес, с которого пришел запрос, заполняется обработчиком
+	RemoteIP string `json:"-"`
 }
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// типы сообщений постоянного канала агента
+const (
+	// сервер -> агент
+	ChannelTask     = "task"     // новая задача
+	ChannelCancel   = "cancel"   // отмена выполняемой задачи
+	ChannelConfig   = "config"   // настройки, применяемые без перезапуска
+	ChannelMonitors = "monitors" // новый манифест регулярных проверок
+	ChannelReply    = "reply"    // ответ на запрос агента
+
+	// агент -> сервер
+	ChannelReady  = "ready" // агент готов принять еще slots задач
+	ChannelAck    = "ack"
+	ChannelNack   = "nack"
+	ChannelResult = "result"
+)
+
+// статусы ответа на запрос агента
+const (
+	ChannelStatusOK          = "ok"
+	ChannelStatusDuplicate   = "duplicate"
+	ChannelStatusNotAssigned = "not_assigned"
+	ChannelStatusRejected    = "rejected"
+	ChannelStatusError       = "error"
+)
+
+type ChannelMessage struct {
+	Type string `json:"type"`
+	// ID связывает запрос агента с ответом сервера
+	ID      string             `json:"id,omitempty"`
+	Task    *CheckTask         `json:"task,omitempty"`
+	CheckID string             `json:"check_id,omitempty"`
+	Slots   int                `json:"slots,omitempty"`
+	Reason  string             `json:"reason,omitempty"`
+	Retry   bool               `json:"retry,omitempty"`
+	Result  *ResultRequest     `json:"result,omitempty"`
+	Config  *AgentConfigUpdate `json:"config,omitempty"`
+	Status  string             `json:"status,omitempty"`
+	Error   string             `json:"error,omitempty"`
+
+	Manifest *MonitorManifest `json:"manifest,omitempty"`
+}
+
+// результат проверки от агента, success=false и duration=0 допустимы,
+// поэтому без required
+type ResultRequest struct {
+	ResultID  string                 `json:"result_id" binding:"omitempty,uuid"`
+	Success   bool                   `json:"success"`
+	Data      map[string]interface{} `json:"data"`
+	Error     string                 `json:"error,omitempty"`
+	Duration  float64                `json:"duration" binding:"min=0"`
+	CreatedAt time.Time              `json:"created_at"`
+	// пустой исход выводится из success
+	Outcome string         `json:"outcome,omitempty" binding:"omitempty,oneof=success failure timeout"`
+	Failure *ResultFailure `json:"failure,omitempty"`
+}
+
+// настройки агента, которые можно изменить без перезапуска
+type AgentConfigUpdate struct {
+	LogLevel          string         `json:"log_level,omitempty" binding:"omitempty,oneof=debug info warn error"`
+	HeartbeatInterval string         `json:"heartbeat_interval,omitempty"`
+	EnabledRunners    []string       `json:"enabled_runners,omitempty"`
+	TypeLimits        map[string]int `json:"type_limits,omitempty"`
+}
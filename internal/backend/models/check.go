@@ -11,6 +11,7 @@ const (
 	CheckTypeTCP        CheckType = "tcp"
 	CheckTypeDNS        CheckType = "dns"
 	CheckTypeTraceroute CheckType = "traceroute"
+	CheckTypeMTR        CheckType = "mtr"
 )
 
 type CheckStatus string
@@ -20,6 +21,8 @@ const (
 	CheckStatusRunning   CheckStatus = "running"
 	CheckStatusCompleted CheckStatus = "completed"
 	CheckStatusFailed    CheckStatus = "failed"
+	// отменена пользователем, конечный статус
+	CheckStatusCancelled CheckStatus = "cancelled"
 )
 
 type Check struct {
@@ -29,10 +32,19 @@ type Check struct {
 	Status    CheckStatus `json:"status"`
 	CreatedAt time.Time   `json:"created_at"`
 	UpdatedAt time.Time   `json:"updated_at"`
+	// регулярная проверка, запуском которой является эта проверка
+	MonitorID string `json:"monitor_id,omitempty"`
+	// опции раннера, проверяются по схеме типа и передаются агенту в задаче
+	Options map[string]interface{} `json:"options,omitempty"`
+	// селектор агентов из запроса, nil - все онлайн агенты
+	Agents *AgentSelector `json:"agents,omitempty"`
+	// агенты, которым при создании ушла задача
+	TargetAgents []string `json:"target_agents"`
 }
 
 type CheckResult struct {
 	ID        string                 `json:"id"`
+	ResultID  string                 `json:"result_id,omitempty"` // ключ идемпотентности от агента
 	CheckID   string                 `json:"check_id"`
 	AgentID   string                 `json:"agent_id"`
 	Success   bool                   `json:"success"`
@@ -40,4 +52,25 @@ type CheckResult struct {
 	Error     string                 `json:"error,omitempty"`
 	Duration  float64                `json:"duration"` // в секундах
 	CreatedAt time.Time              `json:"created_at"`
+	// success, failure или timeout, таймаут не смешивается с обычной ошибкой
+	Outcome string `json:"outcome"`
+	// причина неудачи, по коду и фазе результаты группируются между агентами
+	Failure *ResultFailure `json:"failure,omitempty"`
+	// расположение и AS адреса цели, к которому обратился агент
+	Geo *GeoInfo `json:"geo,omitempty"`
 }
+
+// классификация ошибки проверки на стороне агента:
+// код вроде dns_nxdomain или connection_refused и фаза (resolve, connect, tls, ...)
+type ResultFailure struct {
+	Code    string `json:"code" binding:"required,max=64"`
+	Phase   string `json:"phase,omitempty" binding:"omitempty,max=32"`
+	Message string `json:"message,omitempty"`
+}
+
+// исходы выполнения проверки агентом
+const (
+	ResultOutcomeSuccess = "success"
+	ResultOutcomeFailure = "failure"
+	ResultOutcomeTimeout = "timeout"
+)
@@ -3,6 +3,7 @@ package models
 type CheckWithResults struct {
 	*Check
 	Results []*CheckResult `json:"results"`
+	Verdict *CheckVerdict  `json:"verdict,omitempty"`
 }
 
 type CheckStats struct {
@@ -11,4 +12,36 @@ type CheckStats struct {
 	Failed       int            `json:"failed"`
 	AverageTime  float64        `json:"average_time"`
 	AgentResults map[string]int `json:"agent_results"`
+	FailureCodes map[string]int `json:"failure_codes"`
+	Verdict      *CheckVerdict  `json:"verdict,omitempty"`
+}
+
+// итог проверки по результатам всех агентов
+const (
+	// все агенты достучались до цели
+	VerdictUp = "up"
+	// цель недоступна почти у всех агентов
+	VerdictDown = "down"
+	// цель недоступна у части агентов
+	VerdictPartial = "partial"
+	// единичные неудачи или медленные ответы
+	VerdictDegraded = "degraded"
+	// результатов меньше кворума
+	VerdictUnknown = "unknown"
+)
+
+// CheckVerdict отвечает на вопрос "недоступно у всех или только у меня"
+type CheckVerdict struct {
+	Verdict string `json:"verdict"`
+	// ответили все агенты, вердикт больше не изменится
+	Final bool `json:"final"`
+	// учитывается последний результат каждого агента
+	Results    int `json:"results"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+	// регионы, где неудачны все результаты
+	FailedRegions []string `json:"failed_regions"`
+	// самый частый код неудачи
+	DominantError string  `json:"dominant_error,omitempty"`
+	MedianTime    float64 `json:"median_time"` // в секундах
 }
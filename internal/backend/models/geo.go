@@ -0,0 +1,13 @@
+package models
+
+// GeoInfo сведения о публичном адресе из локальных баз GeoIP
+type GeoInfo struct {
+	IP           string  `json:"ip"`
+	CountryCode  string  `json:"country_code,omitempty"` // ISO 3166-1 alpha-2
+	Country      string  `json:"country,omitempty"`
+	City         string  `json:"city,omitempty"`
+	Latitude     float64 `json:"latitude,omitempty"`
+	Longitude    float64 `json:"longitude,omitempty"`
+	ASN          uint64  `json:"asn,omitempty"`
+	Organization string  `json:"organization,omitempty"` // владелец AS
+}
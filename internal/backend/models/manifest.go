@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// регулярная проверка в манифесте агента: агент выполняет ее сам по
+// расписанию, пока бэкенд недоступен, и присылает результаты после
+// восстановления связи
+type AgentMonitor struct {
+	ID       string                 `json:"id"`
+	Type     CheckType              `json:"type"`
+	Target   string                 `json:"target"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Interval int                    `json:"interval_seconds"`
+}
+
+// манифест регулярных проверок агента, собирается из включенных регулярных
+// проверок, которые он выполняет. Версия меняется при любом их изменении.
+type MonitorManifest struct {
+	Version   int64          `json:"version"`
+	Monitors  []AgentMonitor `json:"monitors"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+}
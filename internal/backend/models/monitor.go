@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Monitor регулярная проверка: планировщик бэкенда создает по ней проверку
+// каждые Interval секунд, запуски связаны с ней через checks.monitor_id
+type Monitor struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Type     CheckType              `json:"type"`
+	Target   string                 `json:"target"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Interval int                    `json:"interval_seconds"`
+	// агенты, выполняющие каждый запуск, nil - все онлайн агенты
+	Agents    *AgentSelector `json:"agents,omitempty"`
+	Enabled   bool           `json:"enabled"`
+	NextRunAt *time.Time     `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time     `json:"last_run_at,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// IntervalDuration возвращает интервал запусков
+func (m *Monitor) IntervalDuration() time.Duration {
+	return time.Duration(m.Interval) * time.Second
+}
+
+// Targets проверяет, может ли агент выполнять эту регулярную проверку.
+// Количество и регионы селектора не учитываются: без бэкенда регулярную
+// проверку выполняет любой подходящий агент.
+func (m *Monitor) Targets(agent *Agent) bool {
+	return m.Agents.Matches(agent)
+}
+
+// тело запросов создания и изменения регулярной проверки
+type MonitorRequest struct {
+	Name     string                 `json:"name" binding:"max=100"`
+	Type     CheckType              `json:"type" binding:"required"`
+	Target   string                 `json:"target" binding:"required"`
+	Options  map[string]interface{} `json:"options"`
+	Interval int                    `json:"interval_seconds" binding:"required,min=10"`
+	Agents   *AgentSelector         `json:"agents"`
+	// по умолчанию регулярная проверка включена
+	Enabled *bool `json:"enabled"`
+}
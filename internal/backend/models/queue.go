@@ -4,9 +4,10 @@ import "time"
 
 type QueueStats struct {
 	// Основные метрики очереди
-	QueueLength  int64 `json:"queue_length"`  // Количество задач в очереди Redis
-	OnlineAgents int   `json:"online_agents"` // Количество онлайн агентов
-	ActiveTasks  int   `json:"active_tasks"`  // Количество взятых на выполнение задач
+	QueueLength  int64            `json:"queue_length"`  // Количество задач во всех очередях Redis
+	Queues       map[string]int64 `json:"queues"`        // Количество задач в каждой очереди
+	OnlineAgents int              `json:"online_agents"` // Количество онлайн агентов
+	ActiveTasks  int              `json:"active_tasks"`  // Количество взятых на выполнение задач
 
 	// Статистика по проверкам
 	PendingChecks   int `json:"pending_checks"`   // Ожидающие проверки
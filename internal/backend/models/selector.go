@@ -0,0 +1,90 @@
+package models
+
+import "strings"
+
+// что делать, если подходящих онлайн агентов меньше, чем нужно
+const (
+	// выполнить на тех, что нашлись (по умолчанию)
+	SelectorFallbackPartial = "partial"
+	// добрать любыми онлайн агентами
+	SelectorFallbackAny = "any"
+	// не создавать проверку
+	SelectorFallbackFail = "fail"
+)
+
+// AgentSelector задает агентов, выполняющих проверку. Заданные условия
+// объединяются через И, значения внутри одного условия - через ИЛИ.
+// Пустой селектор выбирает всех онлайн агентов.
+type AgentSelector struct {
+	// явный список агентов
+	IDs []string `json:"ids,omitempty"`
+	// location, регион (метка region) или код страны агента
+	Locations []string `json:"locations,omitempty"`
+	// все метки должны совпасть
+	Labels map[string]string `json:"labels,omitempty"`
+	// N случайных агентов из подходящих, 0 - все подходящие
+	Count int `json:"count,omitempty"`
+	// по одному агенту из каждого региона, наименее загруженному
+	OnePerRegion bool `json:"one_per_region,omitempty"`
+	// partial, any или fail
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// Matches проверяет, подходит ли агент под условия селектора без учета
+// количества. Nil селектор подходит любому агенту.
+func (s *AgentSelector) Matches(agent *Agent) bool {
+	if s == nil {
+		return true
+	}
+
+	if len(s.IDs) > 0 && !containsFold(s.IDs, agent.ID) {
+		return false
+	}
+
+	if len(s.Locations) > 0 {
+		countryCode := ""
+		if agent.Geo != nil {
+			countryCode = agent.Geo.CountryCode
+		}
+		if !containsFold(s.Locations, agent.Location) &&
+			!containsFold(s.Locations, agent.Region()) &&
+			!containsFold(s.Locations, countryCode) {
+			return false
+		}
+	}
+
+	for name, value := range s.Labels {
+		if agent.Labels[name] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Wanted возвращает, сколько агентов нужно селектору: count, число явно
+// перечисленных агентов или хотя бы один
+func (s *AgentSelector) Wanted() int {
+	switch {
+	case s == nil:
+		return 1
+	case s.Count > 0:
+		return s.Count
+	case len(s.IDs) > 0:
+		return len(s.IDs)
+	default:
+		return 1
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,5 +7,23 @@ type CheckTask struct {
 	Type      CheckType              `json:"type"`
 	Target    string                 `json:"target"`
 	Options   map[string]interface{} `json:"options,omitempty"`
+	Attempt   int                    `json:"attempt,omitempty"` // увеличивается при каждом возврате в очередь
 	CreatedAt time.Time              `json:"created_at"`
+	// бюджет времени на выполнение, агент отсчитывает его от начала проверки
+	// по своим часам и прерывает проверку по его истечении. Абсолютный срок
+	// задаче не передается намеренно: часы агента могут расходиться с часами
+	// backend, а задача может ждать в буфере агента, и срок, выставленный при
+	// выдаче, обрезал бы или вовсе отменял проверку до ее начала. Крайний
+	// срок агент вычисляет сам как начало выполнения + бюджет.
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
 }
+
+// отказ агента от задачи
+type NackRequest struct {
+	Reason string `json:"reason" binding:"required"`
+	Retry  bool   `json:"retry"`
+}
+
+// агент исчерпал лимит одновременных проверок этого типа, такой отказ не
+// расходует попытку задачи
+const NackTypeBusy = "type_busy"
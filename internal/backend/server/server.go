@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 
 	"NetScan/internal/backend/dependencies"
 	"NetScan/internal/backend/handlers"
+	"NetScan/internal/config"
+	"NetScan/pkg/tlsutil"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +27,7 @@ type Server struct {
 type Config struct {
 	Port int
 	Mode string
+	TLS  config.TLSConfig
 }
 
 // New создает сервер с dependency injection
@@ -73,9 +77,15 @@ func (s *Server) setupRoutes() {
 			agents.POST("/register", s.handlers.RegisterAgent)
 			agents.POST("/auth", s.handlers.AuthenticateAgent)
 			agents.POST("/heartbeat", s.handlers.AgentAuthMiddleware(), s.handlers.Heartbeat)
+			agents.PUT("/capabilities", s.handlers.AgentAuthMiddleware(), s.handlers.UpdateCapabilities)
+			agents.POST("/offline", s.handlers.AgentAuthMiddleware(), s.handlers.GoOffline)
+			agents.GET("/monitors", s.handlers.AgentAuthMiddleware(), s.handlers.AgentCertMiddleware(), s.handlers.GetMonitorManifest)
+			agents.POST("/monitors/:monitor_id/results", s.handlers.AgentAuthMiddleware(), s.handlers.AgentCertMiddleware(), s.handlers.SubmitMonitorResult)
+			agents.GET("/channel", s.handlers.AgentAuthMiddleware(), s.handlers.AgentCertMiddleware(), s.handlers.AgentChannel)
 			agents.GET("", s.handlers.ListAgents)
 			agents.GET("/:id", s.handlers.GetAgent)
 			agents.GET("/:id/stats", s.handlers.GetAgentStats)
+			agents.PUT("/:id/config", s.handlers.PushAgentConfig)
 		}
 
 		// Checks routes
@@ -85,12 +95,24 @@ func (s *Server) setupRoutes() {
 			checks.GET("/:id", s.handlers.GetCheck)
 			checks.GET("/:id/results", s.handlers.GetCheckResults)
 			checks.GET("/:id/stats", s.handlers.GetCheckStats)
+			checks.DELETE("/:id", s.handlers.CancelCheck)
 			checks.GET("", s.handlers.ListChecks)
 		}
 
+		// Monitors routes
+		monitors := api.Group("/monitors")
+		{
+			monitors.POST("", s.handlers.CreateMonitor)
+			monitors.GET("", s.handlers.ListMonitors)
+			monitors.GET("/:id", s.handlers.GetMonitor)
+			monitors.PUT("/:id", s.handlers.UpdateMonitor)
+			monitors.DELETE("/:id", s.handlers.DeleteMonitor)
+			monitors.GET("/:id/runs", s.handlers.GetMonitorRuns)
+		}
+
 		// Tasks routes (для агентов)
 		tasks := api.Group("/tasks")
-		tasks.Use(s.handlers.AgentAuthMiddleware())
+		tasks.Use(s.handlers.AgentAuthMiddleware(), s.handlers.AgentCertMiddleware())
 		{
 			tasks.GET("/next", s.handlers.GetNextTask)
 			tasks.POST("/:task_id/ack", s.handlers.AckTask)
@@ -99,20 +121,20 @@ func (s *Server) setupRoutes() {
 
 		// Results routes (для агентов)
 		results := api.Group("/results")
-		results.Use(s.handlers.AgentAuthMiddleware())
+		results.Use(s.handlers.AgentAuthMiddleware(), s.handlers.AgentCertMiddleware())
 		{
 			results.POST("/:check_id", s.handlers.SubmitResult)
 			results.POST("/:check_id/progress", s.handlers.SubmitProgress)
 		}
 
 		// Metrics routes
-		//metrics := api.Group("/metrics")
-		//{
-		//	metrics.GET("", s.handlers.GetMetrics)
-		//	metrics.GET("/queue", s.handlers.GetQueueStats)
-		//	metrics.GET("/health", s.handlers.GetSystemHealth)
-		//	metrics.POST("/cleanup", s.handlers.CleanupStuckTasks)
-		//}
+		metrics := api.Group("/metrics")
+		{
+			//	metrics.GET("", s.handlers.GetMetrics)
+			metrics.GET("/queue", s.handlers.GetQueueStats)
+			//	metrics.GET("/health", s.handlers.GetSystemHealth)
+			//	metrics.POST("/cleanup", s.handlers.CleanupStuckTasks)
+		}
 	}
 
 	// WebSocket routes
@@ -255,15 +277,44 @@ func (s *Server) Start() error {
 		"port", s.config.Port,
 		"mode", s.config.Mode,
 		"address", addr,
+		"tls", s.config.TLS.Enabled,
 	)
 
-	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	var err error
+	if s.config.TLS.Enabled {
+		err = s.listenAndServeTLS()
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 
 	return nil
 }
 
+// listenAndServeTLS запускает HTTPS. Если задан CA агентов, клиентский
+// сертификат проверяется при рукопожатии, а его наличие на маршрутах
+// агентов требует AgentCertMiddleware.
+func (s *Server) listenAndServeTLS() error {
+	source, err := tlsutil.NewSource(tlsutil.Files{
+		CertFile: s.config.TLS.CertFile,
+		KeyFile:  s.config.TLS.KeyFile,
+		CAFile:   s.config.TLS.ClientCAFile,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load tls files: %w", err)
+	}
+
+	clientAuth := tls.NoClientCert
+	if s.config.TLS.ClientCAFile != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	s.httpServer.TLSConfig = source.ServerConfig(clientAuth)
+	return s.httpServer.ListenAndServeTLS("", "")
+}
+
 // Shutdown выполняет graceful shutdown сервера
 func (s *Server) Shutdown(ctx context.Context) error {
 	slog.Info("Shutting down HTTP server...")
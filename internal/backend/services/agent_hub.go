@@ -0,0 +1,111 @@
+package services
+
+import (
+	"NetScan/internal/backend/models"
+	"log/slog"
+	"sync"
+)
+
+// размер очереди исходящих сообщений одного агента
+const agentHubBuffer = 32
+
+// AgentHub хранит подключенные каналы агентов и доставляет им сообщения,
+// которые инициирует backend: отмены задач и новые настройки
+type AgentHub struct {
+	mutex    sync.RWMutex
+	channels map[string]chan *models.ChannelMessage
+	logger   *slog.Logger
+}
+
+func NewAgentHub(logger *slog.Logger) *AgentHub {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &AgentHub{
+		channels: make(map[string]chan *models.ChannelMessage),
+		logger:   logger,
+	}
+}
+
+// Subscribe регистрирует канал агента. Новое подключение того же агента
+// закрывает очередь предыдущего, чтобы старое соединение завершилось.
+func (h *AgentHub) Subscribe(agentID string) (<-chan *models.ChannelMessage, func()) {
+	outbox := make(chan *models.ChannelMessage, agentHubBuffer)
+
+	h.mutex.Lock()
+	if previous, ok := h.channels[agentID]; ok {
+		close(previous)
+		h.logger.Info("agent channel replaced by new connection", "agent_id", agentID)
+	}
+	h.channels[agentID] = outbox
+	h.mutex.Unlock()
+
+	unsubscribe := func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		if current, ok := h.channels[agentID]; ok && current == outbox {
+			close(outbox)
+			delete(h.channels, agentID)
+		}
+	}
+
+	return outbox, unsubscribe
+}
+
+// Send отправляет сообщение агенту, false если агент не подключен
+// или не успевает читать
+func (h *AgentHub) Send(agentID string, message *models.ChannelMessage) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	outbox, ok := h.channels[agentID]
+	if !ok {
+		return false
+	}
+
+	select {
+	case outbox <- message:
+		return true
+	default:
+		h.logger.Warn("agent channel is full, message dropped",
+			"agent_id", agentID,
+			"type", message.Type,
+		)
+		return false
+	}
+}
+
+// Broadcast отправляет сообщение всем подключенным агентам,
+// возвращает количество получателей
+func (h *AgentHub) Broadcast(message *models.ChannelMessage) int {
+	delivered := 0
+	for _, agentID := range h.Connected() {
+		if h.Send(agentID, message) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// Connected возвращает ID агентов с открытым каналом
+func (h *AgentHub) Connected() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	agentIDs := make([]string, 0, len(h.channels))
+	for agentID := range h.channels {
+		agentIDs = append(agentIDs, agentID)
+	}
+	return agentIDs
+}
+
+// IsConnected проверяет, открыт ли у агента канал
+func (h *AgentHub) IsConnected(agentID string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	_, ok := h.channels[agentID]
+	return ok
+}
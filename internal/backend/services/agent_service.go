@@ -6,26 +6,38 @@ import (
 	"NetScan/pkg/uuidutil"
 	"NetScan/pkg/validator"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"time"
 )
 
+var (
+	ErrMonitorNotFound = errors.New("monitor not found in agent manifest")
+	// result_id запуска совпал с проверкой, не принадлежащей этому запуску
+	ErrMonitorRunConflict = errors.New("result id belongs to another check")
+)
+
 type AgentService struct {
-	agentStore  storage.AgentStore
-	checkStore  storage.CheckStore
-	resultStore storage.ResultStore
-	logger      *slog.Logger
+	agentStore   storage.AgentStore
+	checkStore   storage.CheckStore
+	resultStore  storage.ResultStore
+	monitorStore storage.MonitorStore
+	geo          *GeoLocator
+	logger       *slog.Logger
 }
 
 type AgentServiceConfig struct {
 	HeartbeatTimeout time.Duration
+	Geo              *GeoLocator // nil отключает GeoIP обогащение агентов и результатов
 }
 
 func NewAgentService(
 	agentStore storage.AgentStore,
 	checkStore storage.CheckStore,
 	resultStore storage.ResultStore,
+	monitorStore storage.MonitorStore,
 	cfg AgentServiceConfig,
 	logger *slog.Logger,
 ) *AgentService {
@@ -40,10 +52,12 @@ func NewAgentService(
 	}
 
 	return &AgentService{
-		agentStore:  agentStore,
-		checkStore:  checkStore,
-		resultStore: resultStore,
-		logger:      logger,
+		agentStore:   agentStore,
+		checkStore:   checkStore,
+		resultStore:  resultStore,
+		monitorStore: monitorStore,
+		geo:          cfg.Geo,
+		logger:       logger,
 	}
 }
 
@@ -79,15 +93,43 @@ func (s *AgentService) RegisterAgent(ctx context.Context, req *models.RegisterRe
 		}
 	}
 
+	if err := validator.ValidateLabels(req.Labels); err != nil {
+		s.logger.Warn("registration failed: invalid labels",
+			"name", req.Name,
+			"error", err,
+		)
+		return nil, "", fmt.Errorf("invalid labels: %w", err)
+	}
+
 	// Генерируем уникальный токен
 	token := uuidutil.New()
 
+	// Хост уже регистрировался: переиспользуем запись и выдаем новый токен
+	if req.Fingerprint != "" {
+		existing, err := s.agentStore.GetByFingerprint(ctx, req.Fingerprint)
+		if err != nil {
+			s.logger.Error("failed to look up agent by fingerprint",
+				"error", err,
+				"name", req.Name,
+			)
+			return nil, "", fmt.Errorf("failed to register agent: %w", err)
+		}
+
+		if existing != nil {
+			return s.reregisterAgent(ctx, existing, req, token)
+		}
+	}
+
 	agent := &models.Agent{
 		Name:         req.Name,
 		Location:     req.Location,
 		Token:        token,
 		Capabilities: req.Capabilities,
 		Status:       models.AgentStatusOffline,
+		Metadata:     req.Metadata,
+		Fingerprint:  req.Fingerprint,
+		Geo:          s.geo.LocateAgent(req),
+		Labels:       req.Labels,
 	}
 
 	if err := s.agentStore.Create(ctx, agent); err != nil {
@@ -109,6 +151,34 @@ func (s *AgentService) RegisterAgent(ctx context.Context, req *models.RegisterRe
 	return agent, token, nil
 }
 
+// обновляет запись ранее зарегистрированного хоста, старый токен перестает действовать
+func (s *AgentService) reregisterAgent(ctx context.Context, agent *models.Agent, req *models.RegisterRequest, token string) (*models.Agent, string, error) {
+	agent.Name = req.Name
+	agent.Location = req.Location
+	agent.Capabilities = req.Capabilities
+	agent.Metadata = req.Metadata
+	agent.Geo = s.geo.LocateAgent(req)
+	agent.Labels = req.Labels
+	agent.Token = token
+	agent.Status = models.AgentStatusOffline
+
+	if err := s.agentStore.UpdateRegistration(ctx, agent); err != nil {
+		s.logger.Error("failed to update agent registration",
+			"error", err,
+			"agent_id", agent.ID,
+		)
+		return nil, "", fmt.Errorf("failed to register agent: %w", err)
+	}
+
+	s.logger.Info("agent re-registered, existing record reused",
+		"agent_id", agent.ID,
+		"name", agent.Name,
+		"location", agent.Location,
+	)
+
+	return agent, token, nil
+}
+
 // AuthenticateAgent аутентифицирует агента по токену
 func (s *AgentService) AuthenticateAgent(ctx context.Context, token string) (*models.Agent, error) {
 	s.logger.Debug("authenticating agent", "token_length", len(token))
@@ -141,10 +211,11 @@ func (s *AgentService) AuthenticateAgent(ctx context.Context, token string) (*mo
 }
 
 // обновляет время последней активности агента
-func (s *AgentService) UpdateHeartbeat(ctx context.Context, agentID string, load int) error {
+func (s *AgentService) UpdateHeartbeat(ctx context.Context, agentID string, heartbeat *models.HeartbeatRequest) error {
 	s.logger.Debug("updating agent heartbeat",
 		"agent_id", agentID,
-		"load", load,
+		"load", heartbeat.Load,
+		"active_jobs", heartbeat.ActiveJobs,
 	)
 
 	if agentID == "" {
@@ -170,7 +241,7 @@ func (s *AgentService) UpdateHeartbeat(ctx context.Context, agentID string, load
 	}
 
 	// Обновляем heartbeat
-	if err := s.agentStore.UpdateHeartbeat(ctx, agentID); err != nil {
+	if err := s.agentStore.UpdateHeartbeat(ctx, agentID, heartbeat); err != nil {
 		s.logger.Error("failed to update agent heartbeat in storage",
 			"error", err,
 			"agent_id", agentID,
@@ -180,7 +251,9 @@ func (s *AgentService) UpdateHeartbeat(ctx context.Context, agentID string, load
 
 	s.logger.Debug("agent heartbeat updated",
 		"agent_id", agentID,
-		"load", load,
+		"load", heartbeat.Load,
+		"active_jobs", heartbeat.ActiveJobs,
+		"max_jobs", heartbeat.MaxJobs,
 		"name", agent.Name,
 	)
 
@@ -389,8 +462,8 @@ func (s *AgentService) CleanupInactiveAgents(ctx context.Context, timeout time.D
 	return inactiveCount, nil
 }
 
-// обновляет возможности агента
-func (s *AgentService) UpdateAgentCapabilities(ctx context.Context, agentID string, capabilities []string) error {
+// обновляет возможности агента по результатам его самопроверки
+func (s *AgentService) UpdateAgentCapabilities(ctx context.Context, agentID string, capabilities []string, environment *models.AgentEnvironment) error {
 	s.logger.Info("updating agent capabilities",
 		"agent_id", agentID,
 		"capabilities", capabilities,
@@ -430,7 +503,7 @@ func (s *AgentService) UpdateAgentCapabilities(ctx context.Context, agentID stri
 	}
 
 	// Обновляем capabilities
-	if err := s.agentStore.UpdateCapabilities(ctx, agentID, capabilities); err != nil {
+	if err := s.agentStore.UpdateCapabilities(ctx, agentID, capabilities, environment); err != nil {
 		s.logger.Error("failed to update agent capabilities in storage",
 			"error", err,
 			"agent_id", agentID,
@@ -444,6 +517,128 @@ func (s *AgentService) UpdateAgentCapabilities(ctx context.Context, agentID stri
 		"name", agent.Name,
 		"old_capabilities", agent.Capabilities,
 		"new_capabilities", capabilities,
+		"environment", environment,
+	)
+
+	return nil
+}
+
+// GetMonitorManifest собирает манифест из включенных регулярных проверок,
+// под селектор которых подходит агент
+func (s *AgentService) GetMonitorManifest(ctx context.Context, agentID string) (*models.MonitorManifest, error) {
+	agent, err := s.agentStore.GetByID(ctx, agentID)
+	if err != nil {
+		s.logger.Error("failed to get agent for monitor manifest", "error", err, "agent_id", agentID)
+		return nil, err
+	}
+	if agent == nil {
+		return nil, storage.ErrAgentNotFound
+	}
+
+	monitors, err := s.monitorStore.ListEnabled(ctx)
+	if err != nil {
+		s.logger.Error("failed to get agent monitors", "error", err, "agent_id", agentID)
+		return nil, err
+	}
+
+	assigned := make([]*models.Monitor, 0, len(monitors))
+	for _, monitor := range monitors {
+		if monitor.Targets(agent) {
+			assigned = append(assigned, monitor)
+		}
+	}
+	return buildMonitorManifest(assigned), nil
+}
+
+// RecordMonitorRun сохраняет результат регулярной проверки, которую агент
+// выполнил сам, пока бэкенд был недоступен. Каждый запуск становится
+// завершенной проверкой с monitor_id, ее ID совпадает с result_id, так что
+// повтор из спула агента не создает дубликатов.
+func (s *AgentService) RecordMonitorRun(ctx context.Context, agentID, monitorID string, result *models.CheckResult) error {
+	if !uuidutil.IsValid(monitorID) {
+		return ErrMonitorNotFound
+	}
+
+	monitor, err := s.monitorStore.GetByID(ctx, monitorID)
+	if errors.Is(err, storage.ErrMonitorNotFound) {
+		return ErrMonitorNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	agent, err := s.agentStore.GetByID(ctx, agentID)
+	if err != nil {
+		return err
+	}
+	// запуски выключенных проверок, сделанные до выключения, сохраняются
+	if agent == nil || !monitor.Targets(agent) {
+		return ErrMonitorNotFound
+	}
+
+	createdAt := result.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	check := &models.Check{
+		ID:        result.ResultID,
+		Type:      monitor.Type,
+		Target:    monitor.Target,
+		Status:    models.CheckStatusCompleted,
+		CreatedAt: createdAt,
+		MonitorID: monitor.ID,
+		Options:   monitor.Options,
+		// запуск без бэкенда выполняет только приславший его агент
+		TargetAgents: []string{agentID},
+	}
+
+	created, err := s.checkStore.CreateMonitorRun(ctx, check)
+	if err != nil {
+		s.logger.Error("failed to save monitor run",
+			"error", err,
+			"agent_id", agentID,
+			"monitor_id", monitorID,
+		)
+		return err
+	}
+
+	// Повтор из спула находит свой запуск, чужую проверку с тем же ID не трогаем
+	if !created {
+		existing, err := s.checkStore.GetByID(ctx, check.ID)
+		if err != nil {
+			return err
+		}
+		if existing == nil || existing.MonitorID != monitor.ID || !slices.Contains(existing.TargetAgents, agentID) {
+			s.logger.Warn("monitor run id conflicts with another check",
+				"agent_id", agentID,
+				"monitor_id", monitorID,
+				"check_id", check.ID,
+			)
+			return ErrMonitorRunConflict
+		}
+	}
+
+	result.CheckID = check.ID
+	result.AgentID = agentID
+	s.geo.LocateResult(result, monitor.Target)
+	if err := s.resultStore.Create(ctx, result); err != nil {
+		if !errors.Is(err, storage.ErrDuplicateResult) {
+			s.logger.Error("failed to save monitor run result",
+				"error", err,
+				"agent_id", agentID,
+				"monitor_id", monitorID,
+			)
+		}
+		return err
+	}
+
+	s.logger.Info("monitor run recorded",
+		"agent_id", agentID,
+		"monitor_id", monitorID,
+		"check_id", check.ID,
+		"success", result.Success,
+		"run_at", createdAt,
 	)
 
 	return nil
@@ -3,26 +3,42 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"time"
 
 	"NetScan/internal/backend/models"
 	"NetScan/internal/backend/storage"
+	"NetScan/pkg/uuidutil"
 	"NetScan/pkg/validator"
 )
 
+var (
+	// опции не соответствуют схеме типа проверки
+	ErrInvalidCheckOptions = errors.New("invalid check options")
+	// проверки с таким ID нет
+	ErrCheckNotFound = errors.New("check not found")
+	// переход не разрешен машиной состояний проверки
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+	// проверка отменена, ее задачи и результаты больше не принимаются
+	ErrCheckCancelled = errors.New("check cancelled")
+)
+
 type CheckService struct {
 	checkStore  storage.CheckStore
 	agentStore  storage.AgentStore
 	resultStore storage.ResultStore
 	queue       storage.Queue
 	timeout     time.Duration
+	consensus   ConsensusRules
 	logger      *slog.Logger
 }
 
 type CheckServiceConfig struct {
 	TaskTimeout time.Duration
+	Consensus   *ConsensusRules // nil - DefaultConsensusRules
 }
 
 func NewCheckService(
@@ -39,6 +55,11 @@ func NewCheckService(
 		timeout = 30 * time.Second
 	}
 
+	consensus := DefaultConsensusRules()
+	if cfg.Consensus != nil {
+		consensus = *cfg.Consensus
+	}
+
 	if logger == nil {
 		logger = slog.Default()
 	}
@@ -49,12 +70,13 @@ func NewCheckService(
 		resultStore: resultStore,
 		queue:       queue,
 		timeout:     timeout,
+		consensus:   consensus,
 		logger:      logger,
 	}
 }
 
 // CreateCheck создает новую проверку и добавляет в очередь
-func (s *CheckService) CreateCheck(ctx context.Context, checkType models.CheckType, target string) (*models.Check, error) {
+func (s *CheckService) CreateCheck(ctx context.Context, checkType models.CheckType, target string, options map[string]interface{}, agents *models.AgentSelector) (*models.Check, error) {
 	s.logger.Info("creating new check",
 		"type", checkType,
 		"target", target,
@@ -77,56 +99,121 @@ func (s *CheckService) CreateCheck(ctx context.Context, checkType models.CheckTy
 		return nil, fmt.Errorf("invalid target: %s", target)
 	}
 
-	// Создаем проверку
-	check := &models.Check{
-		Type:   checkType,
-		Target: target,
-		Status: models.CheckStatusPending,
+	if err := validator.ValidateCheckOptions(string(checkType), options); err != nil {
+		s.logger.Warn("invalid check options received",
+			"type", checkType,
+			"target", target,
+			"error", err,
+		)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckOptions, err)
 	}
 
-	if err := s.checkStore.Create(ctx, check); err != nil {
-		s.logger.Error("failed to create check in storage",
-			"error", err,
+	if err := validateSelector(agents); err != nil {
+		s.logger.Warn("invalid agent selector received",
 			"type", checkType,
 			"target", target,
+			"error", err,
 		)
-		return nil, fmt.Errorf("failed to create check: %w", err)
+		return nil, err
 	}
 
-	s.logger.Debug("check created in storage",
-		"check_id", check.ID,
-		"type", checkType,
-		"target", target,
-	)
+	// Создаем проверку
+	check := &models.Check{
+		Type:    checkType,
+		Target:  target,
+		Status:  models.CheckStatusPending,
+		Options: options,
+		Agents:  agents,
+	}
+
+	if err := s.startCheck(ctx, check); err != nil {
+		return nil, err
+	}
+
+	return check, nil
+}
+
+// RunMonitor создает очередной запуск регулярной проверки и ставит его в
+// очередь для агентов, выбранных ее селектором
+func (s *CheckService) RunMonitor(ctx context.Context, monitor *models.Monitor) (*models.Check, error) {
+	check := &models.Check{
+		Type:      monitor.Type,
+		Target:    monitor.Target,
+		Status:    models.CheckStatusPending,
+		Options:   monitor.Options,
+		MonitorID: monitor.ID,
+		Agents:    monitor.Agents,
+	}
+
+	if err := s.startCheck(ctx, check); err != nil {
+		return nil, err
+	}
+
+	return check, nil
+}
+
+// startCheck выбирает агентов по селектору проверки, сохраняет проверку
+// вместе с выбранными агентами и кладет по копии задачи на каждого из них
+func (s *CheckService) startCheck(ctx context.Context, check *models.Check) error {
+	checkType, target, options := check.Type, check.Target, check.Options
 
 	// Получаем онлайн агентов для этой проверки
-	agents, err := s.agentStore.ListOnline(ctx)
+	online, err := s.agentStore.ListOnline(ctx)
 	if err != nil {
 		s.logger.Error("failed to get online agents",
 			"error", err,
-			"check_id", check.ID,
+			"type", checkType,
+			"target", target,
 		)
-		return nil, fmt.Errorf("failed to get online agents: %w", err)
+		return fmt.Errorf("failed to get online agents: %w", err)
 	}
 
-	if len(agents) == 0 {
-		s.logger.Warn("no online agents available for check",
-			"check_id", check.ID,
+	agents, err := selectAgents(online, check)
+	if err != nil {
+		s.logger.Warn("no agents selected for check",
+			"error", err,
+			"type", checkType,
+			"target", target,
+			"online_agents", len(online),
+			"monitor_id", check.MonitorID,
+		)
+		return err
+	}
+
+	check.TargetAgents = make([]string, 0, len(agents))
+	for _, agent := range agents {
+		check.TargetAgents = append(check.TargetAgents, agent.ID)
+	}
+
+	if err := s.checkStore.Create(ctx, check); err != nil {
+		s.logger.Error("failed to create check in storage",
+			"error", err,
+			"type", checkType,
+			"target", target,
 		)
-		return nil, fmt.Errorf("no online agents available")
+		return fmt.Errorf("failed to create check: %w", err)
 	}
 
+	s.logger.Debug("check created in storage",
+		"check_id", check.ID,
+		"type", checkType,
+		"target", target,
+		"monitor_id", check.MonitorID,
+	)
+
 	s.logger.Debug("found online agents for check",
 		"check_id", check.ID,
 		"agents_count", len(agents),
 	)
 
-	// Создаем задачи для каждого онлайн агента
+	// Создаем задачу для выбранных агентов
 	task := models.CheckTask{
 		CheckID:   check.ID,
 		Type:      checkType,
 		Target:    target,
+		Options:   options,
 		CreatedAt: time.Now(),
+		TimeoutMs: s.timeout.Milliseconds(),
 	}
 
 	taskData, err := json.Marshal(task)
@@ -135,7 +222,7 @@ func (s *CheckService) CreateCheck(ctx context.Context, checkType models.CheckTy
 			"error", err,
 			"check_id", check.ID,
 		)
-		return nil, fmt.Errorf("failed to marshal task: %w", err)
+		return fmt.Errorf("failed to marshal task: %w", err)
 	}
 
 	s.logger.Debug("Task data before pushing to queue",
@@ -144,14 +231,14 @@ func (s *CheckService) CreateCheck(ctx context.Context, checkType models.CheckTy
 		"data_type", fmt.Sprintf("%T", taskData),
 	)
 
-	// Добавляем задачу в очередь (каждый агент будет брать свою копию)
+	// Кладем копию задачи в очередь каждого выбранного агента
 	successfulPushes := 0
-	for i := range agents {
-		if err := s.queue.PushTask(ctx, "check_tasks", taskData); err != nil {
+	for _, agent := range agents {
+		if err := s.queue.PushTask(ctx, agentTaskQueue(agent.ID), taskData); err != nil {
 			s.logger.Error("failed to push task to queue",
 				"error", err,
 				"check_id", check.ID,
-				"agent_index", i,
+				"agent_id", agent.ID,
 			)
 			// Продолжаем для других агентов, даже если один фейлится
 		} else {
@@ -164,7 +251,7 @@ func (s *CheckService) CreateCheck(ctx context.Context, checkType models.CheckTy
 			"check_id", check.ID,
 			"total_agents", len(agents),
 		)
-		return nil, fmt.Errorf("failed to distribute task to any agent")
+		return fmt.Errorf("failed to distribute task to any agent")
 	}
 
 	s.logger.Info("check created and queued successfully",
@@ -172,10 +259,11 @@ func (s *CheckService) CreateCheck(ctx context.Context, checkType models.CheckTy
 		"type", checkType,
 		"target", target,
 		"total_agents", len(agents),
+		"target_agents", check.TargetAgents,
 		"successful_queues", successfulPushes,
 	)
 
-	return check, nil
+	return nil
 }
 
 // GetCheckByID возвращает проверку по ID
@@ -206,15 +294,26 @@ func (s *CheckService) GetCheckByID(ctx context.Context, id string) (*models.Che
 		return nil, fmt.Errorf("failed to get check results: %w", err)
 	}
 
+	verdict, err := s.checkVerdict(ctx, check, results)
+	if err != nil {
+		s.logger.Error("failed to compute check verdict",
+			"error", err,
+			"check_id", id,
+		)
+		return nil, fmt.Errorf("failed to compute check verdict: %w", err)
+	}
+
 	s.logger.Debug("check retrieved successfully",
 		"check_id", id,
 		"results_count", len(results),
 		"status", check.Status,
+		"verdict", verdict.Verdict,
 	)
 
 	return &models.CheckWithResults{
 		Check:   check,
 		Results: results,
+		Verdict: verdict,
 	}, nil
 }
 
@@ -225,131 +324,48 @@ func (s *CheckService) UpdateCheckStatus(ctx context.Context, id string, status
 		"new_status", status,
 	)
 
-	check, err := s.checkStore.GetByID(ctx, id)
+	if !uuidutil.IsValid(id) {
+		return fmt.Errorf("%w: %s", ErrCheckNotFound, id)
+	}
+
+	// Переход одним условным UPDATE: отмена и завершение проверки не должны
+	// перезаписать друг друга
+	updated, err := s.checkStore.TransitionStatus(ctx, id, transitionSources(status), status)
 	if err != nil {
-		s.logger.Error("failed to get check for status update",
+		s.logger.Error("failed to update check status in storage",
 			"error", err,
 			"check_id", id,
+			"status", status,
 		)
-		return fmt.Errorf("failed to get check: %w", err)
+		return fmt.Errorf("failed to update check status: %w", err)
 	}
 
-	if check == nil {
-		s.logger.Warn("check not found for status update", "check_id", id)
-		return fmt.Errorf("check not found: %s", id)
-	}
+	if !updated {
+		check, err := s.checkStore.GetByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get check: %w", err)
+		}
+		if check == nil {
+			s.logger.Warn("check not found for status update", "check_id", id)
+			return fmt.Errorf("%w: %s", ErrCheckNotFound, id)
+		}
 
-	// Валидация перехода статусов
-	if !s.isValidStatusTransition(check.Status, status) {
 		s.logger.Warn("invalid status transition attempted",
 			"check_id", id,
 			"from_status", check.Status,
 			"to_status", status,
 		)
-		return fmt.Errorf("invalid status transition: %s -> %s", check.Status, status)
-	}
-
-	if err := s.checkStore.UpdateStatus(ctx, id, status); err != nil {
-		s.logger.Error("failed to update check status in storage",
-			"error", err,
-			"check_id", id,
-			"status", status,
-		)
-		return fmt.Errorf("failed to update check status: %w", err)
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, check.Status, status)
 	}
 
 	s.logger.Info("check status updated",
 		"check_id", id,
-		"old_status", check.Status,
 		"new_status", status,
 	)
 
 	return nil
 }
 
-// SubmitResult сохраняет результат проверки от агента
-func (s *CheckService) SubmitResult(ctx context.Context, result *models.CheckResult) error {
-	s.logger.Info("submitting check result",
-		"check_id", result.CheckID,
-		"agent_id", result.AgentID,
-		"success", result.Success,
-		"duration", result.Duration,
-	)
-
-	// Валидация
-	if result.CheckID == "" {
-		s.logger.Warn("empty check ID in result submission")
-		return fmt.Errorf("check ID is required")
-	}
-
-	if result.AgentID == "" {
-		s.logger.Warn("empty agent ID in result submission", "check_id", result.CheckID)
-		return fmt.Errorf("agent ID is required")
-	}
-
-	// Проверяем существование проверки
-	check, err := s.checkStore.GetByID(ctx, result.CheckID)
-	if err != nil {
-		s.logger.Error("failed to get check for result submission",
-			"error", err,
-			"check_id", result.CheckID,
-		)
-		return fmt.Errorf("failed to get check: %w", err)
-	}
-
-	if check == nil {
-		s.logger.Warn("check not found for result submission", "check_id", result.CheckID)
-		return fmt.Errorf("check not found: %s", result.CheckID)
-	}
-
-	// Проверяем существование агента
-	agent, err := s.agentStore.GetByID(ctx, result.AgentID)
-	if err != nil {
-		s.logger.Error("failed to get agent for result submission",
-			"error", err,
-			"agent_id", result.AgentID,
-			"check_id", result.CheckID,
-		)
-		return fmt.Errorf("failed to get agent: %w", err)
-	}
-
-	if agent == nil {
-		s.logger.Warn("agent not found for result submission",
-			"agent_id", result.AgentID,
-			"check_id", result.CheckID,
-		)
-		return fmt.Errorf("agent not found: %s", result.AgentID)
-	}
-
-	// Сохраняем результат
-	if err := s.resultStore.Create(ctx, result); err != nil {
-		s.logger.Error("failed to save check result",
-			"error", err,
-			"check_id", result.CheckID,
-			"agent_id", result.AgentID,
-		)
-		return fmt.Errorf("failed to create result: %w", err)
-	}
-
-	// Обновляем статус проверки если все агенты ответили
-	if err := s.updateCheckCompletionStatus(ctx, result.CheckID); err != nil {
-		s.logger.Warn("failed to update check completion status",
-			"error", err,
-			"check_id", result.CheckID,
-		)
-		// Не прерываем выполнение, это не критическая ошибка
-	}
-
-	s.logger.Info("check result submitted successfully",
-		"check_id", result.CheckID,
-		"agent_id", result.AgentID,
-		"success", result.Success,
-		"duration", result.Duration,
-	)
-
-	return nil
-}
-
 // ListChecks возвращает список проверок с пагинацией
 func (s *CheckService) ListChecks(ctx context.Context, limit, offset int) ([]*models.Check, error) {
 	s.logger.Debug("listing checks",
@@ -385,94 +401,35 @@ func (s *CheckService) ListChecks(ctx context.Context, limit, offset int) ([]*mo
 	return checks, nil
 }
 
-// updateCheckCompletionStatus обновляет статус проверки когда все агенты ответили
-func (s *CheckService) updateCheckCompletionStatus(ctx context.Context, checkID string) error {
-	// Получаем все результаты для проверки
-	results, err := s.resultStore.GetByCheckID(ctx, checkID)
-	if err != nil {
-		s.logger.Error("failed to get check results for completion check",
-			"error", err,
-			"check_id", checkID,
-		)
-		return err
-	}
-
-	// Получаем всех онлайн агентов
-	agents, err := s.agentStore.ListOnline(ctx)
-	if err != nil {
-		s.logger.Error("failed to get online agents for completion check",
-			"error", err,
-			"check_id", checkID,
-		)
-		return err
-	}
-
-	// Если все онлайн агенты ответили, помечаем проверку как завершенную
-	if len(results) >= len(agents) {
-		if err := s.checkStore.UpdateStatus(ctx, checkID, models.CheckStatusCompleted); err != nil {
-			s.logger.Error("failed to mark check as completed",
-				"error", err,
-				"check_id", checkID,
-			)
-			return err
-		}
+// допустимые переходы статусов проверки
+var checkStatusTransitions = map[models.CheckStatus][]models.CheckStatus{
+	models.CheckStatusPending:   {models.CheckStatusRunning, models.CheckStatusFailed, models.CheckStatusCancelled},
+	models.CheckStatusRunning:   {models.CheckStatusCompleted, models.CheckStatusFailed, models.CheckStatusCancelled},
+	models.CheckStatusCompleted: {},
+	models.CheckStatusFailed:    {},
+	models.CheckStatusCancelled: {},
+}
 
-		s.logger.Info("check completed by all agents",
-			"check_id", checkID,
-			"results_count", len(results),
-			"agents_count", len(agents),
-		)
-	} else {
-		// Иначе помечаем как выполняющуюся
-		if err := s.checkStore.UpdateStatus(ctx, checkID, models.CheckStatusRunning); err != nil {
-			s.logger.Error("failed to mark check as running",
-				"error", err,
-				"check_id", checkID,
-			)
-			return err
+// transitionSources возвращает статусы, из которых допустим переход в to
+func transitionSources(to models.CheckStatus) []models.CheckStatus {
+	var sources []models.CheckStatus
+	for from, allowed := range checkStatusTransitions {
+		if slices.Contains(allowed, to) {
+			sources = append(sources, from)
 		}
-
-		s.logger.Debug("check still in progress",
-			"check_id", checkID,
-			"completed_results", len(results),
-			"total_agents", len(agents),
-		)
 	}
-
-	return nil
+	return sources
 }
 
-// isValidStatusTransition проверяет валидность перехода статусов
-func (s *CheckService) isValidStatusTransition(from, to models.CheckStatus) bool {
-	transitions := map[models.CheckStatus][]models.CheckStatus{
-		models.CheckStatusPending:   {models.CheckStatusRunning, models.CheckStatusFailed},
-		models.CheckStatusRunning:   {models.CheckStatusCompleted, models.CheckStatusFailed},
-		models.CheckStatusCompleted: {},
-		models.CheckStatusFailed:    {},
+// код неудачи для статистики, у результатов старых агентов его нет
+func failureCode(result *models.CheckResult) string {
+	if result.Failure != nil && result.Failure.Code != "" {
+		return result.Failure.Code
 	}
-
-	allowed, exists := transitions[from]
-	if !exists {
-		s.logger.Warn("unknown source status for transition validation",
-			"from_status", from,
-			"to_status", to,
-		)
-		return false
+	if result.Outcome == models.ResultOutcomeTimeout {
+		return models.ResultOutcomeTimeout
 	}
-
-	for _, status := range allowed {
-		if status == to {
-			return true
-		}
-	}
-
-	s.logger.Debug("invalid status transition",
-		"from_status", from,
-		"to_status", to,
-		"allowed_transitions", allowed,
-	)
-
-	return false
+	return "unknown"
 }
 
 // GetCheckStats возвращает статистику по проверке
@@ -494,6 +451,7 @@ func (s *CheckService) GetCheckStats(ctx context.Context, checkID string) (*mode
 		Failed:       0,
 		AverageTime:  0,
 		AgentResults: make(map[string]int),
+		FailureCodes: make(map[string]int),
 	}
 
 	var totalTime float64
@@ -502,6 +460,7 @@ func (s *CheckService) GetCheckStats(ctx context.Context, checkID string) (*mode
 			stats.Successful++
 		} else {
 			stats.Failed++
+			stats.FailureCodes[failureCode(result)]++
 		}
 		totalTime += result.Duration
 		stats.AgentResults[result.AgentID]++
@@ -511,6 +470,25 @@ func (s *CheckService) GetCheckStats(ctx context.Context, checkID string) (*mode
 		stats.AverageTime = totalTime / float64(len(results))
 	}
 
+	check, err := s.checkStore.GetByID(ctx, checkID)
+	if err != nil {
+		s.logger.Error("failed to get check for statistics",
+			"error", err,
+			"check_id", checkID,
+		)
+		return nil, err
+	}
+	if check != nil {
+		stats.Verdict, err = s.checkVerdict(ctx, check, results)
+		if err != nil {
+			s.logger.Error("failed to compute check verdict",
+				"error", err,
+				"check_id", checkID,
+			)
+			return nil, err
+		}
+	}
+
 	s.logger.Debug("check statistics calculated",
 		"check_id", checkID,
 		"total_results", stats.TotalResults,
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"NetScan/internal/backend/models"
+	"NetScan/pkg/geoip"
+	"log/slog"
+	"net"
+	"net/url"
+)
+
+// GeoLocator определяет страну, город и AS адресов по локальным базам GeoIP.
+// Nil локатор означает, что обогащение отключено.
+type GeoLocator struct {
+	db     *geoip.DB
+	logger *slog.Logger
+}
+
+func NewGeoLocator(db *geoip.DB, logger *slog.Logger) *GeoLocator {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &GeoLocator{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Locate возвращает сведения о первом адресе, который есть в базах
+func (g *GeoLocator) Locate(ips ...string) *models.GeoInfo {
+	if g == nil {
+		return nil
+	}
+
+	for _, ip := range ips {
+		if ip == "" {
+			continue
+		}
+
+		info, err := g.db.Lookup(ip)
+		if err != nil {
+			g.logger.Warn("geoip lookup failed", "error", err, "ip", ip)
+			continue
+		}
+		if info == nil {
+			continue
+		}
+
+		return &models.GeoInfo{
+			IP:           info.IP,
+			CountryCode:  info.CountryCode,
+			Country:      info.Country,
+			City:         info.City,
+			Latitude:     info.Latitude,
+			Longitude:    info.Longitude,
+			ASN:          info.ASN,
+			Organization: info.Organization,
+		}
+	}
+
+	return nil
+}
+
+// LocateAgent определяет расположение агента по адресу запроса регистрации,
+// за NAT в частной сети используется адрес, который сообщил сам агент
+func (g *GeoLocator) LocateAgent(req *models.RegisterRequest) *models.GeoInfo {
+	reported := ""
+	if req.Metadata != nil {
+		reported = req.Metadata.IPAddress
+	}
+	return g.Locate(req.RemoteIP, reported)
+}
+
+// LocateResult дополняет результат сведениями об адресе, к которому
+// обратился агент, а если агент его не сообщил - об адресе из цели проверки
+func (g *GeoLocator) LocateResult(result *models.CheckResult, target string) {
+	if g == nil || result.Geo != nil {
+		return
+	}
+	result.Geo = g.Locate(resultIP(result.Data), targetIP(target))
+}
+
+// адрес цели из данных результата: resolved_ip у http, ping, tcp и mtr
+func resultIP(data map[string]interface{}) string {
+	if ip, ok := data["resolved_ip"].(string); ok && ip != "" {
+		return ip
+	}
+	if address, ok := data["remote_address"].(string); ok {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			return host
+		}
+	}
+	return ""
+}
+
+// адрес, если цель задана IP, в том числе в URL или вместе с портом
+func targetIP(target string) string {
+	host := target
+	if parsed, err := url.Parse(target); err == nil && parsed.Host != "" {
+		host = parsed.Hostname()
+	} else if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
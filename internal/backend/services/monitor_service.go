@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"time"
+
+	"NetScan/internal/backend/models"
+	"NetScan/internal/backend/storage"
+	"NetScan/pkg/uuidutil"
+	"NetScan/pkg/validator"
+)
+
+// регулярная проверка не прошла валидацию
+var ErrInvalidMonitor = errors.New("invalid monitor")
+
+// минимальный интервал регулярной проверки в секундах
+const MinMonitorInterval = 10
+
+// сколько наступивших запусков планировщик забирает за один проход
+const monitorScheduleBatch = 100
+
+type MonitorService struct {
+	monitorStore storage.MonitorStore
+	checkStore   storage.CheckStore
+	checkService *CheckService
+	logger       *slog.Logger
+}
+
+func NewMonitorService(
+	monitorStore storage.MonitorStore,
+	checkStore storage.CheckStore,
+	checkService *CheckService,
+	logger *slog.Logger,
+) *MonitorService {
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &MonitorService{
+		monitorStore: monitorStore,
+		checkStore:   checkStore,
+		checkService: checkService,
+		logger:       logger,
+	}
+}
+
+// CreateMonitor создает регулярную проверку, первый запуск планируется на
+// ближайший слот ее расписания
+func (s *MonitorService) CreateMonitor(ctx context.Context, req *models.MonitorRequest) (*models.Monitor, error) {
+	if err := validateMonitor(req); err != nil {
+		s.logger.Warn("invalid monitor received", "type", req.Type, "target", req.Target, "error", err)
+		return nil, err
+	}
+
+	// время хранится в UTC: колонки TIMESTAMP не хранят часовой пояс
+	now := time.Now().UTC()
+	monitor := &models.Monitor{
+		ID:        uuidutil.New(),
+		CreatedAt: now,
+	}
+	applyMonitorRequest(monitor, req, now)
+
+	if err := s.monitorStore.Create(ctx, monitor); err != nil {
+		s.logger.Error("failed to create monitor", "error", err, "type", req.Type, "target", req.Target)
+		return nil, err
+	}
+
+	s.logger.Info("monitor created",
+		"monitor_id", monitor.ID,
+		"type", monitor.Type,
+		"target", monitor.Target,
+		"interval_seconds", monitor.Interval,
+		"next_run_at", monitor.NextRunAt,
+	)
+
+	return monitor, nil
+}
+
+// GetMonitor возвращает регулярную проверку, storage.ErrMonitorNotFound если ее нет
+func (s *MonitorService) GetMonitor(ctx context.Context, id string) (*models.Monitor, error) {
+	if !uuidutil.IsValid(id) {
+		return nil, storage.ErrMonitorNotFound
+	}
+	return s.monitorStore.GetByID(ctx, id)
+}
+
+// ListMonitors возвращает список регулярных проверок
+func (s *MonitorService) ListMonitors(ctx context.Context, limit, offset int) ([]*models.Monitor, error) {
+	monitors, err := s.monitorStore.List(ctx, limit, offset)
+	if err != nil {
+		s.logger.Error("failed to list monitors", "error", err)
+		return nil, err
+	}
+	return monitors, nil
+}
+
+// UpdateMonitor заменяет настройки регулярной проверки. Расписание
+// пересчитывается, следующий запуск попадает на слот нового интервала.
+func (s *MonitorService) UpdateMonitor(ctx context.Context, id string, req *models.MonitorRequest) (*models.Monitor, error) {
+	if err := validateMonitor(req); err != nil {
+		s.logger.Warn("invalid monitor update received", "monitor_id", id, "error", err)
+		return nil, err
+	}
+
+	monitor, err := s.GetMonitor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	applyMonitorRequest(monitor, req, time.Now().UTC())
+
+	if err := s.monitorStore.Update(ctx, monitor); err != nil {
+		if !errors.Is(err, storage.ErrMonitorNotFound) {
+			s.logger.Error("failed to update monitor", "error", err, "monitor_id", id)
+		}
+		return nil, err
+	}
+
+	s.logger.Info("monitor updated",
+		"monitor_id", monitor.ID,
+		"enabled", monitor.Enabled,
+		"interval_seconds", monitor.Interval,
+		"next_run_at", monitor.NextRunAt,
+	)
+
+	return monitor, nil
+}
+
+// DeleteMonitor удаляет регулярную проверку, история запусков сохраняется
+func (s *MonitorService) DeleteMonitor(ctx context.Context, id string) error {
+	if !uuidutil.IsValid(id) {
+		return storage.ErrMonitorNotFound
+	}
+
+	if err := s.monitorStore.Delete(ctx, id); err != nil {
+		if !errors.Is(err, storage.ErrMonitorNotFound) {
+			s.logger.Error("failed to delete monitor", "error", err, "monitor_id", id)
+		}
+		return err
+	}
+
+	s.logger.Info("monitor deleted", "monitor_id", id)
+	return nil
+}
+
+// ListRuns возвращает историю запусков регулярной проверки, новые первыми
+func (s *MonitorService) ListRuns(ctx context.Context, id string, limit, offset int) ([]*models.Check, error) {
+	if _, err := s.GetMonitor(ctx, id); err != nil {
+		return nil, err
+	}
+
+	runs, err := s.checkStore.ListByMonitor(ctx, id, limit, offset)
+	if err != nil {
+		s.logger.Error("failed to list monitor runs", "error", err, "monitor_id", id)
+		return nil, err
+	}
+	if runs == nil {
+		runs = []*models.Check{}
+	}
+	return runs, nil
+}
+
+// RunScheduler запускает наступившие регулярные проверки каждые interval,
+// пока не отменен ctx
+func (s *MonitorService) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.RunDueMonitors(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Error("monitor scheduler pass failed", "error", err)
+			}
+		}
+	}
+}
+
+// RunDueMonitors создает запуски регулярных проверок, время которых
+// наступило. Слот сначала переносится на следующий и только потом
+// запускается: если слот уже забрал другой экземпляр или проход до
+// перезапуска, проверка пропускается, поэтому каждый слот запускается
+// не больше одного раза. Пропущенные за время простоя слоты не догоняются.
+func (s *MonitorService) RunDueMonitors(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+
+	monitors, err := s.monitorStore.ListDue(ctx, now, monitorScheduleBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due monitors: %w", err)
+	}
+
+	started := 0
+	for _, monitor := range monitors {
+		if monitor.NextRunAt == nil {
+			continue
+		}
+
+		claimed, err := s.monitorStore.ClaimRun(ctx, monitor.ID, *monitor.NextRunAt, nextMonitorRun(monitor, now))
+		if err != nil {
+			s.logger.Error("failed to claim monitor run", "error", err, "monitor_id", monitor.ID)
+			continue
+		}
+		if !claimed {
+			s.logger.Debug("monitor run already claimed", "monitor_id", monitor.ID)
+			continue
+		}
+
+		check, err := s.checkService.RunMonitor(ctx, monitor)
+		if err != nil {
+			s.logger.Warn("failed to start monitor run",
+				"error", err,
+				"monitor_id", monitor.ID,
+				"slot", monitor.NextRunAt,
+			)
+			continue
+		}
+
+		started++
+		s.logger.Debug("monitor run started",
+			"monitor_id", monitor.ID,
+			"check_id", check.ID,
+			"slot", monitor.NextRunAt,
+			"delay", now.Sub(*monitor.NextRunAt),
+		)
+	}
+
+	return started, nil
+}
+
+func validateMonitor(req *models.MonitorRequest) error {
+	if !validator.ValidateCheckType(string(req.Type)) {
+		return fmt.Errorf("%w: invalid check type %s", ErrInvalidMonitor, req.Type)
+	}
+	if !validator.ValidateTarget(req.Target) {
+		return fmt.Errorf("%w: invalid target %s", ErrInvalidMonitor, req.Target)
+	}
+	if req.Interval < MinMonitorInterval {
+		return fmt.Errorf("%w: interval_seconds must be at least %d", ErrInvalidMonitor, MinMonitorInterval)
+	}
+	if err := validator.ValidateCheckOptions(string(req.Type), req.Options); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMonitor, err)
+	}
+	if err := validateSelector(req.Agents); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMonitor, err)
+	}
+	return nil
+}
+
+func applyMonitorRequest(monitor *models.Monitor, req *models.MonitorRequest, now time.Time) {
+	monitor.Name = req.Name
+	monitor.Type = req.Type
+	monitor.Target = req.Target
+	monitor.Options = req.Options
+	monitor.Interval = req.Interval
+	monitor.Agents = req.Agents
+	monitor.Enabled = req.Enabled == nil || *req.Enabled
+	monitor.UpdatedAt = now
+
+	next := nextMonitorRun(monitor, now)
+	monitor.NextRunAt = &next
+}
+
+// nextMonitorRun возвращает первый слот после now. Слоты кратны интервалу и
+// сдвинуты на постоянное для проверки смещение от хеша ID: запуски разных
+// проверок с одним интервалом разнесены во времени, а расписание одной
+// проверки не плывет, сколько бы раз ни перезапускался бэкенд.
+func nextMonitorRun(monitor *models.Monitor, now time.Time) time.Time {
+	interval := monitor.IntervalDuration()
+
+	hash := fnv.New64a()
+	hash.Write([]byte(monitor.ID))
+	// с точностью до миллисекунды, чтобы слот не терялся при записи в TIMESTAMP
+	offset := time.Duration(hash.Sum64()%uint64(interval/time.Millisecond)) * time.Millisecond
+
+	at := now.Truncate(interval).Add(offset)
+	for !at.After(now) {
+		at = at.Add(interval)
+	}
+	return at
+}
+
+// buildMonitorManifest собирает манифест агента. Версия - хеш содержимого,
+// поэтому любое изменение проверок агента дает новую версию, а повторная
+// сборка без изменений - ту же.
+func buildMonitorManifest(monitors []*models.Monitor) *models.MonitorManifest {
+	manifest := &models.MonitorManifest{Monitors: make([]models.AgentMonitor, 0, len(monitors))}
+
+	for _, monitor := range monitors {
+		manifest.Monitors = append(manifest.Monitors, models.AgentMonitor{
+			ID:       monitor.ID,
+			Type:     monitor.Type,
+			Target:   monitor.Target,
+			Options:  monitor.Options,
+			Interval: monitor.Interval,
+		})
+
+		updatedAt := monitor.UpdatedAt
+		if manifest.UpdatedAt == nil || updatedAt.After(*manifest.UpdatedAt) {
+			manifest.UpdatedAt = &updatedAt
+		}
+	}
+
+	sort.Slice(manifest.Monitors, func(i, j int) bool {
+		return manifest.Monitors[i].ID < manifest.Monitors[j].ID
+	})
+
+	content, _ := json.Marshal(manifest.Monitors)
+	hash := fnv.New64a()
+	hash.Write(content)
+	manifest.Version = int64(hash.Sum64() >> 1)
+
+	return manifest
+}
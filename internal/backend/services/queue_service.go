@@ -6,8 +6,14 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -17,16 +23,23 @@ type QueueService struct {
 	agentStore      storage.AgentStore
 	agentTasksStore storage.AgentTasksStore
 	resultStore     storage.ResultStore
+	geo             *GeoLocator
 	logger          *slog.Logger
 	timeout         time.Duration
+
+	maxRetries       int
+	ackTimeout       time.Duration
+	stuckTaskTimeout time.Duration
 }
 
 type QueueServiceConfig struct {
 	TaskTimeout      time.Duration
 	StuckTaskTimeout time.Duration
+	AckTimeout       time.Duration // время на подтверждение получения задачи агентом
 	PollInterval     time.Duration
 	MaxRetries       int
 	RetryDelay       time.Duration
+	Geo              *GeoLocator // nil отключает GeoIP обогащение результатов
 }
 
 func NewQueueService(
@@ -49,6 +62,16 @@ func NewQueueService(
 		stuckTaskTimeout = 10 * time.Minute // 10 минут по умолчанию
 	}
 
+	ackTimeout := cfg.AckTimeout
+	if ackTimeout == 0 {
+		ackTimeout = 30 * time.Second
+	}
+
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+
 	if logger == nil {
 		logger = slog.Default()
 	}
@@ -59,14 +82,32 @@ func NewQueueService(
 		agentStore:      agentStore,
 		agentTasksStore: agentTasksStore,
 		resultStore:     resultStore,
+		geo:             cfg.Geo,
 		logger:          logger,
 		timeout:         timeout,
+
+		maxRetries:       maxRetries,
+		ackTimeout:       ackTimeout,
+		stuckTaskTimeout: stuckTaskTimeout,
 	}
 }
 
+// время ожидания задачи при опросе агентом по HTTP
+const pollWait = time.Second
+
 // возвращает следующую задачу для агента
 func (s *QueueService) GetNextTask(ctx context.Context, agentID string) (*models.CheckTask, error) {
-	s.logger.Debug("getting next task for agent", "agent_id", agentID)
+	return s.WaitNextTask(ctx, agentID, pollWait)
+}
+
+// WaitNextTask ждет задачу в очереди не дольше wait (но не дольше TaskTimeout),
+// nil без ошибки означает, что задач нет
+func (s *QueueService) WaitNextTask(ctx context.Context, agentID string, wait time.Duration) (*models.CheckTask, error) {
+	s.logger.Debug("getting next task for agent", "agent_id", agentID, "wait", wait)
+
+	if wait <= 0 || wait > s.timeout {
+		wait = s.timeout
+	}
 
 	// Проверяем существование агента
 	agent, err := s.agentStore.GetByID(ctx, agentID)
@@ -91,143 +132,171 @@ func (s *QueueService) GetNextTask(ctx context.Context, agentID string) (*models
 		return nil, fmt.Errorf("agent is not online: %s", agentID)
 	}
 
-	taskData, err := s.queue.PopTask(ctx, "check_tasks", s.timeout)
-	if err != nil {
-		s.logger.Error("failed to pop task from queue",
-			"error", err,
-			"agent_id", agentID,
-		)
-		return nil, fmt.Errorf("failed to get task from queue: %w", err)
-	}
-
-	if taskData == nil {
-		s.logger.Debug("no tasks available in queue", "agent_id", agentID)
-		return nil, nil
-	}
-
-	s.logger.Debug("Raw task data from Redis",
-		"raw_data", string(taskData),
-		"agent_id", agentID,
-	)
+	// Сначала своя очередь агента, затем общие очереди его возможностей
+	queues := taskQueues(agent)
+	ownQueue := queues[0]
+	waitUntil := time.Now().Add(wait)
 
-	// ИСПРАВЛЕНИЕ: Декодируем base64 из JSON строки
-	var taskJson []byte
+	for {
+		remaining := time.Until(waitUntil)
+		if remaining <= 0 {
+			return nil, nil
+		}
 
-	// Данные приходят как: "\"eyJjaGVja...\""
-	rawString := string(taskData)
+		queue, taskData, err := s.queue.PopTask(ctx, queues, remaining)
+		if err != nil {
+			s.logger.Error("failed to pop task from queue",
+				"error", err,
+				"agent_id", agentID,
+			)
+			return nil, fmt.Errorf("failed to get task from queue: %w", err)
+		}
 
-	// Проверяем если это JSON строка с кавычками
-	if len(rawString) >= 2 && rawString[0] == '"' && rawString[len(rawString)-1] == '"' {
-		// Убираем внешние кавычки
-		base64String := rawString[1 : len(rawString)-1]
+		if taskData == nil {
+			s.logger.Debug("no tasks available in queue", "agent_id", agentID)
+			return nil, nil
+		}
 
-		s.logger.Debug("Decoding base64 string",
-			"base64_string", base64String,
+		s.logger.Debug("Raw task data from Redis",
+			"raw_data", string(taskData),
+			"queue", queue,
 			"agent_id", agentID,
 		)
 
-		// Декодируем base64
-		decoded, err := base64.StdEncoding.DecodeString(base64String)
+		// Битую задачу не выполнит ни один агент, а возвращенная в очередь она
+		// заблокировала бы ее, поэтому ее отбрасываем
+		taskJson, err := decodeQueuedTask(taskData)
 		if err != nil {
-			s.logger.Error("Failed to decode base64 task data",
+			s.logger.Error("dropping undecodable task",
 				"error", err,
 				"agent_id", agentID,
-				"base64_string", base64String,
+				"task_data", string(taskData),
 			)
-			return nil, fmt.Errorf("failed to decode base64 task: %w", err)
+			continue
 		}
 
-		taskJson = decoded
-		s.logger.Info("Successfully decoded base64 task data",
-			"agent_id", agentID,
-			"decoded_data", string(taskJson),
-		)
-	} else {
-		// Если не в кавычках, используем как есть
-		taskJson = taskData
-		s.logger.Debug("Using raw task data (not base64 encoded)",
-			"agent_id", agentID,
-		)
-	}
+		// Парсим задачу из JSON
+		var task models.CheckTask
+		if err := json.Unmarshal(taskJson, &task); err != nil {
+			s.logger.Error("dropping malformed task",
+				"error", err,
+				"agent_id", agentID,
+				"task_data", string(taskJson),
+			)
+			continue
+		}
 
-	// Парсим задачу из JSON
-	var task models.CheckTask
-	if err := json.Unmarshal(taskJson, &task); err != nil {
-		s.logger.Error("failed to unmarshal task data",
-			"error", err,
+		s.logger.Info("Task successfully parsed",
 			"agent_id", agentID,
-			"task_data", string(taskJson),
-		)
-		return nil, fmt.Errorf("failed to unmarshal task: %w", err)
-	}
-
-	s.logger.Info("Task successfully parsed",
-		"agent_id", agentID,
-		"check_id", task.CheckID,
-		"type", task.Type,
-		"target", task.Target,
-	)
-
-	// Проверяем существование проверки
-	check, err := s.checkStore.GetByID(ctx, task.CheckID)
-	if err != nil {
-		s.logger.Error("failed to get check for task",
-			"error", err,
 			"check_id", task.CheckID,
-			"agent_id", agentID,
+			"type", task.Type,
+			"target", task.Target,
+			"queue", queue,
 		)
-		return nil, fmt.Errorf("failed to get check: %w", err)
-	}
 
-	if check == nil {
-		s.logger.Warn("check not found for task",
-			"check_id", task.CheckID,
-			"agent_id", agentID,
-		)
-		return nil, fmt.Errorf("check not found: %s", task.CheckID)
-	}
+		// Проверяем существование проверки
+		check, err := s.checkStore.GetByID(ctx, task.CheckID)
+		if err != nil {
+			s.logger.Error("failed to get check for task",
+				"error", err,
+				"check_id", task.CheckID,
+				"agent_id", agentID,
+			)
+			s.returnPoppedTask(queue, taskJson, agentID)
+			return nil, fmt.Errorf("failed to get check: %w", err)
+		}
 
-	// Проверяем что агент поддерживает тип проверки
-	if !s.agentSupportsCheckType(agent, task.Type) {
-		s.logger.Warn("agent does not support check type",
-			"agent_id", agentID,
-			"check_type", task.Type,
-			"agent_capabilities", agent.Capabilities,
-		)
+		// Проверку могли удалить, пока задача ждала в очереди
+		if check == nil {
+			s.logger.Warn("dropping task of missing check",
+				"check_id", task.CheckID,
+				"agent_id", agentID,
+			)
+			continue
+		}
 
-		// Возвращаем задачу обратно в очередь
-		if err := s.requeueTask(ctx, taskData); err != nil {
-			s.logger.Error("failed to requeue unsupported task",
-				"error", err,
+		// Задачи отмененной проверки могли остаться в очереди после отмены
+		if check.Status == models.CheckStatusCancelled {
+			s.logger.Debug("dropping task of cancelled check",
 				"check_id", task.CheckID,
 				"agent_id", agentID,
 			)
+			continue
 		}
 
-		return nil, fmt.Errorf("agent does not support check type: %s", task.Type)
-	}
+		// Задача из общей очереди могла пережить свою проверку
+		if queue != ownQueue && (check.Status == models.CheckStatusCompleted || check.Status == models.CheckStatusFailed) {
+			s.logger.Debug("dropping task of finished check",
+				"check_id", task.CheckID,
+				"status", check.Status,
+			)
+			continue
+		}
 
-	// Обновляем статус проверки на "выполняется"
-	if check.Status == models.CheckStatusPending {
-		if err := s.checkStore.UpdateStatus(ctx, task.CheckID, models.CheckStatusRunning); err != nil {
-			s.logger.Warn("failed to update check status to running",
+		// Общие очереди агент слушает только по своим возможностям, а своя
+		// очередь могла получить задачу до того, как агент их потерял
+		if queue == ownQueue && (!agentSupports(agent, task.Type) || !agentReachesTarget(agent, task.Target)) {
+			s.logger.Warn("agent no longer supports queued task",
+				"agent_id", agentID,
+				"check_type", task.Type,
+				"target", task.Target,
+				"agent_capabilities", agent.Capabilities,
+			)
+
+			if err := s.handOverTask(ctx, check, &task, taskJson, agentID); err != nil {
+				s.logger.Error("failed to hand over unsupported task",
+					"error", err,
+					"check_id", task.CheckID,
+					"agent_id", agentID,
+				)
+				s.returnPoppedTask(queue, taskJson, agentID)
+				return nil, fmt.Errorf("failed to hand over task: %w", err)
+			}
+			continue
+		}
+
+		// Обновляем статус проверки на "выполняется"
+		if check.Status == models.CheckStatusPending {
+			if err := s.checkStore.UpdateStatus(ctx, task.CheckID, models.CheckStatusRunning); err != nil {
+				s.logger.Warn("failed to update check status to running",
+					"error", err,
+					"check_id", task.CheckID,
+					"agent_id", agentID,
+				)
+				// Не прерываем выполнение, это не критическая ошибка
+			}
+		}
+
+		// Фиксируем выдачу, без нее ack и повторная выдача невозможны
+		if err := s.createAssignment(ctx, agentID, &task); err != nil {
+			s.logger.Error("failed to record task assignment",
 				"error", err,
 				"check_id", task.CheckID,
 				"agent_id", agentID,
 			)
-			// Не прерываем выполнение, это не критическая ошибка
+
+			s.returnPoppedTask(queue, taskJson, agentID)
+			return nil, fmt.Errorf("failed to assign task: %w", err)
 		}
-	}
 
-	s.logger.Info("task assigned to agent",
-		"agent_id", agentID,
-		"agent_name", agent.Name,
-		"check_id", task.CheckID,
-		"check_type", task.Type,
-		"target", task.Target,
-	)
+		// Бюджет относительный: срок считает агент от начала выполнения,
+		// время в очереди и расхождение часов его не расходуют
+		if task.TimeoutMs <= 0 {
+			task.TimeoutMs = s.timeout.Milliseconds()
+		}
 
-	return &task, nil
+		s.logger.Info("task assigned to agent",
+			"agent_id", agentID,
+			"agent_name", agent.Name,
+			"check_id", task.CheckID,
+			"check_type", task.Type,
+			"target", task.Target,
+			"attempt", task.Attempt,
+			"queue", queue,
+		)
+
+		return &task, nil
+	}
 }
 
 // SubmitTaskResult обрабатывает результат выполнения задачи
@@ -265,6 +334,14 @@ func (s *QueueService) SubmitTaskResult(ctx context.Context, result *models.Chec
 		return fmt.Errorf("check not found: %s", result.CheckID)
 	}
 
+	if check.Status == models.CheckStatusCancelled {
+		s.logger.Info("result of cancelled check rejected",
+			"check_id", result.CheckID,
+			"agent_id", result.AgentID,
+		)
+		return ErrCheckCancelled
+	}
+
 	// Проверяем существование агента
 	agent, err := s.agentStore.GetByID(ctx, result.AgentID)
 	if err != nil {
@@ -285,7 +362,16 @@ func (s *QueueService) SubmitTaskResult(ctx context.Context, result *models.Chec
 	}
 
 	// Сохраняем результат
+	s.geo.LocateResult(result, check.Target)
 	if err := s.resultStore.Create(ctx, result); err != nil {
+		if errors.Is(err, storage.ErrDuplicateResult) {
+			s.logger.Info("duplicate task result ignored",
+				"check_id", result.CheckID,
+				"agent_id", result.AgentID,
+				"result_id", result.ResultID,
+			)
+			return err
+		}
 		s.logger.Error("failed to save task result",
 			"error", err,
 			"check_id", result.CheckID,
@@ -294,6 +380,25 @@ func (s *QueueService) SubmitTaskResult(ctx context.Context, result *models.Chec
 		return fmt.Errorf("failed to create result: %w", err)
 	}
 
+	// Закрываем выдачу, результат мог прийти и без нее (из спула агента)
+	err = s.agentTasksStore.UpdateTaskStatus(ctx, result.AgentID, result.CheckID,
+		[]string{models.AgentTaskAssigned, models.AgentTaskAcked, models.AgentTaskRequeued},
+		models.AgentTaskCompleted, "")
+	if err != nil && !errors.Is(err, storage.ErrAgentTaskNotFound) {
+		s.logger.Warn("failed to complete agent task",
+			"error", err,
+			"check_id", result.CheckID,
+			"agent_id", result.AgentID,
+		)
+	}
+
+	if err := s.updateCheckStatus(ctx, result.CheckID); err != nil {
+		s.logger.Warn("failed to update check status",
+			"error", err,
+			"check_id", result.CheckID,
+		)
+	}
+
 	// Публикуем уведомление о результате (для WebSocket)
 	if err := s.publishResultNotification(ctx, result); err != nil {
 		s.logger.Warn("failed to publish result notification",
@@ -317,22 +422,27 @@ func (s *QueueService) SubmitTaskResult(ctx context.Context, result *models.Chec
 func (s *QueueService) GetQueueStats(ctx context.Context) (*models.QueueStats, error) {
 	s.logger.Debug("getting comprehensive queue statistics")
 
-	// Получаем длину очереди
-	queueLength, err := s.queue.GetQueueLength(ctx, "check_tasks")
+	// Получаем онлайн агентов
+	agents, err := s.agentStore.ListOnline(ctx)
 	if err != nil {
-		s.logger.Error("failed to get queue length",
+		s.logger.Error("failed to get online agents for queue stats",
 			"error", err,
 		)
-		return nil, fmt.Errorf("failed to get queue length: %w", err)
+		return nil, fmt.Errorf("failed to get online agents: %w", err)
 	}
 
-	// Получаем онлайн агентов
-	agents, err := s.agentStore.ListOnline(ctx)
+	// Получаем глубину каждой очереди задач
+	queues, err := s.queueDepths(ctx, agents)
 	if err != nil {
-		s.logger.Error("failed to get online agents for queue stats",
+		s.logger.Error("failed to get queue length",
 			"error", err,
 		)
-		return nil, fmt.Errorf("failed to get online agents: %w", err)
+		return nil, fmt.Errorf("failed to get queue length: %w", err)
+	}
+
+	var queueLength int64
+	for _, depth := range queues {
+		queueLength += depth
 	}
 
 	// Получаем количество проверок по статусам
@@ -372,6 +482,7 @@ func (s *QueueService) GetQueueStats(ctx context.Context) (*models.QueueStats, e
 
 	stats := &models.QueueStats{
 		QueueLength:     queueLength,
+		Queues:          queues,
 		OnlineAgents:    len(agents),
 		ActiveTasks:     activeTasksCount,
 		PendingChecks:   pendingChecks,
@@ -384,6 +495,7 @@ func (s *QueueService) GetQueueStats(ctx context.Context) (*models.QueueStats, e
 
 	s.logger.Debug("comprehensive queue statistics calculated",
 		"queue_length", stats.QueueLength,
+		"queues", len(stats.Queues),
 		"online_agents", stats.OnlineAgents,
 		"active_tasks", stats.ActiveTasks,
 		"pending_checks", stats.PendingChecks,
@@ -396,79 +508,370 @@ func (s *QueueService) GetQueueStats(ctx context.Context) (*models.QueueStats, e
 	return stats, nil
 }
 
-// очищает зависшие задачи
-func (s *QueueService) CleanupStuckTasks(ctx context.Context, timeout time.Duration) (int, error) {
-	s.logger.Info("cleaning up stuck tasks", "timeout", timeout)
+// AckTask подтверждает получение задачи агентом
+func (s *QueueService) AckTask(ctx context.Context, agentID, checkID string) error {
+	err := s.agentTasksStore.UpdateTaskStatus(ctx, agentID, checkID,
+		[]string{models.AgentTaskAssigned, models.AgentTaskAcked},
+		models.AgentTaskAcked, "")
+	if err != nil {
+		if !errors.Is(err, storage.ErrAgentTaskNotFound) {
+			s.logger.Error("failed to ack task",
+				"error", err,
+				"check_id", checkID,
+				"agent_id", agentID,
+			)
+		}
+		return err
+	}
 
-	if timeout == 0 {
-		timeout = 10 * time.Minute // default timeout
+	s.logger.Debug("task acknowledged", "check_id", checkID, "agent_id", agentID)
+	return nil
+}
+
+// NackTask обрабатывает отказ агента от задачи. При retry задача возвращается
+// в очередь, пока не исчерпаны попытки, иначе выдача помечается как failed.
+// Возвращает true, если задача возвращена в очередь.
+func (s *QueueService) NackTask(ctx context.Context, agentID, checkID, reason string, retry bool) (bool, error) {
+	task, err := s.agentTasksStore.GetTask(ctx, agentID, checkID)
+	if err != nil {
+		s.logger.Error("failed to get agent task for nack",
+			"error", err,
+			"check_id", checkID,
+			"agent_id", agentID,
+		)
+		return false, err
 	}
 
+	if task == nil || (task.Status != models.AgentTaskAssigned && task.Status != models.AgentTaskAcked) {
+		return false, storage.ErrAgentTaskNotFound
+	}
+
+	// занятый агент отказывается от задач регулярно, это не ошибка
+	level := slog.LevelWarn
+	if reason == models.NackTypeBusy {
+		level = slog.LevelDebug
+	}
+
+	s.logger.Log(ctx, level, "task rejected by agent",
+		"check_id", checkID,
+		"agent_id", agentID,
+		"reason", reason,
+		"retry", retry,
+		"attempt", task.Attempt,
+	)
+
+	return s.releaseTask(ctx, task, reason, retry)
+}
+
+// ReleaseAgentTasks возвращает в очередь все незавершенные выдачи агента,
+// например при его штатной остановке. Возвращает число возвращенных задач.
+func (s *QueueService) ReleaseAgentTasks(ctx context.Context, agentID, reason string) (int, error) {
+	tasks, err := s.agentTasksStore.ListActiveByAgent(ctx, agentID)
+	if err != nil {
+		s.logger.Error("failed to list agent tasks for release",
+			"error", err,
+			"agent_id", agentID,
+		)
+		return 0, fmt.Errorf("failed to list agent tasks: %w", err)
+	}
+
+	requeuedCount := 0
+	for _, task := range tasks {
+		requeued, err := s.releaseTask(ctx, task, reason, true)
+		if err != nil {
+			// выдачу мог одновременно закрыть результат или reaper
+			if !errors.Is(err, storage.ErrAgentTaskNotFound) {
+				s.logger.Error("failed to release agent task",
+					"error", err,
+					"agent_id", agentID,
+					"check_id", task.CheckID,
+				)
+			}
+			continue
+		}
+		if requeued {
+			requeuedCount++
+		}
+	}
+
+	// Задачи, которые агент не успел взять, отдаем остальным агентам
+	moved, err := s.moveAgentQueue(ctx, agentID)
+	if err != nil {
+		s.logger.Error("failed to move queued agent tasks",
+			"error", err,
+			"agent_id", agentID,
+		)
+	}
+
+	if len(tasks) > 0 || moved > 0 {
+		s.logger.Info("agent tasks released",
+			"agent_id", agentID,
+			"reason", reason,
+			"total", len(tasks),
+			"requeued", requeuedCount,
+			"moved_from_queue", moved,
+		)
+	}
+
+	return requeuedCount, nil
+}
+
+// moveAgentQueue передает задачи из очереди ушедшего агента другим агентам
+func (s *QueueService) moveAgentQueue(ctx context.Context, agentID string) (int, error) {
+	queue := agentTaskQueue(agentID)
+
+	length, err := s.queue.GetQueueLength(ctx, queue)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get agent queue length: %w", err)
+	}
+
+	moved := 0
+	for i := int64(0); i < length; i++ {
+		_, taskData, err := s.queue.PopTask(ctx, []string{queue}, time.Second)
+		if err != nil {
+			return moved, fmt.Errorf("failed to pop agent task: %w", err)
+		}
+		if taskData == nil {
+			break
+		}
+
+		taskJson, err := decodeQueuedTask(taskData)
+		if err != nil {
+			return moved, err
+		}
+
+		var task models.CheckTask
+		if err := json.Unmarshal(taskJson, &task); err != nil {
+			s.logger.Warn("dropping malformed agent task", "error", err, "agent_id", agentID)
+			continue
+		}
+
+		check, err := s.checkStore.GetByID(ctx, task.CheckID)
+		if err != nil {
+			// не теряем задачу, вернем ее в очередь агента
+			if pushErr := s.queue.PushTask(ctx, queue, taskJson); pushErr != nil {
+				s.logger.Error("failed to return agent task", "error", pushErr, "check_id", task.CheckID)
+			}
+			return moved, fmt.Errorf("failed to get check: %w", err)
+		}
+		if check == nil || checkFinished(check) {
+			continue
+		}
+
+		if err := s.handOverTask(ctx, check, &task, taskJson, agentID); err != nil {
+			return moved, fmt.Errorf("failed to hand over task: %w", err)
+		}
+		moved++
+	}
+
+	return moved, nil
+}
+
+// MigrateLegacyQueue переносит задачи из общей очереди, оставшейся от версии
+// без очередей агентов, в очереди их типов: агенты слушают только типы,
+// которые умеют выполнять
+func (s *QueueService) MigrateLegacyQueue(ctx context.Context) (int, error) {
+	length, err := s.queue.GetQueueLength(ctx, legacyTaskQueue)
+	if err != nil || length == 0 {
+		return 0, err
+	}
+
+	moved := 0
+	for i := int64(0); i < length; i++ {
+		_, taskData, err := s.queue.PopTask(ctx, []string{legacyTaskQueue}, time.Second)
+		if err != nil {
+			return moved, fmt.Errorf("failed to pop legacy task: %w", err)
+		}
+		if taskData == nil {
+			break
+		}
+
+		taskJson, err := decodeQueuedTask(taskData)
+		if err != nil {
+			s.logger.Warn("dropping undecodable legacy task", "error", err)
+			continue
+		}
+
+		var task models.CheckTask
+		if err := json.Unmarshal(taskJson, &task); err != nil {
+			s.logger.Warn("dropping malformed legacy task", "error", err)
+			continue
+		}
+
+		if err := s.queue.PushTask(ctx, capabilityTaskQueue(task.Type, task.Target), taskJson); err != nil {
+			return moved, fmt.Errorf("failed to move legacy task: %w", err)
+		}
+		moved++
+	}
+
+	s.logger.Info("legacy task queue migrated", "moved", moved)
+	return moved, nil
+}
+
+// CancelCheckTasks снимает задачи отмененной проверки: удаляет их копии из
+// всех очередей и закрывает незавершенные выдачи. Возвращает число удаленных
+// из очередей задач и агентов, которые выполняют проверку прямо сейчас.
+func (s *QueueService) CancelCheckTasks(ctx context.Context, checkID string) (int, []string, error) {
+	queues, err := s.queue.ListQueues(ctx, taskQueuePrefix)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list task queues: %w", err)
+	}
+
+	removed := 0
+	for _, queue := range queues {
+		count, err := s.removeCheckTasks(ctx, queue, checkID)
+		removed += count
+		if err != nil {
+			return removed, nil, err
+		}
+	}
+
+	tasks, err := s.agentTasksStore.ListActiveByCheck(ctx, checkID)
+	if err != nil {
+		return removed, nil, fmt.Errorf("failed to list check tasks: %w", err)
+	}
+
+	agents := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		err := s.agentTasksStore.UpdateTaskStatus(ctx, task.AgentID, checkID,
+			[]string{models.AgentTaskAssigned, models.AgentTaskAcked},
+			models.AgentTaskCancelled, "check_cancelled")
+		if err != nil {
+			// выдачу мог одновременно закрыть результат
+			if !errors.Is(err, storage.ErrAgentTaskNotFound) {
+				s.logger.Error("failed to cancel agent task",
+					"error", err,
+					"check_id", checkID,
+					"agent_id", task.AgentID,
+				)
+			}
+			continue
+		}
+		agents = append(agents, task.AgentID)
+	}
+
+	s.logger.Info("check tasks cancelled",
+		"check_id", checkID,
+		"removed_from_queues", removed,
+		"in_flight", len(agents),
+	)
+
+	return removed, agents, nil
+}
+
+// CancelledTasks возвращает задачи агента, отмененные во время выполнения.
+// Агенты без канала узнают об отмене из ответа на heartbeat, поэтому отмена
+// повторяется, пока задача могла бы еще выполняться: не дольше StuckTaskTimeout.
+func (s *QueueService) CancelledTasks(ctx context.Context, agentID string) ([]string, error) {
+	checkIDs, err := s.agentTasksStore.ListCancelledByAgent(ctx, agentID, time.Now().Add(-s.stuckTaskTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cancelled tasks: %w", err)
+	}
+	return checkIDs, nil
+}
+
+// removeCheckTasks удаляет из очереди все задачи проверки
+func (s *QueueService) removeCheckTasks(ctx context.Context, queue, checkID string) (int, error) {
+	tasks, err := s.queue.GetTasks(ctx, queue)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read queue %s: %w", queue, err)
+	}
+
+	removed := 0
+	for _, taskData := range tasks {
+		taskJson, err := decodeQueuedTask(taskData)
+		if err != nil {
+			continue
+		}
+
+		var task models.CheckTask
+		if err := json.Unmarshal(taskJson, &task); err != nil || task.CheckID != checkID {
+			continue
+		}
+
+		// копии одной задачи одинаковы, LREM удаляет их все, повторы дают 0
+		count, err := s.queue.RemoveTask(ctx, queue, taskData)
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove task from %s: %w", queue, err)
+		}
+		removed += int(count)
+	}
+
+	return removed, nil
+}
+
+// RunLeaseReaper периодически возвращает в очередь задачи, которые агент
+// не подтвердил за AckTimeout или не выполнил за StuckTaskTimeout
+func (s *QueueService) RunLeaseReaper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = s.ackTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.CleanupStuckTasks(ctx); err != nil {
+				s.logger.Error("lease reaper failed", "error", err)
+			}
+		}
+	}
+}
+
+// очищает зависшие задачи
+func (s *QueueService) CleanupStuckTasks(ctx context.Context) (int, error) {
 	// Получаем список зависших задач
-	stuckTasks, err := s.agentTasksStore.GetStuckTasks(ctx, timeout)
+	stuckTasks, err := s.agentTasksStore.GetStuckTasks(ctx, s.ackTimeout, s.stuckTaskTimeout)
 	if err != nil {
 		s.logger.Error("failed to get stuck tasks",
 			"error", err,
-			"timeout", timeout,
+			"ack_timeout", s.ackTimeout,
+			"timeout", s.stuckTaskTimeout,
 		)
 		return 0, fmt.Errorf("failed to get stuck tasks: %w", err)
 	}
 
 	if len(stuckTasks) == 0 {
-		s.logger.Debug("no stuck tasks found", "timeout", timeout)
 		return 0, nil
 	}
 
 	cleanedCount := 0
 	for _, task := range stuckTasks {
+		reason := "ack_timeout"
+		if task.Status == models.AgentTaskAcked {
+			reason = "lease_expired"
+		}
+
 		s.logger.Warn("found stuck task",
 			"task_id", task.ID,
 			"agent_id", task.AgentID,
 			"check_id", task.CheckID,
+			"status", task.Status,
 			"taken_at", task.TakenAt,
 			"stuck_duration", time.Since(task.TakenAt),
 		)
 
-		// Возвращаем задачу в очередь
-		if err := s.requeueStuckTask(ctx, task); err != nil {
-			s.logger.Error("failed to requeue stuck task",
-				"error", err,
-				"task_id", task.ID,
-				"check_id", task.CheckID,
-			)
-			continue
-		}
-
-		// Удаляем запись о взятой задаче
-		if err := s.agentTasksStore.DeleteTask(ctx, task.AgentID, task.CheckID); err != nil {
-			s.logger.Error("failed to delete stuck task record",
-				"error", err,
-				"task_id", task.ID,
-				"agent_id", task.AgentID,
-			)
+		if _, err := s.releaseTask(ctx, task, reason, true); err != nil {
+			if !errors.Is(err, storage.ErrAgentTaskNotFound) {
+				s.logger.Error("failed to release stuck task",
+					"error", err,
+					"task_id", task.ID,
+					"check_id", task.CheckID,
+				)
+			}
 			continue
 		}
 
-		// Обновляем статус проверки если нужно
-		if err := s.handleStuckCheck(ctx, task.CheckID); err != nil {
-			s.logger.Warn("failed to handle stuck check status",
-				"error", err,
-				"check_id", task.CheckID,
-			)
-		}
-
 		cleanedCount++
-		s.logger.Info("stuck task cleaned up successfully",
-			"task_id", task.ID,
-			"agent_id", task.AgentID,
-			"check_id", task.CheckID,
-		)
 	}
 
 	s.logger.Info("stuck tasks cleanup completed",
 		"total_found", len(stuckTasks),
 		"cleaned_count", cleanedCount,
-		"timeout", timeout,
 	)
 
 	return cleanedCount, nil
@@ -510,19 +913,78 @@ func (s *QueueService) PublishTaskProgress(ctx context.Context, progress *models
 	return nil
 }
 
-// проверяет поддержку типа проверки агентом
-func (s *QueueService) agentSupportsCheckType(agent *models.Agent, checkType models.CheckType) bool {
+// Очереди задач в Redis. У каждого агента своя очередь, в нее кладется его
+// копия проверки. Общая очередь типа проверки хранит только задачи, которые
+// может взять любой агент с этой возможностью: задачи старых проверок без
+// выбранных агентов. IPv6 цели - в отдельной очереди, ее слушают только
+// агенты с IPv6.
+const taskQueuePrefix = "check_tasks"
+
+// общая очередь до разделения по агентам, ее задачи переносятся в очереди
+// типов при старте бэкенда
+const legacyTaskQueue = taskQueuePrefix
+
+func agentTaskQueue(agentID string) string {
+	return taskQueuePrefix + ":agent:" + agentID
+}
+
+func capabilityTaskQueue(checkType models.CheckType, target string) string {
+	queue := taskQueuePrefix + ":type:" + string(checkType)
+	if targetNeedsIPv6(target) {
+		queue += ":ipv6"
+	}
+	return queue
+}
+
+// taskQueues возвращает очереди, из которых агент берет задачи, в порядке
+// приоритета: своя, затем общие очереди его возможностей
+func taskQueues(agent *models.Agent) []string {
+	ipv6 := agent.Environment == nil || agent.Environment.IPv6
+
+	queues := []string{agentTaskQueue(agent.ID)}
 	for _, capability := range agent.Capabilities {
-		if string(checkType) == capability {
-			return true
+		queue := taskQueuePrefix + ":type:" + capability
+		queues = append(queues, queue)
+		if ipv6 {
+			queues = append(queues, queue+":ipv6")
 		}
 	}
-	return false
+	return queues
 }
 
-// возвращает задачу обратно в очередь
-func (s *QueueService) requeueTask(ctx context.Context, taskData []byte) error {
-	return s.queue.PushTask(ctx, "check_tasks", taskData)
+// decodeQueuedTask возвращает JSON задачи из очереди. Задачи, положенные
+// как JSON строка, приходят в кавычках и в base64.
+func decodeQueuedTask(taskData []byte) ([]byte, error) {
+	rawString := string(taskData)
+	if len(rawString) < 2 || rawString[0] != '"' || rawString[len(rawString)-1] != '"' {
+		return taskData, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(rawString[1 : len(rawString)-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 task: %w", err)
+	}
+	return decoded, nil
+}
+
+// agentReachesTarget отсекает IPv6 цели для агентов, у которых самопроверка
+// не нашла IPv6 маршрута. Агенты без сведений о сети считаются способными.
+func agentReachesTarget(agent *models.Agent, target string) bool {
+	return agent.Environment == nil || agent.Environment.IPv6 || !targetNeedsIPv6(target)
+}
+
+// targetNeedsIPv6 проверяет, задана ли цель IPv6 адресом
+func targetNeedsIPv6(target string) bool {
+	host := target
+	if parsed, err := url.Parse(target); err == nil && parsed.Host != "" {
+		host = parsed.Hostname()
+	} else if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
 }
 
 // публикует уведомление о результате
@@ -543,8 +1005,235 @@ func (s *QueueService) publishResultNotification(ctx context.Context, result *mo
 	return s.queue.Publish(ctx, "check_results", notificationData)
 }
 
-// обрабатывает статус проверки для зависшей задачи
-func (s *QueueService) handleStuckCheck(ctx context.Context, checkID string) error {
+// создает запись о выдаче задачи агенту
+func (s *QueueService) createAssignment(ctx context.Context, agentID string, task *models.CheckTask) error {
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task: %w", err)
+	}
+
+	var taskData map[string]interface{}
+	if err := json.Unmarshal(taskJSON, &taskData); err != nil {
+		return fmt.Errorf("failed to convert task data: %w", err)
+	}
+
+	return s.agentTasksStore.CreateTask(ctx, &models.AgentTask{
+		AgentID:  agentID,
+		CheckID:  task.CheckID,
+		TaskData: taskData,
+		TakenAt:  time.Now(),
+		Status:   models.AgentTaskAssigned,
+		Attempt:  task.Attempt,
+	})
+}
+
+// returnPoppedTask возвращает снятую, но не выданную задачу в ее очередь.
+// Ошибка могла быть вызвана отменой контекста запроса, поэтому задача
+// возвращается с context.Background(), иначе она была бы потеряна.
+func (s *QueueService) returnPoppedTask(queue string, taskJson []byte, agentID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.queue.PushTask(ctx, queue, taskJson); err != nil {
+		s.logger.Error("failed to return popped task",
+			"error", err,
+			"queue", queue,
+			"agent_id", agentID,
+			"task_data", string(taskJson),
+		)
+	}
+}
+
+// снимает выдачу с агента: возвращает задачу в очередь со следующей попыткой
+// или помечает выдачу как failed, в том числе когда выполнить задачу некому
+func (s *QueueService) releaseTask(ctx context.Context, task *models.AgentTask, reason string, retry bool) (bool, error) {
+	from := []string{models.AgentTaskAssigned, models.AgentTaskAcked}
+
+	// занятый агент не выполнял задачу, попытка не расходуется
+	attempt := task.Attempt + 1
+	if reason == models.NackTypeBusy {
+		attempt = task.Attempt
+	}
+
+	if retry && attempt < s.maxRetries {
+		// Сначала меняем статус, чтобы одновременный nack и reaper не вернули задачу дважды
+		if err := s.agentTasksStore.UpdateTaskStatus(ctx, task.AgentID, task.CheckID, from, models.AgentTaskRequeued, reason); err != nil {
+			return false, err
+		}
+
+		requeued, err := s.requeueAgentTask(ctx, task, attempt)
+		if err != nil {
+			return false, fmt.Errorf("failed to requeue task: %w", err)
+		}
+
+		if requeued {
+			level := slog.LevelInfo
+			if reason == models.NackTypeBusy {
+				level = slog.LevelDebug
+			}
+			s.logger.Log(ctx, level, "task requeued",
+				"check_id", task.CheckID,
+				"agent_id", task.AgentID,
+				"reason", reason,
+				"attempt", attempt,
+			)
+			return true, nil
+		}
+
+		from = []string{models.AgentTaskRequeued}
+		reason = "no_agent"
+	}
+
+	if err := s.agentTasksStore.UpdateTaskStatus(ctx, task.AgentID, task.CheckID, from, models.AgentTaskFailed, reason); err != nil {
+		return false, err
+	}
+
+	s.logger.Warn("task failed",
+		"check_id", task.CheckID,
+		"agent_id", task.AgentID,
+		"reason", reason,
+		"attempt", task.Attempt,
+	)
+
+	if err := s.updateCheckStatus(ctx, task.CheckID); err != nil {
+		s.logger.Warn("failed to update check status",
+			"error", err,
+			"check_id", task.CheckID,
+		)
+	}
+
+	return false, nil
+}
+
+// возвращает задачу из выдачи в очередь с номером попытки attempt, false -
+// задачу выполнить некому или ее проверка уже закончена
+func (s *QueueService) requeueAgentTask(ctx context.Context, task *models.AgentTask, attempt int) (bool, error) {
+	taskData, err := json.Marshal(task.TaskData)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal task data: %w", err)
+	}
+
+	var checkTask models.CheckTask
+	if err := json.Unmarshal(taskData, &checkTask); err != nil {
+		return false, fmt.Errorf("failed to unmarshal task data: %w", err)
+	}
+	checkTask.Attempt = attempt
+
+	taskData, err = json.Marshal(checkTask)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal task: %w", err)
+	}
+
+	check, err := s.checkStore.GetByID(ctx, task.CheckID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get check: %w", err)
+	}
+	if check == nil || checkFinished(check) {
+		return false, nil
+	}
+
+	queue, err := s.routeTask(ctx, check, &checkTask, task.AgentID)
+	if err != nil || queue == "" {
+		return false, err
+	}
+
+	return true, s.queue.PushTask(ctx, queue, taskData)
+}
+
+// routeTask выбирает очередь для задачи, снятой с агента fromAgent. Задача
+// остается у него, пока он онлайн и может ее выполнить. Иначе ее получает
+// замена: наименее загруженный онлайн агент, подходящий под селектор и еще
+// не выбранный для проверки, он занимает место ушедшего в target_agents.
+// Пустая строка означает, что выполнить задачу некому.
+func (s *QueueService) routeTask(ctx context.Context, check *models.Check, task *models.CheckTask, fromAgent string) (string, error) {
+	agent, err := s.agentStore.GetByID(ctx, fromAgent)
+	if err != nil {
+		return "", fmt.Errorf("failed to get agent: %w", err)
+	}
+	if agent != nil && agent.Status == models.AgentStatusOnline &&
+		agentSupports(agent, task.Type) && agentReachesTarget(agent, task.Target) {
+		return agentTaskQueue(fromAgent), nil
+	}
+
+	// задачу старой проверки без выбранных агентов может взять любой агент
+	if len(check.TargetAgents) == 0 {
+		return capabilityTaskQueue(task.Type, task.Target), nil
+	}
+
+	online, err := s.agentStore.ListOnline(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get online agents: %w", err)
+	}
+
+	var candidates []*models.Agent
+	for _, candidate := range online {
+		if candidate.ID == fromAgent || slices.Contains(check.TargetAgents, candidate.ID) {
+			continue
+		}
+		if agentSupports(candidate, task.Type) && agentReachesTarget(candidate, task.Target) && check.Agents.Matches(candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Load < candidates[j].Load
+	})
+
+	for _, candidate := range candidates {
+		// замену мог одновременно занять другой снятый агент
+		replaced, err := s.checkStore.ReplaceTargetAgent(ctx, check.ID, fromAgent, candidate.ID)
+		if err != nil {
+			return "", fmt.Errorf("failed to replace target agent: %w", err)
+		}
+		if replaced {
+			s.logger.Info("task handed over to another agent",
+				"check_id", check.ID,
+				"from_agent", fromAgent,
+				"to_agent", candidate.ID,
+			)
+			return agentTaskQueue(candidate.ID), nil
+		}
+	}
+
+	return "", nil
+}
+
+// handOverTask передает задачу из очереди агента fromAgent другому агенту, а
+// если выполнить ее некому, засчитывает ее как неудачную выдачу fromAgent,
+// чтобы проверка могла завершиться
+func (s *QueueService) handOverTask(ctx context.Context, check *models.Check, task *models.CheckTask, taskJson []byte, fromAgent string) error {
+	queue, err := s.routeTask(ctx, check, task, fromAgent)
+	if err != nil {
+		return err
+	}
+	if queue != "" {
+		return s.queue.PushTask(ctx, queue, taskJson)
+	}
+
+	s.logger.Warn("no agent can take the task",
+		"check_id", task.CheckID,
+		"agent_id", fromAgent,
+	)
+
+	if err := s.createAssignment(ctx, fromAgent, task); err != nil {
+		return fmt.Errorf("failed to record unrouted task: %w", err)
+	}
+	if err := s.agentTasksStore.UpdateTaskStatus(ctx, fromAgent, task.CheckID,
+		[]string{models.AgentTaskAssigned}, models.AgentTaskFailed, "no_agent"); err != nil {
+		return fmt.Errorf("failed to fail unrouted task: %w", err)
+	}
+	return s.updateCheckStatus(ctx, task.CheckID)
+}
+
+// checkFinished проверяет, что проверка больше не ждет результатов
+func checkFinished(check *models.Check) bool {
+	return check.Status == models.CheckStatusCompleted ||
+		check.Status == models.CheckStatusFailed ||
+		check.Status == models.CheckStatusCancelled
+}
+
+// обновляет статус проверки: завершена, когда все выбранные агенты прислали
+// результат или окончательно отказались, failed, если результатов нет
+func (s *QueueService) updateCheckStatus(ctx context.Context, checkID string) error {
 	check, err := s.checkStore.GetByID(ctx, checkID)
 	if err != nil {
 		return err
@@ -554,29 +1243,66 @@ func (s *QueueService) handleStuckCheck(ctx context.Context, checkID string) err
 		return fmt.Errorf("check not found: %s", checkID)
 	}
 
-	// Если проверка в статусе running и зависла, помечаем как failed
-	if check.Status == models.CheckStatusRunning {
-		return s.checkStore.UpdateStatus(ctx, checkID, models.CheckStatusFailed)
+	if checkFinished(check) {
+		return nil
 	}
 
-	return nil
+	results, err := s.resultStore.GetByCheckID(ctx, checkID)
+	if err != nil {
+		return err
+	}
+
+	counts, err := s.agentTasksStore.CountByCheck(ctx, checkID)
+	if err != nil {
+		return err
+	}
+
+	agents, err := expectedAgents(ctx, s.agentStore, check)
+	if err != nil {
+		return err
+	}
+
+	finished := len(results) + counts[models.AgentTaskFailed]
+	if finished < agents || counts[models.AgentTaskAssigned]+counts[models.AgentTaskAcked] > 0 {
+		return nil
+	}
+
+	status := models.CheckStatusCompleted
+	if len(results) == 0 {
+		status = models.CheckStatusFailed
+	}
+
+	return s.checkStore.UpdateStatus(ctx, checkID, status)
 }
 
-// возвращает зависшую задачу обратно в очередь
-func (s *QueueService) requeueStuckTask(ctx context.Context, task *models.AgentTask) error {
-	taskData, err := json.Marshal(task.TaskData)
+// queueDepths возвращает глубину очередей задач. Пустые очереди в Redis не
+// хранятся, поэтому очереди онлайн агентов добавляются явно.
+func (s *QueueService) queueDepths(ctx context.Context, agents []*models.Agent) (map[string]int64, error) {
+	names, err := s.queue.ListQueues(ctx, taskQueuePrefix)
 	if err != nil {
-		return fmt.Errorf("failed to marshal stuck task data: %w", err)
+		return nil, err
+	}
+	for _, agent := range agents {
+		names = append(names, agentTaskQueue(agent.ID))
 	}
 
-	return s.queue.PushTask(ctx, "check_tasks", taskData)
+	depths := make(map[string]int64, len(names))
+	for _, name := range names {
+		if _, ok := depths[name]; ok {
+			continue
+		}
+		depth, err := s.queue.GetQueueLength(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		depths[name] = depth
+	}
+	return depths, nil
 }
 
 // возвращает количество активных задач
 func (s *QueueService) getActiveTasksCount(ctx context.Context) (int, error) {
-	// В реальной реализации здесь был бы запрос к agent_tasks
-	// Пока возвращаем 0 как заглушку
-	return 0, nil
+	return s.agentTasksStore.CountActive(ctx)
 }
 
 // рассчитывает пропускную способность очереди
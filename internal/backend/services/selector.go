@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+
+	"NetScan/internal/backend/models"
+	"NetScan/internal/backend/storage"
+	"NetScan/pkg/uuidutil"
+)
+
+var (
+	// селектор агентов задан неверно
+	ErrInvalidSelector = errors.New("invalid agent selector")
+	// нет онлайн агентов, подходящих для проверки
+	ErrNoAgentsAvailable = errors.New("no online agents available")
+)
+
+// ограничение на count селектора
+const maxSelectorCount = 100
+
+func validateSelector(selector *models.AgentSelector) error {
+	if selector == nil {
+		return nil
+	}
+
+	for _, id := range selector.IDs {
+		if !uuidutil.IsValid(id) {
+			return fmt.Errorf("%w: agent id %q is not a uuid", ErrInvalidSelector, id)
+		}
+	}
+	for _, location := range selector.Locations {
+		if strings.TrimSpace(location) == "" {
+			return fmt.Errorf("%w: empty location", ErrInvalidSelector)
+		}
+	}
+	for name := range selector.Labels {
+		if name == "" {
+			return fmt.Errorf("%w: empty label name", ErrInvalidSelector)
+		}
+	}
+	if selector.Count < 0 || selector.Count > maxSelectorCount {
+		return fmt.Errorf("%w: count must be between 0 and %d", ErrInvalidSelector, maxSelectorCount)
+	}
+
+	switch selector.Fallback {
+	case "", models.SelectorFallbackPartial, models.SelectorFallbackAny, models.SelectorFallbackFail:
+	default:
+		return fmt.Errorf("%w: fallback must be one of %s, %s, %s", ErrInvalidSelector,
+			models.SelectorFallbackPartial, models.SelectorFallbackAny, models.SelectorFallbackFail)
+	}
+
+	return nil
+}
+
+// selectAgents выбирает из онлайн агентов исполнителей проверки. Агенты, не
+// умеющие тип проверки или не достающие до цели, не рассматриваются вовсе.
+// Если подходящих меньше, чем нужно селектору, действует его fallback.
+func selectAgents(online []*models.Agent, check *models.Check) ([]*models.Agent, error) {
+	selector := check.Agents
+
+	eligible := make([]*models.Agent, 0, len(online))
+	for _, agent := range online {
+		if agentSupports(agent, check.Type) && agentReachesTarget(agent, check.Target) {
+			eligible = append(eligible, agent)
+		}
+	}
+
+	if selector == nil {
+		if len(eligible) == 0 {
+			return nil, ErrNoAgentsAvailable
+		}
+		return eligible, nil
+	}
+
+	matching := make([]*models.Agent, 0, len(eligible))
+	for _, agent := range eligible {
+		if selector.Matches(agent) {
+			matching = append(matching, agent)
+		}
+	}
+
+	rand.Shuffle(len(matching), func(i, j int) {
+		matching[i], matching[j] = matching[j], matching[i]
+	})
+
+	selected := matching
+	if selector.OnePerRegion {
+		selected = onePerRegion(matching)
+	}
+	if selector.Count > 0 && len(selected) > selector.Count {
+		selected = selected[:selector.Count]
+	}
+
+	wanted := selector.Wanted()
+	if len(selected) < wanted {
+		switch selector.Fallback {
+		case models.SelectorFallbackFail:
+			return nil, fmt.Errorf("%w: %d of %d requested agents match the selector", ErrNoAgentsAvailable, len(selected), wanted)
+		case models.SelectorFallbackAny:
+			selected = topUp(selected, eligible, wanted)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: no online agent matches the selector", ErrNoAgentsAvailable)
+	}
+	return selected, nil
+}
+
+// onePerRegion оставляет по наименее загруженному агенту из каждого региона,
+// агенты уже перемешаны, так что равные по загрузке выбираются случайно
+func onePerRegion(agents []*models.Agent) []*models.Agent {
+	best := make(map[string]*models.Agent)
+	var regions []string
+	for _, agent := range agents {
+		region := strings.ToLower(agent.Region())
+		current, ok := best[region]
+		if !ok {
+			regions = append(regions, region)
+		}
+		if !ok || agent.Load < current.Load {
+			best[region] = agent
+		}
+	}
+
+	selected := make([]*models.Agent, 0, len(regions))
+	for _, region := range regions {
+		selected = append(selected, best[region])
+	}
+	return selected
+}
+
+// topUp добавляет к выбранным случайных агентов из остальных пригодных,
+// пока их не станет wanted. Агенты из новых регионов идут первыми.
+func topUp(selected, eligible []*models.Agent, wanted int) []*models.Agent {
+	chosen := make(map[string]bool, len(selected))
+	regions := make(map[string]bool, len(selected))
+	for _, agent := range selected {
+		chosen[agent.ID] = true
+		regions[strings.ToLower(agent.Region())] = true
+	}
+
+	var rest []*models.Agent
+	for _, agent := range eligible {
+		if !chosen[agent.ID] {
+			rest = append(rest, agent)
+		}
+	}
+	rand.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+	sort.SliceStable(rest, func(i, j int) bool {
+		return !regions[strings.ToLower(rest[i].Region())] && regions[strings.ToLower(rest[j].Region())]
+	})
+
+	result := append([]*models.Agent(nil), selected...)
+	for _, agent := range rest {
+		if len(result) >= wanted {
+			break
+		}
+		result = append(result, agent)
+	}
+	return result
+}
+
+// expectedAgents возвращает, сколько агентов должны ответить по проверке:
+// выбранные при создании, а для проверок без них - все онлайн агенты
+func expectedAgents(ctx context.Context, agentStore storage.AgentStore, check *models.Check) (int, error) {
+	if len(check.TargetAgents) > 0 {
+		return len(check.TargetAgents), nil
+	}
+
+	agents, err := agentStore.ListOnline(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(agents), nil
+}
+
+// проверяет поддержку типа проверки агентом
+func agentSupports(agent *models.Agent, checkType models.CheckType) bool {
+	for _, capability := range agent.Capabilities {
+		if capability == string(checkType) {
+			return true
+		}
+	}
+	return false
+}
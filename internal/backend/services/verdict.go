@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"NetScan/internal/backend/models"
+)
+
+// правила вердикта заданы неверно
+var ErrInvalidConsensusRules = errors.New("invalid consensus rules")
+
+// ConsensusRules задает, как результаты агентов сводятся к вердикту
+type ConsensusRules struct {
+	// минимум ответивших агентов, меньше - unknown
+	Quorum int
+	// доля неудач, начиная с которой цель считается недоступной
+	DownRatio float64
+	// доля неудач, до которой цель считается деградировавшей, а не частично недоступной
+	DegradedRatio float64
+	// медианное время ответа, выше которого успешная проверка деградировала, 0 - не учитывается
+	SlowThreshold time.Duration
+}
+
+// DefaultConsensusRules правила по умолчанию: недоступна у 80% агентов - down,
+// до 20% неудач - degraded
+func DefaultConsensusRules() ConsensusRules {
+	return ConsensusRules{
+		Quorum:        1,
+		DownRatio:     0.8,
+		DegradedRatio: 0.2,
+	}
+}
+
+// Validate проверяет согласованность правил
+func (r ConsensusRules) Validate() error {
+	if r.Quorum < 1 {
+		return fmt.Errorf("%w: quorum must be at least 1", ErrInvalidConsensusRules)
+	}
+	if r.DownRatio <= 0 || r.DownRatio > 1 {
+		return fmt.Errorf("%w: down_ratio must be in (0, 1]", ErrInvalidConsensusRules)
+	}
+	if r.DegradedRatio < 0 || r.DegradedRatio >= r.DownRatio {
+		return fmt.Errorf("%w: degraded_ratio must be in [0, down_ratio)", ErrInvalidConsensusRules)
+	}
+	if r.SlowThreshold < 0 {
+		return fmt.Errorf("%w: slow_threshold must not be negative", ErrInvalidConsensusRules)
+	}
+	return nil
+}
+
+// checkVerdict сводит результаты проверки к вердикту, регионы берутся у
+// агентов, приславших результаты
+func (s *CheckService) checkVerdict(ctx context.Context, check *models.Check, results []*models.CheckResult) (*models.CheckVerdict, error) {
+	latest := latestResults(results)
+
+	regions := make(map[string]string, len(latest))
+	for _, result := range latest {
+		agent, err := s.agentStore.GetByID(ctx, result.AgentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get agent %s: %w", result.AgentID, err)
+		}
+		if agent != nil {
+			regions[result.AgentID] = agent.Region()
+		}
+	}
+
+	final := check.Status == models.CheckStatusCompleted ||
+		check.Status == models.CheckStatusFailed ||
+		check.Status == models.CheckStatusCancelled
+	return computeVerdict(latest, regions, final, s.consensus), nil
+}
+
+// latestResults оставляет последний результат каждого агента: повторная
+// выдача задачи может дать агенту несколько результатов
+func latestResults(results []*models.CheckResult) []*models.CheckResult {
+	byAgent := make(map[string]*models.CheckResult, len(results))
+	for _, result := range results {
+		current, ok := byAgent[result.AgentID]
+		if !ok || result.CreatedAt.After(current.CreatedAt) {
+			byAgent[result.AgentID] = result
+		}
+	}
+
+	latest := make([]*models.CheckResult, 0, len(byAgent))
+	for _, result := range byAgent {
+		latest = append(latest, result)
+	}
+	sort.Slice(latest, func(i, j int) bool {
+		return latest[i].AgentID < latest[j].AgentID
+	})
+	return latest
+}
+
+func computeVerdict(results []*models.CheckResult, regions map[string]string, final bool, rules ConsensusRules) *models.CheckVerdict {
+	verdict := &models.CheckVerdict{
+		Final:         final,
+		Results:       len(results),
+		FailedRegions: []string{},
+	}
+
+	regionTotal := make(map[string]int)
+	regionFailed := make(map[string]int)
+	codes := make(map[string]int)
+	durations := make([]float64, 0, len(results))
+
+	for _, result := range results {
+		region := regions[result.AgentID]
+		if region == "" {
+			region = "unknown"
+		}
+		regionTotal[region]++
+
+		if result.Success {
+			verdict.Successful++
+			durations = append(durations, result.Duration)
+			continue
+		}
+
+		verdict.Failed++
+		regionFailed[region]++
+		codes[failureCode(result)]++
+	}
+
+	for region, failed := range regionFailed {
+		if failed == regionTotal[region] {
+			verdict.FailedRegions = append(verdict.FailedRegions, region)
+		}
+	}
+	sort.Strings(verdict.FailedRegions)
+
+	// при равенстве частот выбирается первый по алфавиту код
+	for code, count := range codes {
+		if count > codes[verdict.DominantError] || (count == codes[verdict.DominantError] && code < verdict.DominantError) {
+			verdict.DominantError = code
+		}
+	}
+
+	if len(durations) > 0 {
+		sort.Float64s(durations)
+		middle := len(durations) / 2
+		verdict.MedianTime = durations[middle]
+		if len(durations)%2 == 0 {
+			verdict.MedianTime = (durations[middle-1] + durations[middle]) / 2
+		}
+	}
+
+	if verdict.Results == 0 || verdict.Results < rules.Quorum {
+		verdict.Verdict = models.VerdictUnknown
+		return verdict
+	}
+
+	failedRatio := float64(verdict.Failed) / float64(verdict.Results)
+	slow := rules.SlowThreshold > 0 && verdict.MedianTime > rules.SlowThreshold.Seconds()
+
+	switch {
+	case verdict.Failed == 0 && !slow:
+		verdict.Verdict = models.VerdictUp
+	case failedRatio >= rules.DownRatio:
+		verdict.Verdict = models.VerdictDown
+	case failedRatio <= rules.DegradedRatio:
+		verdict.Verdict = models.VerdictDegraded
+	default:
+		verdict.Verdict = models.VerdictPartial
+	}
+
+	return verdict
+}
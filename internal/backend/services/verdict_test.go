@@ -0,0 +1,203 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"NetScan/internal/backend/models"
+)
+
+func verdictResult(agentID string, success bool, duration float64, code string) *models.CheckResult {
+	result := &models.CheckResult{
+		AgentID:  agentID,
+		Success:  success,
+		Duration: duration,
+	}
+	if code != "" {
+		result.Failure = &models.ResultFailure{Code: code}
+	}
+	return result
+}
+
+// results возвращает total результатов, из них failed первых неудачных
+func verdictResults(total, failed int) []*models.CheckResult {
+	results := make([]*models.CheckResult, 0, total)
+	for i := 0; i < total; i++ {
+		if i < failed {
+			results = append(results, verdictResult(fmt.Sprintf("agent-%02d", i), false, 0, "connection_refused"))
+		} else {
+			results = append(results, verdictResult(fmt.Sprintf("agent-%02d", i), true, 0.1, ""))
+		}
+	}
+	return results
+}
+
+func TestComputeVerdict(t *testing.T) {
+	defaults := DefaultConsensusRules()
+
+	slowRules := defaults
+	slowRules.SlowThreshold = time.Second
+
+	quorumRules := defaults
+	quorumRules.Quorum = 3
+
+	tests := []struct {
+		name    string
+		results []*models.CheckResult
+		rules   ConsensusRules
+		verdict string
+	}{
+		{"no results", nil, defaults, models.VerdictUnknown},
+		{"below quorum", verdictResults(2, 0), quorumRules, models.VerdictUnknown},
+		{"quorum reached", verdictResults(3, 0), quorumRules, models.VerdictUp},
+		{"all successful", verdictResults(10, 0), defaults, models.VerdictUp},
+		{"few failures", verdictResults(10, 1), defaults, models.VerdictDegraded},
+		{"degraded ratio is inclusive", verdictResults(10, 2), defaults, models.VerdictDegraded},
+		{"half failed", verdictResults(10, 5), defaults, models.VerdictPartial},
+		{"down ratio is inclusive", verdictResults(10, 8), defaults, models.VerdictDown},
+		{"all failed", verdictResults(10, 10), defaults, models.VerdictDown},
+		{
+			"slow median",
+			[]*models.CheckResult{
+				verdictResult("a", true, 2, ""),
+				verdictResult("b", true, 3, ""),
+			},
+			slowRules,
+			models.VerdictDegraded,
+		},
+		{
+			"fast median",
+			[]*models.CheckResult{
+				verdictResult("a", true, 0.2, ""),
+				verdictResult("b", true, 3, ""),
+				verdictResult("c", true, 0.4, ""),
+			},
+			slowRules,
+			models.VerdictUp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := computeVerdict(tt.results, nil, false, tt.rules)
+			if verdict.Verdict != tt.verdict {
+				t.Errorf("verdict = %q, want %q", verdict.Verdict, tt.verdict)
+			}
+			if verdict.Successful+verdict.Failed != len(tt.results) {
+				t.Errorf("successful %d + failed %d != %d results", verdict.Successful, verdict.Failed, len(tt.results))
+			}
+		})
+	}
+}
+
+func TestComputeVerdictMedianTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []float64
+		want      float64
+	}{
+		{"odd count", []float64{3, 1, 2}, 2},
+		{"even count", []float64{4, 1, 3, 2}, 2.5},
+		{"single", []float64{0.25}, 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results []*models.CheckResult
+			for i, duration := range tt.durations {
+				results = append(results, verdictResult(fmt.Sprint(i), true, duration, ""))
+			}
+			// неудачные результаты в медиану не входят
+			results = append(results, verdictResult("failed", false, 100, "timeout"))
+
+			verdict := computeVerdict(results, nil, false, DefaultConsensusRules())
+			if verdict.MedianTime != tt.want {
+				t.Errorf("median time = %v, want %v", verdict.MedianTime, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeVerdictRegionsAndErrors(t *testing.T) {
+	results := []*models.CheckResult{
+		verdictResult("eu-1", false, 0, "dns_nxdomain"),
+		verdictResult("eu-2", false, 0, "connection_refused"),
+		verdictResult("us-1", false, 0, "connection_refused"),
+		verdictResult("us-2", true, 0.1, ""),
+		verdictResult("asia-1", false, 0, "dns_nxdomain"),
+		verdictResult("nowhere", false, 0, ""),
+	}
+	regions := map[string]string{
+		"eu-1":   "eu",
+		"eu-2":   "eu",
+		"us-1":   "us",
+		"us-2":   "us",
+		"asia-1": "asia",
+	}
+
+	verdict := computeVerdict(results, regions, true, DefaultConsensusRules())
+
+	// агент без региона попадает в unknown
+	wantRegions := []string{"asia", "eu", "unknown"}
+	if !reflect.DeepEqual(verdict.FailedRegions, wantRegions) {
+		t.Errorf("failed regions = %v, want %v", verdict.FailedRegions, wantRegions)
+	}
+	// при равенстве частот выбирается первый по алфавиту код
+	if verdict.DominantError != "connection_refused" {
+		t.Errorf("dominant error = %q, want connection_refused", verdict.DominantError)
+	}
+	if !verdict.Final {
+		t.Error("verdict is not final")
+	}
+}
+
+func TestLatestResults(t *testing.T) {
+	now := time.Now()
+	results := []*models.CheckResult{
+		{ID: "b-old", AgentID: "b", CreatedAt: now.Add(-time.Minute)},
+		{ID: "a", AgentID: "a", CreatedAt: now},
+		{ID: "b-new", AgentID: "b", CreatedAt: now},
+		{ID: "b-older", AgentID: "b", CreatedAt: now.Add(-time.Hour)},
+	}
+
+	var ids []string
+	for _, result := range latestResults(results) {
+		ids = append(ids, result.ID)
+	}
+
+	want := []string{"a", "b-new"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("latest results = %v, want %v", ids, want)
+	}
+}
+
+func TestConsensusRulesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules ConsensusRules
+		valid bool
+	}{
+		{"defaults", DefaultConsensusRules(), true},
+		{"zero quorum", ConsensusRules{Quorum: 0, DownRatio: 0.8, DegradedRatio: 0.2}, false},
+		{"down ratio above one", ConsensusRules{Quorum: 1, DownRatio: 1.1, DegradedRatio: 0.2}, false},
+		{"zero down ratio", ConsensusRules{Quorum: 1, DownRatio: 0, DegradedRatio: 0}, false},
+		{"degraded not below down", ConsensusRules{Quorum: 1, DownRatio: 0.5, DegradedRatio: 0.5}, false},
+		{"negative slow threshold", ConsensusRules{Quorum: 1, DownRatio: 0.8, DegradedRatio: 0.2, SlowThreshold: -time.Second}, false},
+		{"zero degraded ratio", ConsensusRules{Quorum: 2, DownRatio: 1, DegradedRatio: 0}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidConsensusRules) {
+				t.Errorf("error = %v, want ErrInvalidConsensusRules", err)
+			}
+		})
+	}
+}
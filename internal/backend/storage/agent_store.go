@@ -4,6 +4,7 @@ import (
 	"NetScan/internal/backend/models"
 	"NetScan/pkg/uuidutil"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -31,19 +32,38 @@ func (s *agentStore) Create(ctx context.Context, agent *models.Agent) error {
 	agent.CreatedAt = time.Now()
 	agent.Status = models.AgentStatusOffline
 
+	metadataJSON, err := marshalNullable(agent.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent metadata: %w", err)
+	}
+
+	geoJSON, err := marshalNullable(agent.Geo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent geo: %w", err)
+	}
+
+	labelsJSON, err := marshalLabels(agent.Labels)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO agents (id, name, token, location, status, capabilities, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO agents (id, name, token, location, status, capabilities, metadata, fingerprint, created_at, geo, labels)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11)
 	`
 
-	_, err := s.pool.Exec(ctx, query,
+	_, err = s.pool.Exec(ctx, query,
 		agent.ID,
 		agent.Name,
 		agent.Token,
 		agent.Location,
 		agent.Status,
 		agent.Capabilities,
+		metadataJSON,
+		agent.Fingerprint,
 		agent.CreatedAt,
+		geoJSON,
+		labelsJSON,
 	)
 
 	if err != nil {
@@ -55,13 +75,15 @@ func (s *agentStore) Create(ctx context.Context, agent *models.Agent) error {
 
 func (s *agentStore) GetByToken(ctx context.Context, token string) (*models.Agent, error) {
 	query := `
-		SELECT id, name, token, location, status, capabilities, last_heartbeat, created_at
+		SELECT id, name, token, location, status, capabilities, last_heartbeat, created_at,
+			load, active_jobs, max_jobs, spool_depth, metadata, system_load, environment, geo, labels
 		FROM agents 
 		WHERE token = $1
 	`
 
 	var agent models.Agent
 	var lastHeartbeat *time.Time
+	var metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON []byte
 
 	err := s.pool.QueryRow(ctx, query, token).Scan(
 		&agent.ID,
@@ -72,6 +94,15 @@ func (s *agentStore) GetByToken(ctx context.Context, token string) (*models.Agen
 		&agent.Capabilities,
 		&lastHeartbeat,
 		&agent.CreatedAt,
+		&agent.Load,
+		&agent.ActiveJobs,
+		&agent.MaxJobs,
+		&agent.SpoolDepth,
+		&metadataJSON,
+		&systemLoadJSON,
+		&environmentJSON,
+		&geoJSON,
+		&labelsJSON,
 	)
 
 	if err != nil {
@@ -85,18 +116,24 @@ func (s *agentStore) GetByToken(ctx context.Context, token string) (*models.Agen
 		agent.LastHeartbeat = *lastHeartbeat
 	}
 
+	if err := unmarshalHostInfo(&agent, metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON); err != nil {
+		return nil, err
+	}
+
 	return &agent, nil
 }
 
 func (s *agentStore) GetByID(ctx context.Context, id string) (*models.Agent, error) {
 	query := `
-		SELECT id, name, token, location, status, capabilities, last_heartbeat, created_at
+		SELECT id, name, token, location, status, capabilities, last_heartbeat, created_at,
+			load, active_jobs, max_jobs, spool_depth, metadata, system_load, environment, geo, labels
 		FROM agents 
 		WHERE id = $1
 	`
 
 	var agent models.Agent
 	var lastHeartbeat *time.Time
+	var metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON []byte
 
 	err := s.pool.QueryRow(ctx, query, id).Scan(
 		&agent.ID,
@@ -107,6 +144,15 @@ func (s *agentStore) GetByID(ctx context.Context, id string) (*models.Agent, err
 		&agent.Capabilities,
 		&lastHeartbeat,
 		&agent.CreatedAt,
+		&agent.Load,
+		&agent.ActiveJobs,
+		&agent.MaxJobs,
+		&agent.SpoolDepth,
+		&metadataJSON,
+		&systemLoadJSON,
+		&environmentJSON,
+		&geoJSON,
+		&labelsJSON,
 	)
 
 	if err != nil {
@@ -120,17 +166,130 @@ func (s *agentStore) GetByID(ctx context.Context, id string) (*models.Agent, err
 		agent.LastHeartbeat = *lastHeartbeat
 	}
 
+	if err := unmarshalHostInfo(&agent, metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON); err != nil {
+		return nil, err
+	}
+
+	return &agent, nil
+}
+
+// возвращает агента по отпечатку хоста
+func (s *agentStore) GetByFingerprint(ctx context.Context, fingerprint string) (*models.Agent, error) {
+	query := `
+		SELECT id, name, token, location, status, capabilities, last_heartbeat, created_at,
+			load, active_jobs, max_jobs, spool_depth, metadata, system_load, environment, geo, labels
+		FROM agents 
+		WHERE fingerprint = $1
+	`
+
+	var agent models.Agent
+	var lastHeartbeat *time.Time
+	var metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON []byte
+
+	err := s.pool.QueryRow(ctx, query, fingerprint).Scan(
+		&agent.ID,
+		&agent.Name,
+		&agent.Token,
+		&agent.Location,
+		&agent.Status,
+		&agent.Capabilities,
+		&lastHeartbeat,
+		&agent.CreatedAt,
+		&agent.Load,
+		&agent.ActiveJobs,
+		&agent.MaxJobs,
+		&agent.SpoolDepth,
+		&metadataJSON,
+		&systemLoadJSON,
+		&environmentJSON,
+		&geoJSON,
+		&labelsJSON,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get agent by fingerprint: %w", err)
+	}
+
+	if lastHeartbeat != nil {
+		agent.LastHeartbeat = *lastHeartbeat
+	}
+
+	if err := unmarshalHostInfo(&agent, metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON); err != nil {
+		return nil, err
+	}
+
+	agent.Fingerprint = fingerprint
 	return &agent, nil
 }
 
-func (s *agentStore) UpdateHeartbeat(ctx context.Context, agentID string) error {
+// обновляет существующую запись при повторной регистрации хоста
+func (s *agentStore) UpdateRegistration(ctx context.Context, agent *models.Agent) error {
+	metadataJSON, err := marshalNullable(agent.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent metadata: %w", err)
+	}
+
+	geoJSON, err := marshalNullable(agent.Geo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent geo: %w", err)
+	}
+
+	labelsJSON, err := marshalLabels(agent.Labels)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE agents 
-		SET last_heartbeat = $1, status = $2, updated_at = $3
-		WHERE id = $4
+		SET name = $1, token = $2, location = $3, capabilities = $4,
+			metadata = COALESCE($5, metadata), status = $6, updated_at = $7,
+			geo = COALESCE($9, geo), labels = $10
+		WHERE id = $8
 	`
 
-	result, err := s.pool.Exec(ctx, query, time.Now(), models.AgentStatusOnline, time.Now(), agentID)
+	result, err := s.pool.Exec(ctx, query,
+		agent.Name,
+		agent.Token,
+		agent.Location,
+		agent.Capabilities,
+		metadataJSON,
+		agent.Status,
+		time.Now(),
+		agent.ID,
+		geoJSON,
+		labelsJSON,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update agent registration: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("agent not found with id %s", agent.ID)
+	}
+
+	return nil
+}
+
+func (s *agentStore) UpdateHeartbeat(ctx context.Context, agentID string, heartbeat *models.HeartbeatRequest) error {
+	systemLoadJSON, err := marshalNullable(heartbeat.System)
+	if err != nil {
+		return fmt.Errorf("failed to marshal system load: %w", err)
+	}
+
+	// старые агенты не присылают метрики хоста, последние известные не затираем
+	query := `
+		UPDATE agents 
+		SET last_heartbeat = $1, status = $2, updated_at = $3,
+			load = $4, active_jobs = $5, max_jobs = $6, spool_depth = $7,
+			system_load = COALESCE($8, system_load)
+		WHERE id = $9
+	`
+
+	result, err := s.pool.Exec(ctx, query, time.Now(), models.AgentStatusOnline, time.Now(),
+		heartbeat.Load, heartbeat.ActiveJobs, heartbeat.MaxJobs, heartbeat.SpoolDepth, systemLoadJSON, agentID)
 	if err != nil {
 		return fmt.Errorf("failed to update agent heartbeat: %w", err)
 	}
@@ -163,7 +322,8 @@ func (s *agentStore) UpdateStatus(ctx context.Context, agentID string, status mo
 
 func (s *agentStore) ListOnline(ctx context.Context) ([]*models.Agent, error) {
 	query := `
-		SELECT id, name, location, capabilities, last_heartbeat
+		SELECT id, name, location, capabilities, last_heartbeat, load, active_jobs, max_jobs,
+			spool_depth, metadata, system_load, environment, geo, labels
 		FROM agents 
 		WHERE status = $1
 		ORDER BY last_heartbeat DESC
@@ -179,6 +339,7 @@ func (s *agentStore) ListOnline(ctx context.Context) ([]*models.Agent, error) {
 	for rows.Next() {
 		var agent models.Agent
 		var lastHeartbeat *time.Time
+		var metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON []byte
 
 		err := rows.Scan(
 			&agent.ID,
@@ -186,6 +347,15 @@ func (s *agentStore) ListOnline(ctx context.Context) ([]*models.Agent, error) {
 			&agent.Location,
 			&agent.Capabilities,
 			&lastHeartbeat,
+			&agent.Load,
+			&agent.ActiveJobs,
+			&agent.MaxJobs,
+			&agent.SpoolDepth,
+			&metadataJSON,
+			&systemLoadJSON,
+			&environmentJSON,
+			&geoJSON,
+			&labelsJSON,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan agent row: %w", err)
@@ -195,6 +365,10 @@ func (s *agentStore) ListOnline(ctx context.Context) ([]*models.Agent, error) {
 			agent.LastHeartbeat = *lastHeartbeat
 		}
 
+		if err := unmarshalHostInfo(&agent, metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON); err != nil {
+			return nil, err
+		}
+
 		agent.Status = models.AgentStatusOnline
 		agents = append(agents, &agent)
 	}
@@ -206,15 +380,21 @@ func (s *agentStore) ListOnline(ctx context.Context) ([]*models.Agent, error) {
 	return agents, nil
 }
 
-// обновляет возможности агента
-func (s *agentStore) UpdateCapabilities(ctx context.Context, agentID string, capabilities []string) error {
+// обновляет возможности агента и результаты его самопроверки,
+// nil environment оставляет прежние сведения
+func (s *agentStore) UpdateCapabilities(ctx context.Context, agentID string, capabilities []string, environment *models.AgentEnvironment) error {
+	environmentJSON, err := marshalNullable(environment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent environment: %w", err)
+	}
+
 	query := `
 		UPDATE agents 
-		SET capabilities = $1, updated_at = $2
-		WHERE id = $3
+		SET capabilities = $1, environment = COALESCE($2, environment), updated_at = $3
+		WHERE id = $4
 	`
 
-	result, err := s.pool.Exec(ctx, query, capabilities, time.Now(), agentID)
+	result, err := s.pool.Exec(ctx, query, capabilities, environmentJSON, time.Now(), agentID)
 	if err != nil {
 		return fmt.Errorf("failed to update agent capabilities: %w", err)
 	}
@@ -225,3 +405,62 @@ func (s *agentStore) UpdateCapabilities(ctx context.Context, agentID string, cap
 
 	return nil
 }
+
+// сериализует значение в JSON, nil превращается в NULL
+func marshalNullable[T any](value *T) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// метки хранятся как JSONB, пустые - как NULL
+func marshalLabels(labels map[string]string) ([]byte, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal agent labels: %w", err)
+	}
+	return labelsJSON, nil
+}
+
+// разбирает JSONB колонки с описанием хоста агента
+func unmarshalHostInfo(agent *models.Agent, metadataJSON, systemLoadJSON, environmentJSON, geoJSON, labelsJSON []byte) error {
+	if len(metadataJSON) > 0 {
+		agent.Metadata = &models.AgentMetadata{}
+		if err := json.Unmarshal(metadataJSON, agent.Metadata); err != nil {
+			return fmt.Errorf("failed to unmarshal agent metadata: %w", err)
+		}
+	}
+
+	if len(systemLoadJSON) > 0 {
+		agent.SystemLoad = &models.SystemLoad{}
+		if err := json.Unmarshal(systemLoadJSON, agent.SystemLoad); err != nil {
+			return fmt.Errorf("failed to unmarshal agent system load: %w", err)
+		}
+	}
+
+	if len(environmentJSON) > 0 {
+		agent.Environment = &models.AgentEnvironment{}
+		if err := json.Unmarshal(environmentJSON, agent.Environment); err != nil {
+			return fmt.Errorf("failed to unmarshal agent environment: %w", err)
+		}
+	}
+
+	if len(geoJSON) > 0 {
+		agent.Geo = &models.GeoInfo{}
+		if err := json.Unmarshal(geoJSON, agent.Geo); err != nil {
+			return fmt.Errorf("failed to unmarshal agent geo: %w", err)
+		}
+	}
+
+	if len(labelsJSON) > 0 {
+		if err := json.Unmarshal(labelsJSON, &agent.Labels); err != nil {
+			return fmt.Errorf("failed to unmarshal agent labels: %w", err)
+		}
+	}
+
+	return nil
+}
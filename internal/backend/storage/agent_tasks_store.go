@@ -4,12 +4,17 @@ import (
 	"NetScan/internal/backend/models"
 	"NetScan/pkg/uuidutil"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var ErrAgentTaskNotFound = errors.New("agent task not found")
+
 type agentTasksStore struct {
 	pool *pgxpool.Pool
 }
@@ -18,22 +23,35 @@ func NewAgentTasksStore(pool *pgxpool.Pool) AgentTasksStore {
 	return &agentTasksStore{pool: pool}
 }
 
+// создает запись о выдаче задачи, повторная выдача той же проверки агенту
+// перезаписывает предыдущую
 func (s *agentTasksStore) CreateTask(ctx context.Context, task *models.AgentTask) error {
 	task.ID = uuidutil.New()
 
+	taskDataJSON, err := json.Marshal(task.TaskData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent task data: %w", err)
+	}
+
 	query := `
-		INSERT INTO agent_tasks (id, agent_id, check_id, task_data, taken_at, status)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO agent_tasks (id, agent_id, check_id, task_data, taken_at, status, attempt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (agent_id, check_id) DO UPDATE
+		SET task_data = EXCLUDED.task_data, taken_at = EXCLUDED.taken_at,
+			status = EXCLUDED.status, attempt = EXCLUDED.attempt,
+			reason = NULL, acked_at = NULL
+		RETURNING id
 	`
 
-	_, err := s.pool.Exec(ctx, query,
+	err = s.pool.QueryRow(ctx, query,
 		task.ID,
 		task.AgentID,
 		task.CheckID,
-		task.TaskData,
+		taskDataJSON,
 		task.TakenAt,
 		task.Status,
-	)
+		task.Attempt,
+	).Scan(&task.ID)
 
 	if err != nil {
 		return fmt.Errorf("failed to create agent task: %w", err)
@@ -42,16 +60,60 @@ func (s *agentTasksStore) CreateTask(ctx context.Context, task *models.AgentTask
 	return nil
 }
 
-func (s *agentTasksStore) GetStuckTasks(ctx context.Context, timeout time.Duration) ([]*models.AgentTask, error) {
+// возвращает выдачу проверки агенту
+func (s *agentTasksStore) GetTask(ctx context.Context, agentID, checkID string) (*models.AgentTask, error) {
+	query := `
+		SELECT id, agent_id, check_id, task_data, taken_at, status, attempt, COALESCE(reason, ''), acked_at, created_at
+		FROM agent_tasks
+		WHERE agent_id = $1 AND check_id = $2
+	`
+
+	task, err := scanAgentTask(s.pool.QueryRow(ctx, query, agentID, checkID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get agent task: %w", err)
+	}
+
+	return task, nil
+}
+
+// переводит выдачу в новое состояние, если текущее входит в from
+func (s *agentTasksStore) UpdateTaskStatus(ctx context.Context, agentID, checkID string, from []string, to, reason string) error {
 	query := `
-		SELECT id, agent_id, check_id, task_data, taken_at, status, created_at
-		FROM agent_tasks 
-		WHERE taken_at < $1 AND status = 'processing'
+		UPDATE agent_tasks
+		SET status = $1,
+			reason = NULLIF($2, ''),
+			acked_at = CASE WHEN $1 = 'acked' THEN COALESCE(acked_at, $3) ELSE acked_at END
+		WHERE agent_id = $4 AND check_id = $5 AND status = ANY($6)
+	`
+
+	result, err := s.pool.Exec(ctx, query, to, reason, time.Now(), agentID, checkID, from)
+	if err != nil {
+		return fmt.Errorf("failed to update agent task status: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrAgentTaskNotFound
+	}
+
+	return nil
+}
+
+// возвращает задачи с истекшей арендой: не подтвержденные за ackTimeout
+// и подтвержденные, но без результата за timeout
+func (s *agentTasksStore) GetStuckTasks(ctx context.Context, ackTimeout, timeout time.Duration) ([]*models.AgentTask, error) {
+	query := `
+		SELECT id, agent_id, check_id, task_data, taken_at, status, attempt, COALESCE(reason, ''), acked_at, created_at
+		FROM agent_tasks
+		WHERE (status = 'assigned' AND taken_at < $1)
+			OR (status = 'acked' AND acked_at < $2)
 		ORDER BY taken_at ASC
 	`
 
-	cutoffTime := time.Now().Add(-timeout)
-	rows, err := s.pool.Query(ctx, query, cutoffTime)
+	now := time.Now()
+	rows, err := s.pool.Query(ctx, query, now.Add(-ackTimeout), now.Add(-timeout))
 	if err != nil {
 		return nil, fmt.Errorf("failed to query stuck tasks: %w", err)
 	}
@@ -59,20 +121,42 @@ func (s *agentTasksStore) GetStuckTasks(ctx context.Context, timeout time.Durati
 
 	var tasks []*models.AgentTask
 	for rows.Next() {
-		var task models.AgentTask
-		err := rows.Scan(
-			&task.ID,
-			&task.AgentID,
-			&task.CheckID,
-			&task.TaskData,
-			&task.TakenAt,
-			&task.Status,
-			&task.CreatedAt,
-		)
+		task, err := scanAgentTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan agent task row: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating agent task rows: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// возвращает незавершенные выдачи агента
+func (s *agentTasksStore) ListActiveByAgent(ctx context.Context, agentID string) ([]*models.AgentTask, error) {
+	query := `
+		SELECT id, agent_id, check_id, task_data, taken_at, status, attempt, COALESCE(reason, ''), acked_at, created_at
+		FROM agent_tasks
+		WHERE agent_id = $1 AND status IN ('assigned', 'acked')
+		ORDER BY taken_at ASC
+	`
+
+	rows, err := s.pool.Query(ctx, query, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agent tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []*models.AgentTask
+	for rows.Next() {
+		task, err := scanAgentTask(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan agent task row: %w", err)
 		}
-		tasks = append(tasks, &task)
+		tasks = append(tasks, task)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -82,9 +166,123 @@ func (s *agentTasksStore) GetStuckTasks(ctx context.Context, timeout time.Durati
 	return tasks, nil
 }
 
+// возвращает проверки, выдачи которых агенту взяты после since и отменены
+func (s *agentTasksStore) ListCancelledByAgent(ctx context.Context, agentID string, since time.Time) ([]string, error) {
+	query := `
+		SELECT check_id
+		FROM agent_tasks
+		WHERE agent_id = $1 AND status = 'cancelled' AND taken_at >= $2
+		ORDER BY taken_at ASC
+	`
+
+	rows, err := s.pool.Query(ctx, query, agentID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cancelled agent tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var checkIDs []string
+	for rows.Next() {
+		var checkID string
+		if err := rows.Scan(&checkID); err != nil {
+			return nil, fmt.Errorf("failed to scan cancelled agent task row: %w", err)
+		}
+		checkIDs = append(checkIDs, checkID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cancelled agent task rows: %w", err)
+	}
+
+	return checkIDs, nil
+}
+
+// возвращает незавершенные выдачи проверки
+func (s *agentTasksStore) ListActiveByCheck(ctx context.Context, checkID string) ([]*models.AgentTask, error) {
+	query := `
+		SELECT id, agent_id, check_id, task_data, taken_at, status, attempt, COALESCE(reason, ''), acked_at, created_at
+		FROM agent_tasks
+		WHERE check_id = $1 AND status IN ('assigned', 'acked')
+		ORDER BY taken_at ASC
+	`
+
+	rows, err := s.pool.Query(ctx, query, checkID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agent tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []*models.AgentTask
+	for rows.Next() {
+		task, err := scanAgentTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan agent task row: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating agent task rows: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// возвращает количество выдач проверки по состояниям
+func (s *agentTasksStore) CountByCheck(ctx context.Context, checkID string) (map[string]int, error) {
+	query := `
+		SELECT status, COUNT(*)
+		FROM agent_tasks
+		WHERE check_id = $1
+		GROUP BY status
+	`
+
+	return s.countByStatus(ctx, query, checkID)
+}
+
+// возвращает количество выдач в работе по всем агентам
+func (s *agentTasksStore) CountActive(ctx context.Context) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM agent_tasks
+		WHERE status IN ('assigned', 'acked')
+	`
+
+	var count int
+	if err := s.pool.QueryRow(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count active agent tasks: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *agentTasksStore) countByStatus(ctx context.Context, query string, args ...interface{}) (map[string]int, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count agent tasks: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan agent task count: %w", err)
+		}
+		counts[status] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating agent task counts: %w", err)
+	}
+
+	return counts, nil
+}
+
 func (s *agentTasksStore) DeleteTask(ctx context.Context, agentID, checkID string) error {
 	query := `
-		DELETE FROM agent_tasks 
+		DELETE FROM agent_tasks
 		WHERE agent_id = $1 AND check_id = $2
 	`
 
@@ -102,7 +300,7 @@ func (s *agentTasksStore) DeleteTask(ctx context.Context, agentID, checkID strin
 
 func (s *agentTasksStore) DeleteTasksByAgent(ctx context.Context, agentID string) error {
 	query := `
-		DELETE FROM agent_tasks 
+		DELETE FROM agent_tasks
 		WHERE agent_id = $1
 	`
 
@@ -113,3 +311,33 @@ func (s *agentTasksStore) DeleteTasksByAgent(ctx context.Context, agentID string
 
 	return nil
 }
+
+// сканирует одну строку agent_tasks
+func scanAgentTask(row pgx.Row) (*models.AgentTask, error) {
+	var task models.AgentTask
+	var taskDataJSON []byte
+
+	err := row.Scan(
+		&task.ID,
+		&task.AgentID,
+		&task.CheckID,
+		&taskDataJSON,
+		&task.TakenAt,
+		&task.Status,
+		&task.Attempt,
+		&task.Reason,
+		&task.AckedAt,
+		&task.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(taskDataJSON) > 0 {
+		if err := json.Unmarshal(taskDataJSON, &task.TaskData); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal agent task data: %w", err)
+		}
+	}
+
+	return &task, nil
+}
@@ -5,10 +5,12 @@ import (
 	"NetScan/pkg/uuidutil"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -26,27 +28,77 @@ func (s *checkStore) Create(ctx context.Context, check *models.Check) error {
 	check.CreatedAt = time.Now()
 	check.UpdatedAt = time.Now()
 
-	query := `INSERT INTO checks (id, type, target, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)`
+	optionsJSON, err := marshalOptions(check.Options)
+	if err != nil {
+		return err
+	}
+
+	selectorJSON, err := marshalSelector(check.Agents)
+	if err != nil {
+		return err
+	}
 
-	_, err := s.pool.Exec(ctx, query,
+	query := `INSERT INTO checks (id, type, target, status, created_at, updated_at, options, monitor_id,
+			selector, target_agents)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, '')::uuid, $9, $10)`
+
+	_, err = s.pool.Exec(ctx, query,
 		check.ID,
 		check.Type,
 		check.Target,
 		check.Status,
 		check.CreatedAt,
 		check.UpdatedAt,
+		optionsJSON,
+		check.MonitorID,
+		selectorJSON,
+		check.TargetAgents,
 	)
 
 	return err
 }
 
+// CreateMonitorRun сохраняет запуск регулярной проверки, выполненный агентом
+// самостоятельно. ID задает агент, поэтому повторная отправка из спула не
+// создает дубликат. Возвращает false, если запуск уже сохранен.
+func (s *checkStore) CreateMonitorRun(ctx context.Context, check *models.Check) (bool, error) {
+	check.UpdatedAt = time.Now()
+
+	optionsJSON, err := marshalOptions(check.Options)
+	if err != nil {
+		return false, err
+	}
+
+	query := `INSERT INTO checks (id, type, target, status, monitor_id, created_at, updated_at, options, target_agents)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO NOTHING`
+
+	tag, err := s.pool.Exec(ctx, query,
+		check.ID,
+		check.Type,
+		check.Target,
+		check.Status,
+		check.MonitorID,
+		check.CreatedAt,
+		check.UpdatedAt,
+		optionsJSON,
+		check.TargetAgents,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to create monitor run: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
 // Возвращает по ID
 func (s *checkStore) GetByID(ctx context.Context, id string) (*models.Check, error) {
-	query := `SELECT id, type, target, status, created_at, updated_at
+	query := `SELECT id, type, target, status, created_at, updated_at, COALESCE(monitor_id::text, ''), options,
+			selector, target_agents
 		FROM checks WHERE id = $1`
 
 	var check models.Check
+	var optionsJSON, selectorJSON []byte
 	err := s.pool.QueryRow(ctx, query, id).Scan(
 		&check.ID,
 		&check.Type,
@@ -54,26 +106,70 @@ func (s *checkStore) GetByID(ctx context.Context, id string) (*models.Check, err
 		&check.Status,
 		&check.CreatedAt,
 		&check.UpdatedAt,
+		&check.MonitorID,
+		&optionsJSON,
+		&selectorJSON,
+		&check.TargetAgents,
 	)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalOptions(&check, optionsJSON); err != nil {
+		return nil, err
+	}
+	if check.Agents, err = unmarshalSelector(selectorJSON); err != nil {
+		return nil, err
+	}
 
-	return &check, err
+	return &check, nil
 }
 
 // Обновляет статус проверки
 func (s *checkStore) UpdateStatus(ctx context.Context, id string, status models.CheckStatus) error {
-	query := `UPDATE checks SET status = $1, updated_at = $2 WHERE id = $3`
+	// отмена окончательна: опоздавший результат не вернет проверку в работу
+	query := `UPDATE checks SET status = $1, updated_at = $2 WHERE id = $3 AND status <> 'cancelled'`
 	_, err := s.pool.Exec(ctx, query, status, time.Now(), id)
 	return err
 }
 
+// TransitionStatus меняет статус проверки, только если текущий входит в from,
+// false - проверки нет или ее статус уже другой
+func (s *checkStore) TransitionStatus(ctx context.Context, id string, from []models.CheckStatus, to models.CheckStatus) (bool, error) {
+	statuses := make([]string, len(from))
+	for i, status := range from {
+		statuses[i] = string(status)
+	}
+
+	query := `UPDATE checks SET status = $1, updated_at = $2 WHERE id = $3 AND status = ANY($4)`
+	tag, err := s.pool.Exec(ctx, query, to, time.Now(), id, statuses)
+	if err != nil {
+		return false, fmt.Errorf("failed to transition check status: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ReplaceTargetAgent заменяет выбранного для проверки агента другим, false -
+// агент to уже выбран для нее
+func (s *checkStore) ReplaceTargetAgent(ctx context.Context, id, from, to string) (bool, error) {
+	query := `UPDATE checks SET target_agents = array_replace(target_agents, $2, $3), updated_at = $4
+		WHERE id = $1 AND NOT ($3 = ANY(target_agents))`
+	tag, err := s.pool.Exec(ctx, query, id, from, to, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to replace target agent: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 // Возвращаем список проверок
 func (s *checkStore) List(ctx context.Context, limit, offset int) ([]*models.Check, error) {
 	query := `
-		SELECT id, type, target, status, created_at, updated_at
+		SELECT id, type, target, status, created_at, updated_at, COALESCE(monitor_id::text, ''), options,
+			selector, target_agents
 		FROM checks 
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2
@@ -82,11 +178,35 @@ func (s *checkStore) List(ctx context.Context, limit, offset int) ([]*models.Che
 	if err != nil {
 		return nil, fmt.Errorf("list checks: failed to query checks (limit=%d, offset=%d): %w", limit, offset, err)
 	}
+
+	return scanChecks(rows)
+}
+
+// ListByMonitor возвращает запуски регулярной проверки, новые первыми
+func (s *checkStore) ListByMonitor(ctx context.Context, monitorID string, limit, offset int) ([]*models.Check, error) {
+	query := `
+		SELECT id, type, target, status, created_at, updated_at, COALESCE(monitor_id::text, ''), options,
+			selector, target_agents
+		FROM checks
+		WHERE monitor_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.pool.Query(ctx, query, monitorID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list monitor runs: failed to query checks (monitor_id=%s): %w", monitorID, err)
+	}
+
+	return scanChecks(rows)
+}
+
+func scanChecks(rows pgx.Rows) ([]*models.Check, error) {
 	defer rows.Close()
 
 	var checks []*models.Check
 	for rows.Next() {
 		var check models.Check
+		var optionsJSON, selectorJSON []byte
 		err := rows.Scan(
 			&check.ID,
 			&check.Type,
@@ -94,10 +214,20 @@ func (s *checkStore) List(ctx context.Context, limit, offset int) ([]*models.Che
 			&check.Status,
 			&check.CreatedAt,
 			&check.UpdatedAt,
+			&check.MonitorID,
+			&optionsJSON,
+			&selectorJSON,
+			&check.TargetAgents,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("list checks: failed to scan row: %w", err)
 		}
+		if err := unmarshalOptions(&check, optionsJSON); err != nil {
+			return nil, fmt.Errorf("list checks: %w", err)
+		}
+		if check.Agents, err = unmarshalSelector(selectorJSON); err != nil {
+			return nil, fmt.Errorf("list checks: %w", err)
+		}
 		checks = append(checks, &check)
 	}
 
@@ -106,7 +236,6 @@ func (s *checkStore) List(ctx context.Context, limit, offset int) ([]*models.Che
 	}
 
 	return checks, nil
-
 }
 
 // возвращает количество проверок по статусу
@@ -125,3 +254,45 @@ func (s *checkStore) GetCountByStatus(ctx context.Context, status models.CheckSt
 
 	return count, nil
 }
+
+// опции хранятся как JSONB, пустые - как NULL
+func marshalOptions(options map[string]interface{}) ([]byte, error) {
+	if len(options) == 0 {
+		return nil, nil
+	}
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal check options: %w", err)
+	}
+	return optionsJSON, nil
+}
+
+func unmarshalOptions(check *models.Check, optionsJSON []byte) error {
+	if len(optionsJSON) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(optionsJSON, &check.Options); err != nil {
+		return fmt.Errorf("failed to unmarshal check options: %w", err)
+	}
+	return nil
+}
+
+// селектор агентов хранится как JSONB, отсутствующий - как NULL
+func marshalSelector(selector *models.AgentSelector) ([]byte, error) {
+	selectorJSON, err := marshalNullable(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal agent selector: %w", err)
+	}
+	return selectorJSON, nil
+}
+
+func unmarshalSelector(selectorJSON []byte) (*models.AgentSelector, error) {
+	if len(selectorJSON) == 0 {
+		return nil, nil
+	}
+	var selector models.AgentSelector
+	if err := json.Unmarshal(selectorJSON, &selector); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal agent selector: %w", err)
+	}
+	return &selector, nil
+}
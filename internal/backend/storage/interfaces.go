@@ -11,8 +11,12 @@ type CheckStore interface {
 	Create(ctx context.Context, check *models.Check) error
 	GetByID(ctx context.Context, id string) (*models.Check, error)
 	UpdateStatus(ctx context.Context, id string, status models.CheckStatus) error
+	TransitionStatus(ctx context.Context, id string, from []models.CheckStatus, to models.CheckStatus) (bool, error)
+	ReplaceTargetAgent(ctx context.Context, id, from, to string) (bool, error)
 	List(ctx context.Context, limit, offset int) ([]*models.Check, error)
 	GetCountByStatus(ctx context.Context, status models.CheckStatus) (int, error)
+	CreateMonitorRun(ctx context.Context, check *models.Check) (bool, error)
+	ListByMonitor(ctx context.Context, monitorID string, limit, offset int) ([]*models.Check, error)
 }
 
 // AgentStore интерфейс для работы с агентами
@@ -20,12 +24,26 @@ type AgentStore interface {
 	Create(ctx context.Context, agent *models.Agent) error
 	GetByToken(ctx context.Context, token string) (*models.Agent, error)
 	GetByID(ctx context.Context, id string) (*models.Agent, error)
-	UpdateHeartbeat(ctx context.Context, agentID string) error
+	GetByFingerprint(ctx context.Context, fingerprint string) (*models.Agent, error)
+	UpdateRegistration(ctx context.Context, agent *models.Agent) error
+	UpdateHeartbeat(ctx context.Context, agentID string, heartbeat *models.HeartbeatRequest) error
 	UpdateStatus(ctx context.Context, agentID string, status models.AgentStatus) error
-	UpdateCapabilities(ctx context.Context, agentID string, capabilities []string) error
+	UpdateCapabilities(ctx context.Context, agentID string, capabilities []string, environment *models.AgentEnvironment) error
 	ListOnline(ctx context.Context) ([]*models.Agent, error)
 }
 
+// MonitorStore интерфейс для работы с регулярными проверками
+type MonitorStore interface {
+	Create(ctx context.Context, monitor *models.Monitor) error
+	GetByID(ctx context.Context, id string) (*models.Monitor, error)
+	List(ctx context.Context, limit, offset int) ([]*models.Monitor, error)
+	ListEnabled(ctx context.Context) ([]*models.Monitor, error)
+	ListDue(ctx context.Context, now time.Time, limit int) ([]*models.Monitor, error)
+	Update(ctx context.Context, monitor *models.Monitor) error
+	ClaimRun(ctx context.Context, id string, slot, next time.Time) (bool, error)
+	Delete(ctx context.Context, id string) error
+}
+
 // ResultStore интерфейс для работы с результатами
 type ResultStore interface {
 	Create(ctx context.Context, result *models.CheckResult) error
@@ -38,8 +56,11 @@ type ResultStore interface {
 // Queue интерфейс для работы с очередью
 type Queue interface {
 	PushTask(ctx context.Context, queueName string, task interface{}) error
-	PopTask(ctx context.Context, queueName string, timeout time.Duration) ([]byte, error)
+	PopTask(ctx context.Context, queueNames []string, timeout time.Duration) (string, []byte, error)
 	GetQueueLength(ctx context.Context, queueName string) (int64, error)
+	ListQueues(ctx context.Context, prefix string) ([]string, error)
+	GetTasks(ctx context.Context, queueName string) ([][]byte, error)
+	RemoveTask(ctx context.Context, queueName string, task []byte) (int64, error)
 	Publish(ctx context.Context, channel string, message interface{}) error
 	Close() error
 }
@@ -47,7 +68,14 @@ type Queue interface {
 // AgentTasksStore интерфейс для работы с тасками
 type AgentTasksStore interface {
 	CreateTask(ctx context.Context, task *models.AgentTask) error
-	GetStuckTasks(ctx context.Context, timeout time.Duration) ([]*models.AgentTask, error)
+	GetTask(ctx context.Context, agentID, checkID string) (*models.AgentTask, error)
+	UpdateTaskStatus(ctx context.Context, agentID, checkID string, from []string, to, reason string) error
+	GetStuckTasks(ctx context.Context, ackTimeout, timeout time.Duration) ([]*models.AgentTask, error)
+	ListActiveByAgent(ctx context.Context, agentID string) ([]*models.AgentTask, error)
+	ListActiveByCheck(ctx context.Context, checkID string) ([]*models.AgentTask, error)
+	ListCancelledByAgent(ctx context.Context, agentID string, since time.Time) ([]string, error)
+	CountByCheck(ctx context.Context, checkID string) (map[string]int, error)
+	CountActive(ctx context.Context) (int, error)
 	DeleteTask(ctx context.Context, agentID, checkID string) error
 	DeleteTasksByAgent(ctx context.Context, agentID string) error
 }
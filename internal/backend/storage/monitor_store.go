@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"NetScan/internal/backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrMonitorNotFound = errors.New("monitor not found")
+
+const monitorColumns = `id, name, type, target, options, interval_seconds, selector, enabled,
+	next_run_at, last_run_at, created_at, updated_at`
+
+type monitorStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewMonitorStore(pool *pgxpool.Pool) MonitorStore {
+	return &monitorStore{pool: pool}
+}
+
+// Create сохраняет регулярную проверку, ID и время задает сервис
+func (s *monitorStore) Create(ctx context.Context, monitor *models.Monitor) error {
+	optionsJSON, err := marshalOptions(monitor.Options)
+	if err != nil {
+		return err
+	}
+
+	selectorJSON, err := marshalSelector(monitor.Agents)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO monitors (id, name, type, target, options, interval_seconds, selector, enabled,
+			next_run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err = s.pool.Exec(ctx, query,
+		monitor.ID,
+		monitor.Name,
+		monitor.Type,
+		monitor.Target,
+		optionsJSON,
+		monitor.Interval,
+		selectorJSON,
+		monitor.Enabled,
+		monitor.NextRunAt,
+		monitor.CreatedAt,
+		monitor.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create monitor: %w", err)
+	}
+	return nil
+}
+
+// GetByID возвращает регулярную проверку, ErrMonitorNotFound если ее нет
+func (s *monitorStore) GetByID(ctx context.Context, id string) (*models.Monitor, error) {
+	query := `SELECT ` + monitorColumns + ` FROM monitors WHERE id = $1`
+
+	monitor, err := scanMonitor(s.pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMonitorNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monitor: %w", err)
+	}
+	return monitor, nil
+}
+
+// List возвращает регулярные проверки в порядке создания
+func (s *monitorStore) List(ctx context.Context, limit, offset int) ([]*models.Monitor, error) {
+	query := `SELECT ` + monitorColumns + ` FROM monitors
+		ORDER BY created_at DESC
+		LIMIT $1 OFFSET $2`
+
+	return s.query(ctx, query, limit, offset)
+}
+
+// ListEnabled возвращает все включенные регулярные проверки
+func (s *monitorStore) ListEnabled(ctx context.Context) ([]*models.Monitor, error) {
+	query := `SELECT ` + monitorColumns + ` FROM monitors
+		WHERE enabled
+		ORDER BY id`
+
+	return s.query(ctx, query)
+}
+
+// ListDue возвращает включенные регулярные проверки, время запуска которых наступило
+func (s *monitorStore) ListDue(ctx context.Context, now time.Time, limit int) ([]*models.Monitor, error) {
+	query := `SELECT ` + monitorColumns + ` FROM monitors
+		WHERE enabled AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT $2`
+
+	return s.query(ctx, query, now, limit)
+}
+
+// Update заменяет настройки регулярной проверки и время следующего запуска
+func (s *monitorStore) Update(ctx context.Context, monitor *models.Monitor) error {
+	optionsJSON, err := marshalOptions(monitor.Options)
+	if err != nil {
+		return err
+	}
+
+	selectorJSON, err := marshalSelector(monitor.Agents)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE monitors
+		SET name = $1, type = $2, target = $3, options = $4, interval_seconds = $5, selector = $6,
+			enabled = $7, next_run_at = $8, updated_at = $9
+		WHERE id = $10`
+
+	tag, err := s.pool.Exec(ctx, query,
+		monitor.Name,
+		monitor.Type,
+		monitor.Target,
+		optionsJSON,
+		monitor.Interval,
+		selectorJSON,
+		monitor.Enabled,
+		monitor.NextRunAt,
+		monitor.UpdatedAt,
+		monitor.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update monitor: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMonitorNotFound
+	}
+	return nil
+}
+
+// ClaimRun переносит запуск с slot на next, только если его еще никто не
+// перенес. Так один слот запускается ровно один раз, даже если планировщик
+// работает на нескольких экземплярах или перезапустился посреди тика.
+func (s *monitorStore) ClaimRun(ctx context.Context, id string, slot, next time.Time) (bool, error) {
+	query := `UPDATE monitors
+		SET next_run_at = $1, last_run_at = $2
+		WHERE id = $3 AND enabled AND next_run_at = $4`
+
+	tag, err := s.pool.Exec(ctx, query, next, time.Now().UTC(), id, slot)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim monitor run: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Delete удаляет регулярную проверку, ее запуски остаются в истории проверок
+func (s *monitorStore) Delete(ctx context.Context, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM monitors WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete monitor: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMonitorNotFound
+	}
+	return nil
+}
+
+func (s *monitorStore) query(ctx context.Context, query string, args ...interface{}) ([]*models.Monitor, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query monitors: %w", err)
+	}
+	defer rows.Close()
+
+	monitors := []*models.Monitor{}
+	for rows.Next() {
+		monitor, err := scanMonitor(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan monitor: %w", err)
+		}
+		monitors = append(monitors, monitor)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("monitors row iteration error: %w", err)
+	}
+	return monitors, nil
+}
+
+func scanMonitor(row pgx.Row) (*models.Monitor, error) {
+	var monitor models.Monitor
+	var optionsJSON, selectorJSON []byte
+
+	err := row.Scan(
+		&monitor.ID,
+		&monitor.Name,
+		&monitor.Type,
+		&monitor.Target,
+		&optionsJSON,
+		&monitor.Interval,
+		&selectorJSON,
+		&monitor.Enabled,
+		&monitor.NextRunAt,
+		&monitor.LastRunAt,
+		&monitor.CreatedAt,
+		&monitor.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(optionsJSON) > 0 {
+		if err := json.Unmarshal(optionsJSON, &monitor.Options); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal monitor options: %w", err)
+		}
+	}
+
+	monitor.Agents, err = unmarshalSelector(selectorJSON)
+	if err != nil {
+		return nil, err
+	}
+	return &monitor, nil
+}
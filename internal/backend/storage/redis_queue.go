@@ -14,6 +14,9 @@ import (
 
 type redisQueue struct {
 	client *redis.Client
+	// BRPOP держит соединение все время ожидания, поэтому ожидания задач
+	// идут через отдельный пул и не исчерпывают пул остальных команд
+	blockingClient *redis.Client
 }
 
 func NewRedisQueue(cfg *config.RedisConfig, log *slog.Logger) (Queue, error) {
@@ -23,12 +26,15 @@ func NewRedisQueue(cfg *config.RedisConfig, log *slog.Logger) (Queue, error) {
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		log.Error("failed to connect to Redis", "error", err)
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	log.Info("Connected to Redis")
-	return &redisQueue{client: client}, nil
+	blockingClient := redis.NewClient(cfg.GetBlockingRedisOptions())
+
+	log.Info("Connected to Redis", "blocking_pool_size", cfg.BlockingPoolSize)
+	return &redisQueue{client: client, blockingClient: blockingClient}, nil
 }
 
 // Добавляем элемент в очередь
@@ -70,40 +76,78 @@ func (r *redisQueue) PushTask(ctx context.Context, queueName string, task interf
 	return r.client.LPush(ctx, queueName, data).Err()
 }
 
-// Удаляем элемент из очереди
-func (r *redisQueue) PopTask(ctx context.Context, queueName string, timeout time.Duration) ([]byte, error) {
-	result, err := r.client.BRPop(ctx, time.Second, queueName).Result()
+// Удаляем элемент из первой непустой очереди в порядке queueNames, ждем не
+// дольше timeout. Возвращает имя очереди, из которой взят элемент.
+func (r *redisQueue) PopTask(ctx context.Context, queueNames []string, timeout time.Duration) (string, []byte, error) {
+	// BRPop с нулевым таймаутом блокируется навсегда
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	result, err := r.blockingClient.BRPop(ctx, timeout, queueNames...).Result()
 
 	if err != nil {
 		// Проверяем ошибки контекста
 		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-			return nil, err
+			return "", nil, err
 		}
 
-		// Очередь пуста
+		// Очереди пусты
 		if errors.Is(err, redis.Nil) {
-			return nil, nil
+			return "", nil, nil
 		}
 
-		return nil, fmt.Errorf("redis BRPop failed: %w", err)
+		return "", nil, fmt.Errorf("redis BRPop failed: %w", err)
 	}
 
 	// Проверяем результат на корректность
 	if len(result) < 2 {
-		return nil, fmt.Errorf("invalid BRPop result: expected 2 elements, got %d", len(result))
+		return "", nil, fmt.Errorf("invalid BRPop result: expected 2 elements, got %d", len(result))
 	}
 
-	return []byte(result[1]), nil
+	return result[0], []byte(result[1]), nil
 }
 
 func (r *redisQueue) Close() error {
-	return r.client.Close()
+	return errors.Join(r.blockingClient.Close(), r.client.Close())
 }
 
 func (r *redisQueue) GetQueueLength(ctx context.Context, queueName string) (int64, error) {
 	return r.client.LLen(ctx, queueName).Result()
 }
 
+// GetTasks возвращает элементы очереди, не удаляя их
+func (r *redisQueue) GetTasks(ctx context.Context, queueName string) ([][]byte, error) {
+	values, err := r.client.LRange(ctx, queueName, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis LRANGE failed: %w", err)
+	}
+
+	tasks := make([][]byte, 0, len(values))
+	for _, value := range values {
+		tasks = append(tasks, []byte(value))
+	}
+	return tasks, nil
+}
+
+// RemoveTask удаляет из очереди все копии элемента, возвращает их число
+func (r *redisQueue) RemoveTask(ctx context.Context, queueName string, task []byte) (int64, error) {
+	return r.client.LRem(ctx, queueName, 0, task).Result()
+}
+
+// ListQueues возвращает имена непустых очередей, начинающихся с prefix
+func (r *redisQueue) ListQueues(ctx context.Context, prefix string) ([]string, error) {
+	var queues []string
+	iter := r.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		queues = append(queues, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("redis SCAN failed: %w", err)
+	}
+	return queues, nil
+}
+
 func (r *redisQueue) Publish(ctx context.Context, channel string, message interface{}) error {
 	data, err := json.Marshal(message)
 	if err != nil {
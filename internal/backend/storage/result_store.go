@@ -5,6 +5,7 @@ import (
 	"NetScan/pkg/uuidutil"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,6 +13,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// результат с таким result_id уже сохранен
+var ErrDuplicateResult = errors.New("duplicate result")
+
 type resultStore struct {
 	pool *pgxpool.Pool
 }
@@ -22,19 +26,35 @@ func NewResultStore(pool *pgxpool.Pool) ResultStore {
 
 func (s *resultStore) Create(ctx context.Context, result *models.CheckResult) error {
 	result.ID = uuidutil.New()
-	result.CreatedAt = time.Now()
+	// результаты из спула агента сохраняют исходное время
+	if result.CreatedAt.IsZero() {
+		result.CreatedAt = time.Now()
+	}
 
 	dataJSON, err := json.Marshal(result.Data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal result data: %w", err)
 	}
 
+	geoJSON, err := marshalNullable(result.Geo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result geo: %w", err)
+	}
+
 	query := `
-		INSERT INTO check_results (id, check_id, agent_id, success, data, error, duration, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO check_results (id, check_id, agent_id, success, data, error, duration, created_at, result_id, outcome,
+		                           error_code, error_phase, geo)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::uuid, $10, NULLIF($11, ''), NULLIF($12, ''), $13)
+		ON CONFLICT (result_id) DO NOTHING
 	`
 
-	_, err = s.pool.Exec(ctx, query,
+	var errorCode, errorPhase string
+	if result.Failure != nil {
+		errorCode = result.Failure.Code
+		errorPhase = result.Failure.Phase
+	}
+
+	tag, err := s.pool.Exec(ctx, query,
 		result.ID,
 		result.CheckID,
 		result.AgentID,
@@ -43,18 +63,28 @@ func (s *resultStore) Create(ctx context.Context, result *models.CheckResult) er
 		result.Error,
 		result.Duration,
 		result.CreatedAt,
+		result.ResultID,
+		result.Outcome,
+		errorCode,
+		errorPhase,
+		geoJSON,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to create check result: %w", err)
 	}
 
+	if tag.RowsAffected() == 0 {
+		return ErrDuplicateResult
+	}
+
 	return nil
 }
 
 func (s *resultStore) GetByCheckID(ctx context.Context, checkID string) ([]*models.CheckResult, error) {
 	query := `
-		SELECT id, check_id, agent_id, success, data, error, duration, created_at
+		SELECT id, check_id, agent_id, success, data, error, duration, created_at, outcome,
+		       COALESCE(error_code, ''), COALESCE(error_phase, ''), geo
 		FROM check_results 
 		WHERE check_id = $1
 		ORDER BY created_at DESC
@@ -71,7 +101,8 @@ func (s *resultStore) GetByCheckID(ctx context.Context, checkID string) ([]*mode
 
 func (s *resultStore) GetByAgentID(ctx context.Context, agentID string, limit int) ([]*models.CheckResult, error) {
 	query := `
-		SELECT id, check_id, agent_id, success, data, error, duration, created_at
+		SELECT id, check_id, agent_id, success, data, error, duration, created_at, outcome,
+		       COALESCE(error_code, ''), COALESCE(error_phase, ''), geo
 		FROM check_results 
 		WHERE agent_id = $1
 		ORDER BY created_at DESC
@@ -90,7 +121,8 @@ func (s *resultStore) GetByAgentID(ctx context.Context, agentID string, limit in
 // возвращает последние N результатов для конкретной проверки
 func (s *resultStore) GetLatestByCheckID(ctx context.Context, checkID string, limit int) ([]*models.CheckResult, error) {
 	query := `
-		SELECT id, check_id, agent_id, success, data, error, duration, created_at
+		SELECT id, check_id, agent_id, success, data, error, duration, created_at, outcome,
+		       COALESCE(error_code, ''), COALESCE(error_phase, ''), geo
 		FROM check_results 
 		WHERE check_id = $1
 		ORDER BY created_at DESC
@@ -142,7 +174,8 @@ func (s *resultStore) scanResults(rows pgx.Rows) ([]*models.CheckResult, error)
 // сканирует одну строку результата
 func (s *resultStore) scanSingleResult(rows pgx.Rows) (*models.CheckResult, error) {
 	var result models.CheckResult
-	var dataJSON []byte
+	var dataJSON, geoJSON []byte
+	var errorCode, errorPhase string
 
 	err := rows.Scan(
 		&result.ID,
@@ -153,6 +186,10 @@ func (s *resultStore) scanSingleResult(rows pgx.Rows) (*models.CheckResult, erro
 		&result.Error,
 		&result.Duration,
 		&result.CreatedAt,
+		&result.Outcome,
+		&errorCode,
+		&errorPhase,
+		&geoJSON,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan result row: %w", err)
@@ -165,5 +202,16 @@ func (s *resultStore) scanSingleResult(rows pgx.Rows) (*models.CheckResult, erro
 		}
 	}
 
+	if errorCode != "" {
+		result.Failure = &models.ResultFailure{Code: errorCode, Phase: errorPhase, Message: result.Error}
+	}
+
+	if len(geoJSON) > 0 {
+		result.Geo = &models.GeoInfo{}
+		if err := json.Unmarshal(geoJSON, result.Geo); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal result geo: %w", err)
+		}
+	}
+
 	return &result, nil
 }
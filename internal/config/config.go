@@ -11,17 +11,33 @@ import (
 )
 
 type Config struct {
-	App      AppConfig      `mapstructure:"app"`
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Redis    RedisConfig    `mapstructure:"redis"`
-	Logging  LoggingConfig  `mapstructure:"login"`
-	Security SecurityConfig `mapstructure:"security"`
+	App       AppConfig       `mapstructure:"app"`
+	Server    ServerConfig    `mapstructure:"server"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	Redis     RedisConfig     `mapstructure:"redis"`
+	Logging   LoggingConfig   `mapstructure:"login"`
+	Security  SecurityConfig  `mapstructure:"security"`
+	GeoIP     GeoIPConfig     `mapstructure:"geoip"`
+	Consensus ConsensusConfig `mapstructure:"consensus"`
 }
 
 type ServerConfig struct {
-	Port int    `mapstructure:"port"`
-	Mode string `mapstructure:"mode"`
+	Port int       `mapstructure:"port"`
+	Mode string    `mapstructure:"mode"`
+	TLS  TLSConfig `mapstructure:"tls"`
+}
+
+// TLSConfig включает HTTPS на листенере backend. Файлы перечитываются
+// при изменении, ротация сертификатов не требует перезапуска.
+type TLSConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// CA, которым подписаны клиентские сертификаты агентов
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	// требовать сертификат агента на маршрутах задач и результатов,
+	// CN сертификата должен совпадать с ID агента
+	RequireAgentCert bool `mapstructure:"require_agent_cert"`
 }
 
 type DatabaseConfig struct {
@@ -37,6 +53,9 @@ type RedisConfig struct {
 	Addr     string `mapstructure:"addr"`
 	Password string `mapstructure:"password"`
 	DB       int    `mapstructure:"db"`
+	// соединения для ожидания задач (BRPOP): каждое ожидание занимает
+	// соединение целиком, по одному на подключенного по каналу агента
+	BlockingPoolSize int `mapstructure:"blocking_pool_size"`
 }
 
 type LoggingConfig struct {
@@ -49,6 +68,27 @@ type SecurityConfig struct {
 	TokenExpiry      time.Duration `mapstructure:"token_expiry"`
 }
 
+// GeoIPConfig задает локальные базы MaxMind (MMDB) для определения страны,
+// города и AS агентов и целей проверок. Пустые пути отключают обогащение,
+// замененные файлы подхватываются без перезапуска.
+type GeoIPConfig struct {
+	CityDB string `mapstructure:"city_db"`
+	ASNDB  string `mapstructure:"asn_db"`
+}
+
+// ConsensusConfig задает правила итогового вердикта проверки (up, down,
+// partial, degraded) по результатам всех агентов
+type ConsensusConfig struct {
+	// минимум ответивших агентов для вердикта
+	Quorum int `mapstructure:"quorum"`
+	// доля неудач, начиная с которой цель недоступна (down)
+	DownRatio float64 `mapstructure:"down_ratio"`
+	// доля неудач, до которой цель деградировала, а не частично недоступна
+	DegradedRatio float64 `mapstructure:"degraded_ratio"`
+	// медианное время ответа, выше которого цель деградировала, 0 - не учитывать
+	SlowThreshold time.Duration `mapstructure:"slow_threshold"`
+}
+
 type AppConfig struct {
 	Name    string `mapstructure:"name"`
 	Version string `mapstructure:"version"`
@@ -87,6 +127,8 @@ func setDefaults() {
 	// Server defaults
 	viper.SetDefault("server.port", 8080)
 	viper.SetDefault("server.mode", "debug")
+	viper.SetDefault("server.tls.enabled", false)
+	viper.SetDefault("server.tls.require_agent_cert", false)
 
 	// database defaults
 	viper.SetDefault("database.host", "localhost")
@@ -100,6 +142,7 @@ func setDefaults() {
 	viper.SetDefault("redis.addr", "localhost:6379")
 	viper.SetDefault("redis.password", "")
 	viper.SetDefault("redis.db", 0)
+	viper.SetDefault("redis.blocking_pool_size", 1000)
 
 	// login defaults
 	viper.SetDefault("logging.level", "info")
@@ -108,6 +151,16 @@ func setDefaults() {
 	// security defaults
 	viper.SetDefault("security.agent_token_secret", "change-me-in-production")
 	viper.SetDefault("security.token_expiry", "720h") // 30 days
+
+	// geoip defaults
+	viper.SetDefault("geoip.city_db", "")
+	viper.SetDefault("geoip.asn_db", "")
+
+	// consensus defaults
+	viper.SetDefault("consensus.quorum", 1)
+	viper.SetDefault("consensus.down_ratio", 0.8)
+	viper.SetDefault("consensus.degraded_ratio", 0.2)
+	viper.SetDefault("consensus.slow_threshold", "0s")
 }
 
 func validateConfig(cfg *Config) error {
@@ -119,6 +172,14 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("invalid server mode %s", cfg.Server.Mode)
 	}
 
+	if cfg.Server.TLS.Enabled && (cfg.Server.TLS.CertFile == "" || cfg.Server.TLS.KeyFile == "") {
+		return errors.New("server tls requires cert_file and key_file")
+	}
+
+	if cfg.Server.TLS.RequireAgentCert && (!cfg.Server.TLS.Enabled || cfg.Server.TLS.ClientCAFile == "") {
+		return errors.New("server tls require_agent_cert requires tls to be enabled with client_ca_file")
+	}
+
 	if cfg.Database.Host == "" {
 		return errors.New("database host is required")
 	}
@@ -131,6 +192,10 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("redis address is required")
 	}
 
+	if cfg.Redis.BlockingPoolSize < 1 {
+		return fmt.Errorf("invalid redis blocking_pool_size %d", cfg.Redis.BlockingPoolSize)
+	}
+
 	if cfg.Security.AgentTokenSecret == "change-me-in-production" {
 		slog.Warn("Using default agent token secret - change this in production!")
 	}
@@ -153,3 +218,11 @@ func (r *RedisConfig) GetRedisOptions() *redis.Options {
 		DisableIdentity: true,
 	}
 }
+
+// возвращает параметры отдельного пула для блокирующих ожиданий задач, чтобы
+// они не занимали соединения остальных команд
+func (r *RedisConfig) GetBlockingRedisOptions() *redis.Options {
+	options := r.GetRedisOptions()
+	options.PoolSize = r.BlockingPoolSize
+	return options
+}
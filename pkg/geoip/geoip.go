@@ -1 +1,231 @@
+// Package geoip looks up the location and the autonomous system of IP
+// addresses in local MaxMind DB (MMDB) files, such as GeoLite2 City and ASN
+// or the compatible DB-IP databases. No network access is needed.
 package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often files are checked for changes, at most once per lookup
+const reloadCheckInterval = 30 * time.Second
+
+type Files struct {
+	// CityFile is a City or Country database, empty disables locations
+	CityFile string
+	// ASNFile is an ASN database, empty disables autonomous systems
+	ASNFile string
+}
+
+// Info describes an address, fields missing from the databases are empty
+type Info struct {
+	IP           string  `json:"ip"`
+	CountryCode  string  `json:"country_code,omitempty"`
+	Country      string  `json:"country,omitempty"`
+	City         string  `json:"city,omitempty"`
+	Latitude     float64 `json:"latitude,omitempty"`
+	Longitude    float64 `json:"longitude,omitempty"`
+	ASN          uint64  `json:"asn,omitempty"`
+	Organization string  `json:"organization,omitempty"`
+}
+
+// Empty reports whether the databases knew nothing about the address
+func (i *Info) Empty() bool {
+	return i.CountryCode == "" && i.City == "" && i.ASN == 0 && i.Organization == ""
+}
+
+// DB serves lookups from database files and picks up replaced files on
+// the next lookup, no restart is needed. If a replaced file can not be
+// loaded the previous one stays in use.
+type DB struct {
+	files Files
+
+	mutex     sync.RWMutex
+	city      *database
+	asn       *database
+	modTimes  [2]time.Time
+	checkedAt time.Time
+	lastError error
+}
+
+func Open(files Files) (*DB, error) {
+	if files.CityFile == "" && files.ASNFile == "" {
+		return nil, errors.New("no geoip database files configured")
+	}
+
+	db := &DB{files: files}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	db.checkedAt = time.Now()
+	return db, nil
+}
+
+// LastError returns the error of the last failed reload, nil once files
+// load again
+func (db *DB) LastError() error {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.lastError
+}
+
+// Lookup returns what the databases know about ip, nil for addresses that
+// are invalid, private or missing from the databases
+func (db *DB) Lookup(ip string) (*Info, error) {
+	address := net.ParseIP(ip)
+	if address == nil || !Public(address) {
+		return nil, nil
+	}
+
+	db.refresh()
+
+	db.mutex.RLock()
+	city, asn := db.city, db.asn
+	db.mutex.RUnlock()
+
+	info := &Info{IP: address.String()}
+
+	if city != nil {
+		record, err := city.lookup(address)
+		if err != nil {
+			return nil, fmt.Errorf("city lookup failed: %w", err)
+		}
+		fillLocation(info, record)
+	}
+
+	if asn != nil {
+		record, err := asn.lookup(address)
+		if err != nil {
+			return nil, fmt.Errorf("asn lookup failed: %w", err)
+		}
+		info.ASN = toUint(record["autonomous_system_number"])
+		info.Organization, _ = record["autonomous_system_organization"].(string)
+	}
+
+	if info.Empty() {
+		return nil, nil
+	}
+	return info, nil
+}
+
+// Public reports whether ip is routable on the internet, private and
+// special purpose addresses are in no database
+func Public(ip net.IP) bool {
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+func fillLocation(info *Info, record map[string]interface{}) {
+	// the registered country stands in for anycast and satellite ranges
+	country := field(record, "country")
+	if country == nil {
+		country = field(record, "registered_country")
+	}
+	if country != nil {
+		info.CountryCode, _ = country["iso_code"].(string)
+		info.Country = englishName(country)
+	}
+
+	if city := field(record, "city"); city != nil {
+		info.City = englishName(city)
+	}
+
+	if location := field(record, "location"); location != nil {
+		info.Latitude, _ = location["latitude"].(float64)
+		info.Longitude, _ = location["longitude"].(float64)
+	}
+}
+
+func field(record map[string]interface{}, name string) map[string]interface{} {
+	value, _ := record[name].(map[string]interface{})
+	return value
+}
+
+func englishName(record map[string]interface{}) string {
+	name, _ := field(record, "names")["en"].(string)
+	return name
+}
+
+// refresh reloads files whose modification time changed
+func (db *DB) refresh() {
+	db.mutex.RLock()
+	due := time.Since(db.checkedAt) >= reloadCheckInterval
+	db.mutex.RUnlock()
+	if !due {
+		return
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if time.Since(db.checkedAt) < reloadCheckInterval {
+		return
+	}
+	db.checkedAt = time.Now()
+
+	modTimes, err := db.stat()
+	if err != nil {
+		db.lastError = err
+		return
+	}
+	if modTimes == db.modTimes {
+		return
+	}
+
+	if err := db.loadLocked(); err != nil {
+		db.lastError = err
+		return
+	}
+	db.lastError = nil
+}
+
+func (db *DB) load() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.loadLocked()
+}
+
+// loadLocked reads both files, the caller holds the mutex
+func (db *DB) loadLocked() error {
+	modTimes, err := db.stat()
+	if err != nil {
+		return err
+	}
+
+	var city, asn *database
+	if db.files.CityFile != "" {
+		if city, err = openDatabase(db.files.CityFile); err != nil {
+			return fmt.Errorf("failed to load city database: %w", err)
+		}
+	}
+	if db.files.ASNFile != "" {
+		if asn, err = openDatabase(db.files.ASNFile); err != nil {
+			return fmt.Errorf("failed to load asn database: %w", err)
+		}
+	}
+
+	db.city = city
+	db.asn = asn
+	db.modTimes = modTimes
+	return nil
+}
+
+func (db *DB) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{db.files.CityFile, db.files.ASNFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
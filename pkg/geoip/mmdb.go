@@ -0,0 +1,388 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// metadataStart marks the metadata section at the end of an MMDB file
+var metadataStart = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// size of the zero separator between the search tree and the data section
+const dataSectionSeparator = 16
+
+// MMDB data types
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// nested maps and arrays deeper than this are rejected as corrupt
+const maxDepth = 32
+
+var errCorrupt = errors.New("corrupt database")
+
+// database is a MaxMind DB file held in memory
+type database struct {
+	buffer     []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+func openDatabase(path string) (*database, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	index := bytes.LastIndex(buffer, metadataStart)
+	if index < 0 {
+		return nil, fmt.Errorf("%s: metadata not found, not a MaxMind DB file", path)
+	}
+
+	metadata := buffer[index+len(metadataStart):]
+	value, _, err := (&decoder{data: metadata}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid metadata: %w", path, err)
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid metadata", path)
+	}
+
+	db := &database{
+		buffer:     buffer,
+		nodeCount:  uint(toUint(fields["node_count"])),
+		recordSize: uint(toUint(fields["record_size"])),
+		ipVersion:  uint(toUint(fields["ip_version"])),
+	}
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%s: unsupported record size %d", path, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%s: unsupported ip version %d", path, db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(index) {
+		return nil, fmt.Errorf("%s: search tree exceeds file size", path)
+	}
+	db.data = buffer[treeSize+dataSectionSeparator : index]
+
+	// IPv4 addresses live under ::/96 of an IPv6 tree
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// lookup returns the record of the network containing ip, nil when the
+// database has none
+func (db *database) lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bits := 128
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = db.record(node, bit)
+	}
+
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errCorrupt
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparator
+	if offset >= uint(len(db.data)) {
+		return nil, errCorrupt
+	}
+
+	value, _, err := (&decoder{data: db.data}).decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a tree node
+func (db *database) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		offset := node*6 + bit*3
+		b := db.buffer[offset : offset+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		offset := node * 7
+		b := db.buffer[offset : offset+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		offset := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(db.buffer[offset : offset+4]))
+	}
+}
+
+// decoder reads values of the MMDB data section format
+type decoder struct {
+	data []byte
+}
+
+// decode returns the value at offset and the offset after it
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errCorrupt
+	}
+
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kind == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, next, err
+	}
+
+	return d.value(kind, size, offset, depth)
+}
+
+// control reads the control byte, and the extended type and size bytes
+// following it
+func (d *decoder) control(offset uint) (kind int, size uint, next uint, err error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, errCorrupt
+	}
+	ctrl := d.data[offset]
+	offset++
+
+	kind = int(ctrl >> 5)
+	if kind == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, errCorrupt
+		}
+		kind = int(d.data[offset]) + 7
+		offset++
+	}
+
+	size = uint(ctrl & 0x1F)
+	if kind == typePointer {
+		return kind, size, offset, nil
+	}
+
+	var extra uint
+	switch size {
+	case 29:
+		extra = 1
+	case 30:
+		extra = 2
+	case 31:
+		extra = 3
+	default:
+		return kind, size, offset, nil
+	}
+	if offset+extra > uint(len(d.data)) {
+		return 0, 0, 0, errCorrupt
+	}
+
+	n := uint(0)
+	for _, b := range d.data[offset : offset+extra] {
+		n = n<<8 | uint(b)
+	}
+	switch size {
+	case 29:
+		size = 29 + n
+	case 30:
+		size = 285 + n
+	case 31:
+		size = 65821 + n
+	}
+	return kind, size, offset + extra, nil
+}
+
+// pointer resolves a pointer whose control byte carried size, it returns
+// the target offset and the offset after the pointer
+func (d *decoder) pointer(size, offset uint) (uint, uint, error) {
+	length := (size>>3)&0x3 + 1
+	if offset+length > uint(len(d.data)) {
+		return 0, 0, errCorrupt
+	}
+
+	b := d.data[offset : offset+length]
+	value := size & 0x7
+	var target uint
+	switch length {
+	case 1:
+		target = value<<8 | uint(b[0])
+	case 2:
+		target = (value<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		target = (value<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		target = uint(binary.BigEndian.Uint32(b))
+	}
+	return target, offset + length, nil
+}
+
+func (d *decoder) value(kind int, size, offset uint, depth int) (interface{}, uint, error) {
+	switch kind {
+	case typeMap, typeArray:
+		// every entry takes at least a byte
+		if size > uint(len(d.data)) {
+			return nil, 0, errCorrupt
+		}
+	}
+
+	switch kind {
+	case typeMap:
+		return d.decodeMap(size, offset, depth)
+	case typeArray:
+		return d.decodeArray(size, offset, depth)
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errCorrupt
+	}
+	b := d.data[offset : offset+size]
+	next := offset + size
+
+	switch kind {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, errCorrupt
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	default:
+		return nil, 0, fmt.Errorf("%w: unknown data type %d", errCorrupt, kind)
+	}
+}
+
+func (d *decoder) decodeMap(size, offset uint, depth int) (interface{}, uint, error) {
+	values := make(map[string]interface{}, size)
+	for i := uint(0); i < size; i++ {
+		key, next, err := d.decode(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, 0, errCorrupt
+		}
+
+		value, next, err := d.decode(next, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		values[name] = value
+		offset = next
+	}
+	return values, offset, nil
+}
+
+func (d *decoder) decodeArray(size, offset uint, depth int) (interface{}, uint, error) {
+	values := make([]interface{}, 0, size)
+	for i := uint(0); i < size; i++ {
+		value, next, err := d.decode(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		values = append(values, value)
+		offset = next
+	}
+	return values, offset, nil
+}
+
+func toUint(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int64:
+		if v > 0 {
+			return uint64(v)
+		}
+	}
+	return 0
+}
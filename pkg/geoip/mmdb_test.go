@@ -0,0 +1,533 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Fixtures are written by a minimal MMDB writer below instead of being
+// checked in, so every byte of them is visible in the test.
+
+// encoder writes values of the MMDB data section format
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) control(kind int, size int) {
+	var sizeBits byte
+	var extra []byte
+	switch {
+	case size < 29:
+		sizeBits = byte(size)
+	case size < 285:
+		sizeBits = 29
+		extra = []byte{byte(size - 29)}
+	case size < 65821:
+		sizeBits = 30
+		n := size - 285
+		extra = []byte{byte(n >> 8), byte(n)}
+	default:
+		sizeBits = 31
+		n := size - 65821
+		extra = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	if kind > 7 {
+		e.buf = append(e.buf, sizeBits, byte(kind-7))
+	} else {
+		e.buf = append(e.buf, byte(kind)<<5|sizeBits)
+	}
+	e.buf = append(e.buf, extra...)
+}
+
+func (e *encoder) uint(kind int, n uint64) {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	e.control(kind, len(b))
+	e.buf = append(e.buf, b...)
+}
+
+// pointer writes a pointer to offset, only the two shortest forms are used
+func (e *encoder) pointer(offset int) {
+	if offset < 2048 {
+		e.buf = append(e.buf, typePointer<<5|byte(offset>>8), byte(offset))
+		return
+	}
+	n := offset - 2048
+	e.buf = append(e.buf, typePointer<<5|1<<3|byte(n>>16), byte(n>>8), byte(n))
+}
+
+// pointerTo is a value encoded as a pointer to an earlier offset
+type pointerTo int
+
+func (e *encoder) encode(value interface{}) {
+	switch v := value.(type) {
+	case pointerTo:
+		e.pointer(int(v))
+	case string:
+		e.control(typeString, len(v))
+		e.buf = append(e.buf, v...)
+	case float64:
+		e.control(typeDouble, 8)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v))
+	case float32:
+		e.control(typeFloat, 4)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(v))
+	case uint16:
+		e.uint(typeUint16, uint64(v))
+	case uint32:
+		e.uint(typeUint32, uint64(v))
+	case uint64:
+		e.uint(typeUint64, v)
+	case int32:
+		e.control(typeInt32, 4)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		e.control(typeBool, size)
+	case []byte:
+		e.control(typeBytes, len(v))
+		e.buf = append(e.buf, v...)
+	case []interface{}:
+		e.control(typeArray, len(v))
+		for _, item := range v {
+			e.encode(item)
+		}
+	case map[string]interface{}:
+		// keys are sorted so fixtures are reproducible
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		e.control(typeMap, len(v))
+		for _, key := range keys {
+			e.encode(key)
+			e.encode(v[key])
+		}
+	default:
+		panic("unsupported fixture value")
+	}
+}
+
+// treeWriter builds the search tree of an MMDB file. Records hold a child
+// node index, emptyRecord or dataRecord(i) for the i-th data value.
+type treeWriter struct {
+	ipVersion  int
+	recordSize int
+	nodes      [][2]int
+	data       []interface{}
+}
+
+const emptyRecord = -1
+
+func dataRecord(i int) int {
+	return -2 - i
+}
+
+func newTreeWriter(ipVersion, recordSize int) *treeWriter {
+	return &treeWriter{
+		ipVersion:  ipVersion,
+		recordSize: recordSize,
+		nodes:      [][2]int{{emptyRecord, emptyRecord}},
+	}
+}
+
+// insert stores value for the network, IPv4 networks of an IPv6 tree go
+// under ::/96
+func (w *treeWriter) insert(t *testing.T, cidr string, value interface{}) {
+	t.Helper()
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := network.IP
+	prefix, _ := network.Mask.Size()
+	if ip4 := ip.To4(); ip4 != nil && w.ipVersion == 6 {
+		ip = append(make(net.IP, 12), ip4...)
+		prefix += 96
+	}
+
+	w.data = append(w.data, value)
+	record := dataRecord(len(w.data) - 1)
+
+	node := 0
+	for i := 0; i < prefix; i++ {
+		bit := int(ip[i>>3]>>(7-uint(i&7))) & 1
+		if i == prefix-1 {
+			w.nodes[node][bit] = record
+			break
+		}
+		if w.nodes[node][bit] == emptyRecord {
+			w.nodes = append(w.nodes, [2]int{emptyRecord, emptyRecord})
+			w.nodes[node][bit] = len(w.nodes) - 1
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+func (w *treeWriter) bytes() []byte {
+	data := &encoder{}
+	offsets := make([]int, len(w.data))
+	for i, value := range w.data {
+		offsets[i] = len(data.buf)
+		data.encode(value)
+	}
+
+	nodeCount := len(w.nodes)
+	resolve := func(record int) uint32 {
+		switch {
+		case record == emptyRecord:
+			return uint32(nodeCount)
+		case record < emptyRecord:
+			return uint32(nodeCount + dataSectionSeparator + offsets[-2-record])
+		}
+		return uint32(record)
+	}
+
+	var buf []byte
+	for _, node := range w.nodes {
+		left, right := resolve(node[0]), resolve(node[1])
+		switch w.recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(left>>24<<4)|byte(right>>24&0x0F),
+				byte(right>>16), byte(right>>8), byte(right))
+		default:
+			buf = binary.BigEndian.AppendUint32(buf, left)
+			buf = binary.BigEndian.AppendUint32(buf, right)
+		}
+	}
+
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data.buf...)
+	buf = append(buf, metadataStart...)
+
+	metadata := &encoder{}
+	metadata.encode(map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(w.recordSize),
+		"ip_version":    uint16(w.ipVersion),
+		"database_type": "NetScan-Test",
+	})
+	return append(buf, metadata.buf...)
+}
+
+func (w *treeWriter) write(t *testing.T, name string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, w.bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func cityRecord(isoCode, country, city string, latitude, longitude float64) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code": isoCode,
+			"names":    map[string]interface{}{"en": country, "de": country + "-de"},
+		},
+		"city":     map[string]interface{}{"names": map[string]interface{}{"en": city}},
+		"location": map[string]interface{}{"latitude": latitude, "longitude": longitude},
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		city := newTreeWriter(4, recordSize)
+		city.insert(t, "81.2.69.0/24", cityRecord("GB", "United Kingdom", "London", 51.5142, -0.0931))
+		city.insert(t, "175.16.199.0/24", cityRecord("CN", "China", "Changchun", 43.88, 125.3228))
+		// anycast range without a country, the registered country is used
+		city.insert(t, "1.1.1.0/24", map[string]interface{}{
+			"registered_country": map[string]interface{}{
+				"iso_code": "AU",
+				"names":    map[string]interface{}{"en": "Australia"},
+			},
+		})
+
+		asn := newTreeWriter(6, recordSize)
+		asn.insert(t, "1.128.0.0/11", map[string]interface{}{
+			"autonomous_system_number":       uint32(1221),
+			"autonomous_system_organization": "Telstra Pty Ltd",
+		})
+		asn.insert(t, "81.2.69.0/24", map[string]interface{}{
+			"autonomous_system_number":       uint32(4200000000),
+			"autonomous_system_organization": "Example Private ASN",
+		})
+		asn.insert(t, "2a02:c7f::/32", map[string]interface{}{
+			"autonomous_system_number":       uint32(5607),
+			"autonomous_system_organization": "Sky UK Limited",
+		})
+
+		db, err := Open(Files{
+			CityFile: city.write(t, "city.mmdb"),
+			ASNFile:  asn.write(t, "asn.mmdb"),
+		})
+		if err != nil {
+			t.Fatalf("record size %d: Open() = %v", recordSize, err)
+		}
+
+		tests := []struct {
+			ip   string
+			want *Info
+		}{
+			{"81.2.69.160", &Info{
+				IP: "81.2.69.160", CountryCode: "GB", Country: "United Kingdom", City: "London",
+				Latitude: 51.5142, Longitude: -0.0931, ASN: 4200000000, Organization: "Example Private ASN",
+			}},
+			{"175.16.199.1", &Info{
+				IP: "175.16.199.1", CountryCode: "CN", Country: "China", City: "Changchun",
+				Latitude: 43.88, Longitude: 125.3228,
+			}},
+			{"1.1.1.1", &Info{IP: "1.1.1.1", CountryCode: "AU", Country: "Australia"}},
+			{"1.159.255.255", &Info{IP: "1.159.255.255", ASN: 1221, Organization: "Telstra Pty Ltd"}},
+			// IPv6 is only in the ASN database, the IPv4 city database has none
+			{"2a02:c7f:1234::1", &Info{IP: "2a02:c7f:1234::1", ASN: 5607, Organization: "Sky UK Limited"}},
+			{"1.160.0.0", nil},
+			{"8.8.8.8", nil},
+			{"2001:4860::8888", nil},
+			{"10.1.2.3", nil},
+			{"::1", nil},
+			{"not an ip", nil},
+		}
+
+		for _, tt := range tests {
+			got, err := db.Lookup(tt.ip)
+			if err != nil {
+				t.Errorf("record size %d: Lookup(%q) = %v", recordSize, tt.ip, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("record size %d: Lookup(%q) = %+v, want %+v", recordSize, tt.ip, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestOpenRejectsInvalidFiles(t *testing.T) {
+	valid := newTreeWriter(4, 24)
+	valid.insert(t, "81.2.69.0/24", cityRecord("GB", "United Kingdom", "London", 0, 0))
+	content := valid.bytes()
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr string
+	}{
+		{"not a database", []byte("hello"), "metadata not found"},
+		{"node count beyond file", rewriteMetadata(t, content, "node_count", uint32(1000)), "search tree exceeds file size"},
+		{"record size", rewriteMetadata(t, content, "record_size", uint16(20)), "unsupported record size 20"},
+		{"ip version", rewriteMetadata(t, content, "ip_version", uint16(5)), "unsupported ip version 5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.mmdb")
+			if err := os.WriteFile(path, tt.content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := openDatabase(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("openDatabase() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// rewriteMetadata replaces one metadata field of an encoded database
+func rewriteMetadata(t *testing.T, content []byte, name string, value interface{}) []byte {
+	t.Helper()
+
+	index := strings.LastIndex(string(content), string(metadataStart))
+	metadataOffset := index + len(metadataStart)
+	decoded, _, err := (&decoder{data: content[metadataOffset:]}).decode(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := decoded.(map[string]interface{})
+
+	fields := make(map[string]interface{}, len(metadata))
+	for key, v := range metadata {
+		switch n := v.(type) {
+		case uint64:
+			fields[key] = uint32(n)
+		default:
+			fields[key] = v
+		}
+	}
+	fields[name] = value
+
+	encoded := &encoder{}
+	encoded.encode(fields)
+	return append(append([]byte(nil), content[:metadataOffset]...), encoded.buf...)
+}
+
+func TestDecode(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	huge := strings.Repeat("y", 70000)
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"short string", "London", "London"},
+		{"empty string", "", ""},
+		{"string with one size byte", strings.Repeat("z", 100), strings.Repeat("z", 100)},
+		{"string with two size bytes", long, long},
+		{"string with three size bytes", huge, huge},
+		{"double", -0.0931, -0.0931},
+		{"float", float32(1.5), 1.5},
+		{"uint16", uint16(65535), uint64(65535)},
+		{"zero uint32", uint32(0), uint64(0)},
+		{"uint64", uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{"negative int32", int32(-42), int64(-42)},
+		{"true", true, true},
+		{"false", false, false},
+		{"bytes", []byte{1, 2, 3}, []byte{1, 2, 3}},
+		{"array", []interface{}{"a", uint16(1), true}, []interface{}{"a", uint64(1), true}},
+		{"nested map", map[string]interface{}{
+			"names": map[string]interface{}{"en": "Berlin"},
+			"list":  []interface{}{},
+		}, map[string]interface{}{
+			"names": map[string]interface{}{"en": "Berlin"},
+			"list":  []interface{}{},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := &encoder{}
+			encoded.encode(tt.value)
+
+			got, next, err := (&decoder{data: encoded.buf}).decode(0, 0)
+			if err != nil {
+				t.Fatalf("decode() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode() = %#v, want %#v", got, tt.want)
+			}
+			if next != uint(len(encoded.buf)) {
+				t.Errorf("next offset = %d, want %d", next, len(encoded.buf))
+			}
+		})
+	}
+}
+
+func TestDecodeUint128(t *testing.T) {
+	b := []byte{0xFF, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	encoded := &encoder{}
+	encoded.control(typeUint128, len(b))
+	encoded.buf = append(encoded.buf, b...)
+
+	got, _, err := (&decoder{data: encoded.buf}).decode(0, 0)
+	if err != nil {
+		t.Fatalf("decode() = %v", err)
+	}
+	want := new(big.Int).SetBytes(b)
+	if value, ok := got.(*big.Int); !ok || value.Cmp(want) != 0 {
+		t.Errorf("decode() = %v, want %v", got, want)
+	}
+}
+
+func TestDecodePointers(t *testing.T) {
+	encoded := &encoder{}
+	encoded.encode("United Kingdom")
+	encoded.encode(map[string]interface{}{"en": "London"})
+	cityOffset := len("United Kingdom") + 1
+
+	// pad beyond 2048 bytes so the second pointer needs the longer form
+	padding := strings.Repeat("p", 2100)
+	encoded.encode(padding)
+	farOffset := len(encoded.buf)
+	encoded.encode("far away")
+
+	start := len(encoded.buf)
+	encoded.encode(map[string]interface{}{
+		"country": pointerTo(0),
+		"names":   pointerTo(cityOffset),
+		"other":   pointerTo(farOffset),
+	})
+
+	got, next, err := (&decoder{data: encoded.buf}).decode(uint(start), 0)
+	if err != nil {
+		t.Fatalf("decode() = %v", err)
+	}
+	want := map[string]interface{}{
+		"country": "United Kingdom",
+		"names":   map[string]interface{}{"en": "London"},
+		"other":   "far away",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decode() = %#v, want %#v", got, want)
+	}
+	// the offset after a pointer is the one after the pointer itself
+	if next != uint(len(encoded.buf)) {
+		t.Errorf("next offset = %d, want %d", next, len(encoded.buf))
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	selfPointer := &encoder{}
+	selfPointer.pointer(0)
+
+	truncatedString := &encoder{}
+	truncatedString.encode("London")
+
+	truncatedMap := &encoder{}
+	truncatedMap.encode(map[string]interface{}{"a": "b", "c": "d"})
+
+	nonStringKey := &encoder{}
+	nonStringKey.control(typeMap, 1)
+	nonStringKey.encode(uint16(1))
+	nonStringKey.encode("value")
+
+	badDouble := &encoder{}
+	badDouble.control(typeDouble, 4)
+	badDouble.buf = append(badDouble.buf, 0, 0, 0, 0)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"pointer loop", selfPointer.buf},
+		{"truncated string", truncatedString.buf[:4]},
+		{"truncated map", truncatedMap.buf[:len(truncatedMap.buf)-2]},
+		{"non-string map key", nonStringKey.buf},
+		{"double of wrong size", badDouble.buf},
+		{"missing extended type", []byte{0x01}},
+		{"unknown extended type", []byte{0x00, 0x20}},
+		{"array larger than data", []byte{0x1D, 0x04, 0xFF}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := (&decoder{data: tt.data}).decode(0, 0)
+			if !errors.Is(err, errCorrupt) {
+				t.Errorf("decode() = %v, want errCorrupt", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often files are checked for changes, at most once per handshake
+const reloadCheckInterval = 10 * time.Second
+
+type Files struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Source serves a certificate and a CA pool read from files and picks up
+// rotated files on the next handshake, no restart is needed. If a rotated
+// file can not be loaded the previous one stays in use.
+type Source struct {
+	files Files
+
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  [3]time.Time
+	checkedAt time.Time
+	lastError error
+}
+
+func NewSource(files Files) (*Source, error) {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, errors.New("certificate and key files must be set together")
+	}
+
+	s := &Source{files: files}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.checkedAt = time.Now()
+	return s, nil
+}
+
+// LastError returns the error of the last failed reload, nil once files
+// load again
+func (s *Source) LastError() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastError
+}
+
+// Certificate returns the current certificate, nil when none is configured
+func (s *Source) Certificate() *tls.Certificate {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refresh()
+	return s.cert
+}
+
+// CAPool returns the current CA pool, nil when none is configured
+func (s *Source) CAPool() *x509.CertPool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refresh()
+	return s.pool
+}
+
+// ServerConfig builds a listener config. Client certificates are verified
+// against the CA file when one is set.
+func (s *Source) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				ClientAuth: clientAuth,
+				ClientCAs:  s.CAPool(),
+				// WebSocket upgrades need HTTP/1.1
+				NextProtos: []string{"http/1.1"},
+			}
+			if cert := s.Certificate(); cert != nil {
+				config.Certificates = []tls.Certificate{*cert}
+			}
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig builds a config that presents the client certificate and,
+// when a CA file is set, trusts only that CA for the server
+func (s *Source) ClientConfig(serverName string) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := s.Certificate(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+
+	if s.files.CAFile == "" {
+		return config
+	}
+
+	// Verification is done by hand so that a rotated CA file is used
+	// without rebuilding the transport
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       state.ServerName,
+			Roots:         s.CAPool(),
+			Intermediates: intermediates,
+		})
+		return err
+	}
+
+	return config
+}
+
+// refresh reloads files whose modification time changed, the caller holds
+// the mutex
+func (s *Source) refresh() {
+	if time.Since(s.checkedAt) < reloadCheckInterval {
+		return
+	}
+	s.checkedAt = time.Now()
+
+	modTimes, err := s.stat()
+	if err != nil {
+		s.lastError = err
+		return
+	}
+	if modTimes == s.modTimes {
+		return
+	}
+
+	s.lastError = s.load()
+}
+
+func (s *Source) stat() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, path := range []string{s.files.CertFile, s.files.KeyFile, s.files.CAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (s *Source) load() error {
+	modTimes, err := s.stat()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if s.files.CertFile != "" {
+		loaded, err := tls.LoadX509KeyPair(s.files.CertFile, s.files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		cert = &loaded
+	}
+
+	var pool *x509.CertPool
+	if s.files.CAFile != "" {
+		data, err := os.ReadFile(s.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in CA file %s", s.files.CAFile)
+		}
+	}
+
+	s.cert = cert
+	s.pool = pool
+	s.modTimes = modTimes
+	return nil
+}
@@ -0,0 +1,192 @@
+package validator
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// optionRule проверяет значение одной опции после разбора JSON
+type optionRule func(value interface{}) error
+
+var httpOptions = map[string]optionRule{
+	"timeout":          durationRule,
+	"method":           oneOfRule("GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"),
+	"headers":          headersRule,
+	"follow_redirects": boolRule,
+	"verify_ssl":       boolRule,
+	"expected_status":  statusRule,
+}
+
+var mtrOptions = map[string]optionRule{
+	"timeout":     durationRule,
+	"max_hops":    intRule(1, 64),
+	"interval":    durationRule,
+	"duration":    durationRule,
+	"cycles":      intRule(1, 1000),
+	"packet_size": intRule(0, 65500),
+}
+
+// схемы опций по типам проверок, повторяют опции раннеров агента
+var checkOptionSchemas = map[string]map[string]optionRule{
+	"http":  httpOptions,
+	"https": httpOptions,
+	"ping": {
+		"timeout":     durationRule,
+		"count":       intRule(1, 100),
+		"interval":    durationRule,
+		"packet_size": intRule(0, 65500),
+		"deadline":    durationRule,
+		"mode":        oneOfRule("auto", "icmp", "tcp"),
+	},
+	"tcp": {
+		"timeout":     durationRule,
+		"port":        intRule(1, 65535),
+		"banner_grab": boolRule,
+	},
+	"dns": {
+		"timeout":     durationRule,
+		"record_type": oneOfRule("A", "AAAA", "MX", "NS", "TXT", "CNAME", "SOA", "PTR", "SRV"),
+		"server":      hostPortRule,
+	},
+	"mtr":        mtrOptions,
+	"traceroute": mtrOptions,
+}
+
+// ValidateCheckOptions проверяет опции по схеме типа проверки,
+// неизвестные опции отклоняются, чтобы опечатки не терялись молча
+func ValidateCheckOptions(checkType string, options map[string]interface{}) error {
+	schema, ok := checkOptionSchemas[checkType]
+	if !ok {
+		return fmt.Errorf("unknown check type %q", checkType)
+	}
+
+	// сортировка дает одну и ту же ошибку для одного и того же запроса
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rule, ok := schema[name]
+		if !ok {
+			return fmt.Errorf("option %q is not supported by %s checks", name, checkType)
+		}
+		if err := rule(options[name]); err != nil {
+			return fmt.Errorf("option %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func boolRule(value interface{}) error {
+	if _, ok := value.(bool); !ok {
+		return fmt.Errorf("must be a boolean")
+	}
+	return nil
+}
+
+// длительность в секундах числом или строкой вида "500ms"
+func durationRule(value interface{}) error {
+	switch v := value.(type) {
+	case float64:
+		// агент переводит секунды в time.Duration, дробные секунды допустимы
+		if time.Duration(v*float64(time.Second)) <= 0 {
+			return fmt.Errorf("must be positive")
+		}
+		return nil
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("must be seconds or a duration like \"500ms\"")
+		}
+		if duration <= 0 {
+			return fmt.Errorf("must be positive")
+		}
+		return nil
+	}
+	return fmt.Errorf("must be seconds or a duration like \"500ms\"")
+}
+
+func intRule(min, max int) optionRule {
+	return func(value interface{}) error {
+		number, ok := integer(value)
+		if !ok || number < min || number > max {
+			return fmt.Errorf("must be an integer between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+func oneOfRule(allowed ...string) optionRule {
+	return func(value interface{}) error {
+		if s, ok := value.(string); ok {
+			for _, a := range allowed {
+				if s == a {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func headersRule(value interface{}) error {
+	headers, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("must be an object of strings")
+	}
+	for name, v := range headers {
+		if _, ok := v.(string); !ok || name == "" {
+			return fmt.Errorf("must be an object of strings")
+		}
+	}
+	return nil
+}
+
+// код ответа или список кодов
+func statusRule(value interface{}) error {
+	codes, ok := value.([]interface{})
+	if !ok {
+		codes = []interface{}{value}
+	}
+	if len(codes) == 0 {
+		return fmt.Errorf("must not be empty")
+	}
+	for _, code := range codes {
+		if number, ok := integer(code); !ok || number < 100 || number > 599 {
+			return fmt.Errorf("must be a status code or a list of status codes")
+		}
+	}
+	return nil
+}
+
+func hostPortRule(value interface{}) error {
+	address, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("must be host:port")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("must be host:port")
+	}
+	return nil
+}
+
+// целое число из JSON, где все числа приходят как float64
+func integer(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+			return 0, false
+		}
+		return int(v), true
+	case int:
+		return v, true
+	}
+	return 0, false
+}
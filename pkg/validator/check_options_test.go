@@ -0,0 +1,73 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCheckOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		checkType string
+		options   map[string]interface{}
+		wantErr   string
+	}{
+		{"no options", "http", nil, ""},
+		{"unknown check type", "smtp", nil, `unknown check type "smtp"`},
+		{"unknown option", "tcp", map[string]interface{}{"method": "GET"}, `option "method" is not supported by tcp checks`},
+
+		{"http options", "https", map[string]interface{}{
+			"method":           "HEAD",
+			"headers":          map[string]interface{}{"Accept": "text/html"},
+			"follow_redirects": false,
+			"verify_ssl":       true,
+			"expected_status":  []interface{}{float64(200), float64(204)},
+			"timeout":          float64(10),
+		}, ""},
+		{"lowercase method", "http", map[string]interface{}{"method": "get"}, `option "method": must be one of`},
+		{"header with number value", "http", map[string]interface{}{"headers": map[string]interface{}{"X-Id": float64(1)}}, `option "headers"`},
+		{"single expected status", "http", map[string]interface{}{"expected_status": float64(301)}, ""},
+		{"status out of range", "http", map[string]interface{}{"expected_status": float64(600)}, `option "expected_status"`},
+		{"empty status list", "http", map[string]interface{}{"expected_status": []interface{}{}}, `option "expected_status": must not be empty`},
+		{"string boolean", "http", map[string]interface{}{"verify_ssl": "false"}, `option "verify_ssl": must be a boolean`},
+
+		{"whole seconds", "dns", map[string]interface{}{"timeout": float64(5)}, ""},
+		{"fractional seconds", "dns", map[string]interface{}{"timeout": 0.5}, ""},
+		{"duration string", "ping", map[string]interface{}{"interval": "200ms"}, ""},
+		{"zero seconds", "tcp", map[string]interface{}{"timeout": float64(0)}, `option "timeout": must be positive`},
+		{"below a nanosecond", "tcp", map[string]interface{}{"timeout": 1e-12}, `option "timeout": must be positive`},
+		{"negative duration string", "tcp", map[string]interface{}{"timeout": "-1s"}, `option "timeout": must be positive`},
+		{"invalid duration string", "tcp", map[string]interface{}{"timeout": "soon"}, `option "timeout": must be seconds`},
+
+		{"port", "tcp", map[string]interface{}{"port": float64(443)}, ""},
+		{"fractional port", "tcp", map[string]interface{}{"port": 80.5}, `option "port": must be an integer between 1 and 65535`},
+		{"port out of range", "tcp", map[string]interface{}{"port": float64(70000)}, `option "port"`},
+		{"ping count", "ping", map[string]interface{}{"count": float64(100), "mode": "tcp"}, ""},
+		{"ping count too high", "ping", map[string]interface{}{"count": float64(101)}, `option "count"`},
+		{"dns server", "dns", map[string]interface{}{"server": "1.1.1.1:53", "record_type": "AAAA"}, ""},
+		{"dns server without port", "dns", map[string]interface{}{"server": "1.1.1.1"}, `option "server": must be host:port`},
+		{"mtr hops", "mtr", map[string]interface{}{"max_hops": float64(64), "cycles": float64(3)}, ""},
+		{"traceroute shares mtr options", "traceroute", map[string]interface{}{"max_hops": float64(65)}, `option "max_hops"`},
+
+		// ошибка всегда по первой опции в алфавитном порядке
+		{"first invalid option by name", "tcp", map[string]interface{}{
+			"timeout": float64(-1),
+			"port":    float64(0),
+		}, `option "port"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCheckOptions(tt.checkType, tt.options)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
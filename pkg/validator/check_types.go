@@ -8,6 +8,7 @@ func ValidateCheckType(checkType string) bool {
 		"tcp":        true,
 		"dns":        true,
 		"traceroute": true,
+		"mtr":        true,
 	}
 	return validTypes[checkType]
 }
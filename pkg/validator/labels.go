@@ -0,0 +1,26 @@
+package validator
+
+import "fmt"
+
+// ограничения меток агента
+const (
+	maxLabels           = 32
+	maxLabelNameLength  = 64
+	maxLabelValueLength = 128
+)
+
+// ValidateLabels проверяет метки агента: непустые имена и ограниченная длина
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+	for name, value := range labels {
+		if name == "" || len(name) > maxLabelNameLength {
+			return fmt.Errorf("label name %q must be 1 to %d characters", name, maxLabelNameLength)
+		}
+		if len(value) > maxLabelValueLength {
+			return fmt.Errorf("label %q value must be at most %d characters", name, maxLabelValueLength)
+		}
+	}
+	return nil
+}